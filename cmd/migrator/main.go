@@ -46,8 +46,11 @@ func main() {
 			panic(err)
 		}
 	case op == "down":
-		if err = m.Force(1); err != nil {
-			panic(err)
+		// a failed migration leaves the schema dirty; clear the flag so that we can go down
+		if v, dirty, err := m.Version(); err == nil && dirty {
+			if err = m.Force(int(v)); err != nil {
+				panic(err)
+			}
 		}
 		if err = m.Down(); err != nil {
 			if errors.Is(err, migrate.ErrNoChange) {
@@ -3,19 +3,54 @@ package main
 import (
 	"context"
 	"errors"
+	"expvar"
+	"flag"
+	"fmt"
 	"github.com/go-chi/chi/v5"
 	"github.com/go-chi/chi/v5/middleware"
 	ssogrpc "github.com/kxddry/url-shortener/internal/clients/sso/grpc"
 	"github.com/kxddry/url-shortener/internal/config"
+	domainsHandlers "github.com/kxddry/url-shortener/internal/http-server/handlers/domains"
+	"github.com/kxddry/url-shortener/internal/http-server/handlers/health"
+	"github.com/kxddry/url-shortener/internal/http-server/handlers/me"
+	"github.com/kxddry/url-shortener/internal/http-server/handlers/moderation"
+	"github.com/kxddry/url-shortener/internal/http-server/handlers/quotas"
+	"github.com/kxddry/url-shortener/internal/http-server/handlers/signedlinks"
 	del "github.com/kxddry/url-shortener/internal/http-server/handlers/url/delete"
 	"github.com/kxddry/url-shortener/internal/http-server/handlers/url/homepage"
 	"github.com/kxddry/url-shortener/internal/http-server/handlers/url/login"
+	"github.com/kxddry/url-shortener/internal/http-server/handlers/url/preview"
 	"github.com/kxddry/url-shortener/internal/http-server/handlers/url/redirect"
 	"github.com/kxddry/url-shortener/internal/http-server/handlers/url/register"
+	"github.com/kxddry/url-shortener/internal/http-server/handlers/url/report"
 	"github.com/kxddry/url-shortener/internal/http-server/handlers/url/save"
+	"github.com/kxddry/url-shortener/internal/http-server/handlers/url/transfer"
+	"github.com/kxddry/url-shortener/internal/http-server/handlers/url/update"
+	"github.com/kxddry/url-shortener/internal/http-server/handlers/url/view"
+	webhooksHandlers "github.com/kxddry/url-shortener/internal/http-server/handlers/webhooks"
+	"github.com/kxddry/url-shortener/internal/http-server/handlers/workspaces"
+	"github.com/kxddry/url-shortener/internal/http-server/middleware/admin"
+	"github.com/kxddry/url-shortener/internal/http-server/middleware/auth"
+	"github.com/kxddry/url-shortener/internal/http-server/middleware/csrf"
+	"github.com/kxddry/url-shortener/internal/http-server/middleware/deadline"
+	"github.com/kxddry/url-shortener/internal/http-server/middleware/idempotency"
 	mwLogger "github.com/kxddry/url-shortener/internal/http-server/middleware/logger"
+	"github.com/kxddry/url-shortener/internal/http-server/middleware/ratelimit"
+	mwTracing "github.com/kxddry/url-shortener/internal/http-server/middleware/tracing"
+	"github.com/kxddry/url-shortener/internal/http-server/pages"
+	"github.com/kxddry/url-shortener/internal/lib/access"
+	resp "github.com/kxddry/url-shortener/internal/lib/api/response"
+	"github.com/kxddry/url-shortener/internal/lib/blocklist"
+	"github.com/kxddry/url-shortener/internal/lib/domains"
+	"github.com/kxddry/url-shortener/internal/lib/linkcheck"
 	"github.com/kxddry/url-shortener/internal/lib/logger"
 	"github.com/kxddry/url-shortener/internal/lib/logger/sl"
+	"github.com/kxddry/url-shortener/internal/lib/replay"
+	"github.com/kxddry/url-shortener/internal/lib/signed"
+	"github.com/kxddry/url-shortener/internal/lib/tracing"
+	"github.com/kxddry/url-shortener/internal/lib/webhooks"
+	"github.com/kxddry/url-shortener/internal/storage"
+	"github.com/kxddry/url-shortener/internal/storage/cache"
 	"github.com/kxddry/url-shortener/internal/storage/postgres"
 	rds "github.com/kxddry/url-shortener/internal/storage/redis"
 	"log/slog"
@@ -23,18 +58,49 @@ import (
 	"os"
 	"os/signal"
 	"syscall"
+	"time"
 )
 
+// domainsRefreshInterval bounds how long other instances take to see domain changes.
+const domainsRefreshInterval = time.Minute
+
 func main() {
+	checkConfig := flag.Bool("check-config", false, "validate the config and exit")
+
 	// init config
-	cfg := config.MustLoad()
+	path := config.FetchPath()
+	if path == "" {
+		fmt.Fprintln(os.Stderr, "config path is empty: use --config or CONFIG_PATH")
+		os.Exit(1)
+	}
+	cfg, err := config.Load(path)
+	if err != nil {
+		fmt.Fprintln(os.Stderr, err)
+		os.Exit(1)
+	}
+	if *checkConfig {
+		fmt.Println("config OK:", path)
+		return
+	}
+	// SIGHUP would otherwise terminate the process while it's still starting up;
+	// reloads signalled before the server is up are applied once it is
+	hup := make(chan os.Signal, 1)
+	signal.Notify(hup, syscall.SIGHUP)
 
 	// init logger
-	log := logger.SetupLogger(cfg.Env)
+	log, logLevel := logger.SetupLogger(cfg.Env)
+	applyLogLevel(log, logLevel, cfg)
 	log.Info("Starting URL shortener service", "env", cfg.Env)
 	log.Info("Host and port", "host", cfg.HTTPServer.Address, "port", cfg.Storage.Port)
 	log.Debug("debug messages are enabled")
 
+	// init tracing
+	shutdownTracing, err := tracing.Setup(context.Background(), cfg.Tracing)
+	if err != nil {
+		log.Error("failed to set up tracing", sl.Err(err))
+		os.Exit(1)
+	}
+
 	// init SSO client
 	sso := cfg.Clients.SSO
 	ssoClient, err := ssogrpc.New(context.Background(), log, sso.Address, sso.Timeout, sso.Retries)
@@ -57,6 +123,7 @@ func main() {
 		log.Error("Failed to connect to database", sl.Err(err))
 		os.Exit(1)
 	}
+	go store.RunHealthChecks(ctx, log, cfg.Storage.HealthCheckInterval, cfg.Storage.MaxReplicaLag)
 	redis, err := rds.New(cfg.Redis)
 	if err != nil {
 		log.Error("Failed to connect to Redis", sl.Err(err))
@@ -64,26 +131,164 @@ func main() {
 	}
 	log.Info("Connected to database", "host", cfg.Storage.Host, "port", cfg.Storage.Port)
 	log.Info("Connected to Redis", "host", cfg.Redis.Host, "port", cfg.Redis.Port)
+	links := cache.New(redis, cfg.Cache)
+	go links.Run(ctx, log)
+	expvar.Publish("cache", expvar.Func(func() any { return links.Stats() }))
+
+	registry, err := domains.New(ctx, log, store)
+	if err != nil {
+		log.Error("Failed to load domains", sl.Err(err))
+		os.Exit(1)
+	}
+	go registry.Run(ctx, domainsRefreshInterval)
+
+	limiter := ratelimit.New(cfg.RateLimit)
+	// reports are cheap to send, and each one counts towards disabling a link
+	reportLimiter := ratelimit.New(config.RateLimit{
+		Enabled: true,
+		RPS:     float64(cfg.Reports.PerHour) / time.Hour.Seconds(),
+		Burst:   cfg.Reports.PerHour,
+	})
+	blocked := blocklist.New(cfg.Blocklist.Domains)
+	if err = blocked.Refresh(ctx, store); err != nil {
+		log.Error("Failed to load blocked domains", sl.Err(err))
+	}
+	go blocked.Run(ctx, log, store, domainsRefreshInterval)
+	checker := access.New(store, ssoClient)
+	signer := signed.New(cfg.SignedLinks.Keys)
+
+	if cfg.Webhooks.Enabled {
+		go webhooks.New(log, store, cfg.Webhooks).Run(ctx)
+	}
+	if cfg.LinkCheck.Enabled {
+		go linkcheck.New(log, store, cfg.LinkCheck).Run(ctx)
+	}
+	// links created while postgres is down wait in redis
+	var queued health.Queue
+	if cfg.Degraded.QueueCreations {
+		queued = redis
+		go replay.New(log, redis, store, links, checker, store.Breaker(), storage.Quota(cfg.Quota), cfg.Degraded).Run(ctx)
+	}
+
+	// browsers get error pages instead of problem JSON
+	resp.SetHTMLWriter(pages.Problem)
 
 	router := chi.NewRouter()
 
 	router.Use(middleware.RequestID)
+	router.Use(mwTracing.New())
 	router.Use(mwLogger.New(log))
 	router.Use(middleware.Recoverer)
+	router.Use(deadline.New(cfg.HTTPServer.RequestTimeout))
+	router.Use(limiter.Middleware(log))
+	router.Use(csrf.New(log, cfg.Web.SecureCookies))
 	router.Use(middleware.URLFormat)
 
-	router.Post("/url", save.New(log, store, redis, cfg))
-	router.Get("/", homepage.Url(log, cfg))
-	router.Get("/url", homepage.Url(log, cfg))
+	updateHandler := update.New(log, cfg, store, links, checker, registry, blocked)
+	deleteHandler := del.New(log, cfg, store, links, checker, registry)
+
+	router.With(idempotency.New(log, redis, cfg.App.Secret, cfg.Idempotency)).Post("/url", save.New(log, store, links, cfg, blocked, registry, checker, redis))
+	router.Patch("/url/{alias}", updateHandler)
+	router.With(auth.New(log, cfg.App.Secret)).Post("/url/{alias}/transfer", transfer.New(log, store, links, checker, registry, cfg.Quota))
+	router.With(auth.New(log, cfg.App.Secret)).Get("/url/{alias}", view.Stats(log, store, checker, registry))
+	router.With(auth.New(log, cfg.App.Secret)).Get("/url/{alias}/edit", view.Edit(log, store, checker, registry))
+	router.With(auth.New(log, cfg.App.Secret)).Get("/url/{alias}/health", view.Health(log, store, checker, registry))
+	// HTML forms can only GET and POST
+	router.Post("/url/{alias}/edit", updateHandler)
+	router.Post("/url/{alias}/delete", deleteHandler)
+	router.Get("/", homepage.Url(log, cfg, store))
+	router.Get("/url", homepage.Url(log, cfg, store))
 
-	router.Get("/login", homepage.Login(cfg))
-	router.Post("/login", login.New(ctx, log, cfg, ssoClient))
+	if cfg.SignedLinks.Enabled {
+		// stateless: neither minting nor resolving touches postgres
+		router.With(auth.New(log, cfg.App.Secret)).Post("/s", signedlinks.Mint(log, signer, blocked, cfg.SignedLinks))
+		router.Get("/s/{token}", signedlinks.Resolve(log, signer, blocked, redis, cfg.SignedLinks))
+		router.With(auth.New(log, cfg.App.Secret)).Get("/s/{token}/clicks", signedlinks.Clicks(log, signer, redis, cfg.SignedLinks))
+	}
 
-	router.Get("/register", homepage.Register(cfg))
-	router.Post("/register", register.New(ctx, log, ssoClient))
+	router.Get("/healthz", health.Live())
+	router.Get("/readyz", health.Ready(log, store.Breaker(), redis, queued))
 
-	router.Get("/{alias}", redirect.New(log, store, redis))
-	router.Delete("/{alias}", del.New(ctx, log, cfg, store, redis, ssoClient))
+	router.Get("/login", homepage.Login(log, cfg))
+	router.Post("/login", login.New(log, cfg, ssoClient))
+	router.Post("/logout", homepage.Logout(cfg))
+
+	router.Get("/register", homepage.Register(log, cfg))
+	router.Post("/register", register.New(log, ssoClient))
+
+	router.Route("/me", func(r chi.Router) {
+		r.Use(auth.New(log, cfg.App.Secret))
+
+		r.Get("/links", me.Links(log, store, checker))
+		r.Get("/tags", me.Tags(log, store, checker))
+		r.Get("/usage", me.Usage(log, store, checker, cfg.Quota))
+		r.Get("/queued/{id}", me.Queued(log, redis))
+		r.Get("/invitations", workspaces.Invitations(log, store))
+		r.Post("/invitations/{id}/accept", workspaces.Accept(log, store))
+		r.Delete("/invitations/{id}", workspaces.Decline(log, store))
+	})
+
+	router.Route("/webhooks", func(r chi.Router) {
+		r.Use(auth.New(log, cfg.App.Secret))
+
+		r.Get("/", webhooksHandlers.List(log, store, checker))
+		r.Post("/", webhooksHandlers.Create(log, store, checker))
+		r.Delete("/{id}", webhooksHandlers.Delete(log, store, checker))
+		r.Get("/{id}/deliveries", webhooksHandlers.Deliveries(log, store, checker))
+	})
+
+	router.Route("/workspaces", func(r chi.Router) {
+		r.Use(auth.New(log, cfg.App.Secret))
+
+		r.Get("/", workspaces.List(log, store))
+		r.Post("/", workspaces.Create(log, store))
+		r.Get("/{id}/members", workspaces.Members(log, store, checker))
+		r.Patch("/{id}/members/{uid}", workspaces.SetRole(log, store, checker))
+		r.Delete("/{id}/members/{uid}", workspaces.RemoveMember(log, store, checker))
+		r.Post("/{id}/invitations", workspaces.Invite(log, store, checker))
+	})
+
+	router.Route("/admin", func(r chi.Router) {
+		r.Use(admin.New(log, cfg.App.Secret, ssoClient))
+		r.Use(admin.Audit(log, store))
+
+		r.Get("/domains", domainsHandlers.List(log, store))
+		r.Post("/domains", domainsHandlers.Save(log, store, registry))
+		r.Delete("/domains/{id}", domainsHandlers.Delete(log, store, registry))
+
+		r.Get("/users/{uid}/quota", quotas.Get(log, store, cfg.Quota))
+		r.Put("/users/{uid}/quota", quotas.Set(log, store, cfg.Quota))
+		r.Delete("/users/{uid}/quota", quotas.Delete(log, store))
+		r.Get("/workspaces/{workspace}/quota", quotas.Get(log, store, cfg.Quota))
+		r.Put("/workspaces/{workspace}/quota", quotas.Set(log, store, cfg.Quota))
+		r.Delete("/workspaces/{workspace}/quota", quotas.Delete(log, store))
+
+		r.Get("/links", moderation.Links(log, store))
+		r.Get("/links/{alias}", moderation.Link(log, store, registry))
+		r.Post("/links/{alias}/disable", moderation.Disable(log, store, links, registry))
+		r.Post("/links/{alias}/enable", moderation.Enable(log, store, links, registry))
+		r.Delete("/users/{uid}/links", moderation.DeleteUserLinks(log, store, links))
+		r.Get("/blocklist", moderation.ListBlocked(log, store))
+		r.Post("/blocklist", moderation.Block(log, store, blocked))
+		r.Delete("/blocklist/{domain}", moderation.Unblock(log, store, blocked))
+		r.Get("/stats", moderation.Stats(log, store))
+		r.Get("/audit", moderation.Audit(log, store))
+		// expvar: cache hit rates per tier, memory stats and the command line
+		r.Get("/metrics", expvar.Handler().ServeHTTP)
+
+		r.Get("/reports", moderation.Queue(log, store))
+		r.Get("/reports/{alias}", moderation.Reports(log, store, registry))
+		r.Post("/reports/{alias}/dismiss", moderation.Dismiss(log, store, links, registry))
+		r.Post("/reports/{alias}/disable", moderation.Disable(log, store, links, registry))
+	})
+
+	previewHandler := preview.New(log, store, registry)
+	redirectHandler := preview.Switch(previewHandler, redirect.New(log, store, links, registry, blocked, cfg))
+	router.Get(`/{alias:[^/]+\+}`, previewHandler)
+	router.Get("/{alias}", redirectHandler)
+	router.Get("/{alias}/*", redirectHandler)
+	router.Delete("/{alias}", deleteHandler)
+	router.With(reportLimiter.Middleware(log)).Post("/{alias}/report", report.New(log, cfg, store, links, registry))
 
 	log.Info("Starting HTTP server", slog.String("address", cfg.HTTPServer.Address))
 
@@ -109,6 +314,23 @@ func main() {
 
 	stop := make(chan os.Signal, 1)
 	signal.Notify(stop, syscall.SIGINT, syscall.SIGTERM)
+	go func() {
+		for range hup {
+			next, err := config.Load(path)
+			if err != nil {
+				log.Error("config reload failed, keeping the current settings", sl.Err(err))
+				continue
+			}
+			if cfg.RestartRequired(next) {
+				log.Warn("config changed outside of log, rate_limit, blocklist and signed_links.keys; restart to apply it")
+			}
+			applyLogLevel(log, logLevel, next)
+			limiter.Update(next.RateLimit)
+			blocked.Set(next.Blocklist.Domains)
+			signer.SetKeys(next.SignedLinks.Keys)
+			log.Info("config reloaded", slog.String("path", path))
+		}
+	}()
 	<-stop
 	log.Info("Shutting down HTTP server")
 	_ = srv.Shutdown(context.Background())
@@ -116,6 +338,26 @@ func main() {
 	_ = redis.Close()
 	log.Info("Shutting down SQL connection")
 	_ = store.Close()
+	log.Info("Flushing traces")
+	flushCtx, cancel := context.WithTimeout(context.Background(), 5*time.Second)
+	if err = shutdownTracing(flushCtx); err != nil {
+		log.Error("failed to flush traces", sl.Err(err))
+	}
+	cancel()
 	log.Info("Application stopped")
 
 }
+
+// applyLogLevel sets the configured log level, or the env default if none is configured.
+func applyLogLevel(log *slog.Logger, level *slog.LevelVar, cfg *config.Config) {
+	if cfg.Log.Level == "" {
+		level.Set(logger.DefaultLevel(cfg.Env))
+		return
+	}
+	l, err := config.ParseLevel(cfg.Log.Level)
+	if err != nil {
+		log.Error("invalid log level", sl.Err(err))
+		return
+	}
+	level.Set(l)
+}
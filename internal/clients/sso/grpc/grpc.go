@@ -6,6 +6,7 @@ import (
 	grpclog "github.com/grpc-ecosystem/go-grpc-middleware/v2/interceptors/logging"
 	grpcretry "github.com/grpc-ecosystem/go-grpc-middleware/v2/interceptors/retry"
 	ssov1 "github.com/kxddry/sso-protos/gen/go/sso"
+	"go.opentelemetry.io/contrib/instrumentation/google.golang.org/grpc/otelgrpc"
 	"google.golang.org/grpc"
 	"google.golang.org/grpc/codes"
 	"google.golang.org/grpc/credentials/insecure"
@@ -25,6 +26,8 @@ func New(ctx context.Context, log *slog.Logger, addr string, timeout time.Durati
 		grpcretry.WithBackoff(grpcretry.BackoffExponential(timeout)),
 		grpcretry.WithCodes(codes.NotFound, codes.Aborted, codes.DeadlineExceeded),
 		grpcretry.WithMax(uint(retries)),
+		// every attempt gets its own deadline, within the deadline of the caller
+		grpcretry.WithPerRetryTimeout(timeout),
 	}
 
 	logOpts := []grpclog.Option{
@@ -37,6 +40,9 @@ func New(ctx context.Context, log *slog.Logger, addr string, timeout time.Durati
 			grpclog.UnaryClientInterceptor(InterceptorLogger(log), logOpts...),
 			grpcretry.UnaryClientInterceptor(retryOpts...),
 		),
+		// the stats handler sits below the retry interceptor, so every attempt
+		// gets its own client span and carries the trace context to SSO
+		grpc.WithStatsHandler(otelgrpc.NewClientHandler()),
 	)
 
 	if err != nil {
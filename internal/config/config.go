@@ -3,39 +3,98 @@ package config
 import (
 	"errors"
 	"flag"
+	"fmt"
 	"github.com/ilyakaznacheev/cleanenv"
+	"log/slog"
+	"net"
 	"os"
+	"reflect"
+	"strconv"
+	"strings"
 	"time"
 )
 
 type Config struct {
-	Env        string        `yaml:"env" env-required:"true"`
-	Storage    Storage       `yaml:"postgres" env-required:"true"`
-	HTTPServer HTTPServer    `yaml:"http_server"`
-	Redis      RedisStorage  `yaml:"redis" env-required:"true"`
-	Clients    ClientsConfig `yaml:"clients"`
-	App        App           `yaml:"app" env-required:"true"`
-	TokenTTL   time.Duration `yaml:"token_ttl" env-required:"true"`
+	Env         string        `yaml:"env" env-required:"true"`
+	Storage     Storage       `yaml:"postgres" env-required:"true"`
+	HTTPServer  HTTPServer    `yaml:"http_server"`
+	Redis       RedisStorage  `yaml:"redis" env-required:"true"`
+	Clients     ClientsConfig `yaml:"clients"`
+	App         App           `yaml:"app" env-required:"true"`
+	TokenTTL    time.Duration `yaml:"token_ttl" env-required:"true"`
+	Log         Log           `yaml:"log"`
+	RateLimit   RateLimit     `yaml:"rate_limit"`
+	Blocklist   Blocklist     `yaml:"blocklist"`
+	Redirect    Redirect      `yaml:"redirect"`
+	Preview     Preview       `yaml:"preview"`
+	Webhooks    Webhooks      `yaml:"webhooks"`
+	Tracing     Tracing       `yaml:"tracing"`
+	Web         Web           `yaml:"web"`
+	LinkCheck   LinkCheck     `yaml:"link_check"`
+	Quota       Quota         `yaml:"quota"`
+	Reports     Reports       `yaml:"reports"`
+	Idempotency Idempotency   `yaml:"idempotency"`
+	Cache       Cache         `yaml:"cache"`
+	Degraded    Degraded      `yaml:"degraded"`
+	SignedLinks SignedLinks   `yaml:"signed_links"`
 }
 
 type App struct {
-	Name   string `yaml:"name" env-required:"true"`
-	Secret string `yaml:"secret" env-required:"true" env:"APP_SECRET"`
-	ID     int64
+	Name       string `yaml:"name" env-required:"true"`
+	Secret     string `yaml:"secret" env:"APP_SECRET"`
+	SecretFile string `yaml:"secret_file" env:"APP_SECRET_FILE"`
+	ID         int64
 }
 
 type RedisStorage struct {
-	Host     string `yaml:"host" env-required:"true"`
-	Port     string `yaml:"port" env-required:"true"`
-	User     string `yaml:"user" env-default:""`
-	Password string `yaml:"password" env-default:""`
-	DB       int    `yaml:"db" env-default:"0" validate:"min=0,integer"`
-	PoolSize int    `yaml:"pool_size" env-default:"10"`
-	Protocol string `yaml:"protocol" env-default:"tcp"`
+	// Mode is standalone, sentinel or cluster.
+	Mode string `yaml:"mode" env-default:"standalone"`
+	// Host and Port address the server in standalone mode.
+	Host string `yaml:"host"`
+	Port string `yaml:"port"`
+	// Addrs are the sentinels in sentinel mode and the seed nodes in cluster mode.
+	Addrs []string `yaml:"addrs"`
+	// MasterName is the name of the master monitored by the sentinels.
+	MasterName       string `yaml:"master_name"`
+	SentinelPassword string `yaml:"sentinel_password" env:"REDIS_SENTINEL_PASSWORD"`
+
+	User         string `yaml:"user" env-default:""`
+	Password     string `yaml:"password" env-default:""`
+	PasswordFile string `yaml:"password_file" env:"REDIS_PASSWORD_FILE"`
+	DB           int    `yaml:"db" env-default:"0" validate:"min=0,integer"`
+	PoolSize     int    `yaml:"pool_size" env-default:"10"`
+	Protocol     string `yaml:"protocol" env-default:"tcp"`
+
+	// KeyPrefix is prepended to every key, so that deployments can share a server.
+	KeyPrefix    string        `yaml:"key_prefix"`
+	DialTimeout  time.Duration `yaml:"dial_timeout" env-default:"5s"`
+	ReadTimeout  time.Duration `yaml:"read_timeout" env-default:"3s"`
+	WriteTimeout time.Duration `yaml:"write_timeout" env-default:"3s"`
+	// CommandTimeout bounds every cache command. A slow cache is skipped
+	// in favour of Postgres, so it is kept well below the query timeout.
+	CommandTimeout time.Duration `yaml:"command_timeout" env-default:"500ms"`
+	// LinkTTL bounds how long a link stays cached. Changes invalidate it right
+	// away, but a cache miss read from a lagging replica can cache a link as it
+	// was before a change; this bounds how long it is served like that.
+	LinkTTL time.Duration `yaml:"link_ttl" env-default:"1h"`
+	TLS     TLS           `yaml:"tls"`
+}
+
+type TLS struct {
+	Enabled bool `yaml:"enabled"`
+	// CAFile verifies the server with a private CA instead of the system roots.
+	CAFile string `yaml:"ca_file"`
+	// CertFile and KeyFile are the client certificate, for servers that require one.
+	CertFile   string `yaml:"cert_file"`
+	KeyFile    string `yaml:"key_file"`
+	ServerName string `yaml:"server_name"`
+	// InsecureSkipVerify disables verification of the server's certificate. Never use it in prod.
+	InsecureSkipVerify bool `yaml:"insecure_skip_verify"`
 }
 
 type Client struct {
-	Address  string        `yaml:"address"`
+	Address string `yaml:"address"`
+	// Timeout bounds every attempt of a call and is the base of the retry backoff.
 	Timeout  time.Duration `yaml:"timeout"`
 	Retries  int           `yaml:"retries"`
 	Insecure bool          `yaml:"insecure"`
@@ -46,33 +105,603 @@ type ClientsConfig struct {
 }
 
 type HTTPServer struct {
-	Address     string        `yaml:"host" env-default:"localhost:8080"`
-	Timeout     time.Duration `yaml:"timeout" env-default:"5"`
-	IdleTimeout time.Duration `yaml:"idle_timeout" env-default:"60"`
+	Address     string        `yaml:"address" env-default:"localhost:8080"`
+	Timeout     time.Duration `yaml:"timeout" env-default:"5s"`
+	IdleTimeout time.Duration `yaml:"idle_timeout" env-default:"60s"`
+	// RequestTimeout bounds the storage, cache and SSO calls of a request. 0 disables it.
+	RequestTimeout time.Duration `yaml:"request_timeout" env-default:"10s"`
+}
+
+type Redirect struct {
+	// QueryConflict is the default policy for links forwarding the query string:
+	// target, request or both.
+	QueryConflict string `yaml:"query_conflict" env-default:"target"`
+	// DefaultCode is used for links created without a redirect code: 301, 302, 307 or 308.
+	DefaultCode int `yaml:"default_code" env-default:"302"`
+	// CacheMaxAge is how long browsers and CDNs may cache 302 and 307 redirects.
+	CacheMaxAge time.Duration `yaml:"cache_max_age" env-default:"0s"`
+	// PermanentMaxAge caps the caching of 301 and 308 redirects. Links can be
+	// edited or deleted, so permanent redirects must not be cached forever.
+	PermanentMaxAge time.Duration `yaml:"permanent_max_age" env-default:"5m"`
+}
+
+type Preview struct {
+	// Countdown is how long the interstitial page waits before following the link, 0 disables it.
+	Countdown time.Duration `yaml:"countdown" env-default:"5s"`
+}
+
+type Webhooks struct {
+	// Enabled runs the dispatcher. Events are recorded either way.
+	Enabled      bool          `yaml:"enabled"`
+	PollInterval time.Duration `yaml:"poll_interval" env-default:"2s"`
+	Timeout      time.Duration `yaml:"timeout" env-default:"10s"`
+	// A delivery is retried MaxAttempts times in total, waiting MinBackoff
+	// after the first failure and doubling that up to MaxBackoff.
+	MaxAttempts int           `yaml:"max_attempts" env-default:"10"`
+	MinBackoff  time.Duration `yaml:"min_backoff" env-default:"10s"`
+	MaxBackoff  time.Duration `yaml:"max_backoff" env-default:"6h"`
+	BatchSize   int           `yaml:"batch_size" env-default:"100"`
+	Workers     int           `yaml:"workers" env-default:"4"`
+}
+
+type Tracing struct {
+	Enabled bool `yaml:"enabled"`
+	// Exporter is otlp, stdout or file.
+	Exporter string `yaml:"exporter" env-default:"otlp"`
+	// Endpoint is the host:port of an OTLP/gRPC collector.
+	Endpoint string `yaml:"endpoint" env-default:"localhost:4317"`
+	Insecure bool   `yaml:"insecure"`
+	// File receives one JSON span per line with the file exporter.
+	File string `yaml:"file"`
+	// SampleRatio is the share of new traces that are recorded. Requests that
+	// arrive with a trace context follow the sampling decision of the caller.
+	SampleRatio float64 `yaml:"sample_ratio" env-default:"1"`
+	ServiceName string  `yaml:"service_name" env-default:"url-shortener"`
+}
+
+type LinkCheck struct {
+	// Enabled runs the dead-link monitor.
+	Enabled bool `yaml:"enabled"`
+	// Interval is how often every destination is checked.
+	Interval time.Duration `yaml:"interval" env-default:"24h"`
+	// PollInterval is how often the monitor looks for links that are due.
+	PollInterval time.Duration `yaml:"poll_interval" env-default:"1m"`
+	BatchSize    int           `yaml:"batch_size" env-default:"200"`
+	// Workers bounds the checks running at once, PerHost those against a single host.
+	Workers int `yaml:"workers" env-default:"8"`
+	PerHost int `yaml:"per_host" env-default:"1"`
+	// HostDelay is the least time between two requests to the same host.
+	HostDelay time.Duration `yaml:"host_delay" env-default:"1s"`
+	Timeout   time.Duration `yaml:"timeout" env-default:"10s"`
+	// FailureThreshold is how many checks in a row have to fail for a link to be flagged as broken.
+	FailureThreshold int    `yaml:"failure_threshold" env-default:"3"`
+	UserAgent        string `yaml:"user_agent" env-default:"url-shortener-linkcheck"`
+}
+
+// Quota is the default for every user's personal links and for every
+// workspace's links; admins override it per user or workspace. 0 means unlimited.
+type Quota struct {
+	// Links bounds the active links: those that have neither expired nor been used up.
+	Links       int `yaml:"links" env-default:"1000"`
+	LinksPerDay int `yaml:"links_per_day" env-default:"100"`
+	// CustomAliasesPerMonth bounds the links created with an alias of one's choosing.
+	CustomAliasesPerMonth int `yaml:"custom_aliases_per_month" env-default:"20"`
+}
+
+// Reports configures abuse reports of visitors.
+type Reports struct {
+	// PerHour bounds the reports a client IP may send.
+	PerHour int `yaml:"per_hour" env-default:"10"`
+	// AutoDisableAfter distinct reporters disable a link for AutoDisableFor,
+	// or until an admin reviews it. 0 leaves every link to the admins.
+	AutoDisableAfter int           `yaml:"auto_disable_after" env-default:"5"`
+	AutoDisableFor   time.Duration `yaml:"auto_disable_for" env-default:"72h"`
+}
+
+// Cache configures the in-process tier of the link cache, in front of Redis.
+type Cache struct {
+	// Size bounds the links each instance keeps in memory, 0 disables the tier.
+	Size int `yaml:"size" env-default:"10000"`
+	// TTL bounds how long a link is kept in memory. Changes reach every
+	// instance right away, but if an invalidation is lost, a change may take
+	// this long to be seen.
+	TTL time.Duration `yaml:"ttl" env-default:"30s"`
+}
+
+// Degraded configures what happens while Postgres is unavailable, see
+// Storage.Breaker. Redirects keep being served from the cache either way.
+type Degraded struct {
+	// QueueCreations accepts new links into a Redis stream instead of failing
+	// with 503, and creates them once Postgres is back. Links whose alias was
+	// taken, or that exceed their quota, by then are dropped.
+	QueueCreations bool `yaml:"queue_creations" env-default:"false"`
+	// MaxQueued bounds the queue, 0 means unbounded.
+	MaxQueued int64 `yaml:"max_queued" env-default:"10000"`
+	// ReplayInterval is how often the queue is checked.
+	ReplayInterval time.Duration `yaml:"replay_interval" env-default:"5s"`
+	// ReplayBatch is how many queued links are created at once.
+	ReplayBatch int64 `yaml:"replay_batch" env-default:"100"`
+}
+
+// SignedLinks configures links that carry their destination and expiry in a
+// signed token, GET /s/{token}, for short-lived links that don't get a row.
+type SignedLinks struct {
+	Enabled bool `yaml:"enabled"`
+	// Keys sign and verify the tokens. The first one signs new tokens, all of
+	// them verify; to rotate, put a new key first and remove the old one once
+	// max_ttl has passed. They are reloaded on SIGHUP.
+	Keys []SigningKey `yaml:"keys"`
+	// DefaultTTL applies to tokens minted without an expiry, MaxTTL bounds them all.
+	DefaultTTL time.Duration `yaml:"default_ttl" env-default:"168h"`
+	MaxTTL     time.Duration `yaml:"max_ttl" env-default:"720h"`
+	// CountClicks counts the visits of every token in Redis, until it expires.
+	CountClicks bool `yaml:"count_clicks"`
+}
+
+type SigningKey struct {
+	// ID is put in the tokens, to tell which key verifies them.
+	ID         string `yaml:"id"`
+	Secret     string `yaml:"secret"`
+	SecretFile string `yaml:"secret_file"`
+}
+
+// minSigningKeyLength is the least number of bytes of a signing key, that of the HMAC-SHA256 output.
+const minSigningKeyLength = 32
+
+// Idempotency configures the Idempotency-Key header of POST /url.
+type Idempotency struct {
+	// TTL is how long responses are kept to answer retries with.
+	TTL time.Duration `yaml:"ttl" env-default:"24h"`
+	// Wait is how long a retry waits for the request in flight with its key
+	// before getting 409 Conflict.
+	Wait time.Duration `yaml:"wait" env-default:"5s"`
+	// LockTTL bounds how long a key stays in flight when the instance serving it dies.
+	LockTTL time.Duration `yaml:"lock_ttl" env-default:"1m"`
+}
+
+type Web struct {
+	// SecureCookies restricts the session and CSRF cookies to HTTPS.
+	// Only turn it off to use the web UI over plain HTTP locally.
+	SecureCookies bool `yaml:"secure_cookies" env-default:"true"`
+}
+
+// Log, RateLimit and Blocklist are reloaded on SIGHUP, everything else requires a restart.
+
+type Log struct {
+	// Level overrides the env-based default: debug, info, warn or error.
+	Level string `yaml:"level" env:"LOG_LEVEL"`
+}
+
+type RateLimit struct {
+	Enabled bool    `yaml:"enabled"`
+	RPS     float64 `yaml:"rps" env-default:"10"`
+	Burst   int     `yaml:"burst" env-default:"20"`
+}
+
+type Blocklist struct {
+	// Domains that cannot be used as a destination. Subdomains are blocked as well.
+	Domains []string `yaml:"domains"`
 }
 
 func MustLoad() *Config {
-	path := fetchConfigPath()
+	path := FetchPath()
 	if path == "" {
 		panic("config path is empty")
 	}
 	return MustLoadByPath(path)
 }
 
+// validate reports every problem with the config at once, so that a broken file
+// can be fixed in one go instead of one restart per mistake.
 func (c *Config) validate() error {
+	var errs []error
+	add := func(format string, args ...any) {
+		errs = append(errs, fmt.Errorf(format, args...))
+	}
+
 	if c.Env != "local" && c.Env != "dev" && c.Env != "prod" {
-		return errors.New("config: invalid env value")
+		add("env: must be one of local, dev, prod, got %q", c.Env)
 	}
-	return nil
+
+	errs = append(errs, c.Storage.validate("postgres")...)
+
+	errs = append(errs, c.Redis.validate("redis")...)
+
+	if _, port, err := net.SplitHostPort(c.HTTPServer.Address); err != nil {
+		add("http_server.address: must be host:port, got %q", c.HTTPServer.Address)
+	} else if err = validatePort(port); err != nil {
+		add("http_server.address: %v", err)
+	}
+	if c.HTTPServer.Timeout <= 0 {
+		add("http_server.timeout: must be positive, got %s", c.HTTPServer.Timeout)
+	}
+	if c.HTTPServer.IdleTimeout <= 0 {
+		add("http_server.idle_timeout: must be positive, got %s", c.HTTPServer.IdleTimeout)
+	}
+	if c.HTTPServer.RequestTimeout < 0 {
+		add("http_server.request_timeout: must not be negative, got %s", c.HTTPServer.RequestTimeout)
+	}
+
+	if c.Clients.SSO.Address == "" {
+		add("clients.sso.address: must not be empty")
+	}
+	if c.Clients.SSO.Timeout <= 0 {
+		add("clients.sso.timeout: must be positive, got %s", c.Clients.SSO.Timeout)
+	}
+	if c.Clients.SSO.Retries < 0 {
+		add("clients.sso.retries: must not be negative, got %d", c.Clients.SSO.Retries)
+	}
+
+	if c.App.Name == "" {
+		add("app.name: must not be empty")
+	}
+	if c.App.Secret == "" {
+		add("app.secret: must be set inline, via APP_SECRET or via app.secret_file / APP_SECRET_FILE")
+	}
+	if c.TokenTTL <= 0 {
+		add("token_ttl: must be positive, got %s", c.TokenTTL)
+	}
+
+	switch c.Redirect.QueryConflict {
+	case "target", "request", "both":
+	default:
+		add("redirect.query_conflict: must be one of target, request, both, got %q", c.Redirect.QueryConflict)
+	}
+	switch c.Redirect.DefaultCode {
+	case 301, 302, 307, 308:
+	default:
+		add("redirect.default_code: must be one of 301, 302, 307, 308, got %d", c.Redirect.DefaultCode)
+	}
+	if c.Redirect.CacheMaxAge < 0 {
+		add("redirect.cache_max_age: must not be negative, got %s", c.Redirect.CacheMaxAge)
+	}
+	if c.Redirect.PermanentMaxAge <= 0 {
+		add("redirect.permanent_max_age: must be positive, got %s", c.Redirect.PermanentMaxAge)
+	}
+	if c.Preview.Countdown < 0 {
+		add("preview.countdown: must not be negative, got %s", c.Preview.Countdown)
+	}
+
+	if c.Webhooks.Enabled {
+		if c.Webhooks.PollInterval <= 0 {
+			add("webhooks.poll_interval: must be positive, got %s", c.Webhooks.PollInterval)
+		}
+		if c.Webhooks.Timeout <= 0 {
+			add("webhooks.timeout: must be positive, got %s", c.Webhooks.Timeout)
+		}
+		if c.Webhooks.MaxAttempts <= 0 {
+			add("webhooks.max_attempts: must be positive, got %d", c.Webhooks.MaxAttempts)
+		}
+		if c.Webhooks.MinBackoff <= 0 || c.Webhooks.MaxBackoff < c.Webhooks.MinBackoff {
+			add("webhooks: want 0 < min_backoff <= max_backoff, got %s and %s", c.Webhooks.MinBackoff, c.Webhooks.MaxBackoff)
+		}
+		if c.Webhooks.BatchSize <= 0 {
+			add("webhooks.batch_size: must be positive, got %d", c.Webhooks.BatchSize)
+		}
+		if c.Webhooks.Workers <= 0 {
+			add("webhooks.workers: must be positive, got %d", c.Webhooks.Workers)
+		}
+	}
+
+	if c.LinkCheck.Enabled {
+		lc := c.LinkCheck
+		if lc.Interval <= 0 || lc.PollInterval <= 0 || lc.Timeout <= 0 {
+			add("link_check: interval, poll_interval and timeout must be positive, got %s, %s and %s", lc.Interval, lc.PollInterval, lc.Timeout)
+		}
+		if lc.BatchSize <= 0 || lc.Workers <= 0 || lc.PerHost <= 0 || lc.FailureThreshold <= 0 {
+			add("link_check: batch_size, workers, per_host and failure_threshold must be positive, got %d, %d, %d and %d",
+				lc.BatchSize, lc.Workers, lc.PerHost, lc.FailureThreshold)
+		}
+		if lc.HostDelay < 0 {
+			add("link_check.host_delay: must not be negative, got %s", lc.HostDelay)
+		}
+	}
+
+	if c.Quota.Links < 0 || c.Quota.LinksPerDay < 0 || c.Quota.CustomAliasesPerMonth < 0 {
+		add("quota: links, links_per_day and custom_aliases_per_month must not be negative, got %d, %d and %d",
+			c.Quota.Links, c.Quota.LinksPerDay, c.Quota.CustomAliasesPerMonth)
+	}
+
+	if c.Reports.PerHour <= 0 {
+		add("reports.per_hour: must be positive, got %d", c.Reports.PerHour)
+	}
+	if c.Reports.AutoDisableAfter < 0 {
+		add("reports.auto_disable_after: must not be negative, got %d", c.Reports.AutoDisableAfter)
+	}
+	if c.Reports.AutoDisableAfter > 0 && c.Reports.AutoDisableFor <= 0 {
+		add("reports.auto_disable_for: must be positive, got %s", c.Reports.AutoDisableFor)
+	}
+
+	if c.Cache.Size < 0 {
+		add("cache.size: must not be negative, got %d", c.Cache.Size)
+	}
+	if c.Cache.Size > 0 && c.Cache.TTL <= 0 {
+		add("cache.ttl: must be positive, got %s", c.Cache.TTL)
+	}
+
+	if c.Idempotency.TTL <= 0 || c.Idempotency.LockTTL <= 0 {
+		add("idempotency: ttl and lock_ttl must be positive, got %s and %s", c.Idempotency.TTL, c.Idempotency.LockTTL)
+	}
+	if c.Idempotency.Wait < 0 {
+		add("idempotency.wait: must not be negative, got %s", c.Idempotency.Wait)
+	}
+
+	if c.SignedLinks.Enabled {
+		if len(c.SignedLinks.Keys) == 0 {
+			add("signed_links.keys: must not be empty")
+		}
+		ids := make(map[string]bool)
+		for i, k := range c.SignedLinks.Keys {
+			if k.ID == "" || ids[k.ID] {
+				add("signed_links.keys[%d].id: must be set and unique, got %q", i, k.ID)
+			}
+			ids[k.ID] = true
+			if len(k.Secret) < minSigningKeyLength {
+				add("signed_links.keys[%d].secret: must be at least %d bytes, got %d", i, minSigningKeyLength, len(k.Secret))
+			}
+		}
+		if c.SignedLinks.MaxTTL <= 0 || c.SignedLinks.DefaultTTL <= 0 || c.SignedLinks.DefaultTTL > c.SignedLinks.MaxTTL {
+			add("signed_links: default_ttl must be positive and at most max_ttl, got %s and %s", c.SignedLinks.DefaultTTL, c.SignedLinks.MaxTTL)
+		}
+	}
+
+	if c.Degraded.MaxQueued < 0 {
+		add("degraded.max_queued: must not be negative, got %d", c.Degraded.MaxQueued)
+	}
+	if c.Degraded.QueueCreations && (c.Degraded.ReplayInterval <= 0 || c.Degraded.ReplayBatch <= 0) {
+		add("degraded: replay_interval and replay_batch must be positive, got %s and %d", c.Degraded.ReplayInterval, c.Degraded.ReplayBatch)
+	}
+
+	if c.Tracing.Enabled {
+		switch c.Tracing.Exporter {
+		case "otlp":
+			if c.Tracing.Endpoint == "" {
+				add("tracing.endpoint: must not be empty")
+			}
+		case "stdout":
+		case "file":
+			if c.Tracing.File == "" {
+				add("tracing.file: must not be empty")
+			}
+		default:
+			add("tracing.exporter: must be otlp, stdout or file, got %q", c.Tracing.Exporter)
+		}
+		if c.Tracing.SampleRatio < 0 || c.Tracing.SampleRatio > 1 {
+			add("tracing.sample_ratio: must be between 0 and 1, got %v", c.Tracing.SampleRatio)
+		}
+	}
+
+	errs = append(errs, c.validateReloadable()...)
+
+	return errors.Join(errs...)
+}
+
+// validateReloadable checks the settings that can change on SIGHUP.
+func (c *Config) validateReloadable() []error {
+	var errs []error
+	if c.Log.Level != "" {
+		if _, err := ParseLevel(c.Log.Level); err != nil {
+			errs = append(errs, fmt.Errorf("log.level: %w", err))
+		}
+	}
+	if c.RateLimit.Enabled {
+		if c.RateLimit.RPS <= 0 {
+			errs = append(errs, fmt.Errorf("rate_limit.rps: must be positive, got %g", c.RateLimit.RPS))
+		}
+		if c.RateLimit.Burst <= 0 {
+			errs = append(errs, fmt.Errorf("rate_limit.burst: must be positive, got %d", c.RateLimit.Burst))
+		}
+	}
+	for i, d := range c.Blocklist.Domains {
+		if d == "" || strings.ContainsAny(d, "/: ") {
+			errs = append(errs, fmt.Errorf("blocklist.domains[%d]: %q is not a domain name", i, d))
+		}
+	}
+	return errs
+}
+
+// RestartRequired reports whether next differs from c in anything that is not reloadable.
+func (c *Config) RestartRequired(next *Config) bool {
+	a, b := *c, *next
+	a.Log, b.Log = Log{}, Log{}
+	a.RateLimit, b.RateLimit = RateLimit{}, RateLimit{}
+	a.Blocklist, b.Blocklist = Blocklist{}, Blocklist{}
+	a.SignedLinks.Keys, b.SignedLinks.Keys = nil, nil
+	a.App.ID, b.App.ID = 0, 0
+	return !reflect.DeepEqual(a, b)
+}
+
+// ParseLevel parses a slog level name such as "debug" or "warn".
+func ParseLevel(s string) (slog.Level, error) {
+	var l slog.Level
+	if err := l.UnmarshalText([]byte(s)); err != nil {
+		return 0, fmt.Errorf("unknown level %q, want debug, info, warn or error", s)
+	}
+	return l, nil
 }
 
 type Storage struct {
-	Host     string `yaml:"host" env-required:"true"`
-	Port     int    `yaml:"port" env-required:"true"`
-	User     string `yaml:"user" env-required:"true"`
-	Password string `yaml:"password" env-required:"true"`
-	DBName   string `yaml:"dbname" env-required:"true"`
-	SSLMode  string `yaml:"sslmode" env-default:"enable"`
+	Host         string `yaml:"host" env-required:"true"`
+	Port         int    `yaml:"port" env-required:"true"`
+	User         string `yaml:"user" env-required:"true"`
+	Password     string `yaml:"password"`
+	PasswordFile string `yaml:"password_file" env:"POSTGRES_PASSWORD_FILE"`
+	DBName       string `yaml:"dbname" env-required:"true"`
+	SSLMode      string `yaml:"sslmode" env-default:"require"`
+
+	Pool Pool `yaml:"pool"`
+	// Replicas serve read-only queries such as redirect lookups and listings.
+	// They use the primary's credentials, database and sslmode.
+	Replicas []Replica `yaml:"replicas"`
+	// HealthCheckInterval is how often the primary and the replicas are checked.
+	HealthCheckInterval time.Duration `yaml:"health_check_interval" env-default:"5s"`
+	// MaxReplicaLag takes replicas further behind the primary out of rotation.
+	MaxReplicaLag time.Duration `yaml:"max_replica_lag" env-default:"5s"`
+	// ReadYourWrites is how long reads about something this instance just
+	// wrote go to the primary instead of a replica.
+	ReadYourWrites time.Duration `yaml:"read_your_writes" env-default:"10s"`
+	// QueryTimeout bounds every query, including the transactions of writes.
+	QueryTimeout time.Duration `yaml:"query_timeout" env-default:"3s"`
+	Breaker      Breaker       `yaml:"breaker"`
+}
+
+// Breaker stops sending queries to a primary that can't be reached, so that
+// writes fail fast with 503 while redirects are served from the cache.
+type Breaker struct {
+	// FailureThreshold is how many round trips in a row have to fail to
+	// reach the primary before it is considered down, 0 never does.
+	FailureThreshold int `yaml:"failure_threshold" env-default:"5"`
+	// OpenFor is how long the primary is left alone before it is tried again.
+	OpenFor time.Duration `yaml:"open_for" env-default:"10s"`
+}
+
+type Replica struct {
+	Host string `yaml:"host"`
+	Port int    `yaml:"port"`
+}
+
+// Pool applies to the primary and to every replica.
+type Pool struct {
+	MaxOpenConns    int           `yaml:"max_open_conns" env-default:"25"`
+	MaxIdleConns    int           `yaml:"max_idle_conns" env-default:"25"`
+	ConnMaxLifetime time.Duration `yaml:"conn_max_lifetime" env-default:"30m"`
+	ConnMaxIdleTime time.Duration `yaml:"conn_max_idle_time" env-default:"5m"`
+}
+
+func (s *Storage) validate(prefix string) []error {
+	var errs []error
+	if s.Host == "" {
+		errs = append(errs, fmt.Errorf("%s.host: must not be empty", prefix))
+	}
+	if s.Port <= 0 || s.Port > 65535 {
+		errs = append(errs, fmt.Errorf("%s.port: must be between 1 and 65535, got %d", prefix, s.Port))
+	}
+	if s.User == "" {
+		errs = append(errs, fmt.Errorf("%s.user: must not be empty", prefix))
+	}
+	if s.DBName == "" {
+		errs = append(errs, fmt.Errorf("%s.dbname: must not be empty", prefix))
+	}
+	switch s.SSLMode {
+	case "disable", "allow", "prefer", "require", "verify-ca", "verify-full":
+	default:
+		errs = append(errs, fmt.Errorf("%s.sslmode: unknown mode %q", prefix, s.SSLMode))
+	}
+	if s.Pool.MaxOpenConns < 0 {
+		errs = append(errs, fmt.Errorf("%s.pool.max_open_conns: must not be negative, got %d", prefix, s.Pool.MaxOpenConns))
+	}
+	if s.Pool.MaxIdleConns < 0 {
+		errs = append(errs, fmt.Errorf("%s.pool.max_idle_conns: must not be negative, got %d", prefix, s.Pool.MaxIdleConns))
+	}
+	if s.Pool.ConnMaxLifetime < 0 || s.Pool.ConnMaxIdleTime < 0 {
+		errs = append(errs, fmt.Errorf("%s.pool: connection lifetimes must not be negative", prefix))
+	}
+	for i, r := range s.Replicas {
+		if r.Host == "" {
+			errs = append(errs, fmt.Errorf("%s.replicas[%d].host: must not be empty", prefix, i))
+		}
+		if r.Port <= 0 || r.Port > 65535 {
+			errs = append(errs, fmt.Errorf("%s.replicas[%d].port: must be between 1 and 65535, got %d", prefix, i, r.Port))
+		}
+	}
+	if s.HealthCheckInterval <= 0 {
+		errs = append(errs, fmt.Errorf("%s.health_check_interval: must be positive, got %s", prefix, s.HealthCheckInterval))
+	}
+	if len(s.Replicas) > 0 {
+		if s.MaxReplicaLag <= 0 {
+			errs = append(errs, fmt.Errorf("%s.max_replica_lag: must be positive, got %s", prefix, s.MaxReplicaLag))
+		}
+		if s.ReadYourWrites < 0 {
+			errs = append(errs, fmt.Errorf("%s.read_your_writes: must not be negative, got %s", prefix, s.ReadYourWrites))
+		}
+	}
+	if s.QueryTimeout < 0 {
+		errs = append(errs, fmt.Errorf("%s.query_timeout: must not be negative, got %s", prefix, s.QueryTimeout))
+	}
+	if s.Breaker.FailureThreshold < 0 {
+		errs = append(errs, fmt.Errorf("%s.breaker.failure_threshold: must not be negative, got %d", prefix, s.Breaker.FailureThreshold))
+	}
+	if s.Breaker.FailureThreshold > 0 && s.Breaker.OpenFor <= 0 {
+		errs = append(errs, fmt.Errorf("%s.breaker.open_for: must be positive, got %s", prefix, s.Breaker.OpenFor))
+	}
+	return errs
+}
+
+func (r *RedisStorage) validate(prefix string) []error {
+	var errs []error
+	add := func(format string, args ...any) {
+		errs = append(errs, fmt.Errorf(prefix+"."+format, args...))
+	}
+
+	switch r.Mode {
+	case "standalone":
+		if r.Host == "" {
+			add("host: must not be empty")
+		}
+		if err := validatePort(r.Port); err != nil {
+			add("port: %v", err)
+		}
+	case "sentinel":
+		if r.MasterName == "" {
+			add("master_name: must not be empty in sentinel mode")
+		}
+		if len(r.Addrs) == 0 {
+			add("addrs: must list the sentinels in sentinel mode")
+		}
+	case "cluster":
+		if len(r.Addrs) == 0 {
+			add("addrs: must list at least one node in cluster mode")
+		}
+		if r.DB != 0 {
+			add("db: must be 0 in cluster mode, got %d", r.DB)
+		}
+	default:
+		add("mode: must be one of standalone, sentinel, cluster, got %q", r.Mode)
+	}
+	for i, a := range r.Addrs {
+		if _, port, err := net.SplitHostPort(a); err != nil {
+			add("addrs[%d]: must be host:port, got %q", i, a)
+		} else if err = validatePort(port); err != nil {
+			add("addrs[%d]: %v", i, err)
+		}
+	}
+
+	if r.DB < 0 {
+		add("db: must not be negative, got %d", r.DB)
+	}
+	if r.PoolSize <= 0 {
+		add("pool_size: must be positive, got %d", r.PoolSize)
+	}
+	if r.DialTimeout <= 0 {
+		add("dial_timeout: must be positive, got %s", r.DialTimeout)
+	}
+	if r.ReadTimeout < 0 {
+		add("read_timeout: must not be negative, got %s", r.ReadTimeout)
+	}
+	if r.WriteTimeout < 0 {
+		add("write_timeout: must not be negative, got %s", r.WriteTimeout)
+	}
+	if r.CommandTimeout < 0 {
+		add("command_timeout: must not be negative, got %s", r.CommandTimeout)
+	}
+	if r.LinkTTL <= 0 {
+		add("link_ttl: must be positive, got %s", r.LinkTTL)
+	}
+	if (r.TLS.CertFile == "") != (r.TLS.KeyFile == "") {
+		add("tls: cert_file and key_file must be set together")
+	}
+	if !r.TLS.Enabled && (r.TLS.CAFile != "" || r.TLS.CertFile != "") {
+		add("tls: ca_file and cert_file require tls.enabled")
+	}
+	return errs
+}
+
+func validatePort(port string) error {
+	p, err := strconv.Atoi(port)
+	if err != nil || p <= 0 || p > 65535 {
+		return fmt.Errorf("must be a port between 1 and 65535, got %q", port)
+	}
+	return nil
 }
 
 type MigrationConfig struct {
@@ -90,7 +719,7 @@ type GRPCServer struct {
 }
 
 func MustLoadMigration() *MigrationConfig {
-	path := fetchConfigPath()
+	path := FetchPath()
 	if path == "" {
 		panic("config path is empty")
 	}
@@ -105,28 +734,91 @@ func MustLoadMigrationByPath(path string) *MigrationConfig {
 	if err := cleanenv.ReadConfig(path, &res); err != nil {
 		panic(err)
 	}
+	if err := readSecretFile(&res.Storage.Password, res.Storage.PasswordFile); err != nil {
+		panic(fmt.Errorf("storage.password_file: %w", err))
+	}
+	if errs := res.Storage.validate("storage"); len(errs) > 0 {
+		panic(errors.Join(errs...))
+	}
 	return &res
 }
 
 func MustLoadByPath(path string) *Config {
+	res, err := Load(path)
+	if err != nil {
+		panic(err)
+	}
+	return res
+}
+
+// Load reads the config at path, resolves *_FILE secrets and validates the result.
+func Load(path string) (*Config, error) {
 	if _, err := os.Stat(path); os.IsNotExist(err) {
-		panic("config file doesn't exist " + path)
+		return nil, errors.New("config file doesn't exist " + path)
 	}
 	var res Config
 	if err := cleanenv.ReadConfig(path, &res); err != nil {
-		panic(err)
+		return nil, err
 	}
-	return &res
+	if err := res.readSecretFiles(); err != nil {
+		return nil, err
+	}
+	if err := res.validate(); err != nil {
+		return nil, fmt.Errorf("invalid config %s:\n%w", path, err)
+	}
+	return &res, nil
+}
+
+func (c *Config) readSecretFiles() error {
+	return errors.Join(
+		prefixErr("app.secret_file", readSecretFile(&c.App.Secret, c.App.SecretFile)),
+		prefixErr("postgres.password_file", readSecretFile(&c.Storage.Password, c.Storage.PasswordFile)),
+		prefixErr("redis.password_file", readSecretFile(&c.Redis.Password, c.Redis.PasswordFile)),
+		c.readSigningKeys(),
+	)
+}
+
+func (c *Config) readSigningKeys() error {
+	var errs []error
+	for i := range c.SignedLinks.Keys {
+		k := &c.SignedLinks.Keys[i]
+		errs = append(errs, prefixErr(fmt.Sprintf("signed_links.keys[%d].secret_file", i), readSecretFile(&k.Secret, k.SecretFile)))
+	}
+	return errors.Join(errs...)
+}
+
+// readSecretFile replaces *dst with the contents of path, if path is set.
+// Trailing newlines are trimmed, since most tools that write secrets add one.
+func readSecretFile(dst *string, path string) error {
+	if path == "" {
+		return nil
+	}
+	b, err := os.ReadFile(path)
+	if err != nil {
+		return err
+	}
+	*dst = strings.TrimRight(string(b), "\r\n")
+	return nil
 }
 
-// get config path from flag or env.
+func prefixErr(prefix string, err error) error {
+	if err == nil {
+		return nil
+	}
+	return fmt.Errorf("%s: %w", prefix, err)
+}
+
+// FetchPath gets config path from flag or env.
 // prioritize flag over env over default
 // default: empty string
-func fetchConfigPath() string {
-	var res string
-	flag.StringVar(&res, "config", "", "path to config file")
-	flag.Parse()
-	if res != "" {
+func FetchPath() string {
+	if flag.Lookup("config") == nil {
+		flag.String("config", "", "path to config file")
+	}
+	if !flag.Parsed() {
+		flag.Parse()
+	}
+	if res := flag.Lookup("config").Value.String(); res != "" {
 		return res
 	}
 	env := os.Getenv("CONFIG_PATH")
@@ -0,0 +1,117 @@
+package config
+
+import (
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+const validConfig = `
+env: "local"
+postgres:
+    host: "localhost"
+    port: 5432
+    user: "postgres"
+    password: "password"
+    dbname: "database"
+    sslmode: "disable"
+redis:
+    host: "localhost"
+    port: 6379
+http_server:
+    address: "localhost:8085"
+clients:
+    sso:
+        address: "localhost:42042"
+        timeout: 5s
+        retries: 5
+app:
+    name: "url-shortener"
+    secret: "secret"
+token_ttl: 1h
+`
+
+func writeFile(t *testing.T, name, content string) string {
+	t.Helper()
+	path := filepath.Join(t.TempDir(), name)
+	require.NoError(t, os.WriteFile(path, []byte(content), 0o600))
+	return path
+}
+
+func TestLoad(t *testing.T) {
+	cfg, err := Load(writeFile(t, "config.yaml", validConfig))
+	require.NoError(t, err)
+
+	assert.Equal(t, "localhost:8085", cfg.HTTPServer.Address)
+	assert.Equal(t, "5s", cfg.HTTPServer.Timeout.String())
+	assert.Equal(t, "1m0s", cfg.HTTPServer.IdleTimeout.String())
+}
+
+func TestLoadAggregatesErrors(t *testing.T) {
+	broken := strings.NewReplacer(
+		`env: "local"`, `env: "staging"`,
+		`sslmode: "disable"`, `sslmode: "sometimes"`,
+		`secret: "secret"`, `secret: ""`,
+	).Replace(validConfig)
+
+	_, err := Load(writeFile(t, "config.yaml", broken))
+	require.Error(t, err)
+
+	msg := err.Error()
+	assert.Contains(t, msg, "env: must be one of local, dev, prod")
+	assert.Contains(t, msg, `postgres.sslmode: unknown mode "sometimes"`)
+	assert.Contains(t, msg, "app.secret: must be set")
+}
+
+func TestLoadSecretFiles(t *testing.T) {
+	secret := writeFile(t, "secret", "from-file\n")
+	password := writeFile(t, "password", "pg-password")
+	withFiles := strings.NewReplacer(
+		`secret: "secret"`, `secret_file: "`+secret+`"`,
+		`password: "password"`, `password_file: "`+password+`"`,
+	).Replace(validConfig)
+
+	cfg, err := Load(writeFile(t, "config.yaml", withFiles))
+	require.NoError(t, err)
+
+	assert.Equal(t, "from-file", cfg.App.Secret)
+	assert.Equal(t, "pg-password", cfg.Storage.Password)
+}
+
+func TestRestartRequired(t *testing.T) {
+	cfg, err := Load(writeFile(t, "config.yaml", validConfig))
+	require.NoError(t, err)
+
+	next := *cfg
+	next.Log.Level = "warn"
+	next.Blocklist.Domains = []string{"example.com"}
+	next.SignedLinks.Keys = []SigningKey{{ID: "2025-07", Secret: "a new key"}}
+	assert.False(t, cfg.RestartRequired(&next))
+
+	next.HTTPServer.Address = "localhost:9090"
+	assert.True(t, cfg.RestartRequired(&next))
+}
+
+func TestRedisModes(t *testing.T) {
+	sentinel := strings.NewReplacer(
+		"    host: \"localhost\"\n    port: 6379", "    mode: \"sentinel\"\n    addrs: [\"sentinel-1:26379\", \"sentinel-2\"]",
+	).Replace(validConfig)
+
+	_, err := Load(writeFile(t, "config.yaml", sentinel))
+	require.Error(t, err)
+	assert.Contains(t, err.Error(), "redis.master_name: must not be empty in sentinel mode")
+	assert.Contains(t, err.Error(), `redis.addrs[1]: must be host:port, got "sentinel-2"`)
+
+	cluster := strings.NewReplacer(
+		"    host: \"localhost\"\n    port: 6379", "    mode: \"cluster\"\n    addrs: [\"node-1:6379\"]\n    key_prefix: \"staging:\"",
+	).Replace(validConfig)
+
+	cfg, err := Load(writeFile(t, "config.yaml", cluster))
+	require.NoError(t, err)
+	assert.Equal(t, "staging:", cfg.Redis.KeyPrefix)
+	assert.Equal(t, "5s", cfg.Redis.DialTimeout.String())
+}
@@ -0,0 +1,164 @@
+package domains
+
+import (
+	"context"
+	"errors"
+	"github.com/go-chi/chi/v5"
+	"github.com/go-chi/chi/v5/middleware"
+	"github.com/go-chi/render"
+	"github.com/go-playground/validator/v10"
+	"github.com/kxddry/url-shortener/internal/lib/api/request"
+	resp "github.com/kxddry/url-shortener/internal/lib/api/response"
+	"github.com/kxddry/url-shortener/internal/lib/domains"
+	"github.com/kxddry/url-shortener/internal/lib/logger/sl"
+	"github.com/kxddry/url-shortener/internal/storage"
+	"io"
+	"log/slog"
+	"net/http"
+	"strconv"
+)
+
+type Request struct {
+	Host        string `json:"host" validate:"required,hostname"`
+	FallbackURL string `json:"fallback_url,omitempty" validate:"omitempty,url"`
+	// Interstitial warns before every redirect of the domain to an external destination.
+	Interstitial bool `json:"interstitial,omitempty"`
+}
+
+type Response struct {
+	resp.Response
+	Domain  *storage.Domain  `json:"domain,omitempty"`
+	Domains []storage.Domain `json:"domains,omitempty"`
+}
+
+type DomainSaver interface {
+	SaveDomain(ctx context.Context, d storage.Domain) (int64, error)
+}
+
+type DomainLister interface {
+	Domains(ctx context.Context) ([]storage.Domain, error)
+}
+
+type DomainDeleter interface {
+	DeleteDomain(ctx context.Context, id int64) error
+}
+
+type Refresher interface {
+	Refresh(ctx context.Context) error
+}
+
+func Save(log *slog.Logger, store DomainSaver, registry Refresher) http.HandlerFunc {
+	return func(w http.ResponseWriter, r *http.Request) {
+		const op = "handlers.domains.Save"
+
+		log := log.With(
+			slog.String("op", op),
+			slog.String("request_id", middleware.GetReqID(r.Context())))
+
+		var req Request
+		if err := render.DecodeJSON(r.Body, &req); err != nil {
+			if errors.Is(err, io.EOF) {
+				log.Info("request body is empty")
+				resp.Fail(w, r, http.StatusBadRequest, resp.CodeInvalidBody, "request body is empty")
+				return
+			}
+			log.Error("failed to decode request", sl.Err(err))
+			resp.Fail(w, r, http.StatusBadRequest, resp.CodeInvalidBody, "failed to decode request")
+			return
+		}
+
+		req.Host = domains.Host(req.Host)
+		if err := request.Validate(req); err != nil {
+			validateErr := err.(validator.ValidationErrors)
+			log.Info("invalid request", sl.Err(err))
+			resp.FailValidation(w, r, validateErr)
+			return
+		}
+
+		d := storage.Domain{Host: req.Host, FallbackURL: req.FallbackURL, Interstitial: req.Interstitial}
+		id, err := store.SaveDomain(r.Context(), d)
+		if errors.Is(err, storage.ErrDomainExists) {
+			log.Info("domain already exists", slog.String("host", req.Host))
+			resp.FailError(w, r, err)
+			return
+		}
+		if err != nil {
+			log.Error("failed to save domain", sl.Err(err))
+			resp.FailError(w, r, err)
+			return
+		}
+
+		if err = registry.Refresh(r.Context()); err != nil {
+			log.Error("failed to refresh domains", sl.Err(err))
+		}
+
+		log.Info("domain saved", slog.Int64("id", id), slog.String("host", req.Host))
+		d.ID = id
+		w.WriteHeader(http.StatusCreated)
+		render.JSON(w, r, Response{
+			Response: resp.OK(),
+			Domain:   &d,
+		})
+	}
+}
+
+func List(log *slog.Logger, store DomainLister) http.HandlerFunc {
+	return func(w http.ResponseWriter, r *http.Request) {
+		const op = "handlers.domains.List"
+
+		log := log.With(
+			slog.String("op", op),
+			slog.String("request_id", middleware.GetReqID(r.Context())))
+
+		list, err := store.Domains(r.Context())
+		if err != nil {
+			log.Error("failed to list domains", sl.Err(err))
+			resp.FailError(w, r, err)
+			return
+		}
+
+		render.JSON(w, r, Response{
+			Response: resp.OK(),
+			Domains:  list,
+		})
+	}
+}
+
+func Delete(log *slog.Logger, store DomainDeleter, registry Refresher) http.HandlerFunc {
+	return func(w http.ResponseWriter, r *http.Request) {
+		const op = "handlers.domains.Delete"
+
+		log := log.With(
+			slog.String("op", op),
+			slog.String("request_id", middleware.GetReqID(r.Context())))
+
+		id, err := strconv.ParseInt(chi.URLParam(r, "id"), 10, 64)
+		if err != nil {
+			log.Info("invalid domain id", sl.Err(err))
+			resp.Fail(w, r, http.StatusBadRequest, resp.CodeBadRequest, "invalid domain id")
+			return
+		}
+
+		err = store.DeleteDomain(r.Context(), id)
+		switch {
+		case errors.Is(err, storage.ErrDomainNotFound):
+			resp.FailError(w, r, err)
+			return
+		case errors.Is(err, storage.ErrDomainInUse):
+			resp.FailError(w, r, err)
+			return
+		case err != nil:
+			log.Error("failed to delete domain", sl.Err(err))
+			resp.FailError(w, r, err)
+			return
+		}
+
+		if err = registry.Refresh(r.Context()); err != nil {
+			log.Error("failed to refresh domains", sl.Err(err))
+		}
+
+		log.Info("domain deleted", slog.Int64("id", id))
+		w.WriteHeader(http.StatusAccepted)
+		render.JSON(w, r, resp.OK())
+	}
+}
@@ -0,0 +1,104 @@
+package health
+
+import (
+	"context"
+	"github.com/go-chi/chi/v5/middleware"
+	"github.com/go-chi/render"
+	"github.com/kxddry/url-shortener/internal/lib/breaker"
+	"github.com/kxddry/url-shortener/internal/lib/logger/sl"
+	"log/slog"
+	"net/http"
+	"time"
+)
+
+const (
+	StatusOK = "ok"
+	// StatusDegraded means that redirects are served but something else isn't:
+	// while Postgres is down, links can't be created or changed.
+	StatusDegraded    = "degraded"
+	StatusUnavailable = "unavailable"
+)
+
+type Response struct {
+	Status   string    `json:"status"`
+	Postgres Component `json:"postgres"`
+	Redis    Component `json:"redis"`
+	// QueuedCreations is how many links wait for Postgres, with degraded.queue_creations.
+	QueuedCreations *int64 `json:"queued_creations,omitempty"`
+}
+
+type Component struct {
+	Status string `json:"status"`
+	// Breaker is the state of the circuit breaker of Postgres.
+	Breaker string `json:"breaker,omitempty"`
+	// RetryAfter is when Postgres is tried again, in seconds.
+	RetryAfter int    `json:"retry_after,omitempty"`
+	Error      string `json:"error,omitempty"`
+}
+
+// Primary is the circuit breaker of Postgres.
+type Primary interface {
+	State() breaker.State
+	RetryAfter() time.Duration
+}
+
+type Pinger interface {
+	Ping(ctx context.Context) error
+}
+
+type Queue interface {
+	QueuedCreations(ctx context.Context) (int64, error)
+}
+
+// Live answers as long as the process serves requests.
+func Live() http.HandlerFunc {
+	return func(w http.ResponseWriter, r *http.Request) {
+		render.JSON(w, r, map[string]string{"status": StatusOK})
+	}
+}
+
+// Ready reports whether the instance can serve traffic. It is ready while
+// it can redirect, that is while Redis or Postgres is up, and degraded
+// unless both are. queue may be nil if creations aren't queued.
+func Ready(log *slog.Logger, primary Primary, cache Pinger, queue Queue) http.HandlerFunc {
+	return func(w http.ResponseWriter, r *http.Request) {
+		const op = "handlers.health.Ready"
+
+		log := log.With(
+			slog.String("op", op),
+			slog.String("request_id", middleware.GetReqID(r.Context())))
+
+		var res Response
+		state := primary.State()
+		res.Postgres = Component{Status: StatusOK, Breaker: state.String()}
+		if state != breaker.Closed {
+			res.Postgres.Status = StatusUnavailable
+			res.Postgres.RetryAfter = int(primary.RetryAfter().Seconds())
+		}
+
+		res.Redis = Component{Status: StatusOK}
+		if err := cache.Ping(r.Context()); err != nil {
+			log.Warn("redis is unreachable", sl.Err(err))
+			res.Redis = Component{Status: StatusUnavailable, Error: "unreachable"}
+		} else if queue != nil {
+			n, err := queue.QueuedCreations(r.Context())
+			if err != nil {
+				log.Warn("failed to count queued links", sl.Err(err))
+			} else {
+				res.QueuedCreations = &n
+			}
+		}
+
+		w.Header().Set("Cache-Control", "no-store")
+		switch {
+		case res.Postgres.Status == StatusOK && res.Redis.Status == StatusOK:
+			res.Status = StatusOK
+		case res.Postgres.Status == StatusOK || res.Redis.Status == StatusOK:
+			res.Status = StatusDegraded
+		default:
+			res.Status = StatusUnavailable
+			w.WriteHeader(http.StatusServiceUnavailable)
+		}
+		render.JSON(w, r, res)
+	}
+}
@@ -0,0 +1,73 @@
+package health
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"io"
+	"log/slog"
+	"net/http"
+	"net/http/httptest"
+	"testing"
+	"time"
+
+	"github.com/kxddry/url-shortener/internal/lib/breaker"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+type fakePrimary struct{ state breaker.State }
+
+func (p fakePrimary) State() breaker.State { return p.state }
+
+func (p fakePrimary) RetryAfter() time.Duration {
+	if p.state == breaker.Open {
+		return 7 * time.Second
+	}
+	return 0
+}
+
+type fakePinger struct{ err error }
+
+func (p fakePinger) Ping(context.Context) error { return p.err }
+
+type fakeQueue struct{}
+
+func (fakeQueue) QueuedCreations(context.Context) (int64, error) { return 3, nil }
+
+func TestReady(t *testing.T) {
+	log := slog.New(slog.NewTextHandler(io.Discard, nil))
+	down := errors.New("connection refused")
+	tests := []struct {
+		name     string
+		primary  breaker.State
+		redisErr error
+		code     int
+		status   string
+	}{
+		{"up", breaker.Closed, nil, http.StatusOK, StatusOK},
+		{"postgres down", breaker.Open, nil, http.StatusOK, StatusDegraded},
+		{"redis down", breaker.Closed, down, http.StatusOK, StatusDegraded},
+		{"both down", breaker.HalfOpen, down, http.StatusServiceUnavailable, StatusUnavailable},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			w := httptest.NewRecorder()
+			Ready(log, fakePrimary{tt.primary}, fakePinger{tt.redisErr}, fakeQueue{}).
+				ServeHTTP(w, httptest.NewRequest(http.MethodGet, "/readyz", nil))
+
+			assert.Equal(t, tt.code, w.Code)
+			var res Response
+			require.NoError(t, json.NewDecoder(w.Body).Decode(&res))
+			assert.Equal(t, tt.status, res.Status)
+			assert.Equal(t, tt.primary.String(), res.Postgres.Breaker)
+			if tt.primary == breaker.Open {
+				assert.Equal(t, 7, res.Postgres.RetryAfter)
+			}
+			if tt.redisErr == nil {
+				require.NotNil(t, res.QueuedCreations)
+				assert.Equal(t, int64(3), *res.QueuedCreations)
+			}
+		})
+	}
+}
@@ -0,0 +1,236 @@
+package me
+
+import (
+	"context"
+	"errors"
+	"github.com/go-chi/chi/v5"
+	"github.com/go-chi/chi/v5/middleware"
+	"github.com/go-chi/render"
+	"github.com/kxddry/url-shortener/internal/config"
+	"github.com/kxddry/url-shortener/internal/http-server/middleware/auth"
+	resp "github.com/kxddry/url-shortener/internal/lib/api/response"
+	"github.com/kxddry/url-shortener/internal/lib/logger/sl"
+	"github.com/kxddry/url-shortener/internal/storage"
+	"log/slog"
+	"net/http"
+	"strconv"
+	"strings"
+)
+
+const (
+	defaultLimit = 50
+	maxLimit     = 500
+)
+
+type LinksResponse struct {
+	resp.Response
+	Links []storage.Link `json:"links"`
+}
+
+type TagsResponse struct {
+	resp.Response
+	Tags []storage.TagCount `json:"tags"`
+}
+
+type UsageResponse struct {
+	resp.Response
+	Quota storage.Quota `json:"quota"`
+	Usage storage.Usage `json:"usage"`
+}
+
+type QueuedResponse struct {
+	resp.Response
+	Queued storage.QueuedStatus `json:"queued"`
+}
+
+type LinkLister interface {
+	Links(ctx context.Context, uid int64, f storage.LinkFilter) ([]storage.Link, error)
+}
+
+type TagCounter interface {
+	TagCounts(ctx context.Context, uid, workspaceID int64) ([]storage.TagCount, error)
+}
+
+type UsageGetter interface {
+	Usage(ctx context.Context, uid, workspaceID int64, defaults storage.Quota) (storage.Quota, storage.Usage, error)
+}
+
+type QueuedStatusGetter interface {
+	QueuedStatus(ctx context.Context, id string) (storage.QueuedStatus, error)
+}
+
+type Access interface {
+	Member(ctx context.Context, uid, workspaceID int64, min string) (bool, error)
+}
+
+// Links lists the caller's personal links, or those of ?workspace=, newest first.
+// It also accepts ?tag=, ?broken=true for the links whose destination is
+// flagged by the dead-link monitor, ?limit= (default 50, at most 500) and ?offset=.
+func Links(log *slog.Logger, store LinkLister, access Access) http.HandlerFunc {
+	return func(w http.ResponseWriter, r *http.Request) {
+		const op = "handlers.me.Links"
+
+		log := log.With(
+			slog.String("op", op),
+			slog.String("request_id", middleware.GetReqID(r.Context())))
+
+		uid := auth.UID(r.Context())
+		workspaceID, ok := workspace(log, w, r, uid, access)
+		if !ok {
+			return
+		}
+
+		q := r.URL.Query()
+		f := storage.LinkFilter{
+			WorkspaceID: workspaceID,
+			Tag:         strings.ToLower(strings.TrimSpace(q.Get("tag"))),
+			Limit:       defaultLimit,
+		}
+		var err error
+		if v := q.Get("broken"); v != "" {
+			if f.Broken, err = strconv.ParseBool(v); err != nil {
+				resp.Fail(w, r, http.StatusBadRequest, resp.CodeBadRequest, "broken must be true or false")
+				return
+			}
+		}
+		if v := q.Get("limit"); v != "" {
+			if f.Limit, err = strconv.Atoi(v); err != nil || f.Limit < 1 || f.Limit > maxLimit {
+				resp.Fail(w, r, http.StatusBadRequest, resp.CodeBadRequest, "limit must be between 1 and "+strconv.Itoa(maxLimit))
+				return
+			}
+		}
+		if v := q.Get("offset"); v != "" {
+			if f.Offset, err = strconv.Atoi(v); err != nil || f.Offset < 0 {
+				resp.Fail(w, r, http.StatusBadRequest, resp.CodeBadRequest, "offset must be a non-negative number")
+				return
+			}
+		}
+
+		links, err := store.Links(r.Context(), uid, f)
+		if err != nil {
+			log.Error("failed to list links", sl.Err(err))
+			resp.FailError(w, r, err)
+			return
+		}
+
+		render.JSON(w, r, LinksResponse{
+			Response: resp.OK(),
+			Links:    links,
+		})
+	}
+}
+
+// Tags returns the tag cloud of the links listed by Links: every tag used and how many links carry it.
+func Tags(log *slog.Logger, store TagCounter, access Access) http.HandlerFunc {
+	return func(w http.ResponseWriter, r *http.Request) {
+		const op = "handlers.me.Tags"
+
+		log := log.With(
+			slog.String("op", op),
+			slog.String("request_id", middleware.GetReqID(r.Context())))
+
+		uid := auth.UID(r.Context())
+		workspaceID, ok := workspace(log, w, r, uid, access)
+		if !ok {
+			return
+		}
+
+		counts, err := store.TagCounts(r.Context(), uid, workspaceID)
+		if err != nil {
+			log.Error("failed to count tags", sl.Err(err))
+			resp.FailError(w, r, err)
+			return
+		}
+
+		render.JSON(w, r, TagsResponse{
+			Response: resp.OK(),
+			Tags:     counts,
+		})
+	}
+}
+
+// Usage returns the quota of the caller's personal links, or of those of
+// ?workspace=, and how much of it is used.
+func Usage(log *slog.Logger, store UsageGetter, access Access, defaults config.Quota) http.HandlerFunc {
+	return func(w http.ResponseWriter, r *http.Request) {
+		const op = "handlers.me.Usage"
+
+		log := log.With(
+			slog.String("op", op),
+			slog.String("request_id", middleware.GetReqID(r.Context())))
+
+		uid := auth.UID(r.Context())
+		workspaceID, ok := workspace(log, w, r, uid, access)
+		if !ok {
+			return
+		}
+
+		quota, usage, err := store.Usage(r.Context(), uid, workspaceID, storage.Quota(defaults))
+		if err != nil {
+			log.Error("failed to get usage", sl.Err(err))
+			resp.FailError(w, r, err)
+			return
+		}
+
+		render.JSON(w, r, UsageResponse{
+			Response: resp.OK(),
+			Quota:    quota,
+			Usage:    usage,
+		})
+	}
+}
+
+// Queued tells the caller what became of a link they created while the
+// database was unavailable: still queued, created (with the alias it got)
+// or dropped (with the reason).
+func Queued(log *slog.Logger, queue QueuedStatusGetter) http.HandlerFunc {
+	return func(w http.ResponseWriter, r *http.Request) {
+		const op = "handlers.me.Queued"
+
+		log := log.With(
+			slog.String("op", op),
+			slog.String("request_id", middleware.GetReqID(r.Context())))
+
+		uid := auth.UID(r.Context())
+		status, err := queue.QueuedStatus(r.Context(), chi.URLParam(r, "id"))
+		if errors.Is(err, storage.ErrQueuedNotFound) || err == nil && status.CreatedBy != uid {
+			// other users' links aren't told apart from missing ones
+			resp.FailError(w, r, storage.ErrQueuedNotFound)
+			return
+		}
+		if err != nil {
+			log.Error("failed to get queued link", sl.Err(err))
+			resp.FailError(w, r, err)
+			return
+		}
+
+		render.JSON(w, r, QueuedResponse{
+			Response: resp.OK(),
+			Queued:   status,
+		})
+	}
+}
+
+// workspace parses ?workspace= and checks that uid may see the workspace's links.
+func workspace(log *slog.Logger, w http.ResponseWriter, r *http.Request, uid int64, access Access) (int64, bool) {
+	v := r.URL.Query().Get("workspace")
+	if v == "" {
+		return 0, true
+	}
+	id, err := strconv.ParseInt(v, 10, 64)
+	if err != nil || id < 1 {
+		resp.Fail(w, r, http.StatusBadRequest, resp.CodeBadRequest, "invalid workspace id")
+		return 0, false
+	}
+	ok, err := access.Member(r.Context(), uid, id, storage.RoleViewer)
+	if err != nil {
+		log.Error("failed to check membership", sl.Err(err))
+		resp.FailError(w, r, err)
+		return 0, false
+	}
+	if !ok {
+		resp.Fail(w, r, http.StatusForbidden, resp.CodeForbidden, "you are not a member of the workspace")
+		return 0, false
+	}
+	return id, true
+}
@@ -0,0 +1,509 @@
+package moderation
+
+import (
+	"context"
+	"errors"
+	"github.com/go-chi/chi/v5"
+	"github.com/go-chi/chi/v5/middleware"
+	"github.com/go-chi/render"
+	"github.com/go-playground/validator/v10"
+	mwAdmin "github.com/kxddry/url-shortener/internal/http-server/middleware/admin"
+	"github.com/kxddry/url-shortener/internal/lib/api/request"
+	resp "github.com/kxddry/url-shortener/internal/lib/api/response"
+	"github.com/kxddry/url-shortener/internal/lib/blocklist"
+	"github.com/kxddry/url-shortener/internal/lib/logger/sl"
+	"github.com/kxddry/url-shortener/internal/storage"
+	"io"
+	"log/slog"
+	"net/http"
+	"strconv"
+	"strings"
+	"time"
+)
+
+const (
+	defaultLimit = 50
+	maxLimit     = 500
+)
+
+type LinksResponse struct {
+	resp.Response
+	Links []storage.Link `json:"links"`
+}
+
+type LinkResponse struct {
+	resp.Response
+	Link storage.Link `json:"link"`
+}
+
+type DeletedResponse struct {
+	resp.Response
+	Deleted int `json:"deleted"`
+}
+
+type BlocklistResponse struct {
+	resp.Response
+	Domain  *storage.BlockedDomain  `json:"domain,omitempty"`
+	Domains []storage.BlockedDomain `json:"domains,omitempty"`
+}
+
+type StatsResponse struct {
+	resp.Response
+	Stats storage.Stats `json:"stats"`
+}
+
+type AuditResponse struct {
+	resp.Response
+	Entries []storage.AuditEntry `json:"entries"`
+}
+
+type DisableRequest struct {
+	// Reason is shown to the link's owner.
+	Reason string `json:"reason" validate:"required,max=500"`
+}
+
+type BlockRequest struct {
+	Domain string `json:"domain" validate:"required,hostname"`
+	Reason string `json:"reason,omitempty" validate:"max=500"`
+}
+
+type Searcher interface {
+	SearchLinks(ctx context.Context, f storage.SearchFilter) ([]storage.Link, error)
+}
+
+type LinkGetter interface {
+	GetLink(ctx context.Context, domain, alias string) (storage.Link, error)
+	LinkHealth(ctx context.Context, linkID int64) (storage.LinkHealth, error)
+}
+
+type Disabler interface {
+	DisableLink(ctx context.Context, domain, alias, reason string, by int64) (storage.Link, error)
+	EnableLink(ctx context.Context, domain, alias string) (storage.Link, error)
+}
+
+type UserLinksDeleter interface {
+	DeleteUserLinks(ctx context.Context, uid int64) ([]storage.Link, error)
+}
+
+type BlockStore interface {
+	blocklist.Lister
+	BlockDomain(ctx context.Context, d storage.BlockedDomain) (storage.BlockedDomain, error)
+	UnblockDomain(ctx context.Context, domain string) error
+}
+
+type StatsGetter interface {
+	Stats(ctx context.Context) (storage.Stats, error)
+}
+
+type AuditLister interface {
+	AuditLog(ctx context.Context, actor int64, limit, offset int) ([]storage.AuditEntry, error)
+}
+
+type CacheDeleter interface {
+	DeleteURL(ctx context.Context, domain, alias string) error
+}
+
+type DomainResolver interface {
+	Resolve(r *http.Request) (storage.Domain, error)
+}
+
+type Blocklist interface {
+	Refresh(ctx context.Context, store blocklist.Lister) error
+}
+
+// Links searches everyone's links, newest first. It accepts ?q= matching
+// part of the alias, destination or title, ?user=, ?domain=, ?disabled=true,
+// ?limit= (default 50, at most 500) and ?offset=.
+func Links(log *slog.Logger, store Searcher) http.HandlerFunc {
+	return func(w http.ResponseWriter, r *http.Request) {
+		const op = "handlers.moderation.Links"
+
+		log := log.With(
+			slog.String("op", op),
+			slog.String("request_id", middleware.GetReqID(r.Context())))
+
+		q := r.URL.Query()
+		f := storage.SearchFilter{
+			Query:  strings.TrimSpace(q.Get("q")),
+			Domain: strings.ToLower(strings.TrimSpace(q.Get("domain"))),
+		}
+		var err error
+		if v := q.Get("user"); v != "" {
+			if f.UserID, err = strconv.ParseInt(v, 10, 64); err != nil || f.UserID < 1 {
+				resp.Fail(w, r, http.StatusBadRequest, resp.CodeBadRequest, "invalid user id")
+				return
+			}
+		}
+		if v := q.Get("disabled"); v != "" {
+			if f.Disabled, err = strconv.ParseBool(v); err != nil {
+				resp.Fail(w, r, http.StatusBadRequest, resp.CodeBadRequest, "disabled must be true or false")
+				return
+			}
+		}
+		var ok bool
+		if f.Limit, f.Offset, ok = page(w, r); !ok {
+			return
+		}
+
+		links, err := store.SearchLinks(r.Context(), f)
+		if err != nil {
+			log.Error("failed to search links", sl.Err(err))
+			resp.FailError(w, r, err)
+			return
+		}
+
+		render.JSON(w, r, LinksResponse{
+			Response: resp.OK(),
+			Links:    links,
+		})
+	}
+}
+
+// Link shows any link with its click count and the health of its destination.
+func Link(log *slog.Logger, store LinkGetter, domains DomainResolver) http.HandlerFunc {
+	return func(w http.ResponseWriter, r *http.Request) {
+		const op = "handlers.moderation.Link"
+
+		log := log.With(
+			slog.String("op", op),
+			slog.String("request_id", middleware.GetReqID(r.Context())))
+
+		domain, err := domains.Resolve(r)
+		if err != nil {
+			resp.FailError(w, r, err)
+			return
+		}
+		link, err := store.GetLink(r.Context(), domain.Host, chi.URLParam(r, "alias"))
+		if errors.Is(err, storage.ErrAliasNotFound) {
+			resp.FailError(w, r, err)
+			return
+		}
+		if err != nil {
+			log.Error("failed to get link", sl.Err(err))
+			resp.FailError(w, r, err)
+			return
+		}
+		health, err := store.LinkHealth(r.Context(), link.ID)
+		switch {
+		case err == nil:
+			link.Health = &health
+		case !errors.Is(err, storage.ErrHealthNotChecked):
+			log.Error("failed to get link health", sl.Err(err))
+		}
+
+		render.JSON(w, r, LinkResponse{
+			Response: resp.OK(),
+			Link:     link,
+		})
+	}
+}
+
+// Disable stops a link from redirecting, for the reason given, and resolves
+// its pending reports.
+func Disable(log *slog.Logger, store Disabler, redis CacheDeleter, domains DomainResolver) http.HandlerFunc {
+	return func(w http.ResponseWriter, r *http.Request) {
+		const op = "handlers.moderation.Disable"
+
+		log := log.With(
+			slog.String("op", op),
+			slog.String("request_id", middleware.GetReqID(r.Context())))
+
+		var req DisableRequest
+		if !decode(log, w, r, &req) {
+			return
+		}
+		domain, err := domains.Resolve(r)
+		if err != nil {
+			resp.FailError(w, r, err)
+			return
+		}
+
+		link, err := store.DisableLink(r.Context(), domain.Host, chi.URLParam(r, "alias"), req.Reason, mwAdmin.UID(r.Context()))
+		setDisabled(log, w, r, redis, link, err)
+	}
+}
+
+// Enable lets a disabled link redirect again.
+func Enable(log *slog.Logger, store Disabler, redis CacheDeleter, domains DomainResolver) http.HandlerFunc {
+	return func(w http.ResponseWriter, r *http.Request) {
+		const op = "handlers.moderation.Enable"
+
+		log := log.With(
+			slog.String("op", op),
+			slog.String("request_id", middleware.GetReqID(r.Context())))
+
+		domain, err := domains.Resolve(r)
+		if err != nil {
+			resp.FailError(w, r, err)
+			return
+		}
+
+		link, err := store.EnableLink(r.Context(), domain.Host, chi.URLParam(r, "alias"))
+		setDisabled(log, w, r, redis, link, err)
+	}
+}
+
+// setDisabled answers Disable, Enable and Dismiss once the link has been changed.
+func setDisabled(log *slog.Logger, w http.ResponseWriter, r *http.Request, redis CacheDeleter, link storage.Link, err error) {
+	if errors.Is(err, storage.ErrAliasNotFound) {
+		resp.FailError(w, r, err)
+		return
+	}
+	if err != nil {
+		log.Error("failed to change link", sl.Err(err))
+		resp.FailError(w, r, err)
+		return
+	}
+	// the cached copy would keep redirecting, or keep refusing to
+	if err = redis.DeleteURL(context.WithoutCancel(r.Context()), link.Domain, link.Alias); err != nil {
+		log.Error("failed to invalidate cache", sl.Err(err))
+	}
+
+	log.Info("link changed", slog.String("domain", link.Domain), slog.String("alias", link.Alias),
+		slog.Bool("disabled", link.Disabled(time.Now())), slog.Int64("admin", mwAdmin.UID(r.Context())))
+	render.JSON(w, r, LinkResponse{
+		Response: resp.OK(),
+		Link:     link,
+	})
+}
+
+// DeleteUserLinks deletes every link created by the user {uid}.
+func DeleteUserLinks(log *slog.Logger, store UserLinksDeleter, redis CacheDeleter) http.HandlerFunc {
+	return func(w http.ResponseWriter, r *http.Request) {
+		const op = "handlers.moderation.DeleteUserLinks"
+
+		log := log.With(
+			slog.String("op", op),
+			slog.String("request_id", middleware.GetReqID(r.Context())))
+
+		uid, err := strconv.ParseInt(chi.URLParam(r, "uid"), 10, 64)
+		if err != nil || uid < 1 {
+			resp.Fail(w, r, http.StatusBadRequest, resp.CodeBadRequest, "invalid user id")
+			return
+		}
+
+		deleted, err := store.DeleteUserLinks(r.Context(), uid)
+		if err != nil {
+			log.Error("failed to delete links", sl.Err(err))
+			resp.FailError(w, r, err)
+			return
+		}
+		ctx := context.WithoutCancel(r.Context())
+		for _, l := range deleted {
+			if err = redis.DeleteURL(ctx, l.Domain, l.Alias); err != nil {
+				log.Error("failed to invalidate cache", slog.String("domain", l.Domain), slog.String("alias", l.Alias), sl.Err(err))
+			}
+		}
+
+		log.Info("user's links deleted", slog.Int64("uid", uid), slog.Int("deleted", len(deleted)),
+			slog.Int64("admin", mwAdmin.UID(r.Context())))
+		render.JSON(w, r, DeletedResponse{
+			Response: resp.OK(),
+			Deleted:  len(deleted),
+		})
+	}
+}
+
+// ListBlocked lists the destination domains blocked by admins. Those of
+// blocklist.domains in the config are not included.
+func ListBlocked(log *slog.Logger, store BlockStore) http.HandlerFunc {
+	return func(w http.ResponseWriter, r *http.Request) {
+		const op = "handlers.moderation.ListBlocked"
+
+		log := log.With(
+			slog.String("op", op),
+			slog.String("request_id", middleware.GetReqID(r.Context())))
+
+		list, err := store.BlockedDomains(r.Context())
+		if err != nil {
+			log.Error("failed to list blocked domains", sl.Err(err))
+			resp.FailError(w, r, err)
+			return
+		}
+
+		render.JSON(w, r, BlocklistResponse{
+			Response: resp.OK(),
+			Domains:  list,
+		})
+	}
+}
+
+// Block blocks a destination domain, and its subdomains, for every link.
+func Block(log *slog.Logger, store BlockStore, blocked Blocklist) http.HandlerFunc {
+	return func(w http.ResponseWriter, r *http.Request) {
+		const op = "handlers.moderation.Block"
+
+		log := log.With(
+			slog.String("op", op),
+			slog.String("request_id", middleware.GetReqID(r.Context())))
+
+		var req BlockRequest
+		if err := render.DecodeJSON(r.Body, &req); err != nil {
+			failDecode(log, w, r, err)
+			return
+		}
+		req.Domain = blocklist.Normalize(req.Domain)
+		if err := request.Validate(req); err != nil {
+			validateErr := err.(validator.ValidationErrors)
+			log.Info("invalid request", sl.Err(err))
+			resp.FailValidation(w, r, validateErr)
+			return
+		}
+
+		d, err := store.BlockDomain(r.Context(), storage.BlockedDomain{
+			Domain:    req.Domain,
+			Reason:    req.Reason,
+			BlockedBy: mwAdmin.UID(r.Context()),
+		})
+		if err != nil {
+			log.Error("failed to block domain", sl.Err(err))
+			resp.FailError(w, r, err)
+			return
+		}
+		// other instances pick it up on their next refresh
+		if err = blocked.Refresh(r.Context(), store); err != nil {
+			log.Error("failed to refresh blocklist", sl.Err(err))
+		}
+
+		log.Info("domain blocked", slog.String("domain", d.Domain), slog.Int64("admin", d.BlockedBy))
+		w.WriteHeader(http.StatusCreated)
+		render.JSON(w, r, BlocklistResponse{
+			Response: resp.OK(),
+			Domain:   &d,
+		})
+	}
+}
+
+// Unblock lifts the block of the domain {domain}.
+func Unblock(log *slog.Logger, store BlockStore, blocked Blocklist) http.HandlerFunc {
+	return func(w http.ResponseWriter, r *http.Request) {
+		const op = "handlers.moderation.Unblock"
+
+		log := log.With(
+			slog.String("op", op),
+			slog.String("request_id", middleware.GetReqID(r.Context())))
+
+		domain := blocklist.Normalize(chi.URLParam(r, "domain"))
+		err := store.UnblockDomain(r.Context(), domain)
+		if errors.Is(err, storage.ErrNotBlocked) {
+			resp.FailError(w, r, err)
+			return
+		}
+		if err != nil {
+			log.Error("failed to unblock domain", sl.Err(err))
+			resp.FailError(w, r, err)
+			return
+		}
+		if err = blocked.Refresh(r.Context(), store); err != nil {
+			log.Error("failed to refresh blocklist", sl.Err(err))
+		}
+
+		log.Info("domain unblocked", slog.String("domain", domain), slog.Int64("admin", mwAdmin.UID(r.Context())))
+		render.JSON(w, r, resp.OK())
+	}
+}
+
+// Stats returns system-wide counts.
+func Stats(log *slog.Logger, store StatsGetter) http.HandlerFunc {
+	return func(w http.ResponseWriter, r *http.Request) {
+		const op = "handlers.moderation.Stats"
+
+		log := log.With(
+			slog.String("op", op),
+			slog.String("request_id", middleware.GetReqID(r.Context())))
+
+		st, err := store.Stats(r.Context())
+		if err != nil {
+			log.Error("failed to count", sl.Err(err))
+			resp.FailError(w, r, err)
+			return
+		}
+
+		render.JSON(w, r, StatsResponse{
+			Response: resp.OK(),
+			Stats:    st,
+		})
+	}
+}
+
+// Audit lists the changes made through the admin API, newest first. It
+// accepts ?actor= for those of one admin, ?limit= and ?offset=.
+func Audit(log *slog.Logger, store AuditLister) http.HandlerFunc {
+	return func(w http.ResponseWriter, r *http.Request) {
+		const op = "handlers.moderation.Audit"
+
+		log := log.With(
+			slog.String("op", op),
+			slog.String("request_id", middleware.GetReqID(r.Context())))
+
+		var actor int64
+		if v := r.URL.Query().Get("actor"); v != "" {
+			var err error
+			if actor, err = strconv.ParseInt(v, 10, 64); err != nil || actor < 1 {
+				resp.Fail(w, r, http.StatusBadRequest, resp.CodeBadRequest, "invalid actor")
+				return
+			}
+		}
+		limit, offset, ok := page(w, r)
+		if !ok {
+			return
+		}
+
+		entries, err := store.AuditLog(r.Context(), actor, limit, offset)
+		if err != nil {
+			log.Error("failed to list audit log", sl.Err(err))
+			resp.FailError(w, r, err)
+			return
+		}
+
+		render.JSON(w, r, AuditResponse{
+			Response: resp.OK(),
+			Entries:  entries,
+		})
+	}
+}
+
+// page parses ?limit= and ?offset=.
+func page(w http.ResponseWriter, r *http.Request) (limit, offset int, ok bool) {
+	q := r.URL.Query()
+	limit = defaultLimit
+	var err error
+	if v := q.Get("limit"); v != "" {
+		if limit, err = strconv.Atoi(v); err != nil || limit < 1 || limit > maxLimit {
+			resp.Fail(w, r, http.StatusBadRequest, resp.CodeBadRequest, "limit must be between 1 and "+strconv.Itoa(maxLimit))
+			return 0, 0, false
+		}
+	}
+	if v := q.Get("offset"); v != "" {
+		if offset, err = strconv.Atoi(v); err != nil || offset < 0 {
+			resp.Fail(w, r, http.StatusBadRequest, resp.CodeBadRequest, "offset must be a non-negative number")
+			return 0, 0, false
+		}
+	}
+	return limit, offset, true
+}
+
+// decode reads and validates the JSON body into req.
+func decode(log *slog.Logger, w http.ResponseWriter, r *http.Request, req any) bool {
+	if err := render.DecodeJSON(r.Body, req); err != nil {
+		failDecode(log, w, r, err)
+		return false
+	}
+	if err := request.Validate(req); err != nil {
+		validateErr := err.(validator.ValidationErrors)
+		log.Info("invalid request", sl.Err(err))
+		resp.FailValidation(w, r, validateErr)
+		return false
+	}
+	return true
+}
+
+func failDecode(log *slog.Logger, w http.ResponseWriter, r *http.Request, err error) {
+	if errors.Is(err, io.EOF) {
+		log.Info("request body is empty")
+		resp.Fail(w, r, http.StatusBadRequest, resp.CodeInvalidBody, "request body is empty")
+		return
+	}
+	log.Error("failed to decode request", sl.Err(err))
+	resp.Fail(w, r, http.StatusBadRequest, resp.CodeInvalidBody, "failed to decode request")
+}
@@ -0,0 +1,131 @@
+package moderation
+
+import (
+	"context"
+	"errors"
+	"github.com/go-chi/chi/v5"
+	"github.com/go-chi/chi/v5/middleware"
+	"github.com/go-chi/render"
+	mwAdmin "github.com/kxddry/url-shortener/internal/http-server/middleware/admin"
+	resp "github.com/kxddry/url-shortener/internal/lib/api/response"
+	"github.com/kxddry/url-shortener/internal/lib/logger/sl"
+	"github.com/kxddry/url-shortener/internal/storage"
+	"log/slog"
+	"net/http"
+)
+
+type QueueResponse struct {
+	resp.Response
+	Links []storage.ReportedLink `json:"links"`
+}
+
+type ReportsResponse struct {
+	resp.Response
+	Link    storage.Link     `json:"link"`
+	Reports []storage.Report `json:"reports"`
+}
+
+type ReportStore interface {
+	GetLink(ctx context.Context, domain, alias string) (storage.Link, error)
+	ReportQueue(ctx context.Context, limit, offset int) ([]storage.ReportedLink, error)
+	LinkReports(ctx context.Context, linkID int64, limit, offset int) ([]storage.Report, error)
+	DismissReports(ctx context.Context, domain, alias string, by int64) (storage.Link, error)
+}
+
+// Queue lists the links with pending reports, most reported first, with
+// ?limit= and ?offset=.
+func Queue(log *slog.Logger, store ReportStore) http.HandlerFunc {
+	return func(w http.ResponseWriter, r *http.Request) {
+		const op = "handlers.moderation.Queue"
+
+		log := log.With(
+			slog.String("op", op),
+			slog.String("request_id", middleware.GetReqID(r.Context())))
+
+		limit, offset, ok := page(w, r)
+		if !ok {
+			return
+		}
+
+		links, err := store.ReportQueue(r.Context(), limit, offset)
+		if err != nil {
+			log.Error("failed to list reported links", sl.Err(err))
+			resp.FailError(w, r, err)
+			return
+		}
+
+		render.JSON(w, r, QueueResponse{
+			Response: resp.OK(),
+			Links:    links,
+		})
+	}
+}
+
+// Reports shows a link with its reports, resolved ones included, newest first.
+func Reports(log *slog.Logger, store ReportStore, domains DomainResolver) http.HandlerFunc {
+	return func(w http.ResponseWriter, r *http.Request) {
+		const op = "handlers.moderation.Reports"
+
+		log := log.With(
+			slog.String("op", op),
+			slog.String("request_id", middleware.GetReqID(r.Context())))
+
+		limit, offset, ok := page(w, r)
+		if !ok {
+			return
+		}
+		domain, err := domains.Resolve(r)
+		if err != nil {
+			resp.FailError(w, r, err)
+			return
+		}
+		link, err := store.GetLink(r.Context(), domain.Host, chi.URLParam(r, "alias"))
+		if errors.Is(err, storage.ErrAliasNotFound) {
+			resp.FailError(w, r, err)
+			return
+		}
+		if err != nil {
+			log.Error("failed to get link", sl.Err(err))
+			resp.FailError(w, r, err)
+			return
+		}
+
+		reports, err := store.LinkReports(r.Context(), link.ID, limit, offset)
+		if err != nil {
+			log.Error("failed to list reports", sl.Err(err))
+			resp.FailError(w, r, err)
+			return
+		}
+
+		render.JSON(w, r, ReportsResponse{
+			Response: resp.OK(),
+			Link:     link,
+			Reports:  reports,
+		})
+	}
+}
+
+// Dismiss resolves the pending reports of a link as unfounded. A link they
+// disabled temporarily redirects again; one disabled by an admin stays disabled.
+func Dismiss(log *slog.Logger, store ReportStore, redis CacheDeleter, domains DomainResolver) http.HandlerFunc {
+	return func(w http.ResponseWriter, r *http.Request) {
+		const op = "handlers.moderation.Dismiss"
+
+		log := log.With(
+			slog.String("op", op),
+			slog.String("request_id", middleware.GetReqID(r.Context())))
+
+		domain, err := domains.Resolve(r)
+		if err != nil {
+			resp.FailError(w, r, err)
+			return
+		}
+
+		link, err := store.DismissReports(r.Context(), domain.Host, chi.URLParam(r, "alias"), mwAdmin.UID(r.Context()))
+		if errors.Is(err, storage.ErrAliasNotFound) || errors.Is(err, storage.ErrNoReports) {
+			resp.FailError(w, r, err)
+			return
+		}
+		setDisabled(log, w, r, redis, link, err)
+	}
+}
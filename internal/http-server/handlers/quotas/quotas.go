@@ -0,0 +1,176 @@
+package quotas
+
+import (
+	"context"
+	"errors"
+	"github.com/go-chi/chi/v5"
+	"github.com/go-chi/chi/v5/middleware"
+	"github.com/go-chi/render"
+	"github.com/go-playground/validator/v10"
+	"github.com/kxddry/url-shortener/internal/config"
+	"github.com/kxddry/url-shortener/internal/lib/api/request"
+	resp "github.com/kxddry/url-shortener/internal/lib/api/response"
+	"github.com/kxddry/url-shortener/internal/lib/logger/sl"
+	"github.com/kxddry/url-shortener/internal/storage"
+	"io"
+	"log/slog"
+	"net/http"
+	"strconv"
+)
+
+// Request replaces the override: limits that are left out or null use the
+// configured default, 0 means unlimited.
+type Request struct {
+	Links                 *int `json:"links" validate:"omitempty,gte=0"`
+	LinksPerDay           *int `json:"links_per_day" validate:"omitempty,gte=0"`
+	CustomAliasesPerMonth *int `json:"custom_aliases_per_month" validate:"omitempty,gte=0"`
+}
+
+type Response struct {
+	resp.Response
+	// Quota is what applies: the override over the configured defaults.
+	Quota    storage.Quota         `json:"quota"`
+	Override storage.QuotaOverride `json:"override"`
+	Usage    storage.Usage         `json:"usage"`
+}
+
+type Storage interface {
+	QuotaOverride(ctx context.Context, uid, workspaceID int64) (storage.QuotaOverride, error)
+	SetQuotaOverride(ctx context.Context, o storage.QuotaOverride) error
+	DeleteQuotaOverride(ctx context.Context, uid, workspaceID int64) error
+	Usage(ctx context.Context, uid, workspaceID int64, defaults storage.Quota) (storage.Quota, storage.Usage, error)
+}
+
+// Get shows the quota of the user {uid} or the workspace {workspace}, its override and usage.
+func Get(log *slog.Logger, store Storage, defaults config.Quota) http.HandlerFunc {
+	return func(w http.ResponseWriter, r *http.Request) {
+		const op = "handlers.quotas.Get"
+
+		log := log.With(
+			slog.String("op", op),
+			slog.String("request_id", middleware.GetReqID(r.Context())))
+
+		uid, workspaceID, ok := owner(w, r)
+		if !ok {
+			return
+		}
+		show(log, w, r, store, defaults, uid, workspaceID)
+	}
+}
+
+// Set overrides the configured quota of the user {uid} or the workspace {workspace}.
+func Set(log *slog.Logger, store Storage, defaults config.Quota) http.HandlerFunc {
+	return func(w http.ResponseWriter, r *http.Request) {
+		const op = "handlers.quotas.Set"
+
+		log := log.With(
+			slog.String("op", op),
+			slog.String("request_id", middleware.GetReqID(r.Context())))
+
+		uid, workspaceID, ok := owner(w, r)
+		if !ok {
+			return
+		}
+
+		var req Request
+		if err := render.DecodeJSON(r.Body, &req); err != nil {
+			if errors.Is(err, io.EOF) {
+				log.Info("request body is empty")
+				resp.Fail(w, r, http.StatusBadRequest, resp.CodeInvalidBody, "request body is empty")
+				return
+			}
+			log.Error("failed to decode request", sl.Err(err))
+			resp.Fail(w, r, http.StatusBadRequest, resp.CodeInvalidBody, "failed to decode request")
+			return
+		}
+		if err := request.Validate(req); err != nil {
+			validateErr := err.(validator.ValidationErrors)
+			log.Info("invalid request", sl.Err(err))
+			resp.FailValidation(w, r, validateErr)
+			return
+		}
+
+		err := store.SetQuotaOverride(r.Context(), storage.QuotaOverride{
+			UserID:                uid,
+			WorkspaceID:           workspaceID,
+			Links:                 req.Links,
+			LinksPerDay:           req.LinksPerDay,
+			CustomAliasesPerMonth: req.CustomAliasesPerMonth,
+		})
+		if errors.Is(err, storage.ErrWorkspaceNotFound) {
+			resp.FailError(w, r, err)
+			return
+		}
+		if err != nil {
+			log.Error("failed to set quota", sl.Err(err))
+			resp.FailError(w, r, err)
+			return
+		}
+
+		log.Info("quota overridden", slog.Int64("uid", uid), slog.Int64("workspace_id", workspaceID))
+		show(log, w, r, store, defaults, uid, workspaceID)
+	}
+}
+
+// Delete restores the configured quota of the user {uid} or the workspace {workspace}.
+func Delete(log *slog.Logger, store Storage) http.HandlerFunc {
+	return func(w http.ResponseWriter, r *http.Request) {
+		const op = "handlers.quotas.Delete"
+
+		log := log.With(
+			slog.String("op", op),
+			slog.String("request_id", middleware.GetReqID(r.Context())))
+
+		uid, workspaceID, ok := owner(w, r)
+		if !ok {
+			return
+		}
+
+		if err := store.DeleteQuotaOverride(r.Context(), uid, workspaceID); err != nil {
+			log.Error("failed to delete quota override", sl.Err(err))
+			resp.FailError(w, r, err)
+			return
+		}
+
+		log.Info("quota override deleted", slog.Int64("uid", uid), slog.Int64("workspace_id", workspaceID))
+		render.JSON(w, r, resp.OK())
+	}
+}
+
+func show(log *slog.Logger, w http.ResponseWriter, r *http.Request, store Storage, defaults config.Quota, uid, workspaceID int64) {
+	o, err := store.QuotaOverride(r.Context(), uid, workspaceID)
+	if err != nil {
+		log.Error("failed to get quota override", sl.Err(err))
+		resp.FailError(w, r, err)
+		return
+	}
+	quota, usage, err := store.Usage(r.Context(), uid, workspaceID, storage.Quota(defaults))
+	if err != nil {
+		log.Error("failed to get usage", sl.Err(err))
+		resp.FailError(w, r, err)
+		return
+	}
+	render.JSON(w, r, Response{
+		Response: resp.OK(),
+		Quota:    quota,
+		Override: o,
+		Usage:    usage,
+	})
+}
+
+// owner parses the {uid} or {workspace} of the route.
+func owner(w http.ResponseWriter, r *http.Request) (uid, workspaceID int64, ok bool) {
+	name, bad := "uid", "invalid user id"
+	if chi.URLParam(r, "uid") == "" {
+		name, bad = "workspace", "invalid workspace id"
+	}
+	id, err := strconv.ParseInt(chi.URLParam(r, name), 10, 64)
+	if err != nil || id < 1 {
+		resp.Fail(w, r, http.StatusBadRequest, resp.CodeBadRequest, bad)
+		return 0, 0, false
+	}
+	if name == "uid" {
+		return id, 0, true
+	}
+	return 0, id, true
+}
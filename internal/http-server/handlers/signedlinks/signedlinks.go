@@ -0,0 +1,206 @@
+package signedlinks
+
+import (
+	"context"
+	"errors"
+	"github.com/go-chi/chi/v5"
+	"github.com/go-chi/chi/v5/middleware"
+	"github.com/go-chi/render"
+	"github.com/go-playground/validator/v10"
+	"github.com/kxddry/url-shortener/internal/config"
+	"github.com/kxddry/url-shortener/internal/http-server/middleware/auth"
+	"github.com/kxddry/url-shortener/internal/lib/api/request"
+	resp "github.com/kxddry/url-shortener/internal/lib/api/response"
+	"github.com/kxddry/url-shortener/internal/lib/logger/sl"
+	"github.com/kxddry/url-shortener/internal/lib/signed"
+	"io"
+	"log/slog"
+	"net/http"
+	"time"
+)
+
+// clicksRetention is how long the clicks of a signed link can be looked up after it expired.
+const clicksRetention = 7 * 24 * time.Hour
+
+type Request struct {
+	URL string `json:"url" validate:"required,url"`
+	// ExpiresAt defaults to signed_links.default_ttl from now.
+	ExpiresAt *time.Time `json:"expires_at,omitempty"`
+}
+
+type Response struct {
+	resp.Response
+	Token     string     `json:"token,omitempty"`
+	Path      string     `json:"path,omitempty"`
+	ExpiresAt *time.Time `json:"expires_at,omitempty"`
+	Clicks    *int64     `json:"clicks,omitempty"`
+}
+
+type Signer interface {
+	Sign(c signed.Claims) (string, error)
+	Verify(token string, now time.Time) (signed.Claims, error)
+}
+
+type Blocklist interface {
+	Blocked(rawURL string) bool
+}
+
+type Counter interface {
+	CountSignedClick(ctx context.Context, id string, expires time.Time) error
+	SignedClicks(ctx context.Context, id string) (int64, error)
+}
+
+// Path is where the signed link token resolves.
+func Path(token string) string {
+	return "/s/" + token
+}
+
+// Mint signs a link to the requested URL for the caller. Nothing is stored:
+// the token carries the destination and expiry.
+func Mint(log *slog.Logger, signer Signer, blocklist Blocklist, cfg config.SignedLinks) http.HandlerFunc {
+	return func(w http.ResponseWriter, r *http.Request) {
+		const op = "handlers.signedlinks.Mint"
+
+		log := log.With(
+			slog.String("op", op),
+			slog.String("request_id", middleware.GetReqID(r.Context())))
+
+		var req Request
+		if err := request.Decode(r, &req); err != nil {
+			if errors.Is(err, io.EOF) {
+				log.Info("request body is empty")
+				resp.Fail(w, r, http.StatusBadRequest, resp.CodeInvalidBody, "request body is empty")
+				return
+			}
+			log.Error("failed to decode request", sl.Err(err))
+			resp.Fail(w, r, http.StatusBadRequest, resp.CodeInvalidBody, "failed to decode request")
+			return
+		}
+		if err := request.Validate(req); err != nil {
+			validateErr := err.(validator.ValidationErrors)
+			log.Info("invalid request", sl.Err(err))
+			resp.FailValidation(w, r, validateErr)
+			return
+		}
+
+		now := time.Now()
+		expires := now.Add(cfg.DefaultTTL)
+		if req.ExpiresAt != nil {
+			expires = *req.ExpiresAt
+		}
+		if !expires.After(now) || expires.After(now.Add(cfg.MaxTTL)) {
+			log.Info("invalid expiry", slog.Time("expires_at", expires))
+			resp.Fail(w, r, http.StatusBadRequest, resp.CodeBadRequest,
+				"expires_at must be in the future, at most "+cfg.MaxTTL.String()+" from now")
+			return
+		}
+		if blocklist.Blocked(req.URL) {
+			log.Info("destination is blocked", slog.String("url", req.URL))
+			resp.Fail(w, r, http.StatusForbidden, resp.CodeDestinationBlocked, "destination domain is blocked")
+			return
+		}
+
+		uid := auth.UID(r.Context())
+		token, err := signer.Sign(signed.Claims{URL: req.URL, ExpiresAt: expires, CreatedBy: uid})
+		if err != nil {
+			log.Error("failed to sign link", sl.Err(err))
+			resp.FailError(w, r, err)
+			return
+		}
+		log.Info("signed link minted", slog.Int64("uid", uid), slog.Time("expires_at", expires))
+
+		// the token only has second precision
+		expires = time.Unix(expires.Unix(), 0).UTC()
+		w.WriteHeader(http.StatusCreated)
+		render.JSON(w, r, Response{
+			Response:  resp.OK(),
+			Token:     token,
+			Path:      Path(token),
+			ExpiresAt: &expires,
+		})
+	}
+}
+
+// Resolve redirects to the destination of the signed link {token}, without
+// touching the database. Tampered tokens get 404, expired ones 410.
+func Resolve(log *slog.Logger, signer Signer, blocklist Blocklist, counter Counter, cfg config.SignedLinks) http.HandlerFunc {
+	return func(w http.ResponseWriter, r *http.Request) {
+		const op = "handlers.signedlinks.Resolve"
+
+		log := log.With(
+			slog.String("op", op),
+			slog.String("request_id", middleware.GetReqID(r.Context())))
+
+		c, err := signer.Verify(chi.URLParam(r, "token"), time.Now())
+		if err != nil {
+			log.Info("signed link rejected", sl.Err(err))
+			w.Header().Set("Cache-Control", "no-store")
+			resp.FailError(w, r, err)
+			return
+		}
+
+		// destinations blocked after the link was minted don't get visitors either
+		if blocklist.Blocked(c.URL) {
+			log.Info("destination is blocked", slog.String("id", c.ID), slog.String("url", c.URL))
+			w.Header().Set("Cache-Control", "no-store")
+			resp.Fail(w, r, http.StatusForbidden, resp.CodeDestinationBlocked, "the destination of the link is blocked")
+			return
+		}
+
+		if cfg.CountClicks {
+			// every visit has to reach us to be counted
+			w.Header().Set("Cache-Control", "no-store")
+		}
+		http.Redirect(w, r, c.URL, http.StatusFound)
+		log.Info("redirected", slog.String("id", c.ID), slog.String("url", c.URL))
+		if !cfg.CountClicks {
+			return
+		}
+
+		// the redirect has been sent, so the click counts even if the client hangs up now
+		err = counter.CountSignedClick(context.WithoutCancel(r.Context()), c.ID, c.ExpiresAt.Add(clicksRetention))
+		if err != nil {
+			log.Error("failed to count click", slog.String("id", c.ID), sl.Err(err))
+		}
+	}
+}
+
+// Clicks shows how often the signed link {token} was visited, to whoever minted it.
+func Clicks(log *slog.Logger, signer Signer, counter Counter, cfg config.SignedLinks) http.HandlerFunc {
+	return func(w http.ResponseWriter, r *http.Request) {
+		const op = "handlers.signedlinks.Clicks"
+
+		log := log.With(
+			slog.String("op", op),
+			slog.String("request_id", middleware.GetReqID(r.Context())))
+
+		if !cfg.CountClicks {
+			resp.Fail(w, r, http.StatusNotFound, resp.CodeNotFound, "clicks of signed links aren't counted")
+			return
+		}
+		// the clicks of expired links are kept for a while
+		c, err := signer.Verify(chi.URLParam(r, "token"), time.Now())
+		if err != nil && !errors.Is(err, signed.ErrExpired) {
+			log.Info("signed link rejected", sl.Err(err))
+			resp.FailError(w, r, err)
+			return
+		}
+		if uid := auth.UID(r.Context()); c.CreatedBy != uid {
+			log.Info("not the link's creator", slog.Int64("uid", uid), slog.String("id", c.ID))
+			resp.Fail(w, r, http.StatusForbidden, resp.CodeForbidden, "you can only see the clicks of your own links")
+			return
+		}
+
+		n, err := counter.SignedClicks(r.Context(), c.ID)
+		if err != nil {
+			log.Error("failed to get clicks", slog.String("id", c.ID), sl.Err(err))
+			resp.FailError(w, r, err)
+			return
+		}
+		render.JSON(w, r, Response{
+			Response:  resp.OK(),
+			ExpiresAt: &c.ExpiresAt,
+			Clicks:    &n,
+		})
+	}
+}
@@ -0,0 +1,103 @@
+package signedlinks
+
+import (
+	"context"
+	"encoding/json"
+	"io"
+	"log/slog"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/go-chi/chi/v5"
+	gojwt "github.com/golang-jwt/jwt/v5"
+	"github.com/kxddry/url-shortener/internal/config"
+	"github.com/kxddry/url-shortener/internal/http-server/middleware/auth"
+	resp "github.com/kxddry/url-shortener/internal/lib/api/response"
+	"github.com/kxddry/url-shortener/internal/lib/signed"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+const secret = "test-secret"
+
+type fakeBlocklist struct{}
+
+func (fakeBlocklist) Blocked(rawURL string) bool { return strings.Contains(rawURL, "blocked.example") }
+
+type fakeCounter map[string]int64
+
+func (c fakeCounter) CountSignedClick(_ context.Context, id string, _ time.Time) error {
+	c[id]++
+	return nil
+}
+
+func (c fakeCounter) SignedClicks(_ context.Context, id string) (int64, error) { return c[id], nil }
+
+func bearer(t *testing.T, uid int64) string {
+	s, err := gojwt.NewWithClaims(gojwt.SigningMethodHS256, gojwt.MapClaims{"uid": uid}).SignedString([]byte(secret))
+	require.NoError(t, err)
+	return "Bearer " + s
+}
+
+func TestSignedLinks(t *testing.T) {
+	log := slog.New(slog.NewTextHandler(io.Discard, nil))
+	cfg := config.SignedLinks{Enabled: true, DefaultTTL: time.Hour, MaxTTL: 24 * time.Hour, CountClicks: true}
+	signer := signed.New([]config.SigningKey{{ID: "k1", Secret: "0123456789abcdef0123456789abcdef"}})
+	counter := fakeCounter{}
+
+	router := chi.NewRouter()
+	router.With(auth.New(log, secret)).Post("/s", Mint(log, signer, fakeBlocklist{}, cfg))
+	router.Get("/s/{token}", Resolve(log, signer, fakeBlocklist{}, counter, cfg))
+	router.With(auth.New(log, secret)).Get("/s/{token}/clicks", Clicks(log, signer, counter, cfg))
+
+	do := func(method, path, body string, uid int64) *httptest.ResponseRecorder {
+		r := httptest.NewRequest(method, path, strings.NewReader(body))
+		if uid != 0 {
+			r.Header.Set("Authorization", bearer(t, uid))
+		}
+		w := httptest.NewRecorder()
+		router.ServeHTTP(w, r)
+		return w
+	}
+
+	w := do(http.MethodPost, "/s", `{"url": "https://example.com/welcome?campaign=june"}`, 42)
+	require.Equal(t, http.StatusCreated, w.Code, w.Body.String())
+	var minted Response
+	require.NoError(t, json.NewDecoder(w.Body).Decode(&minted))
+	assert.Equal(t, "/s/"+minted.Token, minted.Path)
+	assert.WithinDuration(t, time.Now().Add(time.Hour), *minted.ExpiresAt, 2*time.Second)
+
+	for range 2 {
+		w = do(http.MethodGet, minted.Path, "", 0)
+		assert.Equal(t, http.StatusFound, w.Code)
+		assert.Equal(t, "https://example.com/welcome?campaign=june", w.Header().Get("Location"))
+		assert.Equal(t, "no-store", w.Header().Get("Cache-Control"))
+	}
+
+	w = do(http.MethodGet, minted.Path+"/clicks", "", 42)
+	require.Equal(t, http.StatusOK, w.Code, w.Body.String())
+	var clicks Response
+	require.NoError(t, json.NewDecoder(w.Body).Decode(&clicks))
+	assert.Equal(t, int64(2), *clicks.Clicks)
+	assert.Equal(t, http.StatusForbidden, do(http.MethodGet, minted.Path+"/clicks", "", 7).Code, "only the creator sees the clicks")
+
+	payload, sig, _ := strings.Cut(minted.Token, "~")
+	tampered := payload[:len(payload)-2] + "xx~" + sig
+	w = do(http.MethodGet, "/s/"+tampered, "", 0)
+	assert.Equal(t, http.StatusNotFound, w.Code)
+	assert.Contains(t, w.Body.String(), string(resp.CodeInvalidSignedLink))
+
+	expired, err := signer.Sign(signed.Claims{URL: "https://example.com", ExpiresAt: time.Now().Add(-time.Second)})
+	require.NoError(t, err)
+	w = do(http.MethodGet, "/s/"+expired, "", 0)
+	assert.Equal(t, http.StatusGone, w.Code)
+	assert.Contains(t, w.Body.String(), string(resp.CodeLinkExpired))
+
+	assert.Equal(t, http.StatusBadRequest, do(http.MethodPost, "/s", `{"url": "https://example.com", "expires_at": "2999-01-01T00:00:00Z"}`, 42).Code,
+		"expiries past max_ttl are rejected")
+	assert.Equal(t, http.StatusForbidden, do(http.MethodPost, "/s", `{"url": "https://blocked.example/x"}`, 42).Code)
+	assert.Equal(t, http.StatusUnauthorized, do(http.MethodPost, "/s", `{"url": "https://example.com"}`, 0).Code)
+}
@@ -7,6 +7,7 @@ import (
 	"github.com/go-chi/chi/v5/middleware"
 	"github.com/go-chi/render"
 	"github.com/kxddry/url-shortener/internal/config"
+	"github.com/kxddry/url-shortener/internal/lib/api/request"
 	resp "github.com/kxddry/url-shortener/internal/lib/api/response"
 	"github.com/kxddry/url-shortener/internal/lib/jwt"
 	"github.com/kxddry/url-shortener/internal/lib/logger/sl"
@@ -16,118 +17,124 @@ import (
 )
 
 type URLDeleter interface {
-	DeleteURL(alias string) error
+	DeleteURL(ctx context.Context, domain, alias string) error
 }
 
-type CreatorFinder interface {
-	Creator(alias string) (int64, error)
+type Storage interface {
+	GetLink(ctx context.Context, domain, alias string) (storage.Link, error)
+	// DeleteLink deletes the link once check, given the link read from the
+	// primary and locked, returns nil.
+	DeleteLink(ctx context.Context, domain, alias string, check func(link storage.Link) error) error
 }
 
-type Storage interface {
-	URLDeleter
-	CreatorFinder
+type Access interface {
+	Link(ctx context.Context, uid int64, link storage.Link, min string) (bool, error)
 }
 
-type AdminChecker interface {
-	IsAdmin(ctx context.Context, userID int64) (bool, error)
+type DomainResolver interface {
+	Resolve(r *http.Request) (storage.Domain, error)
 }
 
-func New(ctx context.Context, log *slog.Logger, cfg *config.Config, store Storage, redis URLDeleter, sso AdminChecker) http.HandlerFunc {
+func New(log *slog.Logger, cfg *config.Config, store Storage, redis URLDeleter, access Access, domains DomainResolver) http.HandlerFunc {
 	return func(w http.ResponseWriter, r *http.Request) {
 		const op = "handlers.url.delete.New"
 
-		log = log.With(
+		log := log.With(
 			slog.String("op", op),
 			slog.String("request_id", middleware.GetReqID(r.Context())))
 
 		alias := chi.URLParam(r, "alias")
 		if alias == "" {
 			log.Debug("alias is empty")
-			w.WriteHeader(http.StatusBadRequest)
-			render.JSON(w, r, resp.Error(resp.BadRequest, "alias is empty"))
+			resp.Fail(w, r, http.StatusBadRequest, resp.CodeBadRequest, "alias is empty")
 			return
 		}
 
-		uid, err := jwt.UIDfromHeader(r, cfg.App.Secret)
+		uid, err := jwt.UIDfromRequest(r, cfg.App.Secret)
 		if err != nil {
 			if errors.Is(err, jwt.ErrNoHeader) {
-				w.WriteHeader(http.StatusUnauthorized)
-				render.JSON(w, r, resp.Error(resp.Unauthorized, "go to /login or /register"))
+				resp.FailError(w, r, err)
 				return
 			}
 
 			if errors.Is(err, jwt.ErrInvalidToken) {
 				log.Info("invalid token")
-				w.WriteHeader(http.StatusBadRequest)
-				render.JSON(w, r, resp.Error(resp.BadRequest, "invalid token"))
+				resp.FailError(w, r, err)
 				return
 			}
 
 			if errors.Is(err, jwt.ErrInvalidHeader) {
 				log.Info("invalid header")
-				w.WriteHeader(http.StatusBadRequest)
-				render.JSON(w, r, resp.Error(resp.BadRequest, "invalid header"))
+				resp.FailError(w, r, err)
 				return
 			}
 
 			log.Error("internal error!", sl.Err(err))
-			w.WriteHeader(http.StatusInternalServerError)
-			render.JSON(w, r, resp.Error(resp.InternalServerError, "internal server error"))
+			resp.FailError(w, r, err)
+			return
+		}
+
+		domain, err := domains.Resolve(r)
+		if err != nil {
+			resp.Fail(w, r, http.StatusNotFound, resp.CodeDomainNotFound, "domain not found")
 			return
 		}
 
-		creator, err := store.Creator(alias)
+		// authorized before the link is locked, which the check only compares the owner with
+		seen, err := store.GetLink(r.Context(), domain.Host, alias)
 		if err != nil {
 			if errors.Is(err, storage.ErrAliasNotFound) {
 				log.Info("alias not found")
-				w.WriteHeader(http.StatusBadRequest)
-				render.JSON(w, r, resp.Error(resp.BadRequest, "alias not found"))
+				resp.FailError(w, r, err)
 				return
 			}
-
 			log.Error("internal error!", sl.Err(err))
-			w.WriteHeader(http.StatusInternalServerError)
-			render.JSON(w, r, resp.Error(resp.InternalServerError, "internal server error"))
+			resp.FailError(w, r, err)
+			return
+		}
+		allowed, err := access.Link(r.Context(), uid, seen, storage.RoleEditor)
+		if err != nil {
+			log.Error("internal error!", sl.Err(err))
+			resp.FailError(w, r, err)
+			return
+		}
+		if !allowed {
+			log.Info("user tried to delete alias", slog.Int64("uid", uid))
+			resp.Fail(w, r, http.StatusForbidden, resp.CodeForbidden, "you can only delete your own or your workspace's links")
 			return
 		}
 
-		if creator == uid {
-			delete(log, store, alias, redis, w, r)
+		err = store.DeleteLink(r.Context(), domain.Host, alias, func(link storage.Link) error {
+			if !link.SameOwner(seen) {
+				return storage.ErrOwnerChanged
+			}
+			return nil
+		})
+		if err != nil {
+			switch {
+			case errors.Is(err, storage.ErrAliasNotFound):
+				log.Info("alias not found")
+			case errors.Is(err, storage.ErrOwnerChanged):
+				log.Info("link changed owners while deleting it")
+			default:
+				log.Error("internal error!", sl.Err(err))
+			}
+			resp.FailError(w, r, err)
 			return
 		}
 
-		isAdmin, err := sso.IsAdmin(ctx, uid)
+		// the link is gone from the database, don't leave it cached if the client hangs up
+		err = redis.DeleteURL(context.WithoutCancel(r.Context()), domain.Host, alias)
 		if err != nil {
 			log.Error("internal error!", sl.Err(err))
-			http.Error(w, http.StatusText(http.StatusInternalServerError), http.StatusInternalServerError)
+			resp.FailError(w, r, err)
 			return
 		}
-
-		if isAdmin {
-			delete(log, store, alias, redis, w, r)
+		if request.WantsHTML(r) {
+			http.Redirect(w, r, "/", http.StatusSeeOther)
 			return
 		}
-
-		log.Info("user tried to delete alias", slog.Int64("uid", uid))
-		http.Error(w, http.StatusText(http.StatusUnauthorized), http.StatusUnauthorized)
-		return
-	}
-}
-
-func delete(log *slog.Logger, store Storage, alias string, redis URLDeleter, w http.ResponseWriter, r *http.Request) {
-	err := store.DeleteURL(alias)
-	if err != nil {
-		log.Error("internal error!", sl.Err(err))
-		http.Error(w, http.StatusText(http.StatusInternalServerError), http.StatusInternalServerError)
-		return
-	}
-	err = redis.DeleteURL(alias)
-	if err != nil {
-		log.Error("internal error!", sl.Err(err))
-		http.Error(w, http.StatusText(http.StatusInternalServerError), http.StatusInternalServerError)
-		return
+		w.WriteHeader(http.StatusAccepted)
+		render.JSON(w, r, resp.OK())
 	}
-	w.WriteHeader(http.StatusAccepted)
-	render.JSON(w, r, resp.OK())
-	return
 }
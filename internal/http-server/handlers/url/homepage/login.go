@@ -3,17 +3,32 @@ package homepage
 import (
 	"github.com/go-chi/render"
 	"github.com/kxddry/url-shortener/internal/config"
+	"github.com/kxddry/url-shortener/internal/http-server/pages"
+	"github.com/kxddry/url-shortener/internal/lib/api/request"
 	resp "github.com/kxddry/url-shortener/internal/lib/api/response"
 	"github.com/kxddry/url-shortener/internal/lib/jwt"
+	"log/slog"
 	"net/http"
 )
 
-func Login(cfg *config.Config) http.HandlerFunc {
+func Login(log *slog.Logger, cfg *config.Config) http.HandlerFunc {
 	return func(w http.ResponseWriter, r *http.Request) {
-		_, err := jwt.UIDfromHeader(r, cfg.App.Secret)
+		_, err := jwt.UIDfromRequest(r, cfg.App.Secret)
 		if err == nil {
+			if request.WantsHTML(r) {
+				http.Redirect(w, r, "/", http.StatusSeeOther)
+				return
+			}
 			render.JSON(w, r, resp.Info("redirecting to /, you're logged in"))
-			http.Redirect(w, r, "/", http.StatusFound)
+			return
+		}
+
+		if request.WantsHTML(r) {
+			data := pages.Login{Page: pages.UI(r, "Log in")}
+			if r.URL.Query().Has("registered") {
+				data.Message = "Your account is ready, log in to continue."
+			}
+			page(log, w, "login.html", data)
 			return
 		}
 
@@ -0,0 +1,22 @@
+package homepage
+
+import (
+	"github.com/go-chi/render"
+	"github.com/kxddry/url-shortener/internal/config"
+	"github.com/kxddry/url-shortener/internal/lib/api/request"
+	resp "github.com/kxddry/url-shortener/internal/lib/api/response"
+	"github.com/kxddry/url-shortener/internal/lib/session"
+	"net/http"
+)
+
+// Logout ends the browser session. Access tokens held by API clients stay valid until they expire.
+func Logout(cfg *config.Config) http.HandlerFunc {
+	return func(w http.ResponseWriter, r *http.Request) {
+		session.Clear(w, cfg.Web.SecureCookies)
+		if request.WantsHTML(r) {
+			http.Redirect(w, r, "/login", http.StatusSeeOther)
+			return
+		}
+		render.JSON(w, r, resp.OK())
+	}
+}
@@ -1,45 +1,89 @@
 package homepage
 
 import (
+	"context"
 	"errors"
 	"github.com/go-chi/chi/v5/middleware"
 	"github.com/go-chi/render"
 	"github.com/kxddry/url-shortener/internal/config"
+	"github.com/kxddry/url-shortener/internal/http-server/pages"
+	"github.com/kxddry/url-shortener/internal/lib/api/request"
 	resp "github.com/kxddry/url-shortener/internal/lib/api/response"
 	"github.com/kxddry/url-shortener/internal/lib/jwt"
 	"github.com/kxddry/url-shortener/internal/lib/logger/sl"
+	"github.com/kxddry/url-shortener/internal/storage"
 	"log/slog"
 	"net/http"
+	"strconv"
+	"strings"
 )
 
-func Url(log *slog.Logger, cfg *config.Config) http.HandlerFunc {
+// pageSize is how many links the home page lists.
+const pageSize = 100
+
+type LinkLister interface {
+	Links(ctx context.Context, uid int64, f storage.LinkFilter) ([]storage.Link, error)
+}
+
+// Url greets API clients. Browsers get the web UI: a form to shorten links
+// and the list of their links, optionally narrowed down by ?tag= and ?broken=true.
+func Url(log *slog.Logger, cfg *config.Config, store LinkLister) http.HandlerFunc {
 	return func(w http.ResponseWriter, r *http.Request) {
 		const op = "handlers.url.homepage.Url"
 
-		log = log.With(
+		log := log.With(
 			slog.String("op", op),
 			slog.String("request_id", middleware.GetReqID(r.Context())),
 		)
 
 		log.Debug("visited website")
 
-		_, err := jwt.UIDfromHeader(r, cfg.App.Secret)
+		uid, err := jwt.UIDfromRequest(r, cfg.App.Secret)
 
 		if err != nil {
 			log.Debug("problem with jwt token", sl.Err(err))
 			switch {
 			case errors.Is(err, jwt.ErrNoHeader):
 				http.Redirect(w, r, "/login", http.StatusSeeOther)
-			case errors.Is(err, jwt.ErrInvalidHeader):
-				http.Error(w, jwt.ErrInvalidHeader.Error(), http.StatusBadRequest)
 			default:
-				http.Error(w, err.Error(), http.StatusNotAcceptable)
+				resp.FailError(w, r, err)
 			}
 
 			return
 		}
 
-		w.WriteHeader(http.StatusOK)
-		render.JSON(w, r, resp.Info("Welcome to the URL shortener! Usage: POST to /url to create an alias; GET /{alias} to get redirected; DELETE /{alias} to delete the alias. Register and log in at /register and /login."))
+		if !request.WantsHTML(r) {
+			w.WriteHeader(http.StatusOK)
+			render.JSON(w, r, resp.Info("Welcome to the URL shortener! Usage: POST to /url to create an alias; GET /{alias} to get redirected; DELETE /{alias} to delete the alias. Register and log in at /register and /login."))
+			return
+		}
+
+		q := r.URL.Query()
+		f := storage.LinkFilter{
+			Tag:   strings.ToLower(strings.TrimSpace(q.Get("tag"))),
+			Limit: pageSize,
+		}
+		f.Broken, _ = strconv.ParseBool(q.Get("broken"))
+		links, err := store.Links(r.Context(), uid, f)
+		if err != nil {
+			log.Error("failed to list links", sl.Err(err))
+			resp.FailError(w, r, err)
+			return
+		}
+
+		page(log, w, "links.html", pages.Links{
+			Page:   pages.UI(r, "My links"),
+			Host:   r.Host,
+			Tag:    f.Tag,
+			Broken: f.Broken,
+			Links:  links,
+		})
+	}
+}
+
+func page(log *slog.Logger, w http.ResponseWriter, name string, data any) {
+	if err := pages.Render(w, http.StatusOK, name, data); err != nil {
+		log.Error("failed to render page", slog.String("page", name), sl.Err(err))
+		http.Error(w, http.StatusText(http.StatusInternalServerError), http.StatusInternalServerError)
 	}
 }
@@ -7,8 +7,10 @@ import (
 	"github.com/go-chi/render"
 	cds "github.com/kxddry/sso-auth/output-error-codes"
 	"github.com/kxddry/url-shortener/internal/config"
+	"github.com/kxddry/url-shortener/internal/lib/api/request"
 	resp "github.com/kxddry/url-shortener/internal/lib/api/response"
 	"github.com/kxddry/url-shortener/internal/lib/logger/sl"
+	"github.com/kxddry/url-shortener/internal/lib/session"
 	"github.com/kxddry/url-shortener/internal/lib/validator"
 	"google.golang.org/grpc/codes"
 	"google.golang.org/grpc/status"
@@ -33,26 +35,26 @@ type LoginClient interface {
 	Login(ctx context.Context, placeholder, pass string, appId int64) (string, error)
 }
 
-func New(ctx context.Context, log *slog.Logger, cfg *config.Config, lc LoginClient) http.HandlerFunc {
+// New logs a user in with a JSON or an HTML form. API clients get an access
+// token, browsers get it as a session cookie and are sent to the home page.
+func New(log *slog.Logger, cfg *config.Config, lc LoginClient) http.HandlerFunc {
 	return func(w http.ResponseWriter, r *http.Request) {
 		const op = "handlers.url.login.New"
 
-		log = log.With(
+		log := log.With(
 			slog.String("op", op),
 			slog.String("request_id", middleware.GetReqID(r.Context())))
 
 		var req Request
 
-		if err := render.DecodeJSON(r.Body, &req); err != nil {
+		if err := request.Decode(r, &req); err != nil {
 			if errors.Is(err, io.EOF) {
 				log.Debug("request body is empty", sl.Err(err))
-				w.WriteHeader(http.StatusBadRequest)
-				render.JSON(w, r, resp.Error(resp.BadRequest, "request body is empty"))
+				resp.Fail(w, r, http.StatusBadRequest, resp.CodeInvalidBody, "request body is empty")
 				return
 			}
 			log.Error("failed to decode request", sl.Err(err))
-			w.WriteHeader(http.StatusBadRequest)
-			render.JSON(w, r, resp.Error(resp.BadRequest, `failed to decode request. Correct usage: "placeholder": "(email or username)", "password": "(password)"`))
+			resp.Fail(w, r, http.StatusBadRequest, resp.CodeInvalidBody, "failed to decode request")
 			return
 		}
 
@@ -62,22 +64,26 @@ func New(ctx context.Context, log *slog.Logger, cfg *config.Config, lc LoginClie
 			return
 		}
 
-		token, err := lc.Login(ctx, req.Placeholder, req.Password, cfg.App.ID)
+		token, err := lc.Login(r.Context(), req.Placeholder, req.Password, cfg.App.ID)
 		if err != nil {
 			if errors.Is(err, status.Error(codes.InvalidArgument, cds.InvalidCredentials)) {
 				log.Debug("invalid credentials", sl.Err(err))
-				w.WriteHeader(http.StatusBadRequest)
-				render.JSON(w, r, resp.Error(resp.BadRequest, "invalid credentials. Try registering at /register."))
+				resp.Fail(w, r, http.StatusUnauthorized, resp.CodeInvalidCredentials, "invalid credentials")
 				return
 			}
 
 			log.Error(cds.InternalError, sl.Err(err))
-			w.WriteHeader(http.StatusInternalServerError)
-			render.JSON(w, r, resp.Error(resp.InternalServerError, "internal server error"))
+			resp.FailError(w, r, err)
 			return
 		}
 
 		// user is logged in without any errors
+		if request.WantsHTML(r) {
+			session.Set(w, token, cfg.TokenTTL, cfg.Web.SecureCookies)
+			http.Redirect(w, r, "/", http.StatusSeeOther)
+			return
+		}
+
 		response := &Response{
 			Response:    resp.OK(),
 			AccessToken: token,
@@ -93,20 +99,17 @@ func New(ctx context.Context, log *slog.Logger, cfg *config.Config, lc LoginClie
 func validate(req Request, log *slog.Logger, w http.ResponseWriter, r *http.Request) bool {
 	if req.Placeholder == "" {
 		log.Debug("empty placeholder")
-		w.WriteHeader(http.StatusBadRequest)
-		render.JSON(w, r, resp.Error(resp.BadRequest, "empty placeholder"))
+		resp.Fail(w, r, http.StatusBadRequest, resp.CodeBadRequest, "empty placeholder")
 		return false
 	}
 	if req.Password == "" {
 		log.Debug("empty password")
-		w.WriteHeader(http.StatusBadRequest)
-		render.JSON(w, r, resp.Error(resp.BadRequest, "empty password"))
+		resp.Fail(w, r, http.StatusBadRequest, resp.CodeBadRequest, "empty password")
 		return false
 	}
 	if !validateRequest(req) {
 		log.Debug("bad request", slog.String("placeholder", req.Placeholder))
-		w.WriteHeader(http.StatusBadRequest)
-		render.JSON(w, r, resp.Error(resp.BadRequest, "invalid placeholder"))
+		resp.Fail(w, r, http.StatusBadRequest, resp.CodeBadRequest, "invalid placeholder")
 		return false
 	}
 	return true
@@ -0,0 +1,111 @@
+package preview
+
+import (
+	"context"
+	"errors"
+	"github.com/go-chi/chi/v5"
+	"github.com/go-chi/chi/v5/middleware"
+	"github.com/kxddry/url-shortener/internal/http-server/pages"
+	resp "github.com/kxddry/url-shortener/internal/lib/api/response"
+	"github.com/kxddry/url-shortener/internal/lib/logger/sl"
+	"github.com/kxddry/url-shortener/internal/storage"
+	"log/slog"
+	"net/http"
+	"strings"
+	"time"
+)
+
+type LinkGetter interface {
+	GetLink(ctx context.Context, domain, alias string) (storage.Link, error)
+}
+
+type DomainResolver interface {
+	Lookup(host string) (storage.Domain, bool)
+}
+
+// New renders the preview page for GET /{alias}+ and GET /{alias}?preview=1.
+// The link is read from the database, so that the click count is current.
+func New(log *slog.Logger, store LinkGetter, domains DomainResolver) http.HandlerFunc {
+	return func(w http.ResponseWriter, r *http.Request) {
+		const op = "handlers.url.preview.New"
+
+		log := log.With(
+			slog.String("op", op),
+			slog.String("request_id", middleware.GetReqID(r.Context())))
+
+		alias := strings.TrimSuffix(chi.URLParam(r, "alias"), "+")
+		domain, _ := domains.Lookup(r.Host)
+
+		link, err := store.GetLink(r.Context(), domain.Host, alias)
+		if errors.Is(err, storage.ErrAliasNotFound) {
+			render(log, w, http.StatusNotFound, "error.html", pages.Error{
+				Page:    pages.Page{PageTitle: "Link not found"},
+				Message: "There is no short link " + alias + " here.",
+			})
+			return
+		}
+		if err != nil {
+			log.Error("failed to get link", slog.String("alias", alias), sl.Err(err))
+			status := resp.FromError(err).Status
+			render(log, w, status, "error.html", pages.Error{
+				Page:    pages.Page{PageTitle: "Something went wrong"},
+				Message: "Please try again later.",
+			})
+			return
+		}
+
+		if link.Disabled(time.Now()) {
+			w.Header().Set("Cache-Control", "no-store")
+			render(log, w, http.StatusGone, "disabled.html", pages.Disabled{
+				Page:   pages.Page{PageTitle: "Link disabled"},
+				Alias:  alias,
+				Reason: link.DisabledReason,
+				Until:  link.DisabledUntil,
+			})
+			return
+		}
+		// the destination of a campaign stays secret until launch
+		if err = link.CheckWindow(time.Now()); err != nil {
+			message := "The short link " + alias + " has expired."
+			if errors.Is(err, storage.ErrLinkNotActive) {
+				message = "The short link " + alias + " is not active yet."
+			}
+			w.Header().Set("Cache-Control", "no-store")
+			render(log, w, resp.FromError(err).Status, "error.html", pages.Error{
+				Page:    pages.Page{PageTitle: "Link unavailable"},
+				Message: message,
+			})
+			return
+		}
+
+		shortURL := r.Host + "/" + alias
+		title := link.Title
+		if title == "" {
+			title = shortURL
+		}
+		w.Header().Set("Cache-Control", "private, no-cache")
+		render(log, w, http.StatusOK, "preview.html", pages.Preview{
+			Page:     pages.Page{PageTitle: title},
+			Link:     link,
+			ShortURL: shortURL,
+		})
+	}
+}
+
+// Switch serves preview for requests with ?preview=1 and next for everything else.
+func Switch(preview, next http.HandlerFunc) http.HandlerFunc {
+	return func(w http.ResponseWriter, r *http.Request) {
+		if r.URL.Query().Get("preview") == "1" {
+			preview(w, r)
+			return
+		}
+		next(w, r)
+	}
+}
+
+func render(log *slog.Logger, w http.ResponseWriter, status int, name string, data any) {
+	if err := pages.Render(w, status, name, data); err != nil {
+		log.Error("failed to render page", slog.String("page", name), sl.Err(err))
+		http.Error(w, http.StatusText(http.StatusInternalServerError), http.StatusInternalServerError)
+	}
+}
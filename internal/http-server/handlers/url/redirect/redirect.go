@@ -1,66 +1,307 @@
 package redirect
 
 import (
+	"context"
 	"errors"
+	"fmt"
 	"github.com/go-chi/chi/v5"
 	"github.com/go-chi/chi/v5/middleware"
-	"github.com/go-chi/render"
+	"github.com/kxddry/url-shortener/internal/config"
+	"github.com/kxddry/url-shortener/internal/http-server/pages"
+	"github.com/kxddry/url-shortener/internal/lib/api/request"
 	resp "github.com/kxddry/url-shortener/internal/lib/api/response"
+	"github.com/kxddry/url-shortener/internal/lib/forward"
 	"github.com/kxddry/url-shortener/internal/lib/logger/sl"
 	"github.com/kxddry/url-shortener/internal/storage"
+	"math/rand/v2"
+	"net"
 	"net/http"
+	"net/url"
+	"strings"
+	"time"
 )
 import (
 	"log/slog"
 )
 
-type URLGetSaver interface {
-	URLGetter
-	SaveURL(urlToSave, alias string, creator int64) (int64, error)
+type LinkGetSaver interface {
+	LinkGetter
+	SaveLink(ctx context.Context, link storage.Link) (int64, error)
 }
 
-type URLGetter interface {
-	GetURL(alias string) (string, error)
+type LinkGetter interface {
+	GetLink(ctx context.Context, domain, alias string) (storage.Link, error)
 }
 
-func New(log *slog.Logger, urlGetter URLGetter, redis URLGetSaver) http.HandlerFunc {
+type Storage interface {
+	LinkGetter
+	CountClick(ctx context.Context, domain, alias string) error
+}
+
+type DomainResolver interface {
+	Lookup(host string) (storage.Domain, bool)
+}
+
+type Blocklist interface {
+	Blocked(rawURL string) bool
+}
+
+// New redirects GET /{alias} and, for links forwarding the path, GET /{alias}/*.
+func New(log *slog.Logger, store Storage, redis LinkGetSaver, domains DomainResolver, blocklist Blocklist, cfg *config.Config) http.HandlerFunc {
 	return func(w http.ResponseWriter, r *http.Request) {
 		const op = "handlers.url.redirect.New"
 
-		log = log.With(
+		log := log.With(
 			slog.String("op", op),
 			slog.String("request_id", middleware.GetReqID(r.Context())))
 
 		alias := chi.URLParam(r, "alias")
 		if alias == "" {
 			log.Debug("alias is empty")
-			w.WriteHeader(http.StatusNotAcceptable)
-			render.JSON(w, r, resp.Error(resp.NotAcceptable, "alias is empty. Usage: POST to /url to create an alias or GET /{alias} to redirect"))
+			resp.Fail(w, r, http.StatusBadRequest, resp.CodeBadRequest, "alias is empty. Usage: POST to /url to create an alias or GET /{alias} to redirect")
 			return
 		}
-		resURL, err := redis.GetURL(alias) // check redis first
-		if err != nil {
-			resURL, err = urlGetter.GetURL(alias)
+		// hosts that aren't registered share the default namespace
+		domain, _ := domains.Lookup(r.Host)
+
+		link, err := redis.GetLink(r.Context(), domain.Host, alias) // check the cache first
+		cached := err == nil
+		if !cached {
+			link, err = store.GetLink(r.Context(), domain.Host, alias)
 		}
 		if errors.Is(err, storage.ErrAliasNotFound) {
-			log.Debug("alias not found", slog.String("alias", alias))
-			w.WriteHeader(http.StatusNotFound)
-			render.JSON(w, r, resp.Error(resp.NotFound, "alias not found"))
+			notFound(log, w, r, domain, alias)
 			return
 		}
 		if err != nil {
 			log.Error("failed to get URL", slog.String("alias", alias), sl.Err(err))
-			w.WriteHeader(http.StatusInternalServerError)
-			render.JSON(w, r, resp.Error(resp.InternalServerError, "failed to get URL"))
+			resp.FailError(w, r, err)
 			return
 		}
-		log.Debug("alias found", slog.String("alias", alias), slog.String("url", resURL))
-		_, err = redis.SaveURL(alias, resURL, 0) // cache the URL in redis
-		if err != nil {
-			log.Error("failed to save URL in redis", slog.String("alias", alias), slog.String("url", resURL), sl.Err(err))
+		log.Debug("alias found", slog.String("alias", alias), slog.String("url", link.URL))
+		if !cached {
+			_, err = redis.SaveLink(r.Context(), link) // cache the link
+			if err != nil {
+				log.Error("failed to save URL in redis", slog.String("alias", alias), slog.String("url", link.URL), sl.Err(err))
+			}
+		}
+
+		if link.Disabled(time.Now()) {
+			disabled(log, w, r, link)
+			return
+		}
+		if err = link.CheckWindow(time.Now()); err != nil {
+			outsideWindow(log, w, r, link, err)
+			return
+		}
+
+		suffix := pathSuffix(r)
+		if suffix != "" && !link.ForwardPath {
+			notFound(log, w, r, domain, alias)
+			return
+		}
+
+		target := link.URL
+		if t, ok := link.Rules.Evaluate(r, time.Now(), rand.IntN); ok {
+			target = t
+		}
+		if link.ForwardPath || link.ForwardQuery {
+			policy := link.QueryConflict
+			if policy == "" {
+				policy = cfg.Redirect.QueryConflict
+			}
+			var query url.Values
+			if link.ForwardQuery {
+				query = r.URL.Query()
+			}
+			target, err = forward.Target(target, suffix, query, policy)
+			if err != nil {
+				log.Info("failed to build forwarded URL", slog.String("alias", alias), sl.Err(err))
+				resp.Fail(w, r, http.StatusBadRequest, resp.CodeBadRequest, "invalid path")
+				return
+			}
+		}
+
+		// destinations blocked after the link was created don't get visitors either
+		if blocklist.Blocked(target) {
+			log.Info("destination is blocked", slog.String("alias", alias), slog.String("url", target))
+			w.Header().Set("Cache-Control", "no-store")
+			resp.Fail(w, r, http.StatusForbidden, resp.CodeDestinationBlocked, "the destination of the link is blocked")
+			return
+		}
+
+		limited := link.MaxClicks > 0
+		if limited {
+			// the click is claimed in the database before answering, so that
+			// concurrent visitors on any instance can't exceed the limit
+			err = store.CountClick(r.Context(), link.Domain, link.Alias)
+			if errors.Is(err, storage.ErrLinkExhausted) {
+				log.Info("link is used up", slog.String("domain", domain.Host), slog.String("alias", alias))
+				w.Header().Set("Cache-Control", "no-store")
+				resp.FailError(w, r, err)
+				return
+			}
+			if err != nil {
+				log.Error("failed to count click", slog.String("alias", alias), sl.Err(err))
+				resp.FailError(w, r, err)
+				return
+			}
+		}
+
+		code := link.RedirectCode
+		if code == 0 {
+			code = cfg.Redirect.DefaultCode
+		}
+		if (link.Interstitial || domain.Interstitial) && external(target, r.Host, domains) {
+			interstitial(log, w, r, cfg.Preview, link, target)
+			log.Info("shown interstitial", slog.String("domain", domain.Host), slog.String("alias", alias), slog.String("url", target))
+		} else {
+			setCacheHeaders(w, cfg.Redirect, code, len(link.Rules) > 0, link.NotAfter)
+			if limited {
+				// every visit has to reach us to be counted
+				w.Header().Set("Cache-Control", "no-store")
+				w.Header().Del("Expires")
+			}
+			http.Redirect(w, r, target, code)
+			log.Info("redirected", slog.String("domain", domain.Host), slog.String("alias", alias), slog.String("url", target))
+		}
+		if limited {
+			return
+		}
+
+		// the redirect has been sent, so the click counts even if the client hangs up now
+		err = store.CountClick(context.WithoutCancel(r.Context()), link.Domain, link.Alias)
+		switch {
+		case errors.Is(err, storage.ErrUnavailable):
+			// the breaker has logged the outage, the click is lost
+			log.Debug("click not counted, database unavailable", slog.String("alias", alias))
+		case err != nil && !errors.Is(err, storage.ErrLinkExhausted): // deleted in the meantime
+			log.Error("failed to count click", slog.String("alias", alias), sl.Err(err))
+		}
+		return
+	}
+}
+
+// external reports whether target leaves the service: neither the requested host nor any registered domain.
+func external(target, requestHost string, domains DomainResolver) bool {
+	u, err := url.Parse(target)
+	if err != nil {
+		return true
+	}
+	host := strings.ToLower(u.Hostname())
+	if host == "" {
+		return false
+	}
+	if h, _, err := net.SplitHostPort(requestHost); err == nil {
+		requestHost = h
+	}
+	if host == strings.ToLower(requestHost) {
+		return false
+	}
+	_, registered := domains.Lookup(host)
+	return !registered
+}
+
+func interstitial(log *slog.Logger, w http.ResponseWriter, r *http.Request, cfg config.Preview, link storage.Link, target string) {
+	data := pages.Interstitial{
+		Page:    pages.Page{PageTitle: "You are leaving " + r.Host},
+		Host:    r.Host,
+		Title:   link.Title,
+		Target:  target,
+		Seconds: int(cfg.Countdown.Seconds()),
+	}
+	// only follow automatically where a browser would, never to javascript: and the like
+	if u, err := url.Parse(target); err == nil && (u.Scheme == "http" || u.Scheme == "https") && data.Seconds > 0 {
+		data.Refresh = fmt.Sprintf("%d;url=%s", data.Seconds, target)
+	}
+
+	w.Header().Set("Cache-Control", "private, no-cache")
+	if err := pages.Render(w, http.StatusOK, "interstitial.html", data); err != nil {
+		log.Error("failed to render interstitial", sl.Err(err))
+		http.Redirect(w, r, target, http.StatusFound)
+	}
+}
+
+// disabled answers for a disabled link: browsers get a page saying so.
+func disabled(log *slog.Logger, w http.ResponseWriter, r *http.Request, link storage.Link) {
+	log.Info("link is disabled", slog.String("domain", link.Domain), slog.String("alias", link.Alias))
+	// it may be enabled again at any time
+	w.Header().Set("Cache-Control", "no-store")
+	if !request.WantsHTML(r) {
+		resp.FailError(w, r, storage.ErrLinkDisabled)
+		return
+	}
+	err := pages.Render(w, http.StatusGone, "disabled.html", pages.Disabled{
+		Page:   pages.Page{PageTitle: "Link disabled"},
+		Alias:  link.Alias,
+		Reason: link.DisabledReason,
+		Until:  link.DisabledUntil,
+	})
+	if err != nil {
+		log.Error("failed to render page", sl.Err(err))
+		resp.FailError(w, r, storage.ErrLinkDisabled)
+	}
+}
+
+// outsideWindow answers for a link visited before or after its activation window.
+// Visitors that are early go to the link's pre-launch URL if it has one.
+func outsideWindow(log *slog.Logger, w http.ResponseWriter, r *http.Request, link storage.Link, err error) {
+	// the answer changes once the window opens, nobody may keep it
+	w.Header().Set("Cache-Control", "no-store")
+	if errors.Is(err, storage.ErrLinkNotActive) {
+		if link.PrelaunchURL != "" {
+			http.Redirect(w, r, link.PrelaunchURL, http.StatusFound)
+			log.Info("redirected to pre-launch URL", slog.String("domain", link.Domain), slog.String("alias", link.Alias))
+			return
 		}
-		http.Redirect(w, r, resURL, http.StatusFound)
-		log.Info("redirected", slog.String("alias", alias), slog.String("url", resURL))
+		w.Header().Set("Retry-After", link.NotBefore.UTC().Format(http.TimeFormat))
+	}
+	log.Info("link is outside its activation window", slog.String("domain", link.Domain), slog.String("alias", link.Alias), sl.Err(err))
+	resp.FailError(w, r, err)
+}
+
+// setCacheHeaders tells browsers and CDNs how long they may reuse the redirect.
+// Links with rules redirect each visitor differently, so shared caches must not store them.
+// Links that stop working at until must not be reused after it.
+func setCacheHeaders(w http.ResponseWriter, cfg config.Redirect, code int, perVisitor bool, until *time.Time) {
+	maxAge := cfg.CacheMaxAge
+	if code == http.StatusMovedPermanently || code == http.StatusPermanentRedirect {
+		maxAge = cfg.PermanentMaxAge
+	}
+	if until != nil {
+		maxAge = min(maxAge, time.Until(*until).Truncate(time.Second))
+	}
+
+	scope := "public"
+	if perVisitor {
+		scope = "private"
+		w.Header().Set("Vary", "User-Agent, Accept-Language")
+	}
+	if maxAge <= 0 {
+		w.Header().Set("Cache-Control", scope+", no-cache")
+	} else {
+		w.Header().Set("Cache-Control", fmt.Sprintf("%s, max-age=%d", scope, int(maxAge.Seconds())))
+	}
+	w.Header().Set("Expires", time.Now().Add(maxAge).UTC().Format(http.TimeFormat))
+}
+
+func notFound(log *slog.Logger, w http.ResponseWriter, r *http.Request, domain storage.Domain, alias string) {
+	log.Debug("alias not found", slog.String("domain", domain.Host), slog.String("alias", alias))
+	if domain.FallbackURL != "" {
+		http.Redirect(w, r, domain.FallbackURL, http.StatusFound)
 		return
 	}
+	resp.Fail(w, r, http.StatusNotFound, resp.CodeAliasNotFound, "alias not found")
+}
+
+// pathSuffix returns the still escaped part of the path after /{alias}/.
+// It is taken from the request URL rather than the route, because
+// middleware.URLFormat strips extensions like .html from the route path.
+func pathSuffix(r *http.Request) string {
+	p := strings.TrimPrefix(r.URL.EscapedPath(), "/")
+	i := strings.IndexByte(p, '/')
+	if i < 0 {
+		return ""
+	}
+	return p[i+1:]
 }
@@ -0,0 +1,185 @@
+package redirect
+
+import (
+	"context"
+	"io"
+	"log/slog"
+	"net/http"
+	"net/http/httptest"
+	"sync"
+	"testing"
+	"time"
+
+	"github.com/go-chi/chi/v5"
+	"github.com/kxddry/url-shortener/internal/config"
+	resp "github.com/kxddry/url-shortener/internal/lib/api/response"
+	"github.com/kxddry/url-shortener/internal/lib/blocklist"
+	"github.com/kxddry/url-shortener/internal/storage"
+	"github.com/stretchr/testify/assert"
+)
+
+// fakeStore counts clicks like the conditional UPDATE in Postgres does.
+type fakeStore struct {
+	mu   sync.Mutex
+	link storage.Link
+}
+
+func (s *fakeStore) GetLink(_ context.Context, _, alias string) (storage.Link, error) {
+	if alias != s.link.Alias {
+		return storage.Link{}, storage.ErrAliasNotFound
+	}
+	return s.link, nil
+}
+
+func (s *fakeStore) SaveLink(context.Context, storage.Link) (int64, error) { return 0, nil }
+
+func (s *fakeStore) CountClick(context.Context, string, string) error {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	if s.link.MaxClicks > 0 && s.link.Clicks >= s.link.MaxClicks {
+		return storage.ErrLinkExhausted
+	}
+	s.link.Clicks++
+	return nil
+}
+
+type noBlocklist struct{}
+
+func (noBlocklist) Blocked(string) bool { return false }
+
+type noDomains struct{}
+
+func (noDomains) Lookup(string) (storage.Domain, bool) { return storage.Domain{}, false }
+
+func TestMaxClicks(t *testing.T) {
+	store := &fakeStore{link: storage.Link{Alias: "once", URL: "https://example.com", RedirectCode: http.StatusFound, MaxClicks: 3}}
+	cfg := &config.Config{Redirect: config.Redirect{QueryConflict: "target", DefaultCode: http.StatusFound}}
+	router := chi.NewRouter()
+	router.Get("/{alias}", New(slog.New(slog.NewTextHandler(io.Discard, nil)), store, store, noDomains{}, noBlocklist{}, cfg))
+
+	codes := make(chan int, 20)
+	var wg sync.WaitGroup
+	for range 20 {
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+			w := httptest.NewRecorder()
+			router.ServeHTTP(w, httptest.NewRequest(http.MethodGet, "/once", nil))
+			codes <- w.Code
+			if w.Code == http.StatusFound {
+				assert.Equal(t, "no-store", w.Header().Get("Cache-Control"))
+			}
+		}()
+	}
+	wg.Wait()
+	close(codes)
+
+	count := map[int]int{}
+	for c := range codes {
+		count[c]++
+	}
+	assert.Equal(t, map[int]int{http.StatusFound: 3, http.StatusGone: 17}, count)
+	assert.EqualValues(t, 3, store.link.Clicks)
+
+	w := httptest.NewRecorder()
+	router.ServeHTTP(w, httptest.NewRequest(http.MethodGet, "/once", nil))
+	assert.Equal(t, http.StatusGone, w.Code)
+	assert.Equal(t, resp.ContentTypeProblem, w.Header().Get("Content-Type"))
+	assert.Contains(t, w.Body.String(), `"code":"link_exhausted"`)
+}
+
+func TestActivationWindow(t *testing.T) {
+	cfg := &config.Config{Redirect: config.Redirect{QueryConflict: "target", DefaultCode: http.StatusFound, CacheMaxAge: time.Hour}}
+	in := func(d time.Duration) *time.Time {
+		t := time.Now().Add(d)
+		return &t
+	}
+
+	tests := []struct {
+		name     string
+		link     storage.Link
+		code     int
+		location string
+		problem  string
+	}{
+		{"not yet active", storage.Link{NotBefore: in(time.Hour)}, http.StatusForbidden, "", "link_not_active"},
+		{"pre-launch", storage.Link{NotBefore: in(time.Hour), PrelaunchURL: "https://example.com/soon"}, http.StatusFound, "https://example.com/soon", ""},
+		{"active", storage.Link{NotBefore: in(-time.Hour), NotAfter: in(time.Hour)}, http.StatusFound, "https://example.com", ""},
+		{"expired", storage.Link{NotAfter: in(-time.Hour), PrelaunchURL: "https://example.com/soon"}, http.StatusGone, "", "link_expired"},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			tt.link.Alias, tt.link.URL = "launch", "https://example.com"
+			store := &fakeStore{link: tt.link}
+			router := chi.NewRouter()
+			router.Get("/{alias}", New(slog.New(slog.NewTextHandler(io.Discard, nil)), store, store, noDomains{}, noBlocklist{}, cfg))
+
+			w := httptest.NewRecorder()
+			router.ServeHTTP(w, httptest.NewRequest(http.MethodGet, "/launch", nil))
+			assert.Equal(t, tt.code, w.Code)
+			assert.Equal(t, tt.location, w.Header().Get("Location"))
+			if tt.problem != "" {
+				assert.Contains(t, w.Body.String(), `"code":"`+tt.problem+`"`)
+				assert.Equal(t, "no-store", w.Header().Get("Cache-Control"))
+				assert.Zero(t, store.link.Clicks, "visits outside the window aren't clicks")
+			}
+		})
+	}
+
+	// browsers must not keep following a link past its end
+	store := &fakeStore{link: storage.Link{Alias: "launch", URL: "https://example.com", NotAfter: in(10 * time.Minute)}}
+	router := chi.NewRouter()
+	router.Get("/{alias}", New(slog.New(slog.NewTextHandler(io.Discard, nil)), store, store, noDomains{}, noBlocklist{}, cfg))
+	w := httptest.NewRecorder()
+	router.ServeHTTP(w, httptest.NewRequest(http.MethodGet, "/launch", nil))
+	assert.Regexp(t, `^public, max-age=59\d$`, w.Header().Get("Cache-Control"))
+}
+
+func TestModeration(t *testing.T) {
+	cfg := &config.Config{Redirect: config.Redirect{QueryConflict: "target", DefaultCode: http.StatusFound}}
+	now := time.Now()
+	earlier, later := now.Add(-time.Hour), now.Add(time.Hour)
+
+	tests := []struct {
+		name    string
+		link    storage.Link
+		blocked []string
+		code    int
+		problem string
+	}{
+		{"disabled", storage.Link{DisabledAt: &now, DisabledReason: "phishing"}, nil, http.StatusGone, "link_disabled"},
+		{"disabled for a while", storage.Link{DisabledAt: &now, DisabledUntil: &later}, nil, http.StatusGone, "link_disabled"},
+		{"no longer disabled", storage.Link{DisabledAt: &earlier, DisabledUntil: &now}, nil, http.StatusFound, ""},
+		{"blocked destination", storage.Link{}, []string{"example.com"}, http.StatusForbidden, "destination_blocked"},
+		{"allowed", storage.Link{}, []string{"example.org"}, http.StatusFound, ""},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			tt.link.Alias, tt.link.URL = "mod", "https://www.example.com"
+			store := &fakeStore{link: tt.link}
+			router := chi.NewRouter()
+			router.Get("/{alias}", New(slog.New(slog.NewTextHandler(io.Discard, nil)), store, store, noDomains{}, blocklist.New(tt.blocked), cfg))
+
+			w := httptest.NewRecorder()
+			router.ServeHTTP(w, httptest.NewRequest(http.MethodGet, "/mod", nil))
+			assert.Equal(t, tt.code, w.Code)
+			if tt.problem != "" {
+				assert.Contains(t, w.Body.String(), `"code":"`+tt.problem+`"`)
+				assert.Empty(t, w.Header().Get("Location"))
+				assert.Zero(t, store.link.Clicks)
+			}
+		})
+	}
+
+	// browsers are told what happened
+	store := &fakeStore{link: storage.Link{Alias: "mod", URL: "https://example.com", DisabledAt: &now, DisabledReason: "phishing"}}
+	router := chi.NewRouter()
+	router.Get("/{alias}", New(slog.New(slog.NewTextHandler(io.Discard, nil)), store, store, noDomains{}, noBlocklist{}, cfg))
+	w := httptest.NewRecorder()
+	r := httptest.NewRequest(http.MethodGet, "/mod", nil)
+	r.Header.Set("Accept", "text/html")
+	router.ServeHTTP(w, r)
+	assert.Equal(t, http.StatusGone, w.Code)
+	assert.Contains(t, w.Body.String(), "This link has been disabled")
+	assert.Contains(t, w.Body.String(), "phishing")
+}
@@ -0,0 +1,113 @@
+package report
+
+import (
+	"context"
+	"crypto/hmac"
+	"crypto/sha256"
+	"encoding/hex"
+	"errors"
+	"github.com/go-chi/chi/v5"
+	"github.com/go-chi/chi/v5/middleware"
+	"github.com/go-chi/render"
+	"github.com/go-playground/validator/v10"
+	"github.com/kxddry/url-shortener/internal/config"
+	"github.com/kxddry/url-shortener/internal/http-server/middleware/ratelimit"
+	"github.com/kxddry/url-shortener/internal/lib/api/request"
+	resp "github.com/kxddry/url-shortener/internal/lib/api/response"
+	"github.com/kxddry/url-shortener/internal/lib/logger/sl"
+	"github.com/kxddry/url-shortener/internal/storage"
+	"io"
+	"log/slog"
+	"net/http"
+)
+
+type Request struct {
+	Reason  string `json:"reason" validate:"required,oneof=phishing malware spam illegal other"`
+	Comment string `json:"comment,omitempty" validate:"max=1000"`
+}
+
+type Storage interface {
+	Report(ctx context.Context, domain, alias string, r storage.Report, auto storage.AutoDisable) (storage.Link, bool, error)
+}
+
+type CacheDeleter interface {
+	DeleteURL(ctx context.Context, domain, alias string) error
+}
+
+type DomainResolver interface {
+	Lookup(host string) (storage.Domain, bool)
+}
+
+// New takes an abuse report of a visitor against the link {alias} of the
+// requested host. No account is needed: reporters are told apart by their IP
+// address, of which only a keyed hash is stored. Enough distinct reporters
+// disable the link until an admin reviews it, see config.Reports.
+func New(log *slog.Logger, cfg *config.Config, store Storage, redis CacheDeleter, domains DomainResolver) http.HandlerFunc {
+	auto := storage.AutoDisable{After: cfg.Reports.AutoDisableAfter, For: cfg.Reports.AutoDisableFor}
+	return func(w http.ResponseWriter, r *http.Request) {
+		const op = "handlers.url.report.New"
+
+		log := log.With(
+			slog.String("op", op),
+			slog.String("request_id", middleware.GetReqID(r.Context())))
+
+		var req Request
+		if err := render.DecodeJSON(r.Body, &req); err != nil {
+			if errors.Is(err, io.EOF) {
+				log.Info("request body is empty")
+				resp.Fail(w, r, http.StatusBadRequest, resp.CodeInvalidBody, "request body is empty")
+				return
+			}
+			log.Error("failed to decode request", sl.Err(err))
+			resp.Fail(w, r, http.StatusBadRequest, resp.CodeInvalidBody, "failed to decode request")
+			return
+		}
+		if err := request.Validate(req); err != nil {
+			validateErr := err.(validator.ValidationErrors)
+			log.Info("invalid request", sl.Err(err))
+			resp.FailValidation(w, r, validateErr)
+			return
+		}
+
+		// hosts that aren't registered share the default namespace, as with redirects
+		domain, _ := domains.Lookup(r.Host)
+		alias := chi.URLParam(r, "alias")
+
+		link, disabled, err := store.Report(r.Context(), domain.Host, alias, storage.Report{
+			Reason:   req.Reason,
+			Comment:  req.Comment,
+			Reporter: reporter(cfg.App.Secret, ratelimit.ClientIP(r)),
+		}, auto)
+		if errors.Is(err, storage.ErrAliasNotFound) {
+			log.Info("alias not found", slog.String("alias", alias))
+			resp.FailError(w, r, err)
+			return
+		}
+		if err != nil {
+			log.Error("failed to save report", sl.Err(err))
+			resp.FailError(w, r, err)
+			return
+		}
+
+		log.Info("link reported", slog.String("domain", link.Domain), slog.String("alias", link.Alias),
+			slog.String("reason", req.Reason))
+		if disabled {
+			// the cached copy would keep redirecting
+			if err = redis.DeleteURL(context.WithoutCancel(r.Context()), link.Domain, link.Alias); err != nil {
+				log.Error("failed to invalidate cache", sl.Err(err))
+			}
+			log.Warn("link disabled by reports", slog.String("domain", link.Domain), slog.String("alias", link.Alias),
+				slog.Time("until", *link.DisabledUntil))
+		}
+
+		w.WriteHeader(http.StatusAccepted)
+		render.JSON(w, r, resp.OK())
+	}
+}
+
+// reporter identifies the client at ip without storing the address.
+func reporter(secret, ip string) string {
+	mac := hmac.New(sha256.New, []byte(secret))
+	mac.Write([]byte(ip))
+	return hex.EncodeToString(mac.Sum(nil))
+}
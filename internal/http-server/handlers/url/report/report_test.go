@@ -0,0 +1,76 @@
+package report
+
+import (
+	"context"
+	"io"
+	"log/slog"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/go-chi/chi/v5"
+	"github.com/kxddry/url-shortener/internal/config"
+	"github.com/kxddry/url-shortener/internal/storage"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+// fakeStore disables the link once auto.After distinct reporters reported it.
+type fakeStore struct {
+	reporters map[string]bool
+	deleted   []string
+}
+
+func (s *fakeStore) Report(_ context.Context, _, alias string, r storage.Report, auto storage.AutoDisable) (storage.Link, bool, error) {
+	if alias != "abc" {
+		return storage.Link{}, false, storage.ErrAliasNotFound
+	}
+	s.reporters[r.Reporter] = true
+	link := storage.Link{Alias: alias}
+	if len(s.reporters) != auto.After {
+		return link, false, nil
+	}
+	until := time.Now().Add(auto.For)
+	link.DisabledAt, link.DisabledUntil = &until, &until
+	return link, true, nil
+}
+
+func (s *fakeStore) DeleteURL(_ context.Context, _, alias string) error {
+	s.deleted = append(s.deleted, alias)
+	return nil
+}
+
+type noDomains struct{}
+
+func (noDomains) Lookup(string) (storage.Domain, bool) { return storage.Domain{}, false }
+
+func TestReport(t *testing.T) {
+	cfg := &config.Config{App: config.App{Secret: "secret"}, Reports: config.Reports{AutoDisableAfter: 2, AutoDisableFor: time.Hour}}
+	store := &fakeStore{reporters: map[string]bool{}}
+	router := chi.NewRouter()
+	router.Post("/{alias}/report", New(slog.New(slog.NewTextHandler(io.Discard, nil)), cfg, store, store, noDomains{}))
+
+	send := func(alias, ip, body string) *httptest.ResponseRecorder {
+		r := httptest.NewRequest(http.MethodPost, "/"+alias+"/report", strings.NewReader(body))
+		r.RemoteAddr = ip + ":1234"
+		w := httptest.NewRecorder()
+		router.ServeHTTP(w, r)
+		return w
+	}
+
+	assert.Equal(t, http.StatusBadRequest, send("abc", "10.0.0.1", `{"reason":"boring"}`).Code)
+	assert.Equal(t, http.StatusNotFound, send("nope", "10.0.0.1", `{"reason":"spam"}`).Code)
+
+	require.Equal(t, http.StatusAccepted, send("abc", "10.0.0.1", `{"reason":"phishing","comment":"asks for my password"}`).Code)
+	require.Equal(t, http.StatusAccepted, send("abc", "10.0.0.1", `{"reason":"spam"}`).Code)
+	assert.Len(t, store.reporters, 1, "a reporter counts once")
+	for r := range store.reporters {
+		assert.NotContains(t, r, "10.0.0.1", "addresses aren't stored")
+	}
+	assert.Empty(t, store.deleted)
+
+	require.Equal(t, http.StatusAccepted, send("abc", "10.0.0.2", `{"reason":"phishing"}`).Code)
+	assert.Equal(t, []string{"abc"}, store.deleted, "the disabled link leaves the cache")
+}
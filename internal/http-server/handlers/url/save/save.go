@@ -1,143 +1,344 @@
 package save
 
 import (
+	"context"
 	"errors"
-	"fmt"
 	"github.com/go-chi/chi/v5/middleware"
 	"github.com/go-chi/render"
 	"github.com/go-playground/validator/v10"
 	"github.com/kxddry/url-shortener/internal/config"
+	"github.com/kxddry/url-shortener/internal/http-server/pages"
+	"github.com/kxddry/url-shortener/internal/lib/api/request"
 	resp "github.com/kxddry/url-shortener/internal/lib/api/response"
 	"github.com/kxddry/url-shortener/internal/lib/genalias"
 	"github.com/kxddry/url-shortener/internal/lib/jwt"
 	"github.com/kxddry/url-shortener/internal/lib/logger/sl"
+	"github.com/kxddry/url-shortener/internal/lib/rules"
+	"github.com/kxddry/url-shortener/internal/lib/tags"
 	"github.com/kxddry/url-shortener/internal/storage"
 	"io"
 	"log/slog"
 	"net/http"
+	"time"
 )
 
 type Request struct {
-	URL   string `json:"url" validate:"required,url"`
-	Alias string `json:"alias,omitempty"`
+	URL    string `json:"url" validate:"required,url"`
+	Alias  string `json:"alias,omitempty" validate:"omitempty,excludes=/,endsnotwith=+"` // {alias}+ is the preview page
+	Domain string `json:"domain,omitempty"`                                              // one of the domains registered by an admin
+	// WorkspaceID creates the link in a workspace the caller is an editor of.
+	WorkspaceID int64 `json:"workspace_id,omitempty" validate:"omitempty,gt=0"`
+
+	ForwardQuery  bool   `json:"forward_query,omitempty"`
+	ForwardPath   bool   `json:"forward_path,omitempty"`
+	QueryConflict string `json:"query_conflict,omitempty" validate:"omitempty,oneof=target request both"`
+
+	Rules rules.Set `json:"rules,omitempty"`
+	// RedirectCode defaults to redirect.default_code from the config.
+	RedirectCode int `json:"redirect_code,omitempty" validate:"omitempty,oneof=301 302 307 308"`
+
+	// Title and Description are shown on the preview page.
+	Title        string `json:"title,omitempty" validate:"max=200"`
+	Description  string `json:"description,omitempty" validate:"max=1000"`
+	Interstitial bool   `json:"interstitial,omitempty"`
+	// MaxClicks makes the link stop working after that many clicks.
+	MaxClicks int64 `json:"max_clicks,omitempty" validate:"omitempty,gt=0"`
+	// NotBefore and NotAfter limit when the link redirects.
+	NotBefore *time.Time `json:"not_before,omitempty"`
+	NotAfter  *time.Time `json:"not_after,omitempty"`
+	// PrelaunchURL is where visitors go before NotBefore.
+	PrelaunchURL string `json:"prelaunch_url,omitempty" validate:"omitempty,url"`
+
+	// Notes are only shown to whoever manages the link.
+	Notes string   `json:"notes,omitempty" validate:"max=5000"`
+	Tags  []string `json:"tags,omitempty"`
 }
 
 type Response struct {
 	resp.Response
-	Alias string `json:"alias,omitempty"`
+	Alias  string `json:"alias,omitempty"`
+	Domain string `json:"domain,omitempty"`
+	// Queued is set when the link is only created once the database is back.
+	// QueueID then looks up at /me/queued/{id} whether it was, and with which alias.
+	Queued  bool   `json:"queued,omitempty"`
+	QueueID string `json:"queue_id,omitempty"`
+}
+
+type LinkSaver interface {
+	SaveLink(ctx context.Context, link storage.Link) (int64, error)
+}
+
+type LinkGetter interface {
+	GetLink(ctx context.Context, domain, alias string) (storage.Link, error)
 }
 
-type URLSaver interface {
-	SaveURL(urlToSave, alias string, creator int64) (int64, error)
+// Store creates links within the quota of their owner.
+type Store interface {
+	LinkGetter
+	SaveLinkWithin(ctx context.Context, link storage.Link, defaults storage.Quota, customAlias bool) (int64, error)
 }
 
-type URLGetter interface {
-	GetURL(alias string) (string, error)
+type Blocklist interface {
+	Blocked(rawURL string) bool
 }
 
-type URLSaveGetter interface {
-	URLSaver
-	URLGetter
+type DomainResolver interface {
+	Lookup(host string) (storage.Domain, bool)
+}
+
+type Access interface {
+	Member(ctx context.Context, uid, workspaceID int64, min string) (bool, error)
+}
+
+// Queue keeps links for later while the database is unavailable.
+type Queue interface {
+	// QueueCreation returns storage.ErrAliasExists if a queued link has the alias already.
+	QueueCreation(ctx context.Context, q storage.QueuedLink, maxLen int64) (string, error)
 }
 
 const aliasLength = 6
 
-func New(log *slog.Logger, urlSaver URLSaveGetter, redis URLSaver, cfg *config.Config) http.HandlerFunc {
+// queueAttempts is how many generated aliases a queued link tries when they
+// are taken by other queued links.
+const queueAttempts = 3
+
+// reserved aliases collide with the service's own routes.
+var reserved = map[string]bool{
+	"url":        true,
+	"admin":      true,
+	"me":         true,
+	"workspaces": true,
+	"webhooks":   true,
+	"login":      true,
+	"register":   true,
+	"logout":     true,
+	"healthz":    true,
+	"readyz":     true,
+	"s":          true, // signed links
+}
+
+// New creates a link. While the database is unavailable the request fails
+// with 503, unless degraded.queue_creations is set: then the link is queued
+// and created once the database is back, see replay.Replayer.
+func New(log *slog.Logger, linkSaver Store, redis LinkSaver, cfg *config.Config, blocklist Blocklist, domains DomainResolver, access Access, queue Queue) http.HandlerFunc {
 	return func(w http.ResponseWriter, r *http.Request) {
 		const op = "handlers.url.save.New"
 
-		log = log.With(
+		log := log.With(
 			slog.String("op", op),
 			slog.String("request_id", middleware.GetReqID(r.Context())),
 		)
 
 		var req Request
 
-		if err := render.DecodeJSON(r.Body, &req); err != nil {
+		if err := request.Decode(r, &req); err != nil {
 			if errors.Is(err, io.EOF) {
 				log.Info("request body is empty")
-				w.WriteHeader(http.StatusBadRequest)
-				render.JSON(w, r, resp.Error(resp.BadRequest, "request body is empty"))
+				resp.Fail(w, r, http.StatusBadRequest, resp.CodeInvalidBody, "request body is empty")
 				return
 			}
 			log.Error("failed to decode request", sl.Err(err))
-			w.WriteHeader(http.StatusBadRequest)
-			render.JSON(w, r, resp.Error(resp.BadRequest, "failed to decode request"))
+			resp.Fail(w, r, http.StatusBadRequest, resp.CodeInvalidBody, "failed to decode request")
 			return
 		}
 
-		uid, err := jwt.UIDfromHeader(r, cfg.App.Secret)
+		uid, err := jwt.UIDfromRequest(r, cfg.App.Secret)
 
 		if err != nil {
 			if errors.Is(err, jwt.ErrNoHeader) {
 				log.Info("not logged in")
-				w.WriteHeader(http.StatusUnauthorized)
-				render.JSON(w, r, resp.Error(resp.Unauthorized, "You're not logged in. Go to /login."))
+				resp.FailError(w, r, err)
 				return
 			}
 
 			log.Error("invalid token", slog.Any("jwt", r.Header.Get("Authorization")))
-			w.WriteHeader(http.StatusUnauthorized)
-			render.JSON(w, r, resp.Error(resp.Unauthorized, "invalid token"))
+			resp.Fail(w, r, http.StatusUnauthorized, resp.CodeInvalidToken, "invalid token")
 			return
 		}
 
 		log.Info("request body decoded", slog.Any("request", req))
 
-		if err := validator.New().Struct(req); err != nil {
+		if err := request.Validate(req); err != nil {
 			validateErr := err.(validator.ValidationErrors)
 			log.Error("invalid request", sl.Err(err))
-			w.WriteHeader(http.StatusBadRequest)
-			render.JSON(w, r, resp.ValidationError(validateErr))
+			resp.FailValidation(w, r, validateErr)
+			return
+		}
+
+		if err := req.Rules.Validate(); err != nil {
+			log.Info("invalid rules", sl.Err(err))
+			resp.Fail(w, r, http.StatusBadRequest, resp.CodeBadRequest, err.Error())
+			return
+		}
+
+		if req.NotBefore != nil && req.NotAfter != nil && !req.NotAfter.After(*req.NotBefore) {
+			log.Info("invalid activation window")
+			resp.Fail(w, r, http.StatusBadRequest, resp.CodeBadRequest, "not_after must be after not_before")
 			return
 		}
 
+		if req.WorkspaceID != 0 {
+			ok, err := access.Member(r.Context(), uid, req.WorkspaceID, storage.RoleEditor)
+			if errors.Is(err, storage.ErrUnavailable) && cfg.Degraded.QueueCreations {
+				// checked again before the queued link is created
+				ok, err = true, nil
+			}
+			if err != nil {
+				log.Error("failed to check membership", sl.Err(err))
+				resp.FailError(w, r, err)
+				return
+			}
+			if !ok {
+				log.Info("not an editor of the workspace", slog.Int64("uid", uid), slog.Int64("workspace_id", req.WorkspaceID))
+				resp.Fail(w, r, http.StatusForbidden, resp.CodeForbidden, "you need to be a workspace editor")
+				return
+			}
+		}
+
+		linkTags, err := tags.Normalize(req.Tags)
+		if err != nil {
+			log.Info("invalid tags", sl.Err(err))
+			resp.Fail(w, r, http.StatusBadRequest, resp.CodeBadRequest, err.Error())
+			return
+		}
+
+		for _, u := range append(req.Rules.URLs(), req.URL, req.PrelaunchURL) {
+			if blocklist.Blocked(u) {
+				log.Info("destination is blocked", slog.String("url", u))
+				resp.Fail(w, r, http.StatusForbidden, resp.CodeDestinationBlocked, "destination domain is blocked")
+				return
+			}
+		}
+
+		domain := ""
+		if req.Domain != "" {
+			d, ok := domains.Lookup(req.Domain)
+			if !ok {
+				log.Info("unknown domain", slog.String("domain", req.Domain))
+				resp.Fail(w, r, http.StatusNotFound, resp.CodeDomainNotFound, "domain not found")
+				return
+			}
+			domain = d.Host
+		}
+
 		alias := req.Alias
 		if alias == "" {
 			var err error
-			alias, err = genalias.GenerateAlias(aliasLength, urlSaver)
+			alias, err = genalias.GenerateAlias(r.Context(), aliasLength, domain, linkSaver)
 			if err != nil {
 				log.Error("failed to generate alias", sl.Err(err))
-				w.WriteHeader(http.StatusInternalServerError)
-				render.JSON(w, r, resp.Error(resp.InternalServerError, "failed to generate alias"))
+				resp.FailError(w, r, err)
 				return
 			}
 			log.Info("generated alias", slog.String("alias", alias))
 		}
-		if alias == "url" {
+		if reserved[alias] {
 			log.Error("alias is reserved", slog.String("alias", alias))
-			w.WriteHeader(http.StatusNotAcceptable)
-			render.JSON(w, r, resp.Error(resp.NotAcceptable, "alias is reserved"))
+			resp.Fail(w, r, http.StatusConflict, resp.CodeAliasReserved, "alias is reserved")
 			return
 		}
 
-		// save to redis first
-		_, err = redis.SaveURL(req.URL, alias, uid)
-		if err != nil {
-			log.Error(fmt.Sprintf("failed to save to redis %s: %w", op, err))
+		link := storage.Link{
+			Domain:        domain,
+			Alias:         alias,
+			URL:           req.URL,
+			CreatedBy:     uid,
+			WorkspaceID:   req.WorkspaceID,
+			ForwardQuery:  req.ForwardQuery,
+			ForwardPath:   req.ForwardPath,
+			QueryConflict: req.QueryConflict,
+			Rules:         req.Rules,
+			RedirectCode:  req.RedirectCode,
+			Title:         req.Title,
+			Description:   req.Description,
+			Interstitial:  req.Interstitial,
+			MaxClicks:     req.MaxClicks,
+			NotBefore:     req.NotBefore,
+			NotAfter:      req.NotAfter,
+			PrelaunchURL:  req.PrelaunchURL,
+			Notes:         req.Notes,
+			Tags:          linkTags,
 		}
-
-		id, err := urlSaver.SaveURL(req.URL, alias, uid)
+		if link.RedirectCode == 0 {
+			link.RedirectCode = cfg.Redirect.DefaultCode
+		}
+		id, err := linkSaver.SaveLinkWithin(r.Context(), link, storage.Quota(cfg.Quota), req.Alias != "")
 		if errors.Is(err, storage.ErrAliasExists) {
 			log.Error("alias already exists", sl.Err(err))
-			w.WriteHeader(http.StatusNotAcceptable)
-			render.JSON(w, r, resp.Error(resp.NotAcceptable, "alias already exists"))
+			resp.FailError(w, r, err)
+			return
+		}
+		if errors.Is(err, storage.ErrQuotaExceeded) {
+			log.Info("quota exceeded", slog.Int64("uid", uid), slog.Int64("workspace_id", req.WorkspaceID), sl.Err(err))
+			resp.FailError(w, r, err)
+			return
+		}
+		if errors.Is(err, storage.ErrUnavailable) && cfg.Degraded.QueueCreations {
+			queueLink(log, w, r, cfg, queue, linkSaver, link, req.Alias != "", err)
 			return
 		}
 		if err != nil {
 			log.Error("failed to save url", sl.Err(err))
-			w.WriteHeader(http.StatusInternalServerError)
-			render.JSON(w, r, resp.Error(resp.InternalServerError, "failed to save url"))
+			resp.FailError(w, r, err)
 			return
 		}
-		log.Info("url saved", slog.Int64("id", id), slog.String("alias", alias))
-		responseOK(w, r, alias)
+		log.Info("url saved", slog.Int64("id", id), slog.String("domain", domain), slog.String("alias", alias))
+
+		// cache only once the alias is known to be ours
+		link.ID = id
+		_, err = redis.SaveLink(r.Context(), link)
+		if err != nil {
+			log.Error("failed to save to redis", sl.Err(err))
+		}
+
+		if request.WantsHTML(r) {
+			http.Redirect(w, r, pages.LinkPath(domain, alias, ""), http.StatusSeeOther)
+			return
+		}
+		responseOK(w, r, domain, alias)
 	}
 }
 
-func responseOK(w http.ResponseWriter, r *http.Request, alias string) {
+func responseOK(w http.ResponseWriter, r *http.Request, domain, alias string) {
 	render.JSON(w, r, Response{
 		Response: resp.OK(),
 		Alias:    alias,
+		Domain:   domain,
+	})
+}
+
+// queueLink queues link to be created once the database is back, which
+// failed with unavailable. Its alias may still turn out to be taken then,
+// so the client is told where to look up what became of it.
+func queueLink(log *slog.Logger, w http.ResponseWriter, r *http.Request, cfg *config.Config, queue Queue, links LinkGetter,
+	link storage.Link, customAlias bool, unavailable error) {
+	q := storage.QueuedLink{Link: link, CustomAlias: customAlias, QueuedAt: time.Now()}
+	id, err := queue.QueueCreation(r.Context(), q, cfg.Degraded.MaxQueued)
+	for i := 1; i < queueAttempts && !customAlias && errors.Is(err, storage.ErrAliasExists); i++ {
+		if q.Link.Alias, err = genalias.GenerateAlias(r.Context(), aliasLength, link.Domain, links); err != nil {
+			break
+		}
+		id, err = queue.QueueCreation(r.Context(), q, cfg.Degraded.MaxQueued)
+	}
+	if errors.Is(err, storage.ErrAliasExists) && customAlias {
+		log.Info("alias is queued already", slog.String("alias", link.Alias))
+		resp.FailError(w, r, err)
+		return
+	}
+	if err != nil {
+		log.Error("failed to queue url", sl.Err(err))
+		resp.FailError(w, r, unavailable)
+		return
+	}
+
+	log.Warn("database unavailable, url queued", slog.String("domain", q.Link.Domain), slog.String("alias", q.Link.Alias),
+		slog.String("queue_id", id))
+	w.WriteHeader(http.StatusAccepted)
+	render.JSON(w, r, Response{
+		Response: resp.Info("the link is queued until the database is back, see /me/queued/" + id + " for whether it was created"),
+		Alias:    q.Link.Alias,
+		Domain:   q.Link.Domain,
+		Queued:   true,
+		QueueID:  id,
 	})
 }
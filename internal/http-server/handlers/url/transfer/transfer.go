@@ -0,0 +1,135 @@
+package transfer
+
+import (
+	"context"
+	"errors"
+	"github.com/go-chi/chi/v5"
+	"github.com/go-chi/chi/v5/middleware"
+	"github.com/go-chi/render"
+	"github.com/go-playground/validator/v10"
+	"github.com/kxddry/url-shortener/internal/config"
+	"github.com/kxddry/url-shortener/internal/http-server/middleware/auth"
+	"github.com/kxddry/url-shortener/internal/lib/api/request"
+	resp "github.com/kxddry/url-shortener/internal/lib/api/response"
+	"github.com/kxddry/url-shortener/internal/lib/logger/sl"
+	"github.com/kxddry/url-shortener/internal/storage"
+	"io"
+	"log/slog"
+	"net/http"
+)
+
+// Request names exactly one new owner: a user or a workspace.
+type Request struct {
+	UserID      int64 `json:"user_id,omitempty" validate:"required_without=WorkspaceID,excluded_with=WorkspaceID,gte=0"`
+	WorkspaceID int64 `json:"workspace_id,omitempty" validate:"required_without=UserID,gte=0"`
+}
+
+type Storage interface {
+	GetLink(ctx context.Context, domain, alias string) (storage.Link, error)
+	// TransferLink hands the link over once check, given the link read from
+	// the primary and locked, returns nil, and returns a *storage.QuotaError
+	// if the new owner's quota of active links has no room for it.
+	TransferLink(ctx context.Context, domain, alias string, uid, workspaceID int64, defaults storage.Quota,
+		check func(link storage.Link) error) error
+}
+
+type CacheDeleter interface {
+	DeleteURL(ctx context.Context, domain, alias string) error
+}
+
+type Access interface {
+	Link(ctx context.Context, uid int64, link storage.Link, min string) (bool, error)
+	Member(ctx context.Context, uid, workspaceID int64, min string) (bool, error)
+}
+
+type DomainResolver interface {
+	Resolve(r *http.Request) (storage.Domain, error)
+}
+
+// New hands a link over to another user or workspace. The caller has to own
+// the link (be its creator, or an owner of its workspace) and be an editor of
+// the workspace it moves to, whose quota of active links must have room for it.
+func New(log *slog.Logger, store Storage, redis CacheDeleter, access Access, domains DomainResolver, defaults config.Quota) http.HandlerFunc {
+	return func(w http.ResponseWriter, r *http.Request) {
+		const op = "handlers.url.transfer.New"
+
+		log := log.With(
+			slog.String("op", op),
+			slog.String("request_id", middleware.GetReqID(r.Context())))
+
+		var req Request
+		if err := render.DecodeJSON(r.Body, &req); err != nil {
+			if errors.Is(err, io.EOF) {
+				log.Info("request body is empty")
+				resp.Fail(w, r, http.StatusBadRequest, resp.CodeInvalidBody, "request body is empty")
+				return
+			}
+			log.Error("failed to decode request", sl.Err(err))
+			resp.Fail(w, r, http.StatusBadRequest, resp.CodeInvalidBody, "failed to decode request")
+			return
+		}
+
+		if err := request.Validate(req); err != nil {
+			validateErr := err.(validator.ValidationErrors)
+			log.Info("invalid request", sl.Err(err))
+			resp.FailValidation(w, r, validateErr)
+			return
+		}
+
+		domain, err := domains.Resolve(r)
+		if err != nil {
+			resp.Fail(w, r, http.StatusNotFound, resp.CodeDomainNotFound, "domain not found")
+			return
+		}
+
+		alias := chi.URLParam(r, "alias")
+		uid := auth.UID(r.Context())
+		// authorized before the link is locked, which the check only compares the owner with
+		seen, err := store.GetLink(r.Context(), domain.Host, alias)
+		if err != nil {
+			if !errors.Is(err, storage.ErrAliasNotFound) {
+				log.Error("failed to get link", sl.Err(err))
+			}
+			resp.FailError(w, r, err)
+			return
+		}
+		allowed, err := access.Link(r.Context(), uid, seen, storage.RoleOwner)
+		if err == nil && allowed && req.WorkspaceID != 0 {
+			allowed, err = access.Member(r.Context(), uid, req.WorkspaceID, storage.RoleEditor)
+		}
+		if err != nil {
+			log.Error("failed to check access", sl.Err(err))
+			resp.FailError(w, r, err)
+			return
+		}
+		if !allowed {
+			log.Info("user tried to transfer alias", slog.Int64("uid", uid), slog.String("alias", alias))
+			resp.Fail(w, r, http.StatusForbidden, resp.CodeForbidden, "you need to own the link and be an editor of the new workspace")
+			return
+		}
+
+		err = store.TransferLink(r.Context(), domain.Host, alias, req.UserID, req.WorkspaceID, storage.Quota(defaults), func(link storage.Link) error {
+			if !link.SameOwner(seen) {
+				return storage.ErrOwnerChanged
+			}
+			return nil
+		})
+		if err != nil {
+			switch {
+			case errors.Is(err, storage.ErrQuotaExceeded):
+				log.Info("quota exceeded", slog.Int64("to_user_id", req.UserID), slog.Int64("to_workspace_id", req.WorkspaceID), sl.Err(err))
+			case !errors.Is(err, storage.ErrAliasNotFound) && !errors.Is(err, storage.ErrOwnerChanged):
+				log.Error("failed to transfer link", sl.Err(err))
+			}
+			resp.FailError(w, r, err)
+			return
+		}
+		if err = redis.DeleteURL(context.WithoutCancel(r.Context()), domain.Host, alias); err != nil {
+			log.Error("failed to invalidate cache", sl.Err(err))
+		}
+
+		log.Info("link transferred", slog.String("domain", domain.Host), slog.String("alias", alias),
+			slog.Int64("uid", uid), slog.Int64("to_user_id", req.UserID), slog.Int64("to_workspace_id", req.WorkspaceID))
+		render.JSON(w, r, resp.OK())
+	}
+}
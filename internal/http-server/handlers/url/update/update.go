@@ -0,0 +1,245 @@
+package update
+
+import (
+	"context"
+	"errors"
+	"github.com/go-chi/chi/v5"
+	"github.com/go-chi/chi/v5/middleware"
+	"github.com/go-chi/render"
+	"github.com/go-playground/validator/v10"
+	"github.com/kxddry/url-shortener/internal/config"
+	"github.com/kxddry/url-shortener/internal/http-server/pages"
+	"github.com/kxddry/url-shortener/internal/lib/api/request"
+	resp "github.com/kxddry/url-shortener/internal/lib/api/response"
+	"github.com/kxddry/url-shortener/internal/lib/jwt"
+	"github.com/kxddry/url-shortener/internal/lib/logger/sl"
+	"github.com/kxddry/url-shortener/internal/lib/rules"
+	"github.com/kxddry/url-shortener/internal/lib/tags"
+	"github.com/kxddry/url-shortener/internal/storage"
+	"io"
+	"log/slog"
+	"net/http"
+	"time"
+)
+
+// Request is a partial update: fields that are omitted are left as they are.
+type Request struct {
+	URL           *string    `json:"url,omitempty" validate:"omitempty,url"`
+	ForwardQuery  *bool      `json:"forward_query,omitempty"`
+	ForwardPath   *bool      `json:"forward_path,omitempty"`
+	QueryConflict *string    `json:"query_conflict,omitempty" validate:"omitempty,oneof=target request both"`
+	Rules         *rules.Set `json:"rules,omitempty"`
+	RedirectCode  *int       `json:"redirect_code,omitempty" validate:"omitempty,oneof=301 302 307 308"`
+	Title         *string    `json:"title,omitempty" validate:"omitempty,max=200"`
+	Description   *string    `json:"description,omitempty" validate:"omitempty,max=1000"`
+	Interstitial  *bool      `json:"interstitial,omitempty"`
+	Notes         *string    `json:"notes,omitempty" validate:"omitempty,max=5000"`
+	// NotBefore and NotAfter are cleared by sending null.
+	NotBefore request.Nullable[time.Time] `json:"not_before"`
+	NotAfter  request.Nullable[time.Time] `json:"not_after"`
+	// PrelaunchURL is removed by sending an empty one.
+	PrelaunchURL *string `json:"prelaunch_url,omitempty" validate:"omitempty,eq=|url"`
+	// Tags replaces the link's tags; an empty list removes them all.
+	Tags *[]string `json:"tags,omitempty"`
+}
+
+type Response struct {
+	resp.Response
+	Link storage.Link `json:"link"`
+}
+
+type Storage interface {
+	GetLink(ctx context.Context, domain, alias string) (storage.Link, error)
+	// UpdateLink hands the link, read from the primary and locked, to edit
+	// and stores what edit left it with, unless edit fails.
+	UpdateLink(ctx context.Context, domain, alias string, edit func(link *storage.Link) error) (storage.Link, error)
+}
+
+type CacheDeleter interface {
+	DeleteURL(ctx context.Context, domain, alias string) error
+}
+
+type Access interface {
+	Link(ctx context.Context, uid int64, link storage.Link, min string) (bool, error)
+}
+
+type DomainResolver interface {
+	Resolve(r *http.Request) (storage.Domain, error)
+}
+
+type Blocklist interface {
+	Blocked(rawURL string) bool
+}
+
+func New(log *slog.Logger, cfg *config.Config, store Storage, redis CacheDeleter, access Access, domains DomainResolver, blocklist Blocklist) http.HandlerFunc {
+	return func(w http.ResponseWriter, r *http.Request) {
+		const op = "handlers.url.update.New"
+
+		log := log.With(
+			slog.String("op", op),
+			slog.String("request_id", middleware.GetReqID(r.Context())))
+
+		uid, err := jwt.UIDfromRequest(r, cfg.App.Secret)
+		if err != nil {
+			if errors.Is(err, jwt.ErrNoHeader) {
+				resp.FailError(w, r, err)
+				return
+			}
+			log.Info("invalid token", sl.Err(err))
+			resp.Fail(w, r, http.StatusUnauthorized, resp.CodeInvalidToken, "invalid token")
+			return
+		}
+
+		var req Request
+		if err := request.Decode(r, &req); err != nil {
+			if errors.Is(err, io.EOF) {
+				log.Info("request body is empty")
+				resp.Fail(w, r, http.StatusBadRequest, resp.CodeInvalidBody, "request body is empty")
+				return
+			}
+			log.Error("failed to decode request", sl.Err(err))
+			resp.Fail(w, r, http.StatusBadRequest, resp.CodeInvalidBody, "failed to decode request")
+			return
+		}
+
+		if err := request.Validate(req); err != nil {
+			validateErr := err.(validator.ValidationErrors)
+			log.Info("invalid request", sl.Err(err))
+			resp.FailValidation(w, r, validateErr)
+			return
+		}
+
+		if req.Tags != nil {
+			normalized, err := tags.Normalize(*req.Tags)
+			if err != nil {
+				log.Info("invalid tags", sl.Err(err))
+				resp.Fail(w, r, http.StatusBadRequest, resp.CodeBadRequest, err.Error())
+				return
+			}
+			req.Tags = &normalized
+		}
+
+		domain, err := domains.Resolve(r)
+		if err != nil {
+			resp.Fail(w, r, http.StatusNotFound, resp.CodeDomainNotFound, "domain not found")
+			return
+		}
+
+		alias := chi.URLParam(r, "alias")
+		// authorized before the link is locked, which the edit only checks is still owned the same
+		seen, err := store.GetLink(r.Context(), domain.Host, alias)
+		if errors.Is(err, storage.ErrAliasNotFound) {
+			resp.FailError(w, r, err)
+			return
+		}
+		if err != nil {
+			log.Error("failed to get link", sl.Err(err))
+			resp.FailError(w, r, err)
+			return
+		}
+
+		allowed, err := access.Link(r.Context(), uid, seen, storage.RoleEditor)
+		if err != nil {
+			log.Error("internal error!", sl.Err(err))
+			resp.FailError(w, r, err)
+			return
+		}
+		if !allowed {
+			log.Info("user tried to update alias", slog.Int64("uid", uid), slog.String("alias", alias))
+			resp.Fail(w, r, http.StatusForbidden, resp.CodeForbidden, "you can only update your own or your workspace's links")
+			return
+		}
+
+		link, err := store.UpdateLink(r.Context(), domain.Host, alias, func(link *storage.Link) error {
+			if !link.SameOwner(seen) {
+				return storage.ErrOwnerChanged
+			}
+
+			apply(link, req)
+
+			if err := link.Rules.Validate(); err != nil {
+				log.Info("invalid rules", sl.Err(err))
+				return resp.NewProblem(http.StatusBadRequest, resp.CodeBadRequest, err.Error())
+			}
+			if link.NotBefore != nil && link.NotAfter != nil && !link.NotAfter.After(*link.NotBefore) {
+				log.Info("invalid activation window")
+				return resp.NewProblem(http.StatusBadRequest, resp.CodeBadRequest, "not_after must be after not_before")
+			}
+			for _, u := range append(link.Rules.URLs(), link.URL, link.PrelaunchURL) {
+				if blocklist.Blocked(u) {
+					log.Info("destination is blocked", slog.String("url", u))
+					return resp.NewProblem(http.StatusForbidden, resp.CodeDestinationBlocked, "destination domain is blocked")
+				}
+			}
+			return nil
+		})
+		if err != nil {
+			var p resp.Problem
+			if !errors.As(err, &p) && !errors.Is(err, storage.ErrAliasNotFound) && !errors.Is(err, storage.ErrOwnerChanged) {
+				log.Error("failed to update link", sl.Err(err))
+			}
+			resp.FailError(w, r, err)
+			return
+		}
+		// the next redirect caches the new version; the update is committed,
+		// so the cache is invalidated even if the client has gone
+		if err = redis.DeleteURL(context.WithoutCancel(r.Context()), link.Domain, link.Alias); err != nil {
+			log.Error("failed to invalidate cache", sl.Err(err))
+		}
+
+		log.Info("link updated", slog.String("domain", link.Domain), slog.String("alias", link.Alias), slog.Int64("uid", uid))
+		if request.WantsHTML(r) {
+			http.Redirect(w, r, pages.LinkPath(link.Domain, link.Alias, ""), http.StatusSeeOther)
+			return
+		}
+		render.JSON(w, r, Response{
+			Response: resp.OK(),
+			Link:     link,
+		})
+	}
+}
+
+func apply(link *storage.Link, req Request) {
+	if req.URL != nil {
+		link.URL = *req.URL
+	}
+	if req.ForwardQuery != nil {
+		link.ForwardQuery = *req.ForwardQuery
+	}
+	if req.ForwardPath != nil {
+		link.ForwardPath = *req.ForwardPath
+	}
+	if req.QueryConflict != nil {
+		link.QueryConflict = *req.QueryConflict
+	}
+	if req.Rules != nil {
+		link.Rules = *req.Rules
+	}
+	if req.RedirectCode != nil {
+		link.RedirectCode = *req.RedirectCode
+	}
+	if req.Title != nil {
+		link.Title = *req.Title
+	}
+	if req.Description != nil {
+		link.Description = *req.Description
+	}
+	if req.Interstitial != nil {
+		link.Interstitial = *req.Interstitial
+	}
+	if req.Notes != nil {
+		link.Notes = *req.Notes
+	}
+	if req.Tags != nil {
+		link.Tags = *req.Tags
+	}
+	if req.NotBefore.Set {
+		link.NotBefore = req.NotBefore.Value
+	}
+	if req.NotAfter.Set {
+		link.NotAfter = req.NotAfter.Value
+	}
+	if req.PrelaunchURL != nil {
+		link.PrelaunchURL = *req.PrelaunchURL
+	}
+}
@@ -0,0 +1,159 @@
+package view
+
+import (
+	"context"
+	"errors"
+	"github.com/go-chi/chi/v5"
+	"github.com/go-chi/chi/v5/middleware"
+	"github.com/go-chi/render"
+	"github.com/kxddry/url-shortener/internal/http-server/middleware/auth"
+	"github.com/kxddry/url-shortener/internal/http-server/pages"
+	"github.com/kxddry/url-shortener/internal/lib/api/request"
+	resp "github.com/kxddry/url-shortener/internal/lib/api/response"
+	"github.com/kxddry/url-shortener/internal/lib/logger/sl"
+	"github.com/kxddry/url-shortener/internal/storage"
+	"log/slog"
+	"net/http"
+)
+
+type Response struct {
+	resp.Response
+	Link storage.Link `json:"link"`
+}
+
+type HealthResponse struct {
+	resp.Response
+	URL    string             `json:"url"`
+	Health storage.LinkHealth `json:"health"`
+}
+
+type LinkGetter interface {
+	GetLink(ctx context.Context, domain, alias string) (storage.Link, error)
+	LinkHealth(ctx context.Context, linkID int64) (storage.LinkHealth, error)
+}
+
+type Access interface {
+	Link(ctx context.Context, uid int64, link storage.Link, min string) (bool, error)
+}
+
+type DomainResolver interface {
+	Resolve(r *http.Request) (storage.Domain, error)
+}
+
+// Stats returns a link with its click count to the members of its workspace,
+// or its creator. Browsers get the link's page in the web UI.
+func Stats(log *slog.Logger, store LinkGetter, access Access, domains DomainResolver) http.HandlerFunc {
+	return show(log, store, access, domains, "handlers.url.view.Stats", storage.RoleViewer, "link.html")
+}
+
+// Edit is Stats for those who may edit the link, with the edit form for browsers.
+func Edit(log *slog.Logger, store LinkGetter, access Access, domains DomainResolver) http.HandlerFunc {
+	return show(log, store, access, domains, "handlers.url.view.Edit", storage.RoleEditor, "edit.html")
+}
+
+func show(log *slog.Logger, store LinkGetter, access Access, domains DomainResolver, op, min, page string) http.HandlerFunc {
+	return func(w http.ResponseWriter, r *http.Request) {
+		log := log.With(
+			slog.String("op", op),
+			slog.String("request_id", middleware.GetReqID(r.Context())))
+
+		link, ok := load(log, w, r, store, access, domains, min)
+		if !ok {
+			return
+		}
+
+		if !request.WantsHTML(r) {
+			render.JSON(w, r, Response{
+				Response: resp.OK(),
+				Link:     link,
+			})
+			return
+		}
+
+		host := r.Host
+		if link.Domain != "" {
+			host = link.Domain
+		}
+		w.Header().Set("Cache-Control", "private, no-cache")
+		data := pages.Link{
+			Page: pages.UI(r, host+"/"+link.Alias),
+			Host: r.Host,
+			Link: link,
+		}
+		if page == "link.html" {
+			if h, err := store.LinkHealth(r.Context(), link.ID); err == nil {
+				data.Link.Health = &h
+			} else if !errors.Is(err, storage.ErrHealthNotChecked) {
+				log.Error("failed to get link health", sl.Err(err))
+			}
+		}
+		if err := pages.Render(w, http.StatusOK, page, data); err != nil {
+			log.Error("failed to render page", slog.String("page", page), sl.Err(err))
+			http.Error(w, http.StatusText(http.StatusInternalServerError), http.StatusInternalServerError)
+		}
+	}
+}
+
+// Health returns the outcome of the last check of the link's destination by the
+// dead-link monitor, to whoever may see the link.
+func Health(log *slog.Logger, store LinkGetter, access Access, domains DomainResolver) http.HandlerFunc {
+	return func(w http.ResponseWriter, r *http.Request) {
+		const op = "handlers.url.view.Health"
+
+		log := log.With(
+			slog.String("op", op),
+			slog.String("request_id", middleware.GetReqID(r.Context())))
+
+		link, ok := load(log, w, r, store, access, domains, storage.RoleViewer)
+		if !ok {
+			return
+		}
+
+		h, err := store.LinkHealth(r.Context(), link.ID)
+		if err != nil {
+			if !errors.Is(err, storage.ErrHealthNotChecked) {
+				log.Error("failed to get link health", sl.Err(err))
+			}
+			resp.FailError(w, r, err)
+			return
+		}
+
+		render.JSON(w, r, HealthResponse{
+			Response: resp.OK(),
+			URL:      link.URL,
+			Health:   h,
+		})
+	}
+}
+
+// load returns the link the request is about, if the caller has at least the role min for it.
+func load(log *slog.Logger, w http.ResponseWriter, r *http.Request, store LinkGetter, access Access, domains DomainResolver, min string) (storage.Link, bool) {
+	domain, err := domains.Resolve(r)
+	if err != nil {
+		resp.Fail(w, r, http.StatusNotFound, resp.CodeDomainNotFound, "domain not found")
+		return storage.Link{}, false
+	}
+
+	uid := auth.UID(r.Context())
+	alias := chi.URLParam(r, "alias")
+	// read from the database, the cache doesn't keep track of clicks
+	link, err := store.GetLink(r.Context(), domain.Host, alias)
+	if err != nil {
+		log.Info("failed to get link", slog.String("alias", alias), sl.Err(err))
+		resp.FailError(w, r, err)
+		return storage.Link{}, false
+	}
+
+	allowed, err := access.Link(r.Context(), uid, link, min)
+	if err != nil {
+		log.Error("failed to check access", sl.Err(err))
+		resp.FailError(w, r, err)
+		return storage.Link{}, false
+	}
+	if !allowed {
+		log.Info("user tried to view alias", slog.Int64("uid", uid), slog.String("alias", alias))
+		resp.Fail(w, r, http.StatusForbidden, resp.CodeForbidden, "you can only manage your own or your workspace's links")
+		return storage.Link{}, false
+	}
+	return link, true
+}
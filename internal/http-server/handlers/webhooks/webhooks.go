@@ -0,0 +1,274 @@
+package webhooks
+
+import (
+	"context"
+	"errors"
+	"github.com/go-chi/chi/v5"
+	"github.com/go-chi/chi/v5/middleware"
+	"github.com/go-chi/render"
+	"github.com/go-playground/validator/v10"
+	"github.com/kxddry/url-shortener/internal/http-server/middleware/auth"
+	"github.com/kxddry/url-shortener/internal/lib/api/request"
+	resp "github.com/kxddry/url-shortener/internal/lib/api/response"
+	"github.com/kxddry/url-shortener/internal/lib/logger/sl"
+	"github.com/kxddry/url-shortener/internal/lib/webhooks"
+	"github.com/kxddry/url-shortener/internal/storage"
+	"io"
+	"log/slog"
+	"net/http"
+	"net/url"
+	"strconv"
+)
+
+const deliveriesLimit = 100
+
+type Request struct {
+	URL string `json:"url" validate:"required,http_url"`
+	// Events to subscribe to, all of them if omitted.
+	Events []string `json:"events,omitempty" validate:"dive,oneof=link.created link.updated link.deleted link.expired link.clicks"`
+	// WorkspaceID subscribes to the events of a workspace the caller owns
+	// instead of those of the caller's personal links.
+	WorkspaceID int64 `json:"workspace_id,omitempty" validate:"omitempty,gt=0"`
+}
+
+type Response struct {
+	resp.Response
+	Webhook    *storage.Webhook   `json:"webhook,omitempty"`
+	Webhooks   []storage.Webhook  `json:"webhooks,omitempty"`
+	Deliveries []storage.Delivery `json:"deliveries,omitempty"`
+}
+
+type WebhookSaver interface {
+	SaveWebhook(ctx context.Context, wh storage.Webhook) (storage.Webhook, error)
+}
+
+type WebhookLister interface {
+	Webhooks(ctx context.Context, uid, workspaceID int64) ([]storage.Webhook, error)
+}
+
+type WebhookGetter interface {
+	Webhook(ctx context.Context, id int64) (storage.Webhook, error)
+}
+
+type WebhookDeleter interface {
+	WebhookGetter
+	DeleteWebhook(ctx context.Context, id int64) error
+}
+
+type DeliveryLister interface {
+	WebhookGetter
+	Deliveries(ctx context.Context, webhookID int64, limit int) ([]storage.Delivery, error)
+}
+
+type Access interface {
+	Member(ctx context.Context, uid, workspaceID int64, min string) (bool, error)
+}
+
+// Create registers a webhook. The response carries the signing secret, which isn't shown again.
+func Create(log *slog.Logger, store WebhookSaver, access Access) http.HandlerFunc {
+	return func(w http.ResponseWriter, r *http.Request) {
+		const op = "handlers.webhooks.Create"
+
+		log := log.With(
+			slog.String("op", op),
+			slog.String("request_id", middleware.GetReqID(r.Context())))
+
+		var req Request
+		if err := render.DecodeJSON(r.Body, &req); err != nil {
+			if errors.Is(err, io.EOF) {
+				log.Info("request body is empty")
+				resp.Fail(w, r, http.StatusBadRequest, resp.CodeInvalidBody, "request body is empty")
+				return
+			}
+			log.Error("failed to decode request", sl.Err(err))
+			resp.Fail(w, r, http.StatusBadRequest, resp.CodeInvalidBody, "failed to decode request")
+			return
+		}
+
+		if err := request.Validate(req); err != nil {
+			validateErr := err.(validator.ValidationErrors)
+			log.Info("invalid request", sl.Err(err))
+			resp.FailValidation(w, r, validateErr)
+			return
+		}
+
+		if !publicHost(req.URL) {
+			log.Info("webhook address is not public", slog.String("url", req.URL))
+			resp.Fail(w, r, http.StatusBadRequest, resp.CodeBadRequest, "url must be on a public address")
+			return
+		}
+
+		uid := auth.UID(r.Context())
+		wh := storage.Webhook{URL: req.URL, Events: req.Events, CreatedBy: uid, WorkspaceID: req.WorkspaceID}
+		if !allowed(log, w, r, access, wh) {
+			return
+		}
+
+		secret, err := webhooks.NewSecret()
+		if err != nil {
+			log.Error("failed to generate secret", sl.Err(err))
+			resp.FailError(w, r, err)
+			return
+		}
+		wh.Secret = secret
+
+		wh, err = store.SaveWebhook(r.Context(), wh)
+		if err != nil {
+			log.Error("failed to save webhook", sl.Err(err))
+			resp.FailError(w, r, err)
+			return
+		}
+
+		log.Info("webhook created", slog.Int64("id", wh.ID), slog.Int64("uid", uid), slog.Int64("workspace_id", wh.WorkspaceID))
+		w.WriteHeader(http.StatusCreated)
+		render.JSON(w, r, Response{Response: resp.OK(), Webhook: &wh})
+	}
+}
+
+// List lists the caller's personal webhooks, or those of ?workspace=.
+func List(log *slog.Logger, store WebhookLister, access Access) http.HandlerFunc {
+	return func(w http.ResponseWriter, r *http.Request) {
+		const op = "handlers.webhooks.List"
+
+		log := log.With(
+			slog.String("op", op),
+			slog.String("request_id", middleware.GetReqID(r.Context())))
+
+		uid := auth.UID(r.Context())
+		var workspaceID int64
+		if v := r.URL.Query().Get("workspace"); v != "" {
+			id, err := strconv.ParseInt(v, 10, 64)
+			if err != nil || id < 1 {
+				resp.Fail(w, r, http.StatusBadRequest, resp.CodeBadRequest, "invalid workspace id")
+				return
+			}
+			workspaceID = id
+		}
+		if !allowed(log, w, r, access, storage.Webhook{CreatedBy: uid, WorkspaceID: workspaceID}) {
+			return
+		}
+
+		list, err := store.Webhooks(r.Context(), uid, workspaceID)
+		if err != nil {
+			log.Error("failed to list webhooks", sl.Err(err))
+			resp.FailError(w, r, err)
+			return
+		}
+
+		render.JSON(w, r, Response{Response: resp.OK(), Webhooks: list})
+	}
+}
+
+func Delete(log *slog.Logger, store WebhookDeleter, access Access) http.HandlerFunc {
+	return func(w http.ResponseWriter, r *http.Request) {
+		const op = "handlers.webhooks.Delete"
+
+		log := log.With(
+			slog.String("op", op),
+			slog.String("request_id", middleware.GetReqID(r.Context())))
+
+		wh, ok := webhook(log, w, r, store, access)
+		if !ok {
+			return
+		}
+
+		err := store.DeleteWebhook(r.Context(), wh.ID)
+		if errors.Is(err, storage.ErrWebhookNotFound) {
+			resp.FailError(w, r, err)
+			return
+		}
+		if err != nil {
+			log.Error("failed to delete webhook", sl.Err(err))
+			resp.FailError(w, r, err)
+			return
+		}
+
+		log.Info("webhook deleted", slog.Int64("id", wh.ID))
+		w.WriteHeader(http.StatusAccepted)
+		render.JSON(w, r, resp.OK())
+	}
+}
+
+// Deliveries returns the delivery log of a webhook: the latest deliveries
+// with their status and the status code and start of the body of the last
+// response received.
+func Deliveries(log *slog.Logger, store DeliveryLister, access Access) http.HandlerFunc {
+	return func(w http.ResponseWriter, r *http.Request) {
+		const op = "handlers.webhooks.Deliveries"
+
+		log := log.With(
+			slog.String("op", op),
+			slog.String("request_id", middleware.GetReqID(r.Context())))
+
+		wh, ok := webhook(log, w, r, store, access)
+		if !ok {
+			return
+		}
+
+		list, err := store.Deliveries(r.Context(), wh.ID, deliveriesLimit)
+		if err != nil {
+			log.Error("failed to list deliveries", sl.Err(err))
+			resp.FailError(w, r, err)
+			return
+		}
+
+		render.JSON(w, r, Response{Response: resp.OK(), Deliveries: list})
+	}
+}
+
+// webhook loads the webhook named by the {id} URL parameter, if the caller may manage it.
+func webhook(log *slog.Logger, w http.ResponseWriter, r *http.Request, store WebhookGetter, access Access) (storage.Webhook, bool) {
+	id, err := strconv.ParseInt(chi.URLParam(r, "id"), 10, 64)
+	if err != nil || id < 1 {
+		resp.Fail(w, r, http.StatusBadRequest, resp.CodeBadRequest, "invalid webhook id")
+		return storage.Webhook{}, false
+	}
+
+	wh, err := store.Webhook(r.Context(), id)
+	if errors.Is(err, storage.ErrWebhookNotFound) {
+		resp.FailError(w, r, err)
+		return storage.Webhook{}, false
+	}
+	if err != nil {
+		log.Error("failed to get webhook", sl.Err(err))
+		resp.FailError(w, r, err)
+		return storage.Webhook{}, false
+	}
+	return wh, allowed(log, w, r, access, wh)
+}
+
+// allowed checks that the caller may manage wh: personal webhooks belong to
+// their creator, workspace webhooks to the workspace's owners.
+func allowed(log *slog.Logger, w http.ResponseWriter, r *http.Request, access Access, wh storage.Webhook) bool {
+	uid := auth.UID(r.Context())
+	if wh.WorkspaceID == 0 {
+		if wh.CreatedBy == uid {
+			return true
+		}
+		// not telling whether someone else's webhook exists
+		resp.Fail(w, r, http.StatusNotFound, resp.CodeWebhookNotFound, "webhook not found")
+		return false
+	}
+
+	ok, err := access.Member(r.Context(), uid, wh.WorkspaceID, storage.RoleOwner)
+	if err != nil {
+		log.Error("failed to check membership", sl.Err(err))
+		resp.FailError(w, r, err)
+		return false
+	}
+	if !ok {
+		resp.Fail(w, r, http.StatusForbidden, resp.CodeForbidden, "you need to be a workspace owner")
+		return false
+	}
+	return true
+}
+
+// publicHost rejects URLs whose host is obviously not public. Names that
+// resolve to such addresses are refused by the dispatcher when it connects.
+func publicHost(rawURL string) bool {
+	u, err := url.Parse(rawURL)
+	if err != nil {
+		return false
+	}
+	return webhooks.PublicHost(u.Hostname())
+}
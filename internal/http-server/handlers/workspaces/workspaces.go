@@ -0,0 +1,397 @@
+package workspaces
+
+import (
+	"context"
+	"errors"
+	"github.com/go-chi/chi/v5"
+	"github.com/go-chi/chi/v5/middleware"
+	"github.com/go-chi/render"
+	"github.com/go-playground/validator/v10"
+	"github.com/kxddry/url-shortener/internal/http-server/middleware/auth"
+	"github.com/kxddry/url-shortener/internal/lib/api/request"
+	resp "github.com/kxddry/url-shortener/internal/lib/api/response"
+	"github.com/kxddry/url-shortener/internal/lib/logger/sl"
+	"github.com/kxddry/url-shortener/internal/storage"
+	"io"
+	"log/slog"
+	"net/http"
+	"strconv"
+)
+
+type CreateRequest struct {
+	Name string `json:"name" validate:"required,max=100"`
+}
+
+type InviteRequest struct {
+	UserID int64  `json:"user_id" validate:"required,gt=0"`
+	Role   string `json:"role" validate:"required,oneof=owner editor viewer"`
+}
+
+type RoleRequest struct {
+	Role string `json:"role" validate:"required,oneof=owner editor viewer"`
+}
+
+type Response struct {
+	resp.Response
+	Workspace   *storage.Workspace   `json:"workspace,omitempty"`
+	Workspaces  []storage.Workspace  `json:"workspaces,omitempty"`
+	Members     []storage.Member     `json:"members,omitempty"`
+	Member      *storage.Member      `json:"member,omitempty"`
+	Invitation  *storage.Invitation  `json:"invitation,omitempty"`
+	Invitations []storage.Invitation `json:"invitations,omitempty"`
+}
+
+type WorkspaceCreator interface {
+	CreateWorkspace(ctx context.Context, name string, uid int64) (storage.Workspace, error)
+}
+
+type WorkspaceLister interface {
+	Workspaces(ctx context.Context, uid int64) ([]storage.Workspace, error)
+}
+
+type MemberLister interface {
+	Members(ctx context.Context, workspaceID int64) ([]storage.Member, error)
+}
+
+type Inviter interface {
+	Invite(ctx context.Context, inv storage.Invitation) (storage.Invitation, error)
+}
+
+type InvitationStore interface {
+	Invitations(ctx context.Context, uid int64) ([]storage.Invitation, error)
+	AcceptInvitation(ctx context.Context, id, uid int64) (storage.Member, error)
+	DeclineInvitation(ctx context.Context, id, uid int64) error
+}
+
+type MemberEditor interface {
+	SetRole(ctx context.Context, workspaceID, uid int64, role string) error
+	RemoveMember(ctx context.Context, workspaceID, uid int64) error
+}
+
+type Access interface {
+	Member(ctx context.Context, uid, workspaceID int64, min string) (bool, error)
+}
+
+// Create creates a workspace owned by the caller.
+func Create(log *slog.Logger, store WorkspaceCreator) http.HandlerFunc {
+	return func(w http.ResponseWriter, r *http.Request) {
+		const op = "handlers.workspaces.Create"
+
+		log := log.With(
+			slog.String("op", op),
+			slog.String("request_id", middleware.GetReqID(r.Context())))
+
+		var req CreateRequest
+		if !decode(log, w, r, &req) {
+			return
+		}
+
+		uid := auth.UID(r.Context())
+		ws, err := store.CreateWorkspace(r.Context(), req.Name, uid)
+		if err != nil {
+			log.Error("failed to create workspace", sl.Err(err))
+			resp.FailError(w, r, err)
+			return
+		}
+
+		log.Info("workspace created", slog.Int64("id", ws.ID), slog.Int64("uid", uid))
+		w.WriteHeader(http.StatusCreated)
+		render.JSON(w, r, Response{Response: resp.OK(), Workspace: &ws})
+	}
+}
+
+// List lists the workspaces the caller is a member of.
+func List(log *slog.Logger, store WorkspaceLister) http.HandlerFunc {
+	return func(w http.ResponseWriter, r *http.Request) {
+		const op = "handlers.workspaces.List"
+
+		log := log.With(
+			slog.String("op", op),
+			slog.String("request_id", middleware.GetReqID(r.Context())))
+
+		list, err := store.Workspaces(r.Context(), auth.UID(r.Context()))
+		if err != nil {
+			log.Error("failed to list workspaces", sl.Err(err))
+			resp.FailError(w, r, err)
+			return
+		}
+
+		render.JSON(w, r, Response{Response: resp.OK(), Workspaces: list})
+	}
+}
+
+// Members lists the members of a workspace to any of its members.
+func Members(log *slog.Logger, store MemberLister, access Access) http.HandlerFunc {
+	return func(w http.ResponseWriter, r *http.Request) {
+		const op = "handlers.workspaces.Members"
+
+		log := log.With(
+			slog.String("op", op),
+			slog.String("request_id", middleware.GetReqID(r.Context())))
+
+		id, ok := workspaceID(w, r)
+		if !ok || !allowed(log, w, r, access, id, storage.RoleViewer) {
+			return
+		}
+
+		list, err := store.Members(r.Context(), id)
+		if err != nil {
+			log.Error("failed to list members", sl.Err(err))
+			resp.FailError(w, r, err)
+			return
+		}
+
+		render.JSON(w, r, Response{Response: resp.OK(), Members: list})
+	}
+}
+
+// Invite lets an owner invite a user by id. The user joins once they accept.
+func Invite(log *slog.Logger, store Inviter, access Access) http.HandlerFunc {
+	return func(w http.ResponseWriter, r *http.Request) {
+		const op = "handlers.workspaces.Invite"
+
+		log := log.With(
+			slog.String("op", op),
+			slog.String("request_id", middleware.GetReqID(r.Context())))
+
+		id, ok := workspaceID(w, r)
+		if !ok || !allowed(log, w, r, access, id, storage.RoleOwner) {
+			return
+		}
+
+		var req InviteRequest
+		if !decode(log, w, r, &req) {
+			return
+		}
+
+		inv, err := store.Invite(r.Context(), storage.Invitation{
+			WorkspaceID: id,
+			UserID:      req.UserID,
+			Role:        req.Role,
+			InvitedBy:   auth.UID(r.Context()),
+		})
+		switch {
+		case errors.Is(err, storage.ErrAlreadyMember), errors.Is(err, storage.ErrInvitationExists),
+			errors.Is(err, storage.ErrWorkspaceNotFound):
+			resp.FailError(w, r, err)
+			return
+		case err != nil:
+			log.Error("failed to invite", sl.Err(err))
+			resp.FailError(w, r, err)
+			return
+		}
+
+		log.Info("user invited", slog.Int64("workspace_id", id), slog.Int64("user_id", req.UserID), slog.String("role", req.Role))
+		w.WriteHeader(http.StatusCreated)
+		render.JSON(w, r, Response{Response: resp.OK(), Invitation: &inv})
+	}
+}
+
+// SetRole lets an owner change the role of a member.
+func SetRole(log *slog.Logger, store MemberEditor, access Access) http.HandlerFunc {
+	return func(w http.ResponseWriter, r *http.Request) {
+		const op = "handlers.workspaces.SetRole"
+
+		log := log.With(
+			slog.String("op", op),
+			slog.String("request_id", middleware.GetReqID(r.Context())))
+
+		id, ok := workspaceID(w, r)
+		if !ok || !allowed(log, w, r, access, id, storage.RoleOwner) {
+			return
+		}
+		member, ok := userID(w, r)
+		if !ok {
+			return
+		}
+
+		var req RoleRequest
+		if !decode(log, w, r, &req) {
+			return
+		}
+
+		if !memberChanged(log, w, r, store.SetRole(r.Context(), id, member, req.Role)) {
+			return
+		}
+		log.Info("role changed", slog.Int64("workspace_id", id), slog.Int64("user_id", member), slog.String("role", req.Role))
+		render.JSON(w, r, resp.OK())
+	}
+}
+
+// RemoveMember lets an owner remove a member, and any member leave.
+func RemoveMember(log *slog.Logger, store MemberEditor, access Access) http.HandlerFunc {
+	return func(w http.ResponseWriter, r *http.Request) {
+		const op = "handlers.workspaces.RemoveMember"
+
+		log := log.With(
+			slog.String("op", op),
+			slog.String("request_id", middleware.GetReqID(r.Context())))
+
+		id, ok := workspaceID(w, r)
+		if !ok {
+			return
+		}
+		member, ok := userID(w, r)
+		if !ok {
+			return
+		}
+		if member != auth.UID(r.Context()) && !allowed(log, w, r, access, id, storage.RoleOwner) {
+			return
+		}
+
+		if !memberChanged(log, w, r, store.RemoveMember(r.Context(), id, member)) {
+			return
+		}
+		log.Info("member removed", slog.Int64("workspace_id", id), slog.Int64("user_id", member))
+		w.WriteHeader(http.StatusAccepted)
+		render.JSON(w, r, resp.OK())
+	}
+}
+
+// Invitations lists the caller's pending invitations.
+func Invitations(log *slog.Logger, store InvitationStore) http.HandlerFunc {
+	return func(w http.ResponseWriter, r *http.Request) {
+		const op = "handlers.workspaces.Invitations"
+
+		log := log.With(
+			slog.String("op", op),
+			slog.String("request_id", middleware.GetReqID(r.Context())))
+
+		list, err := store.Invitations(r.Context(), auth.UID(r.Context()))
+		if err != nil {
+			log.Error("failed to list invitations", sl.Err(err))
+			resp.FailError(w, r, err)
+			return
+		}
+
+		render.JSON(w, r, Response{Response: resp.OK(), Invitations: list})
+	}
+}
+
+// Accept makes the caller a member of the workspace they were invited to.
+func Accept(log *slog.Logger, store InvitationStore) http.HandlerFunc {
+	return func(w http.ResponseWriter, r *http.Request) {
+		const op = "handlers.workspaces.Accept"
+
+		log := log.With(
+			slog.String("op", op),
+			slog.String("request_id", middleware.GetReqID(r.Context())))
+
+		id, ok := invitationID(w, r)
+		if !ok {
+			return
+		}
+
+		m, err := store.AcceptInvitation(r.Context(), id, auth.UID(r.Context()))
+		if errors.Is(err, storage.ErrInvitationNotFound) {
+			resp.FailError(w, r, err)
+			return
+		}
+		if err != nil {
+			log.Error("failed to accept invitation", sl.Err(err))
+			resp.FailError(w, r, err)
+			return
+		}
+
+		log.Info("invitation accepted", slog.Int64("workspace_id", m.WorkspaceID), slog.Int64("user_id", m.UserID))
+		render.JSON(w, r, Response{Response: resp.OK(), Member: &m})
+	}
+}
+
+// Decline drops one of the caller's invitations.
+func Decline(log *slog.Logger, store InvitationStore) http.HandlerFunc {
+	return func(w http.ResponseWriter, r *http.Request) {
+		const op = "handlers.workspaces.Decline"
+
+		log := log.With(
+			slog.String("op", op),
+			slog.String("request_id", middleware.GetReqID(r.Context())))
+
+		id, ok := invitationID(w, r)
+		if !ok {
+			return
+		}
+
+		err := store.DeclineInvitation(r.Context(), id, auth.UID(r.Context()))
+		if errors.Is(err, storage.ErrInvitationNotFound) {
+			resp.FailError(w, r, err)
+			return
+		}
+		if err != nil {
+			log.Error("failed to decline invitation", sl.Err(err))
+			resp.FailError(w, r, err)
+			return
+		}
+
+		w.WriteHeader(http.StatusAccepted)
+		render.JSON(w, r, resp.OK())
+	}
+}
+
+func decode(log *slog.Logger, w http.ResponseWriter, r *http.Request, req any) bool {
+	if err := render.DecodeJSON(r.Body, req); err != nil {
+		if errors.Is(err, io.EOF) {
+			log.Info("request body is empty")
+			resp.Fail(w, r, http.StatusBadRequest, resp.CodeInvalidBody, "request body is empty")
+			return false
+		}
+		log.Error("failed to decode request", sl.Err(err))
+		resp.Fail(w, r, http.StatusBadRequest, resp.CodeInvalidBody, "failed to decode request")
+		return false
+	}
+	if err := request.Validate(req); err != nil {
+		validateErr := err.(validator.ValidationErrors)
+		log.Info("invalid request", sl.Err(err))
+		resp.FailValidation(w, r, validateErr)
+		return false
+	}
+	return true
+}
+
+func allowed(log *slog.Logger, w http.ResponseWriter, r *http.Request, access Access, workspaceID int64, min string) bool {
+	ok, err := access.Member(r.Context(), auth.UID(r.Context()), workspaceID, min)
+	if err != nil {
+		log.Error("failed to check membership", sl.Err(err))
+		resp.FailError(w, r, err)
+		return false
+	}
+	if !ok {
+		resp.Fail(w, r, http.StatusForbidden, resp.CodeForbidden, "you need to be a workspace "+min)
+		return false
+	}
+	return true
+}
+
+func memberChanged(log *slog.Logger, w http.ResponseWriter, r *http.Request, err error) bool {
+	switch {
+	case errors.Is(err, storage.ErrNotMember), errors.Is(err, storage.ErrLastOwner):
+		resp.FailError(w, r, err)
+		return false
+	case err != nil:
+		log.Error("failed to change member", sl.Err(err))
+		resp.FailError(w, r, err)
+		return false
+	}
+	return true
+}
+
+func workspaceID(w http.ResponseWriter, r *http.Request) (int64, bool) {
+	return idParam(w, r, "id", "invalid workspace id")
+}
+
+func userID(w http.ResponseWriter, r *http.Request) (int64, bool) {
+	return idParam(w, r, "uid", "invalid user id")
+}
+
+func invitationID(w http.ResponseWriter, r *http.Request) (int64, bool) {
+	return idParam(w, r, "id", "invalid invitation id")
+}
+
+func idParam(w http.ResponseWriter, r *http.Request, name, msg string) (int64, bool) {
+	id, err := strconv.ParseInt(chi.URLParam(r, name), 10, 64)
+	if err != nil || id < 1 {
+		resp.Fail(w, r, http.StatusBadRequest, resp.CodeBadRequest, msg)
+		return 0, false
+	}
+	return id, true
+}
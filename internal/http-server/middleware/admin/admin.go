@@ -0,0 +1,97 @@
+package admin
+
+import (
+	"context"
+	"errors"
+	"github.com/go-chi/chi/v5"
+	"github.com/go-chi/chi/v5/middleware"
+	resp "github.com/kxddry/url-shortener/internal/lib/api/response"
+	"github.com/kxddry/url-shortener/internal/lib/jwt"
+	"github.com/kxddry/url-shortener/internal/lib/logger/sl"
+	"github.com/kxddry/url-shortener/internal/storage"
+	"log/slog"
+	"net/http"
+)
+
+type AdminChecker interface {
+	IsAdmin(ctx context.Context, userID int64) (bool, error)
+}
+
+type ctxKey struct{}
+
+// New only lets through requests with a valid token of an SSO admin.
+func New(log *slog.Logger, appSecret string, sso AdminChecker) func(next http.Handler) http.Handler {
+	return func(next http.Handler) http.Handler {
+		log := log.With(slog.String("component", "middleware/admin"))
+		fn := func(w http.ResponseWriter, r *http.Request) {
+			log := log.With(slog.String("request_id", middleware.GetReqID(r.Context())))
+
+			uid, err := jwt.UIDfromHeader(r, appSecret)
+			if err != nil {
+				if errors.Is(err, jwt.ErrNoHeader) {
+					resp.FailError(w, r, err)
+					return
+				}
+				log.Info("invalid token", sl.Err(err))
+				resp.Fail(w, r, http.StatusUnauthorized, resp.CodeInvalidToken, "invalid token")
+				return
+			}
+
+			isAdmin, err := sso.IsAdmin(r.Context(), uid)
+			if err != nil {
+				log.Error("internal error!", sl.Err(err))
+				resp.FailError(w, r, err)
+				return
+			}
+			if !isAdmin {
+				log.Info("non-admin tried to access admin API", slog.Int64("uid", uid))
+				resp.Fail(w, r, http.StatusForbidden, resp.CodeForbidden, "admins only")
+				return
+			}
+
+			next.ServeHTTP(w, r.WithContext(context.WithValue(r.Context(), ctxKey{}, uid)))
+		}
+		return http.HandlerFunc(fn)
+	}
+}
+
+// UID returns the uid of the admin that made the request.
+func UID(ctx context.Context) int64 {
+	uid, _ := ctx.Value(ctxKey{}).(int64)
+	return uid
+}
+
+type AuditRecorder interface {
+	RecordAudit(ctx context.Context, e storage.AuditEntry) error
+}
+
+// Audit logs every request of an admin with the admin's uid and the request
+// ID, and records those that change something in the audit log. It goes
+// after New.
+func Audit(log *slog.Logger, store AuditRecorder) func(next http.Handler) http.Handler {
+	return func(next http.Handler) http.Handler {
+		log := log.With(slog.String("component", "middleware/admin"))
+		fn := func(w http.ResponseWriter, r *http.Request) {
+			ww := middleware.NewWrapResponseWriter(w, r.ProtoMajor)
+			next.ServeHTTP(ww, r)
+
+			e := storage.AuditEntry{
+				Actor:     UID(r.Context()),
+				Action:    r.Method + " " + chi.RouteContext(r.Context()).RoutePattern(),
+				Target:    r.URL.RequestURI(),
+				Status:    ww.Status(),
+				RequestID: middleware.GetReqID(r.Context()),
+			}
+			log.Info("admin request", slog.Int64("uid", e.Actor), slog.String("request_id", e.RequestID),
+				slog.String("action", e.Action), slog.String("target", e.Target), slog.Int("status", e.Status))
+			if r.Method == http.MethodGet || r.Method == http.MethodHead {
+				return
+			}
+			// the action has happened, it is recorded even if the admin has gone
+			if err := store.RecordAudit(context.WithoutCancel(r.Context()), e); err != nil {
+				log.Error("failed to record audit entry", slog.String("request_id", e.RequestID), sl.Err(err))
+			}
+		}
+		return http.HandlerFunc(fn)
+	}
+}
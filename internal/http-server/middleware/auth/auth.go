@@ -0,0 +1,42 @@
+package auth
+
+import (
+	"context"
+	"errors"
+	"github.com/go-chi/chi/v5/middleware"
+	resp "github.com/kxddry/url-shortener/internal/lib/api/response"
+	"github.com/kxddry/url-shortener/internal/lib/jwt"
+	"github.com/kxddry/url-shortener/internal/lib/logger/sl"
+	"log/slog"
+	"net/http"
+)
+
+type ctxKey struct{}
+
+// New only lets through requests with a valid token.
+func New(log *slog.Logger, appSecret string) func(next http.Handler) http.Handler {
+	return func(next http.Handler) http.Handler {
+		log := log.With(slog.String("component", "middleware/auth"))
+		fn := func(w http.ResponseWriter, r *http.Request) {
+			uid, err := jwt.UIDfromRequest(r, appSecret)
+			if err != nil {
+				if errors.Is(err, jwt.ErrNoHeader) {
+					resp.FailError(w, r, err)
+					return
+				}
+				log.Info("invalid token", slog.String("request_id", middleware.GetReqID(r.Context())), sl.Err(err))
+				resp.Fail(w, r, http.StatusUnauthorized, resp.CodeInvalidToken, "invalid token")
+				return
+			}
+
+			next.ServeHTTP(w, r.WithContext(context.WithValue(r.Context(), ctxKey{}, uid)))
+		}
+		return http.HandlerFunc(fn)
+	}
+}
+
+// UID returns the uid of the user that made the request.
+func UID(ctx context.Context) int64 {
+	uid, _ := ctx.Value(ctxKey{}).(int64)
+	return uid
+}
@@ -0,0 +1,107 @@
+package csrf
+
+import (
+	"context"
+	"crypto/rand"
+	"crypto/subtle"
+	"encoding/base64"
+	"github.com/go-chi/chi/v5/middleware"
+	"github.com/kxddry/url-shortener/internal/lib/api/request"
+	resp "github.com/kxddry/url-shortener/internal/lib/api/response"
+	"github.com/kxddry/url-shortener/internal/lib/session"
+	"log/slog"
+	"net/http"
+)
+
+const (
+	// Cookie holds the token that forms have to echo back. It is HttpOnly, so
+	// page scripts can't read it; clients that keep the cookie themselves send
+	// it back in Header.
+	Cookie = "csrf"
+	// Field is the form field and Header the request header carrying the token.
+	Field  = "csrf_token"
+	Header = "X-CSRF-Token"
+)
+
+const tokenBytes = 32
+
+type ctxKey struct{}
+
+type state struct {
+	w      http.ResponseWriter
+	token  string
+	secure bool
+}
+
+// New protects browser sessions with double-submit tokens. Unsafe requests
+// that are authenticated by the session cookie, or that post an HTML form,
+// must carry the token of the csrf cookie in Field or Header: browsers send
+// cookies along with requests made by any site, but other sites can't read them.
+// Clients using an Authorization header and a JSON body are not affected.
+func New(log *slog.Logger, secure bool) func(next http.Handler) http.Handler {
+	return func(next http.Handler) http.Handler {
+		log := log.With(slog.String("component", "middleware/csrf"))
+		fn := func(w http.ResponseWriter, r *http.Request) {
+			s := &state{w: w, secure: secure}
+			if c, err := r.Cookie(Cookie); err == nil {
+				s.token = c.Value
+			}
+
+			if !safe(r.Method) && needsToken(r) {
+				sent := r.Header.Get(Header)
+				if sent == "" {
+					sent = r.PostFormValue(Field)
+				}
+				if s.token == "" || subtle.ConstantTimeCompare([]byte(sent), []byte(s.token)) != 1 {
+					log.Info("missing or invalid CSRF token",
+						slog.String("request_id", middleware.GetReqID(r.Context())),
+						slog.String("path", r.URL.Path))
+					resp.Fail(w, r, http.StatusForbidden, resp.CodeCSRF, "missing or invalid CSRF token, reload the page and try again")
+					return
+				}
+			}
+
+			next.ServeHTTP(w, r.WithContext(context.WithValue(r.Context(), ctxKey{}, s)))
+		}
+		return http.HandlerFunc(fn)
+	}
+}
+
+// Token returns the token forms have to include, setting the csrf cookie if
+// the client doesn't have one yet. Only pages with forms call it, so that
+// visitors following short links don't get a cookie.
+func Token(ctx context.Context) string {
+	s, ok := ctx.Value(ctxKey{}).(*state)
+	if !ok {
+		return ""
+	}
+	if s.token == "" {
+		b := make([]byte, tokenBytes)
+		_, _ = rand.Read(b)
+		s.token = base64.RawURLEncoding.EncodeToString(b)
+		http.SetCookie(s.w, &http.Cookie{
+			Name:     Cookie,
+			Value:    s.token,
+			Path:     "/",
+			Secure:   s.secure,
+			HttpOnly: true,
+			SameSite: http.SameSiteLaxMode,
+		})
+	}
+	return s.token
+}
+
+func safe(method string) bool {
+	switch method {
+	case http.MethodGet, http.MethodHead, http.MethodOptions, http.MethodTrace:
+		return true
+	}
+	return false
+}
+
+func needsToken(r *http.Request) bool {
+	if r.Header.Get("Authorization") == "" && session.Token(r) != "" {
+		return true
+	}
+	return request.IsForm(r)
+}
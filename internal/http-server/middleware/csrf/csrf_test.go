@@ -0,0 +1,80 @@
+package csrf
+
+import (
+	"io"
+	"log/slog"
+	"net/http"
+	"net/http/httptest"
+	"net/url"
+	"strings"
+	"testing"
+
+	"github.com/kxddry/url-shortener/internal/lib/session"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+func TestNew(t *testing.T) {
+	log := slog.New(slog.NewTextHandler(io.Discard, nil))
+	var token string
+	h := New(log, false)(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		token = Token(r.Context())
+		w.WriteHeader(http.StatusNoContent)
+	}))
+
+	// a page with a form hands out the token
+	w := httptest.NewRecorder()
+	h.ServeHTTP(w, httptest.NewRequest(http.MethodGet, "/", nil))
+	require.Equal(t, http.StatusNoContent, w.Code)
+	require.NotEmpty(t, token)
+	cookies := w.Result().Cookies()
+	require.Len(t, cookies, 1)
+	assert.Equal(t, Cookie, cookies[0].Name)
+	assert.True(t, cookies[0].HttpOnly)
+
+	form := func(v url.Values) *http.Request {
+		r := httptest.NewRequest(http.MethodPost, "/url", strings.NewReader(v.Encode()))
+		r.Header.Set("Content-Type", "application/x-www-form-urlencoded")
+		return r
+	}
+	withCookie := func(r *http.Request) *http.Request {
+		r.AddCookie(&http.Cookie{Name: Cookie, Value: token})
+		return r
+	}
+	sessionCookie := &http.Cookie{Name: session.Cookie, Value: "jwt"}
+
+	tests := []struct {
+		name string
+		req  *http.Request
+		want int
+	}{
+		{"form without token", withCookie(form(url.Values{"url": {"x"}})), http.StatusForbidden},
+		{"form with wrong token", withCookie(form(url.Values{Field: {"nope"}})), http.StatusForbidden},
+		{"form without cookie", form(url.Values{Field: {token}}), http.StatusForbidden},
+		{"form with token", withCookie(form(url.Values{Field: {token}})), http.StatusNoContent},
+		{"session JSON without token", func() *http.Request {
+			r := httptest.NewRequest(http.MethodDelete, "/abc", nil)
+			r.AddCookie(sessionCookie)
+			return withCookie(r)
+		}(), http.StatusForbidden},
+		{"session JSON with header", func() *http.Request {
+			r := httptest.NewRequest(http.MethodDelete, "/abc", nil)
+			r.AddCookie(sessionCookie)
+			r.Header.Set(Header, token)
+			return withCookie(r)
+		}(), http.StatusNoContent},
+		{"bearer JSON", func() *http.Request {
+			r := httptest.NewRequest(http.MethodPost, "/url", strings.NewReader(`{}`))
+			r.Header.Set("Authorization", "Bearer jwt")
+			r.AddCookie(sessionCookie)
+			return r
+		}(), http.StatusNoContent},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			w := httptest.NewRecorder()
+			h.ServeHTTP(w, tt.req)
+			assert.Equal(t, tt.want, w.Code)
+		})
+	}
+}
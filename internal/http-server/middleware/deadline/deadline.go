@@ -0,0 +1,23 @@
+package deadline
+
+import (
+	"context"
+	"net/http"
+	"time"
+)
+
+// New bounds the context of every request by timeout, so that storage, cache
+// and SSO calls made on its behalf give up together. The context is also
+// cancelled when the client disconnects. A timeout of 0 disables the deadline.
+func New(timeout time.Duration) func(next http.Handler) http.Handler {
+	return func(next http.Handler) http.Handler {
+		if timeout <= 0 {
+			return next
+		}
+		return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+			ctx, cancel := context.WithTimeout(r.Context(), timeout)
+			defer cancel()
+			next.ServeHTTP(w, r.WithContext(ctx))
+		})
+	}
+}
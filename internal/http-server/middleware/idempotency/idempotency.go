@@ -0,0 +1,198 @@
+package idempotency
+
+import (
+	"bytes"
+	"context"
+	"crypto/sha256"
+	"encoding/hex"
+	"github.com/go-chi/chi/v5/middleware"
+	"github.com/kxddry/url-shortener/internal/config"
+	resp "github.com/kxddry/url-shortener/internal/lib/api/response"
+	"github.com/kxddry/url-shortener/internal/lib/jwt"
+	"github.com/kxddry/url-shortener/internal/lib/logger/sl"
+	"github.com/kxddry/url-shortener/internal/storage"
+	"io"
+	"log/slog"
+	"net/http"
+	"strconv"
+	"time"
+)
+
+const (
+	// Header carries the key the client picked for the request.
+	Header = "Idempotency-Key"
+	// ReplayedHeader is set on responses that are replays.
+	ReplayedHeader = "Idempotent-Replayed"
+
+	maxKeyLength = 255
+	pollInterval = 100 * time.Millisecond
+)
+
+type Store interface {
+	ReserveIdempotencyKey(ctx context.Context, key string, res storage.IdempotentResponse, ttl time.Duration) (bool, error)
+	IdempotentResponse(ctx context.Context, key string) (storage.IdempotentResponse, bool, error)
+	SaveIdempotentResponse(ctx context.Context, key string, res storage.IdempotentResponse, ttl time.Duration) error
+	ReleaseIdempotencyKey(ctx context.Context, key string) error
+}
+
+// New makes requests carrying an Idempotency-Key safe to retry. The first
+// request with a key is served and its response kept for cfg.TTL, stored by
+// the caller's uid and the key along with a hash of the request; retries get
+// the same response back. Reusing the key for a different request gets 422,
+// and a retry made while the first request is still in flight waits for it
+// up to cfg.Wait, then gets 409.
+//
+// Responses that are worth retrying, 5xx and 429, are not kept. Requests
+// without the header, or without a valid token, are passed through untouched.
+func New(log *slog.Logger, store Store, appSecret string, cfg config.Idempotency) func(next http.Handler) http.Handler {
+	return func(next http.Handler) http.Handler {
+		log := log.With(slog.String("component", "middleware/idempotency"))
+		fn := func(w http.ResponseWriter, r *http.Request) {
+			key := r.Header.Get(Header)
+			if key == "" {
+				next.ServeHTTP(w, r)
+				return
+			}
+			log := log.With(slog.String("request_id", middleware.GetReqID(r.Context())))
+			if len(key) > maxKeyLength {
+				resp.Fail(w, r, http.StatusBadRequest, resp.CodeBadRequest, Header+" must be at most "+strconv.Itoa(maxKeyLength)+" characters")
+				return
+			}
+			// the handler rejects the request
+			uid, err := jwt.UIDfromRequest(r, appSecret)
+			if err != nil {
+				next.ServeHTTP(w, r)
+				return
+			}
+
+			body, err := io.ReadAll(r.Body)
+			if err != nil {
+				log.Error("failed to read request", sl.Err(err))
+				resp.Fail(w, r, http.StatusBadRequest, resp.CodeInvalidBody, "failed to read request")
+				return
+			}
+			r.Body = io.NopCloser(bytes.NewReader(body))
+
+			// keys are the users' own, so that nobody gets another one's response
+			k := strconv.FormatInt(uid, 10) + ":" + hash([]byte(key))
+			fp := hash([]byte(r.Method), []byte(r.URL.RequestURI()), body)
+			if !claim(log, w, r, store, cfg, k, fp) {
+				return
+			}
+
+			ctx := context.WithoutCancel(r.Context())
+			release := func() {
+				if err := store.ReleaseIdempotencyKey(ctx, k); err != nil {
+					log.Error("failed to release idempotency key", sl.Err(err))
+				}
+			}
+			defer func() {
+				if p := recover(); p != nil {
+					release()
+					panic(p)
+				}
+			}()
+
+			var buf bytes.Buffer
+			ww := middleware.NewWrapResponseWriter(w, r.ProtoMajor)
+			ww.Tee(&buf)
+			next.ServeHTTP(ww, r)
+
+			status := ww.Status()
+			if status == 0 {
+				status = http.StatusOK
+			}
+			if status >= http.StatusInternalServerError || status == http.StatusTooManyRequests {
+				release()
+				return
+			}
+			header := ww.Header().Clone()
+			header.Del("Set-Cookie")
+			err = store.SaveIdempotentResponse(ctx, k, storage.IdempotentResponse{
+				Fingerprint: fp,
+				Done:        true,
+				Status:      status,
+				Header:      header,
+				Body:        buf.Bytes(),
+			}, cfg.TTL)
+			if err != nil {
+				// a retry would be served again rather than replayed
+				log.Error("failed to save idempotent response", sl.Err(err))
+				release()
+			}
+		}
+		return http.HandlerFunc(fn)
+	}
+}
+
+// claim reserves the key k for the request with the fingerprint fp. It
+// reports false if it has answered the request instead: with the response
+// to replay, or with an error.
+func claim(log *slog.Logger, w http.ResponseWriter, r *http.Request, store Store, cfg config.Idempotency, k, fp string) bool {
+	waitUntil := time.Now().Add(cfg.Wait)
+	for {
+		reserved, err := store.ReserveIdempotencyKey(r.Context(), k, storage.IdempotentResponse{Fingerprint: fp}, cfg.LockTTL)
+		if err != nil {
+			log.Error("failed to reserve idempotency key", sl.Err(err))
+			resp.FailError(w, r, err)
+			return false
+		}
+		if reserved {
+			return true
+		}
+
+		prev, found, err := store.IdempotentResponse(r.Context(), k)
+		if err != nil {
+			log.Error("failed to get idempotent response", sl.Err(err))
+			resp.FailError(w, r, err)
+			return false
+		}
+		switch {
+		case !found:
+			// released in between, try again
+			continue
+		case prev.Fingerprint != fp:
+			log.Info("idempotency key reused for another request")
+			resp.Fail(w, r, http.StatusUnprocessableEntity, resp.CodeIdempotencyKeyReused,
+				"the "+Header+" was already used for a different request")
+			return false
+		case prev.Done:
+			log.Info("replaying response", slog.Int("status", prev.Status))
+			replay(w, prev)
+			return false
+		case !time.Now().Before(waitUntil):
+			w.Header().Set("Retry-After", "1")
+			resp.Fail(w, r, http.StatusConflict, resp.CodeIdempotencyInFlight,
+				"a request with this "+Header+" is still in progress")
+			return false
+		}
+
+		t := time.NewTimer(pollInterval)
+		select {
+		case <-r.Context().Done():
+			t.Stop()
+			resp.FailError(w, r, r.Context().Err())
+			return false
+		case <-t.C:
+		}
+	}
+}
+
+func replay(w http.ResponseWriter, res storage.IdempotentResponse) {
+	for k, v := range res.Header {
+		w.Header()[k] = v
+	}
+	w.Header().Set(ReplayedHeader, "true")
+	w.WriteHeader(res.Status)
+	_, _ = w.Write(res.Body)
+}
+
+// hash returns the hex SHA-256 of parts, which are told apart from each other.
+func hash(parts ...[]byte) string {
+	h := sha256.New()
+	for _, p := range parts {
+		h.Write([]byte(strconv.Itoa(len(p)) + ":"))
+		h.Write(p)
+	}
+	return hex.EncodeToString(h.Sum(nil))
+}
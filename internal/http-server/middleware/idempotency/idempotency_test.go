@@ -0,0 +1,132 @@
+package idempotency
+
+import (
+	"context"
+	"io"
+	"log/slog"
+	"net/http"
+	"net/http/httptest"
+	"strconv"
+	"strings"
+	"sync"
+	"sync/atomic"
+	"testing"
+	"time"
+
+	gojwt "github.com/golang-jwt/jwt/v5"
+	"github.com/kxddry/url-shortener/internal/config"
+	"github.com/kxddry/url-shortener/internal/storage"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+const secret = "secret"
+
+// fakeStore keeps responses in memory, ignoring their TTL.
+type fakeStore struct {
+	mu   sync.Mutex
+	keys map[string]storage.IdempotentResponse
+}
+
+func (s *fakeStore) ReserveIdempotencyKey(_ context.Context, key string, res storage.IdempotentResponse, _ time.Duration) (bool, error) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	if _, ok := s.keys[key]; ok {
+		return false, nil
+	}
+	s.keys[key] = res
+	return true, nil
+}
+
+func (s *fakeStore) IdempotentResponse(_ context.Context, key string) (storage.IdempotentResponse, bool, error) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	res, ok := s.keys[key]
+	return res, ok, nil
+}
+
+func (s *fakeStore) SaveIdempotentResponse(_ context.Context, key string, res storage.IdempotentResponse, _ time.Duration) error {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	s.keys[key] = res
+	return nil
+}
+
+func (s *fakeStore) ReleaseIdempotencyKey(_ context.Context, key string) error {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	delete(s.keys, key)
+	return nil
+}
+
+func token(t *testing.T, uid int64) string {
+	s, err := gojwt.NewWithClaims(gojwt.SigningMethodHS256, gojwt.MapClaims{"uid": uid}).SignedString([]byte(secret))
+	require.NoError(t, err)
+	return s
+}
+
+func TestIdempotency(t *testing.T) {
+	var calls atomic.Int64
+	status := http.StatusCreated
+	release := make(chan struct{})
+	close(release)
+	var block atomic.Pointer[chan struct{}]
+	block.Store(&release)
+
+	// every call creates a new alias, as save.New does
+	handler := http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		n := calls.Add(1)
+		<-*block.Load()
+		_, _ = io.ReadAll(r.Body)
+		w.Header().Set("Content-Type", "application/json")
+		w.WriteHeader(status)
+		_, _ = w.Write([]byte(`{"alias":"a` + strconv.FormatInt(n, 10) + `"}`))
+	})
+	cfg := config.Idempotency{TTL: time.Hour, Wait: 50 * time.Millisecond, LockTTL: time.Minute}
+	h := New(slog.New(slog.NewTextHandler(io.Discard, nil)), &fakeStore{keys: map[string]storage.IdempotentResponse{}}, secret, cfg)(handler)
+
+	send := func(uid int64, key, body string) *httptest.ResponseRecorder {
+		r := httptest.NewRequest(http.MethodPost, "/url", strings.NewReader(body))
+		r.Header.Set("Authorization", "Bearer "+token(t, uid))
+		if key != "" {
+			r.Header.Set(Header, key)
+		}
+		w := httptest.NewRecorder()
+		h.ServeHTTP(w, r)
+		return w
+	}
+
+	first := send(1, "k1", `{"url":"https://example.com"}`)
+	require.Equal(t, http.StatusCreated, first.Code)
+	retry := send(1, "k1", `{"url":"https://example.com"}`)
+	assert.Equal(t, http.StatusCreated, retry.Code)
+	assert.Equal(t, first.Body.String(), retry.Body.String())
+	assert.Equal(t, "application/json", retry.Header().Get("Content-Type"))
+	assert.Equal(t, "true", retry.Header().Get(ReplayedHeader))
+	assert.Equal(t, int64(1), calls.Load(), "retries aren't served again")
+
+	conflict := send(1, "k1", `{"url":"https://example.org"}`)
+	assert.Equal(t, http.StatusUnprocessableEntity, conflict.Code)
+	assert.Contains(t, conflict.Body.String(), "idempotency_key_reused")
+
+	assert.Equal(t, `{"alias":"a2"}`, send(2, "k1", `{"url":"https://example.com"}`).Body.String(), "keys are per user")
+	assert.Equal(t, `{"alias":"a3"}`, send(1, "", `{"url":"https://example.com"}`).Body.String(), "no key, no replay")
+
+	status = http.StatusServiceUnavailable
+	send(1, "k2", `{}`)
+	status = http.StatusCreated
+	assert.Equal(t, http.StatusCreated, send(1, "k2", `{}`).Code, "failures can be retried")
+
+	// a retry while the first request is in flight
+	wait := make(chan struct{})
+	block.Store(&wait)
+	done := make(chan *httptest.ResponseRecorder)
+	go func() { done <- send(1, "k3", `{}`) }()
+	require.Eventually(t, func() bool { return calls.Load() == 6 }, time.Second, time.Millisecond)
+	inFlight := send(1, "k3", `{}`)
+	assert.Equal(t, http.StatusConflict, inFlight.Code)
+	assert.Equal(t, "1", inFlight.Header().Get("Retry-After"))
+	close(wait)
+	assert.Equal(t, http.StatusCreated, (<-done).Code)
+	assert.Equal(t, int64(6), calls.Load())
+}
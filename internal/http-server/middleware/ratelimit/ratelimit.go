@@ -0,0 +1,103 @@
+package ratelimit
+
+import (
+	"github.com/go-chi/chi/v5/middleware"
+	"github.com/kxddry/url-shortener/internal/config"
+	resp "github.com/kxddry/url-shortener/internal/lib/api/response"
+	"golang.org/x/time/rate"
+	"log/slog"
+	"net"
+	"net/http"
+	"sync"
+	"time"
+)
+
+// idleTTL is how long a client's bucket is kept after its last request.
+const idleTTL = 10 * time.Minute
+
+type client struct {
+	limiter  *rate.Limiter
+	lastSeen time.Time
+}
+
+// Limiter is a per-client-IP token bucket whose settings can be changed at runtime.
+type Limiter struct {
+	mu      sync.Mutex
+	cfg     config.RateLimit
+	clients map[string]*client
+	sweep   time.Time
+}
+
+func New(cfg config.RateLimit) *Limiter {
+	return &Limiter{
+		cfg:     cfg,
+		clients: make(map[string]*client),
+	}
+}
+
+// Update applies new settings to every existing and future client.
+func (l *Limiter) Update(cfg config.RateLimit) {
+	l.mu.Lock()
+	defer l.mu.Unlock()
+	l.cfg = cfg
+	for _, c := range l.clients {
+		c.limiter.SetLimit(rate.Limit(cfg.RPS))
+		c.limiter.SetBurst(cfg.Burst)
+	}
+}
+
+// Allow reports whether a request from key may proceed.
+func (l *Limiter) Allow(key string) bool {
+	l.mu.Lock()
+	defer l.mu.Unlock()
+	if !l.cfg.Enabled {
+		return true
+	}
+
+	now := time.Now()
+	if now.Sub(l.sweep) > idleTTL {
+		for k, c := range l.clients {
+			if now.Sub(c.lastSeen) > idleTTL {
+				delete(l.clients, k)
+			}
+		}
+		l.sweep = now
+	}
+
+	c, ok := l.clients[key]
+	if !ok {
+		c = &client{limiter: rate.NewLimiter(rate.Limit(l.cfg.RPS), l.cfg.Burst)}
+		l.clients[key] = c
+	}
+	c.lastSeen = now
+	return c.limiter.AllowN(now, 1)
+}
+
+// Middleware rejects requests over the limit with 429 Too Many Requests.
+func (l *Limiter) Middleware(log *slog.Logger) func(next http.Handler) http.Handler {
+	return func(next http.Handler) http.Handler {
+		log := log.With(slog.String("component", "middleware/ratelimit"))
+		fn := func(w http.ResponseWriter, r *http.Request) {
+			ip := ClientIP(r)
+			if !l.Allow(ip) {
+				log.Info("rate limit exceeded",
+					slog.String("remote_addr", ip),
+					slog.String("request_id", middleware.GetReqID(r.Context())))
+				w.Header().Set("Retry-After", "1")
+				resp.Fail(w, r, http.StatusTooManyRequests, resp.CodeRateLimited, "too many requests")
+				return
+			}
+			next.ServeHTTP(w, r)
+		}
+		return http.HandlerFunc(fn)
+	}
+}
+
+// ClientIP returns the host part of r.RemoteAddr.
+func ClientIP(r *http.Request) string {
+	host, _, err := net.SplitHostPort(r.RemoteAddr)
+	if err != nil {
+		return r.RemoteAddr
+	}
+	return host
+}
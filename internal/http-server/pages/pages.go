@@ -0,0 +1,184 @@
+package pages
+
+import (
+	"bytes"
+	"embed"
+	"fmt"
+	"github.com/kxddry/url-shortener/internal/http-server/middleware/csrf"
+	resp "github.com/kxddry/url-shortener/internal/lib/api/response"
+	"github.com/kxddry/url-shortener/internal/lib/session"
+	"github.com/kxddry/url-shortener/internal/storage"
+	"html/template"
+	"net/http"
+	"net/url"
+	"time"
+)
+
+//go:embed templates/*.html
+var files embed.FS
+
+var funcs = template.FuncMap{
+	"date": func(t any) string {
+		switch t := t.(type) {
+		case time.Time:
+			return t.Format("2 January 2006")
+		case *time.Time:
+			if t != nil {
+				return t.Format("2 January 2006")
+			}
+		}
+		return ""
+	},
+	// datetime is for moments that matter to the minute, such as launches.
+	"datetime": func(t *time.Time) string {
+		if t == nil {
+			return ""
+		}
+		return t.UTC().Format("2 January 2006 15:04 MST")
+	},
+	"short": func(host string, l storage.Link) string {
+		if l.Domain != "" {
+			host = l.Domain
+		}
+		return host + "/" + l.Alias
+	},
+	"manage": func(l storage.Link, suffix string) string { return LinkPath(l.Domain, l.Alias, suffix) },
+	"codes":  func() []int { return []int{301, 302, 307, 308} },
+}
+
+var templates = template.Must(template.New("").Funcs(funcs).ParseFS(files, "templates/*.html"))
+
+// Page holds what the layout needs. Template data embeds it.
+type Page struct {
+	PageTitle string
+	// Refresh is the content of a meta refresh tag, e.g. "5;url=https://example.com".
+	Refresh string
+
+	// UI shows the navigation of the web UI, see UI.
+	UI       bool
+	SignedIn bool
+	// CSRF is the token forms have to post as csrf_token.
+	CSRF string
+}
+
+// UI returns the Page of a web UI page served to r.
+func UI(r *http.Request, title string) Page {
+	return Page{
+		PageTitle: title,
+		UI:        true,
+		SignedIn:  session.Token(r) != "",
+		CSRF:      csrf.Token(r.Context()),
+	}
+}
+
+// Error is the data for error.html.
+type Error struct {
+	Page
+	Message string
+	Details []string
+	// Back is where the "Back" link goes, none if empty.
+	Back string
+}
+
+// Preview is the data for preview.html.
+type Preview struct {
+	Page
+	Link     storage.Link
+	ShortURL string
+}
+
+// Interstitial is the data for interstitial.html.
+type Interstitial struct {
+	Page
+	Host    string
+	Title   string
+	Target  string
+	Seconds int
+}
+
+// Disabled is the data for disabled.html, served instead of redirecting
+// through a disabled link.
+type Disabled struct {
+	Page
+	Alias  string
+	Reason string
+	// Until is when a temporary disable ends, nil until an admin enables the link.
+	Until *time.Time
+}
+
+// Login is the data for login.html.
+type Login struct {
+	Page
+	Message string
+}
+
+// Register is the data for register.html.
+type Register struct {
+	Page
+}
+
+// Links is the data for links.html, the home page of signed in users.
+type Links struct {
+	Page
+	// Host is the host of links without a custom domain.
+	Host   string
+	Tag    string
+	Broken bool
+	Links  []storage.Link
+}
+
+// Link is the data for link.html and edit.html.
+type Link struct {
+	Page
+	Host string
+	Link storage.Link
+}
+
+// LinkPath returns the path of the web UI page of a link, followed by suffix,
+// e.g. "/edit". Links with a custom domain are addressed with ?domain=.
+func LinkPath(domain, alias, suffix string) string {
+	p := "/url/" + url.PathEscape(alias) + suffix
+	if domain != "" {
+		p += "?domain=" + url.QueryEscape(domain)
+	}
+	return p
+}
+
+// Render writes the named template with status. The page is rendered into
+// a buffer first, so that a template error doesn't leave a half-written page.
+func Render(w http.ResponseWriter, status int, name string, data any) error {
+	const op = "http-server.pages.Render"
+	var buf bytes.Buffer
+	if err := templates.ExecuteTemplate(&buf, name, data); err != nil {
+		return fmt.Errorf("%s: %w", op, err)
+	}
+	w.Header().Set("Content-Type", "text/html; charset=utf-8")
+	w.WriteHeader(status)
+	_, err := buf.WriteTo(w)
+	return err
+}
+
+// Problem shows p to a browser. Unauthenticated users are sent to the login page.
+// It is meant for resp.SetHTMLWriter.
+func Problem(w http.ResponseWriter, r *http.Request, p resp.Problem) {
+	if p.Status == http.StatusUnauthorized {
+		http.Redirect(w, r, "/login", http.StatusSeeOther)
+		return
+	}
+
+	data := Error{
+		Page:    UI(r, p.Title),
+		Message: p.Detail,
+		Back:    "/",
+	}
+	for _, fe := range p.Errors {
+		data.Details = append(data.Details, fe.Field+" "+fe.Message)
+	}
+	// forms come back to the page they were posted from
+	if ref, err := url.Parse(r.Referer()); err == nil && ref.Host == r.Host && ref.Path != "" {
+		data.Back = ref.RequestURI()
+	}
+	if err := Render(w, p.Status, "error.html", data); err != nil {
+		http.Error(w, p.Detail, p.Status)
+	}
+}
@@ -0,0 +1,75 @@
+package pages
+
+import (
+	"net/http"
+	"net/http/httptest"
+	"testing"
+	"time"
+
+	resp "github.com/kxddry/url-shortener/internal/lib/api/response"
+	"github.com/kxddry/url-shortener/internal/storage"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+func TestRender(t *testing.T) {
+	link := storage.Link{
+		Domain:       "go.example.com",
+		Alias:        "abc",
+		URL:          "https://example.com/?a=1&b=2",
+		RedirectCode: 302,
+		CreatedAt:    time.Now(),
+		Clicks:       42,
+		Tags:         []string{"news", "q3"},
+	}
+	page := Page{PageTitle: "t", UI: true, SignedIn: true, CSRF: "tok"}
+	checked := link
+	checked.Health = &storage.LinkHealth{StatusCode: 404, Error: "unexpected status 404", Failures: 3, Broken: true, CheckedAt: &link.CreatedAt}
+	launch := time.Date(2030, 3, 1, 9, 30, 0, 0, time.UTC)
+	scheduled := link
+	scheduled.NotBefore, scheduled.PrelaunchURL = &launch, "https://example.com/soon"
+
+	tests := []struct {
+		name string
+		data any
+		want []string
+	}{
+		{"login.html", Login{Page: page, Message: "hi"}, []string{`action="/login"`, `value="tok"`}},
+		{"register.html", Register{Page: page}, []string{`action="/register"`}},
+		{"links.html", Links{Page: page, Host: "sho.rt", Links: []storage.Link{link, {Alias: "x"}}},
+			[]string{"go.example.com/abc", "sho.rt/x", `/url/abc/delete?domain=go.example.com`, "Log out"}},
+		{"links.html", Links{Page: page, Host: "sho.rt", Broken: true, Links: []storage.Link{checked}},
+			[]string{`<span class="tag broken" title="unexpected status 404">broken</span>`, "Show all"}},
+		{"link.html", Link{Page: page, Host: "sho.rt", Link: link}, []string{"42", `/url/abc/edit?domain=go.example.com`, "not checked yet"}},
+		{"link.html", Link{Page: page, Host: "sho.rt", Link: checked}, []string{"unexpected status 404", "checked " + link.CreatedAt.Format("2 January 2006")}},
+		{"link.html", Link{Page: page, Host: "sho.rt", Link: scheduled}, []string{"from 1 March 2030 09:30 UTC", "visitors go to https://example.com/soon"}},
+		{"edit.html", Link{Page: page, Host: "sho.rt", Link: link}, []string{`value="news, q3"`, `<option value="302" selected>`}},
+		{"disabled.html", Disabled{Page: page, Alias: "abc", Reason: "phishing", Until: &launch},
+			[]string{"abc", "Reason:</strong> phishing", "until 1 March 2030 09:30 UTC"}},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			w := httptest.NewRecorder()
+			require.NoError(t, Render(w, http.StatusOK, tt.name, tt.data))
+			for _, s := range tt.want {
+				assert.Contains(t, w.Body.String(), s)
+			}
+		})
+	}
+}
+
+func TestProblem(t *testing.T) {
+	r := httptest.NewRequest(http.MethodPost, "/url", nil)
+	r.Header.Set("Referer", "http://example.com/?tag=a")
+
+	w := httptest.NewRecorder()
+	Problem(w, r, resp.NewProblem(http.StatusConflict, resp.CodeAliasExists, "alias already exists"))
+	assert.Equal(t, http.StatusConflict, w.Code)
+	assert.Contains(t, w.Body.String(), "alias already exists")
+	assert.Contains(t, w.Body.String(), `href="/?tag=a"`)
+
+	w = httptest.NewRecorder()
+	Problem(w, r, resp.NewProblem(http.StatusUnauthorized, resp.CodeUnauthenticated, ""))
+	assert.Equal(t, http.StatusSeeOther, w.Code)
+	assert.Equal(t, "/login", w.Header().Get("Location"))
+}
@@ -0,0 +1,72 @@
+package access
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"github.com/kxddry/url-shortener/internal/storage"
+)
+
+type RoleGetter interface {
+	Role(ctx context.Context, workspaceID, uid int64) (string, error)
+}
+
+type AdminChecker interface {
+	IsAdmin(ctx context.Context, userID int64) (bool, error)
+}
+
+// Checker decides what a user may do with links and workspaces.
+// SSO admins may do anything.
+type Checker struct {
+	members RoleGetter
+	sso     AdminChecker
+}
+
+func New(members RoleGetter, sso AdminChecker) *Checker {
+	return &Checker{members: members, sso: sso}
+}
+
+var rank = map[string]int{
+	storage.RoleViewer: 1,
+	storage.RoleEditor: 2,
+	storage.RoleOwner:  3,
+}
+
+// AtLeast reports whether role is min or a more privileged one.
+func AtLeast(role, min string) bool {
+	return rank[role] >= rank[min] && rank[role] > 0
+}
+
+// Member reports whether uid holds at least min in the workspace.
+func (c *Checker) Member(ctx context.Context, uid, workspaceID int64, min string) (bool, error) {
+	const op = "lib.access.Member"
+
+	role, err := c.members.Role(ctx, workspaceID, uid)
+	if err != nil && !errors.Is(err, storage.ErrNotMember) {
+		return false, fmt.Errorf("%s: %w", op, err)
+	}
+	if AtLeast(role, min) {
+		return true, nil
+	}
+	return c.admin(ctx, op, uid)
+}
+
+// Link reports whether uid holds at least min for the link. The creator of a
+// personal link holds every role; workspace links go by workspace membership.
+func (c *Checker) Link(ctx context.Context, uid int64, link storage.Link, min string) (bool, error) {
+	if link.WorkspaceID == 0 {
+		if link.CreatedBy == uid {
+			return true, nil
+		}
+		return c.admin(ctx, "lib.access.Link", uid)
+	}
+	return c.Member(ctx, uid, link.WorkspaceID, min)
+}
+
+func (c *Checker) admin(ctx context.Context, op string, uid int64) (bool, error) {
+	isAdmin, err := c.sso.IsAdmin(ctx, uid)
+	if err != nil {
+		return false, fmt.Errorf("%s: %w", op, err)
+	}
+	return isAdmin, nil
+}
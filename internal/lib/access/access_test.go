@@ -0,0 +1,58 @@
+package access
+
+import (
+	"context"
+	"testing"
+
+	"github.com/kxddry/url-shortener/internal/storage"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+type roles map[int64]string
+
+func (r roles) Role(_ context.Context, _, uid int64) (string, error) {
+	role, ok := r[uid]
+	if !ok {
+		return "", storage.ErrNotMember
+	}
+	return role, nil
+}
+
+type admins map[int64]bool
+
+func (a admins) IsAdmin(_ context.Context, uid int64) (bool, error) {
+	return a[uid], nil
+}
+
+func TestLink(t *testing.T) {
+	c := New(roles{1: storage.RoleOwner, 2: storage.RoleEditor, 3: storage.RoleViewer}, admins{9: true})
+	personal := storage.Link{CreatedBy: 5}
+	shared := storage.Link{CreatedBy: 5, WorkspaceID: 7}
+
+	cases := []struct {
+		name string
+		uid  int64
+		link storage.Link
+		min  string
+		want bool
+	}{
+		{"creator of personal link", 5, personal, storage.RoleOwner, true},
+		{"stranger to personal link", 1, personal, storage.RoleViewer, false},
+		{"admin on personal link", 9, personal, storage.RoleOwner, true},
+		{"owner edits", 1, shared, storage.RoleEditor, true},
+		{"editor edits", 2, shared, storage.RoleEditor, true},
+		{"editor can't transfer", 2, shared, storage.RoleOwner, false},
+		{"viewer can't edit", 3, shared, storage.RoleEditor, false},
+		{"viewer views", 3, shared, storage.RoleViewer, true},
+		{"creator outside the workspace", 5, shared, storage.RoleViewer, false},
+		{"admin on workspace link", 9, shared, storage.RoleOwner, true},
+	}
+	for _, tc := range cases {
+		t.Run(tc.name, func(t *testing.T) {
+			got, err := c.Link(context.Background(), tc.uid, tc.link, tc.min)
+			require.NoError(t, err)
+			assert.Equal(t, tc.want, got)
+		})
+	}
+}
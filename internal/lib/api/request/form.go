@@ -0,0 +1,138 @@
+package request
+
+import (
+	"errors"
+	"fmt"
+	"github.com/go-chi/render"
+	"io"
+	"mime"
+	"net/http"
+	"net/url"
+	"reflect"
+	"strconv"
+	"strings"
+)
+
+const contentTypeForm = "application/x-www-form-urlencoded"
+
+// Decode reads the body of r into v, which must point to a struct. HTML forms
+// are decoded by the fields' JSON names, anything else is decoded as JSON.
+// An empty body is reported as io.EOF either way.
+func Decode(r *http.Request, v any) error {
+	if !IsForm(r) {
+		return render.DecodeJSON(r.Body, v)
+	}
+	if err := r.ParseForm(); err != nil {
+		return err
+	}
+	if len(r.PostForm) == 0 {
+		return io.EOF
+	}
+	return decodeForm(r.PostForm, reflect.ValueOf(v).Elem())
+}
+
+// IsForm reports whether r carries an HTML form.
+func IsForm(r *http.Request) bool {
+	mt, _, _ := mime.ParseMediaType(r.Header.Get("Content-Type"))
+	return mt == contentTypeForm
+}
+
+// decodeForm sets the fields of the struct v that form has values for. Forms
+// can only express strings, numbers, booleans and lists of strings.
+func decodeForm(form url.Values, v reflect.Value) error {
+	t := v.Type()
+	for i := 0; i < t.NumField(); i++ {
+		f := t.Field(i)
+		name, _, _ := strings.Cut(f.Tag.Get("json"), ",")
+		if name == "" || name == "-" {
+			continue
+		}
+		values, ok := form[name]
+		if !ok || len(values) == 0 {
+			continue
+		}
+		if err := setField(v.Field(i), values); err != nil {
+			return fmt.Errorf("%s: %w", name, err)
+		}
+	}
+	return nil
+}
+
+func setField(f reflect.Value, values []string) error {
+	if f.Kind() == reflect.Pointer {
+		p := reflect.New(f.Type().Elem())
+		if err := setField(p.Elem(), values); err != nil {
+			return err
+		}
+		f.Set(p)
+		return nil
+	}
+
+	// checkboxes follow a hidden input of the same name, so the last value wins
+	last := strings.TrimSpace(values[len(values)-1])
+	switch f.Kind() {
+	case reflect.String:
+		f.SetString(last)
+	case reflect.Bool:
+		b := last == "on"
+		if !b {
+			var err error
+			if b, err = strconv.ParseBool(last); err != nil {
+				return errors.New("must be a boolean")
+			}
+		}
+		f.SetBool(b)
+	case reflect.Int, reflect.Int8, reflect.Int16, reflect.Int32, reflect.Int64:
+		if last == "" {
+			return nil
+		}
+		n, err := strconv.ParseInt(last, 10, f.Type().Bits())
+		if err != nil {
+			return errors.New("must be a number")
+		}
+		f.SetInt(n)
+	case reflect.Slice:
+		if f.Type().Elem().Kind() != reflect.String {
+			return errors.New("cannot be set from a form")
+		}
+		// lists are typed as "a, b, c" or sent as repeated values
+		list := []string{}
+		for _, v := range values {
+			for _, item := range strings.Split(v, ",") {
+				if item = strings.TrimSpace(item); item != "" {
+					list = append(list, item)
+				}
+			}
+		}
+		f.Set(reflect.ValueOf(list).Convert(f.Type()))
+	default:
+		return errors.New("cannot be set from a form")
+	}
+	return nil
+}
+
+// WantsHTML reports whether the client prefers an HTML page to JSON, as
+// browsers navigating to a page do. Clients that accept both equally, or
+// send no Accept header, get JSON.
+func WantsHTML(r *http.Request) bool {
+	html, json := -1.0, -1.0
+	for _, part := range strings.Split(r.Header.Get("Accept"), ",") {
+		mt, params, _ := strings.Cut(part, ";")
+		q := 1.0
+		for _, p := range strings.Split(params, ";") {
+			k, v, _ := strings.Cut(strings.TrimSpace(p), "=")
+			if k == "q" {
+				if f, err := strconv.ParseFloat(v, 64); err == nil {
+					q = f
+				}
+			}
+		}
+		switch strings.ToLower(strings.TrimSpace(mt)) {
+		case "text/html":
+			html = max(html, q)
+		case "application/json", "application/problem+json":
+			json = max(json, q)
+		}
+	}
+	return html > 0 && html > json
+}
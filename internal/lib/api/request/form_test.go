@@ -0,0 +1,68 @@
+package request
+
+import (
+	"io"
+	"net/http/httptest"
+	"strings"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+func TestDecodeForm(t *testing.T) {
+	type req struct {
+		URL          string    `json:"url"`
+		Code         int       `json:"redirect_code,omitempty"`
+		Interstitial *bool     `json:"interstitial,omitempty"`
+		Title        *string   `json:"title,omitempty"`
+		Tags         *[]string `json:"tags,omitempty"`
+		Ignored      string    `json:"-"`
+	}
+
+	body := "url=https%3A%2F%2Fexample.com&redirect_code=301&interstitial=false&interstitial=on&tags=a,+b&tags=c&csrf_token=x"
+	r := httptest.NewRequest("POST", "/url", strings.NewReader(body))
+	r.Header.Set("Content-Type", "application/x-www-form-urlencoded")
+
+	var got req
+	require.NoError(t, Decode(r, &got))
+	assert.Equal(t, "https://example.com", got.URL)
+	assert.Equal(t, 301, got.Code)
+	require.NotNil(t, got.Interstitial)
+	assert.True(t, *got.Interstitial)
+	assert.Nil(t, got.Title, "fields missing from the form are left alone")
+	require.NotNil(t, got.Tags)
+	assert.Equal(t, []string{"a", "b", "c"}, *got.Tags)
+
+	r = httptest.NewRequest("POST", "/url", strings.NewReader("redirect_code=abc"))
+	r.Header.Set("Content-Type", "application/x-www-form-urlencoded")
+	assert.ErrorContains(t, Decode(r, &got), "redirect_code")
+
+	r = httptest.NewRequest("POST", "/url", strings.NewReader(""))
+	r.Header.Set("Content-Type", "application/x-www-form-urlencoded")
+	assert.ErrorIs(t, Decode(r, &got), io.EOF)
+
+	r = httptest.NewRequest("POST", "/url", strings.NewReader(`{"url":"https://example.org"}`))
+	require.NoError(t, Decode(r, &got))
+	assert.Equal(t, "https://example.org", got.URL)
+}
+
+func TestWantsHTML(t *testing.T) {
+	tests := []struct {
+		accept string
+		want   bool
+	}{
+		{"", false},
+		{"*/*", false},
+		{"application/json", false},
+		{"text/html,application/xhtml+xml,application/xml;q=0.9,*/*;q=0.8", true},
+		{"application/json, text/html", false},
+		{"application/json;q=0.5, text/html", true},
+		{"text/html;q=0", false},
+	}
+	for _, tt := range tests {
+		r := httptest.NewRequest("GET", "/", nil)
+		r.Header.Set("Accept", tt.accept)
+		assert.Equal(t, tt.want, WantsHTML(r), tt.accept)
+	}
+}
@@ -0,0 +1,53 @@
+package request
+
+import (
+	"encoding/json"
+	"github.com/go-playground/validator/v10"
+	"reflect"
+	"strings"
+)
+
+var validate = newValidator()
+
+func newValidator() *validator.Validate {
+	v := validator.New()
+	// report fields by the names clients send them under
+	v.RegisterTagNameFunc(func(f reflect.StructField) string {
+		name, _, _ := strings.Cut(f.Tag.Get("json"), ",")
+		if name == "-" {
+			return ""
+		}
+		if name == "" {
+			return f.Name
+		}
+		return name
+	})
+	return v
+}
+
+// Validate checks the validate tags of the request v. Its errors are
+// validator.ValidationErrors whose fields carry their JSON names.
+func Validate(v any) error {
+	return validate.Struct(v)
+}
+
+// Nullable is a field of a partial update that can be left out, which leaves
+// it as it is, or sent as null to clear it.
+type Nullable[T any] struct {
+	Set   bool
+	Value *T
+}
+
+func (n *Nullable[T]) UnmarshalJSON(b []byte) error {
+	n.Set = true
+	if string(b) == "null" {
+		n.Value = nil
+		return nil
+	}
+	var v T
+	if err := json.Unmarshal(b, &v); err != nil {
+		return err
+	}
+	n.Value = &v
+	return nil
+}
@@ -0,0 +1,157 @@
+package response
+
+import (
+	"context"
+	"database/sql/driver"
+	"errors"
+	"fmt"
+	"github.com/kxddry/url-shortener/internal/lib/jwt"
+	"github.com/kxddry/url-shortener/internal/lib/signed"
+	"github.com/kxddry/url-shortener/internal/storage"
+	"github.com/lib/pq"
+	"google.golang.org/grpc/codes"
+	"google.golang.org/grpc/status"
+	"net"
+	"net/http"
+	"syscall"
+	"time"
+)
+
+type mapping struct {
+	err    error
+	status int
+	code   Code
+	detail string
+}
+
+// known maps domain errors to the problem they are reported as.
+var known = []mapping{
+	{storage.ErrAliasNotFound, http.StatusNotFound, CodeAliasNotFound, "alias not found"},
+	{storage.ErrAliasExists, http.StatusConflict, CodeAliasExists, "alias already exists"},
+	{storage.ErrLinkExhausted, http.StatusGone, CodeLinkExhausted, "the link has been used up"},
+	{storage.ErrLinkNotActive, http.StatusForbidden, CodeLinkNotActive, "the link is not active yet"},
+	{storage.ErrLinkExpired, http.StatusGone, CodeLinkExpired, "the link has expired"},
+	{storage.ErrLinkDisabled, http.StatusGone, CodeLinkDisabled, "the link has been disabled"},
+	{storage.ErrNotBlocked, http.StatusNotFound, CodeNotBlocked, "domain is not blocked"},
+	{storage.ErrNoReports, http.StatusNotFound, CodeNoReports, "the link has no pending reports"},
+	{storage.ErrDomainNotFound, http.StatusNotFound, CodeDomainNotFound, "domain not found"},
+	{storage.ErrDomainExists, http.StatusConflict, CodeDomainExists, "domain already exists"},
+	{storage.ErrDomainInUse, http.StatusConflict, CodeDomainInUse, "domain still has links"},
+	{storage.ErrOwnerChanged, http.StatusConflict, CodeConflict, "the link changed owners in the meantime, try again"},
+	{storage.ErrWorkspaceNotFound, http.StatusNotFound, CodeWorkspaceNotFound, "workspace not found"},
+	{storage.ErrNotMember, http.StatusNotFound, CodeNotMember, "member not found"},
+	{storage.ErrAlreadyMember, http.StatusConflict, CodeAlreadyMember, "user is already a member"},
+	{storage.ErrInvitationExists, http.StatusConflict, CodeInvitationExists, "user is already invited"},
+	{storage.ErrInvitationNotFound, http.StatusNotFound, CodeInvitationNotFound, "invitation not found"},
+	{storage.ErrLastOwner, http.StatusConflict, CodeLastOwner, "workspace must keep an owner"},
+	{storage.ErrWebhookNotFound, http.StatusNotFound, CodeWebhookNotFound, "webhook not found"},
+	{storage.ErrHealthNotChecked, http.StatusNotFound, CodeHealthNotChecked, "the destination hasn't been checked yet"},
+	{storage.ErrUnavailable, http.StatusServiceUnavailable, CodeReadOnly, "the service is read-only while its database is unavailable, existing links still redirect"},
+	{storage.ErrQueuedNotFound, http.StatusNotFound, CodeQueuedNotFound, "queued link not found"},
+	{signed.ErrInvalidLink, http.StatusNotFound, CodeInvalidSignedLink, "the link is invalid"},
+	{signed.ErrExpired, http.StatusGone, CodeLinkExpired, "the link has expired"},
+	{jwt.ErrNoHeader, http.StatusUnauthorized, CodeUnauthenticated, "go to /login or /register"},
+	{jwt.ErrInvalidHeader, http.StatusUnauthorized, CodeInvalidToken, "invalid authorization header"},
+	{jwt.ErrInvalidToken, http.StatusUnauthorized, CodeInvalidToken, "invalid token"},
+	{jwt.ErrInvalidSigning, http.StatusUnauthorized, CodeInvalidToken, "invalid token"},
+	{jwt.ErrMissingUserID, http.StatusUnauthorized, CodeInvalidToken, "invalid token"},
+}
+
+// grpcCodes maps the status codes of SSO calls.
+var grpcCodes = map[codes.Code]mapping{
+	codes.InvalidArgument:   {nil, http.StatusBadRequest, CodeBadRequest, "invalid request"},
+	codes.NotFound:          {nil, http.StatusNotFound, CodeNotFound, "not found"},
+	codes.AlreadyExists:     {nil, http.StatusConflict, CodeConflict, "already exists"},
+	codes.PermissionDenied:  {nil, http.StatusForbidden, CodeForbidden, "permission denied"},
+	codes.Unauthenticated:   {nil, http.StatusUnauthorized, CodeUnauthenticated, "unauthenticated"},
+	codes.ResourceExhausted: {nil, http.StatusTooManyRequests, CodeRateLimited, "too many requests"},
+	codes.Unavailable:       {nil, http.StatusServiceUnavailable, CodeUnavailable, "service temporarily unavailable"},
+	codes.Canceled:          {nil, http.StatusServiceUnavailable, CodeUnavailable, "service temporarily unavailable"},
+	codes.DeadlineExceeded:  {nil, http.StatusGatewayTimeout, CodeTimeout, "timed out waiting for an upstream service"},
+}
+
+// FromError maps err to a problem: problems returned as errors to themselves,
+// domain errors such as storage.ErrAliasExists and jwt.ErrInvalidToken to their
+// own code, gRPC statuses to the matching HTTP status, running out of time to
+// 504, unreachable dependencies to 503 and anything else to a 500 that doesn't
+// reveal the error.
+func FromError(err error) Problem {
+	var p Problem
+	if errors.As(err, &p) {
+		return p
+	}
+	var qe *storage.QuotaError
+	if errors.As(err, &qe) {
+		return quotaProblem(qe)
+	}
+	for _, m := range known {
+		if errors.Is(err, m.err) {
+			return NewProblem(m.status, m.code, m.detail)
+		}
+	}
+	switch {
+	case timedOut(err):
+		return NewProblem(http.StatusGatewayTimeout, CodeTimeout, "timed out waiting for an upstream service")
+	case unavailable(err):
+		return NewProblem(http.StatusServiceUnavailable, CodeUnavailable, "service temporarily unavailable")
+	}
+	if s, ok := grpcStatus(err); ok {
+		if m, ok := grpcCodes[s.Code()]; ok {
+			return NewProblem(m.status, m.code, m.detail)
+		}
+	}
+	return NewProblem(http.StatusInternalServerError, CodeInternal, "internal server error")
+}
+
+// quotaProblem says which limit was hit: a 403 for the active links, which
+// only deleting links frees up, and a 429 for the limits that reset.
+func quotaProblem(qe *storage.QuotaError) Problem {
+	reset := qe.Reset.Format(time.RFC3339)
+	switch qe.Limit {
+	case "links":
+		return NewProblem(http.StatusForbidden, CodeQuotaExceeded,
+			fmt.Sprintf("the quota of %d active links is used up, delete some links first", qe.Max))
+	case "links_per_day":
+		return NewProblem(http.StatusTooManyRequests, CodeQuotaExceeded,
+			fmt.Sprintf("the quota of %d new links per day is used up, try again after %s", qe.Max, reset))
+	case "custom_aliases_per_month":
+		return NewProblem(http.StatusTooManyRequests, CodeQuotaExceeded,
+			fmt.Sprintf("the quota of %d custom aliases per month is used up, try again after %s or leave out the alias", qe.Max, reset))
+	}
+	return NewProblem(http.StatusTooManyRequests, CodeQuotaExceeded, qe.Error())
+}
+
+func timedOut(err error) bool {
+	if errors.Is(err, context.DeadlineExceeded) {
+		return true
+	}
+	var netErr net.Error
+	if errors.As(err, &netErr) && netErr.Timeout() {
+		return true
+	}
+	var pqErr *pq.Error
+	return errors.As(err, &pqErr) && pqErr.Code.Name() == "query_canceled"
+}
+
+func unavailable(err error) bool {
+	if errors.Is(err, context.Canceled) || errors.Is(err, driver.ErrBadConn) || errors.Is(err, syscall.ECONNREFUSED) {
+		return true
+	}
+	var pqErr *pq.Error
+	if errors.As(err, &pqErr) {
+		switch pqErr.Code.Class() {
+		case "08", "53", "57": // connection exception, insufficient resources, operator intervention
+			return true
+		}
+	}
+	return false
+}
+
+// grpcStatus finds a gRPC status anywhere in err's chain.
+func grpcStatus(err error) (*status.Status, bool) {
+	var se interface{ GRPCStatus() *status.Status }
+	if errors.As(err, &se) {
+		return se.GRPCStatus(), true
+	}
+	return nil, false
+}
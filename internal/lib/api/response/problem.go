@@ -0,0 +1,139 @@
+package response
+
+import (
+	"encoding/json"
+	"errors"
+	"github.com/go-chi/chi/v5/middleware"
+	"github.com/kxddry/url-shortener/internal/lib/api/request"
+	"github.com/kxddry/url-shortener/internal/storage"
+	"net/http"
+	"strconv"
+	"time"
+)
+
+// Code identifies what went wrong. Codes are stable, so clients can branch on
+// them instead of on the wording of the detail.
+type Code string
+
+const (
+	CodeInvalidBody        Code = "invalid_body"
+	CodeValidationFailed   Code = "validation_failed"
+	CodeBadRequest         Code = "bad_request"
+	CodeUnauthenticated    Code = "unauthenticated"
+	CodeInvalidToken       Code = "invalid_token"
+	CodeInvalidCredentials Code = "invalid_credentials"
+	CodeForbidden          Code = "forbidden"
+	CodeNotFound           Code = "not_found"
+	CodeConflict           Code = "conflict"
+	CodeRateLimited        Code = "rate_limited"
+	CodeInternal           Code = "internal"
+	CodeUnavailable        Code = "unavailable"
+	CodeTimeout            Code = "timeout"
+
+	CodeAliasNotFound        Code = "alias_not_found"
+	CodeAliasExists          Code = "alias_exists"
+	CodeLinkExhausted        Code = "link_exhausted"
+	CodeLinkNotActive        Code = "link_not_active"
+	CodeLinkExpired          Code = "link_expired"
+	CodeQuotaExceeded        Code = "quota_exceeded"
+	CodeLinkDisabled         Code = "link_disabled"
+	CodeNotBlocked           Code = "not_blocked"
+	CodeNoReports            Code = "no_reports"
+	CodeIdempotencyKeyReused Code = "idempotency_key_reused"
+	CodeIdempotencyInFlight  Code = "idempotency_in_flight"
+	CodeAliasReserved        Code = "alias_reserved"
+	CodeDestinationBlocked   Code = "destination_blocked"
+	CodeDomainNotFound       Code = "domain_not_found"
+	CodeDomainExists         Code = "domain_exists"
+	CodeDomainInUse          Code = "domain_in_use"
+	CodeWorkspaceNotFound    Code = "workspace_not_found"
+	CodeNotMember            Code = "not_member"
+	CodeAlreadyMember        Code = "already_member"
+	CodeInvitationExists     Code = "invitation_exists"
+	CodeInvitationNotFound   Code = "invitation_not_found"
+	CodeLastOwner            Code = "last_owner"
+	CodeWebhookNotFound      Code = "webhook_not_found"
+	CodeUserExists           Code = "user_exists"
+	CodeCSRF                 Code = "csrf_failed"
+	CodeHealthNotChecked     Code = "health_not_checked"
+	CodeReadOnly             Code = "read_only"
+	CodeInvalidSignedLink    Code = "invalid_signed_link"
+	CodeQueuedNotFound       Code = "queued_not_found"
+)
+
+// TypePrefix turns a code into the problem type URI.
+const TypePrefix = "urn:url-shortener:problem:"
+
+const ContentTypeProblem = "application/problem+json"
+
+// Problem is an RFC 7807 problem details object.
+type Problem struct {
+	Type   string `json:"type"`
+	Title  string `json:"title"`
+	Status int    `json:"status"`
+	Detail string `json:"detail,omitempty"`
+	// Instance is the ID of the request, as found in the logs.
+	Instance string       `json:"instance,omitempty"`
+	Code     Code         `json:"code"`
+	Errors   []FieldError `json:"errors,omitempty"`
+}
+
+// Error lets a problem be returned as an error, such as from a check that runs
+// deeper down, and be sent back as is by FailError.
+func (p Problem) Error() string {
+	return p.Detail
+}
+
+func NewProblem(status int, code Code, detail string) Problem {
+	return Problem{
+		Type:   TypePrefix + string(code),
+		Title:  http.StatusText(status),
+		Status: status,
+		Detail: detail,
+		Code:   code,
+	}
+}
+
+// htmlWriter renders problems for browsers, see SetHTMLWriter.
+var htmlWriter func(w http.ResponseWriter, r *http.Request, p Problem)
+
+// SetHTMLWriter makes problems of requests that prefer HTML go to fn
+// instead of being sent as JSON. It must be called before serving.
+func SetHTMLWriter(fn func(w http.ResponseWriter, r *http.Request, p Problem)) {
+	htmlWriter = fn
+}
+
+// WriteProblem sends p as application/problem+json with p.Status,
+// or as a page if the client wants HTML and SetHTMLWriter was called.
+func WriteProblem(w http.ResponseWriter, r *http.Request, p Problem) {
+	if p.Instance == "" {
+		p.Instance = middleware.GetReqID(r.Context())
+	}
+	if htmlWriter != nil && request.WantsHTML(r) {
+		htmlWriter(w, r, p)
+		return
+	}
+	w.Header().Set("Content-Type", ContentTypeProblem)
+	w.Header().Set("X-Content-Type-Options", "nosniff")
+	w.WriteHeader(p.Status)
+	_ = json.NewEncoder(w).Encode(p)
+}
+
+// Fail sends a problem with the given status, code and detail.
+func Fail(w http.ResponseWriter, r *http.Request, status int, code Code, detail string) {
+	WriteProblem(w, r, NewProblem(status, code, detail))
+}
+
+// FailError sends the problem err maps to, see FromError. Quotas that free up
+// again and a database that is down tell the client when to retry with Retry-After.
+func FailError(w http.ResponseWriter, r *http.Request, err error) {
+	var qe *storage.QuotaError
+	if errors.As(err, &qe) && !qe.Reset.IsZero() {
+		w.Header().Set("Retry-After", strconv.Itoa(int(time.Until(qe.Reset).Seconds())+1))
+	}
+	var ue *storage.UnavailableError
+	if errors.As(err, &ue) {
+		w.Header().Set("Retry-After", strconv.Itoa(int(ue.RetryAfter.Seconds())+1))
+	}
+	WriteProblem(w, r, FromError(err))
+}
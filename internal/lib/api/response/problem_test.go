@@ -0,0 +1,110 @@
+package response
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"net/http"
+	"net/http/httptest"
+	"testing"
+	"time"
+
+	"github.com/go-chi/chi/v5/middleware"
+	"github.com/go-playground/validator/v10"
+	"github.com/kxddry/url-shortener/internal/lib/api/request"
+	"github.com/kxddry/url-shortener/internal/lib/jwt"
+	"github.com/kxddry/url-shortener/internal/lib/signed"
+	"github.com/kxddry/url-shortener/internal/storage"
+	"github.com/lib/pq"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+	"google.golang.org/grpc/codes"
+	"google.golang.org/grpc/status"
+)
+
+func TestFromError(t *testing.T) {
+	tests := []struct {
+		name   string
+		err    error
+		status int
+		code   Code
+	}{
+		{"alias exists", fmt.Errorf("storage.SaveLink: %w", storage.ErrAliasExists), http.StatusConflict, CodeAliasExists},
+		{"alias not found", storage.ErrAliasNotFound, http.StatusNotFound, CodeAliasNotFound},
+		{"invalid token", jwt.ErrInvalidToken, http.StatusUnauthorized, CodeInvalidToken},
+		{"deadline", fmt.Errorf("storage.GetLink: %w", context.DeadlineExceeded), http.StatusGatewayTimeout, CodeTimeout},
+		{"statement cancelled", &pq.Error{Code: "57014"}, http.StatusGatewayTimeout, CodeTimeout},
+		{"connection failure", &pq.Error{Code: "08006"}, http.StatusServiceUnavailable, CodeUnavailable},
+		{"client gone", context.Canceled, http.StatusServiceUnavailable, CodeUnavailable},
+		{"sso deadline", fmt.Errorf("grpc.Login: %w", status.Error(codes.DeadlineExceeded, "")), http.StatusGatewayTimeout, CodeTimeout},
+		{"sso unavailable", status.Error(codes.Unavailable, ""), http.StatusServiceUnavailable, CodeUnavailable},
+		{"sso already exists", status.Error(codes.AlreadyExists, "user exists"), http.StatusConflict, CodeConflict},
+		{"active links quota", fmt.Errorf("storage.SaveLink: %w", &storage.QuotaError{Limit: "links", Max: 10}), http.StatusForbidden, CodeQuotaExceeded},
+		{"daily quota", &storage.QuotaError{Limit: "links_per_day", Max: 5}, http.StatusTooManyRequests, CodeQuotaExceeded},
+		{"tampered signed link", fmt.Errorf("lib.signed.Verify: %w", signed.ErrInvalidLink), http.StatusNotFound, CodeInvalidSignedLink},
+		{"expired signed link", signed.ErrExpired, http.StatusGone, CodeLinkExpired},
+		{"problem", fmt.Errorf("storage.UpdateLink: %w", NewProblem(http.StatusForbidden, CodeForbidden, "not yours")), http.StatusForbidden, CodeForbidden},
+		{"other", errors.New("boom"), http.StatusInternalServerError, CodeInternal},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			p := FromError(tt.err)
+			assert.Equal(t, tt.status, p.Status)
+			assert.Equal(t, tt.code, p.Code)
+			assert.Equal(t, TypePrefix+string(tt.code), p.Type)
+			assert.Equal(t, http.StatusText(tt.status), p.Title)
+		})
+	}
+	assert.NotContains(t, FromError(errors.New("pq: password authentication failed")).Detail, "password")
+}
+
+func TestFailQuota(t *testing.T) {
+	r := httptest.NewRequest(http.MethodPost, "/url", nil)
+	w := httptest.NewRecorder()
+	FailError(w, r, &storage.QuotaError{Limit: "custom_aliases_per_month", Max: 20, Reset: time.Now().Add(time.Hour)})
+
+	assert.Equal(t, http.StatusTooManyRequests, w.Code)
+	assert.Equal(t, "3600", w.Header().Get("Retry-After"))
+	var p Problem
+	require.NoError(t, json.NewDecoder(w.Body).Decode(&p))
+	assert.Contains(t, p.Detail, "20 custom aliases per month")
+}
+
+func TestFailUnavailable(t *testing.T) {
+	r := httptest.NewRequest(http.MethodPost, "/url", nil)
+	w := httptest.NewRecorder()
+	FailError(w, r, fmt.Errorf("storage.SaveLink: %w", &storage.UnavailableError{RetryAfter: 4500 * time.Millisecond}))
+
+	assert.Equal(t, http.StatusServiceUnavailable, w.Code)
+	assert.Equal(t, "5", w.Header().Get("Retry-After"))
+	var p Problem
+	require.NoError(t, json.NewDecoder(w.Body).Decode(&p))
+	assert.Equal(t, CodeReadOnly, p.Code)
+}
+
+func TestFailValidation(t *testing.T) {
+	type Request struct {
+		URL  string   `json:"url" validate:"required,url"`
+		Tags []string `json:"tags" validate:"max=1,dive,max=3"`
+	}
+	err := request.Validate(Request{Tags: []string{"toolong"}})
+	var errs validator.ValidationErrors
+	require.ErrorAs(t, err, &errs)
+
+	rec := httptest.NewRecorder()
+	req := httptest.NewRequest(http.MethodPost, "/url", nil)
+	req = req.WithContext(context.WithValue(req.Context(), middleware.RequestIDKey, "host/abc-000001"))
+	FailValidation(rec, req, errs)
+
+	assert.Equal(t, http.StatusBadRequest, rec.Code)
+	assert.Equal(t, ContentTypeProblem, rec.Header().Get("Content-Type"))
+	var p Problem
+	require.NoError(t, json.Unmarshal(rec.Body.Bytes(), &p))
+	assert.Equal(t, CodeValidationFailed, p.Code)
+	assert.Equal(t, "host/abc-000001", p.Instance)
+	assert.Equal(t, []FieldError{
+		{Field: "url", Rule: "required", Message: "is required"},
+		{Field: "tags[0]", Rule: "max", Message: "must be at most 3"},
+	}, p.Errors)
+}
@@ -0,0 +1,67 @@
+package response
+
+import (
+	"github.com/go-playground/validator/v10"
+	"net/http"
+	"strings"
+)
+
+// FieldError explains why one field of a request is invalid.
+type FieldError struct {
+	// Field is the path of the field in the request body, such as "tags[2]".
+	Field string `json:"field"`
+	// Rule is the validation rule that failed, such as "required" or "max".
+	Rule    string `json:"rule"`
+	Message string `json:"message"`
+}
+
+// FailValidation sends a 400 listing every invalid field.
+func FailValidation(w http.ResponseWriter, r *http.Request, errs validator.ValidationErrors) {
+	p := NewProblem(http.StatusBadRequest, CodeValidationFailed, "the request has invalid fields")
+	for _, e := range errs {
+		p.Errors = append(p.Errors, FieldError{
+			Field:   fieldPath(e),
+			Rule:    e.ActualTag(),
+			Message: fieldMessage(e),
+		})
+	}
+	WriteProblem(w, r, p)
+}
+
+// fieldPath drops the name of the request struct from the namespace.
+func fieldPath(e validator.FieldError) string {
+	ns := e.Namespace()
+	if i := strings.IndexByte(ns, '.'); i >= 0 {
+		return ns[i+1:]
+	}
+	return ns
+}
+
+func fieldMessage(e validator.FieldError) string {
+	switch e.ActualTag() {
+	case "required":
+		return "is required"
+	case "required_without":
+		return "is required unless " + e.Param() + " is set"
+	case "excluded_with":
+		return "can't be set together with " + e.Param()
+	case "url", "http_url":
+		return "must be a valid URL"
+	case "eq=|url":
+		return "must be a valid URL or empty"
+	case "oneof":
+		return "must be one of " + e.Param()
+	case "min", "gte":
+		return "must be at least " + e.Param()
+	case "max", "lte":
+		return "must be at most " + e.Param()
+	case "gt":
+		return "must be greater than " + e.Param()
+	case "lt":
+		return "must be less than " + e.Param()
+	case "alphanum":
+		return "must contain only letters and digits"
+	default:
+		return "is invalid"
+	}
+}
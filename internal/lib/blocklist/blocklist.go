@@ -0,0 +1,105 @@
+package blocklist
+
+import (
+	"context"
+	"fmt"
+	"github.com/kxddry/url-shortener/internal/lib/logger/sl"
+	"github.com/kxddry/url-shortener/internal/storage"
+	"log/slog"
+	"net/url"
+	"strings"
+	"sync/atomic"
+	"time"
+)
+
+type Lister interface {
+	BlockedDomains(ctx context.Context) ([]storage.BlockedDomain, error)
+}
+
+// List is a set of blocked destination domains that can be replaced at runtime:
+// those of the config and those blocked by admins.
+type List struct {
+	domains atomic.Pointer[map[string]struct{}]
+	stored  atomic.Pointer[map[string]struct{}]
+}
+
+func New(domains []string) *List {
+	l := &List{}
+	l.Set(domains)
+	l.stored.Store(&map[string]struct{}{})
+	return l
+}
+
+// Set replaces the blocked domains of the config.
+func (l *List) Set(domains []string) {
+	l.domains.Store(set(domains))
+}
+
+// Refresh reloads the domains blocked by admins from the store.
+func (l *List) Refresh(ctx context.Context, store Lister) error {
+	const op = "lib.blocklist.Refresh"
+	list, err := store.BlockedDomains(ctx)
+	if err != nil {
+		return fmt.Errorf("%s: %w", op, err)
+	}
+	domains := make([]string, len(list))
+	for i, d := range list {
+		domains[i] = d.Domain
+	}
+	l.stored.Store(set(domains))
+	return nil
+}
+
+// Run refreshes the domains blocked by admins every interval, picking up
+// changes made on other instances.
+func (l *List) Run(ctx context.Context, log *slog.Logger, store Lister, interval time.Duration) {
+	t := time.NewTicker(interval)
+	defer t.Stop()
+	for {
+		select {
+		case <-ctx.Done():
+			return
+		case <-t.C:
+			if err := l.Refresh(ctx, store); err != nil {
+				log.Error("failed to refresh blocked domains", sl.Err(err))
+			}
+		}
+	}
+}
+
+func set(domains []string) *map[string]struct{} {
+	m := make(map[string]struct{}, len(domains))
+	for _, d := range domains {
+		m[Normalize(d)] = struct{}{}
+	}
+	return &m
+}
+
+// Blocked reports whether the host of rawURL or any of its parent domains is blocked.
+func (l *List) Blocked(rawURL string) bool {
+	u, err := url.Parse(rawURL)
+	if err != nil {
+		return false
+	}
+	configured, stored := *l.domains.Load(), *l.stored.Load()
+	host := Normalize(u.Hostname())
+	for host != "" {
+		if _, ok := configured[host]; ok {
+			return true
+		}
+		if _, ok := stored[host]; ok {
+			return true
+		}
+		i := strings.IndexByte(host, '.')
+		if i < 0 {
+			break
+		}
+		host = host[i+1:]
+	}
+	return false
+}
+
+// Normalize returns domain the way it is matched against hosts.
+func Normalize(domain string) string {
+	return strings.TrimSuffix(strings.ToLower(strings.TrimSpace(domain)), ".")
+}
@@ -0,0 +1,146 @@
+package breaker
+
+import (
+	"sync"
+	"time"
+)
+
+type State int
+
+const (
+	// Closed lets every call through.
+	Closed State = iota
+	// Open fails calls fast until the dependency had time to recover.
+	Open
+	// HalfOpen lets a single call through to find out whether it recovered.
+	HalfOpen
+)
+
+func (s State) String() string {
+	switch s {
+	case Closed:
+		return "closed"
+	case Open:
+		return "open"
+	case HalfOpen:
+		return "half-open"
+	}
+	return "unknown"
+}
+
+// Breaker stops calls to a dependency that keeps failing. After threshold
+// failures in a row it opens for openFor, then lets one call through: if it
+// succeeds the breaker closes, if it fails the breaker opens again.
+type Breaker struct {
+	threshold int
+	openFor   time.Duration
+	now       func() time.Time
+
+	mu       sync.Mutex
+	onChange func(from, to State)
+	state    State
+	failures int
+	openedAt time.Time
+	probing  bool
+}
+
+// New returns a closed breaker. A threshold of 0 never opens it.
+func New(threshold int, openFor time.Duration) *Breaker {
+	return &Breaker{threshold: threshold, openFor: openFor, now: time.Now}
+}
+
+// OnChange makes fn be called on every change of state. It is called with
+// the lock held, so fn must not call the breaker.
+func (b *Breaker) OnChange(fn func(from, to State)) {
+	b.mu.Lock()
+	defer b.mu.Unlock()
+	b.onChange = fn
+}
+
+// Allow reports whether a call may go ahead. Every allowed call must be
+// followed by Success, Failure or Release.
+func (b *Breaker) Allow() bool {
+	b.mu.Lock()
+	defer b.mu.Unlock()
+	switch b.current() {
+	case Closed:
+		return true
+	case HalfOpen:
+		if b.probing {
+			return false
+		}
+		b.set(HalfOpen)
+		b.probing = true
+		return true
+	}
+	return false
+}
+
+// Success records that an allowed call reached the dependency.
+func (b *Breaker) Success() {
+	b.mu.Lock()
+	defer b.mu.Unlock()
+	b.failures = 0
+	b.probing = false
+	b.set(Closed)
+}
+
+// Failure records that an allowed call couldn't reach the dependency.
+func (b *Breaker) Failure() {
+	b.mu.Lock()
+	defer b.mu.Unlock()
+	b.failures++
+	wasProbing := b.probing
+	b.probing = false
+	if b.threshold <= 0 || b.current() == Open {
+		return
+	}
+	if wasProbing || b.failures >= b.threshold {
+		b.openedAt = b.now()
+		b.set(Open)
+	}
+}
+
+// Release records that an allowed call ended without telling either way,
+// such as one the caller gave up on.
+func (b *Breaker) Release() {
+	b.mu.Lock()
+	defer b.mu.Unlock()
+	b.probing = false
+}
+
+// State returns the current state.
+func (b *Breaker) State() State {
+	b.mu.Lock()
+	defer b.mu.Unlock()
+	return b.current()
+}
+
+// RetryAfter is how long until an open breaker lets a call through again, 0 otherwise.
+func (b *Breaker) RetryAfter() time.Duration {
+	b.mu.Lock()
+	defer b.mu.Unlock()
+	if b.state != Open {
+		return 0
+	}
+	return max(b.openedAt.Add(b.openFor).Sub(b.now()), 0)
+}
+
+// current is the state, an open breaker turning half-open once openFor has passed.
+func (b *Breaker) current() State {
+	if b.state == Open && !b.now().Before(b.openedAt.Add(b.openFor)) {
+		return HalfOpen
+	}
+	return b.state
+}
+
+func (b *Breaker) set(s State) {
+	if b.state == s {
+		return
+	}
+	from := b.state
+	b.state = s
+	if b.onChange != nil {
+		b.onChange(from, s)
+	}
+}
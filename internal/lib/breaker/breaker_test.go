@@ -0,0 +1,56 @@
+package breaker
+
+import (
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+)
+
+func TestBreaker(t *testing.T) {
+	now := time.Date(2025, 6, 1, 12, 0, 0, 0, time.UTC)
+	b := New(3, 10*time.Second)
+	b.now = func() time.Time { return now }
+	var changes []string
+	b.OnChange(func(from, to State) { changes = append(changes, from.String()+">"+to.String()) })
+
+	for range 2 {
+		assert.True(t, b.Allow())
+		b.Failure()
+	}
+	assert.True(t, b.Allow())
+	b.Success()
+	assert.Equal(t, Closed, b.State(), "a success resets the count")
+
+	for range 3 {
+		assert.True(t, b.Allow())
+		b.Failure()
+	}
+	assert.Equal(t, Open, b.State())
+	assert.False(t, b.Allow())
+	assert.Equal(t, 10*time.Second, b.RetryAfter())
+
+	now = now.Add(10 * time.Second)
+	assert.Equal(t, HalfOpen, b.State())
+	assert.True(t, b.Allow())
+	assert.False(t, b.Allow(), "one call at a time finds out")
+	b.Failure()
+	assert.Equal(t, Open, b.State(), "a failed probe opens it again")
+	assert.Equal(t, 10*time.Second, b.RetryAfter())
+
+	now = now.Add(10 * time.Second)
+	assert.True(t, b.Allow())
+	b.Release()
+	assert.True(t, b.Allow(), "a call given up on doesn't decide")
+	b.Success()
+	assert.Equal(t, Closed, b.State())
+	assert.Zero(t, b.RetryAfter())
+
+	assert.Equal(t, []string{"closed>open", "open>half-open", "half-open>open", "open>half-open", "half-open>closed"}, changes)
+
+	never := New(0, time.Second)
+	for range 10 {
+		never.Failure()
+	}
+	assert.True(t, never.Allow(), "a threshold of 0 never opens it")
+}
@@ -0,0 +1,100 @@
+package domains
+
+import (
+	"context"
+	"fmt"
+	"github.com/kxddry/url-shortener/internal/lib/logger/sl"
+	"github.com/kxddry/url-shortener/internal/storage"
+	"log/slog"
+	"net"
+	"net/http"
+	"strings"
+	"sync/atomic"
+	"time"
+)
+
+type Lister interface {
+	Domains(ctx context.Context) ([]storage.Domain, error)
+}
+
+// Registry is an in-memory copy of the domains table, so that resolving
+// the Host of every redirect doesn't cost a database round-trip.
+type Registry struct {
+	store  Lister
+	log    *slog.Logger
+	byHost atomic.Pointer[map[string]storage.Domain]
+}
+
+func New(ctx context.Context, log *slog.Logger, store Lister) (*Registry, error) {
+	r := &Registry{
+		store: store,
+		log:   log.With(slog.String("component", "domains")),
+	}
+	return r, r.Refresh(ctx)
+}
+
+// Refresh reloads the domains from the store.
+func (r *Registry) Refresh(ctx context.Context) error {
+	const op = "lib.domains.Refresh"
+	list, err := r.store.Domains(ctx)
+	if err != nil {
+		return fmt.Errorf("%s: %w", op, err)
+	}
+	m := make(map[string]storage.Domain, len(list))
+	for _, d := range list {
+		m[d.Host] = d
+	}
+	r.byHost.Store(&m)
+	return nil
+}
+
+// Run refreshes the registry every interval, picking up changes made on other instances.
+func (r *Registry) Run(ctx context.Context, interval time.Duration) {
+	t := time.NewTicker(interval)
+	defer t.Stop()
+	for {
+		select {
+		case <-ctx.Done():
+			return
+		case <-t.C:
+			if err := r.Refresh(ctx); err != nil {
+				r.log.Error("failed to refresh domains", sl.Err(err))
+			}
+		}
+	}
+}
+
+// Lookup returns the domain registered for host. Unknown hosts
+// resolve to the default namespace with ok set to false.
+func (r *Registry) Lookup(host string) (d storage.Domain, ok bool) {
+	m := r.byHost.Load()
+	if m == nil {
+		return storage.Domain{}, false
+	}
+	d, ok = (*m)[Host(host)]
+	return d, ok
+}
+
+// Resolve returns the domain a management request refers to: the ?domain= query
+// parameter if present, the request's Host otherwise. Unlike Lookup it fails
+// with storage.ErrDomainNotFound if an explicitly requested domain is unknown.
+func (r *Registry) Resolve(req *http.Request) (storage.Domain, error) {
+	if host := req.URL.Query().Get("domain"); host != "" {
+		d, ok := r.Lookup(host)
+		if !ok {
+			return storage.Domain{}, storage.ErrDomainNotFound
+		}
+		return d, nil
+	}
+	d, _ := r.Lookup(req.Host)
+	return d, nil
+}
+
+// Host normalizes a Host header or a user-supplied domain: no port, lower case, no trailing dot.
+func Host(hostport string) string {
+	host := hostport
+	if h, _, err := net.SplitHostPort(hostport); err == nil {
+		host = h
+	}
+	return strings.TrimSuffix(strings.ToLower(host), ".")
+}
@@ -0,0 +1,94 @@
+package forward
+
+import (
+	"fmt"
+	"net/url"
+	"path"
+	"strings"
+)
+
+// Policies for query parameters present both in the target and in the incoming request.
+const (
+	KeepTarget  = "target"  // the target's value wins
+	KeepRequest = "request" // the visitor's value wins
+	KeepBoth    = "both"    // both values are kept, target's first
+)
+
+func ValidPolicy(p string) bool {
+	return p == KeepTarget || p == KeepRequest || p == KeepBoth
+}
+
+// Query merges query into the query string of target according to policy.
+func Query(target *url.URL, query url.Values, policy string) {
+	if len(query) == 0 {
+		return
+	}
+	merged := target.Query()
+	for k, vs := range query {
+		_, exists := merged[k]
+		switch {
+		case !exists:
+			merged[k] = vs
+		case policy == KeepRequest:
+			merged[k] = vs
+		case policy == KeepBoth:
+			merged[k] = append(merged[k], vs...)
+		}
+	}
+	target.RawQuery = merged.Encode()
+}
+
+// Path appends the escaped path suffix to the path of target. Dot segments
+// in the suffix, escaped or not, are resolved first, so that it can't climb
+// above the target's path.
+func Path(target *url.URL, escapedSuffix string) error {
+	const op = "lib.forward.Path"
+	if escapedSuffix == "" {
+		return nil
+	}
+	suffix := path.Clean("/" + unescapeDots(escapedSuffix))
+	if suffix == "/" {
+		return nil
+	}
+	if strings.HasSuffix(escapedSuffix, "/") {
+		suffix += "/"
+	}
+
+	escaped := strings.TrimSuffix(target.EscapedPath(), "/") + suffix
+	unescaped, err := url.PathUnescape(escaped)
+	if err != nil {
+		return fmt.Errorf("%s: %w", op, err)
+	}
+	target.Path = unescaped
+	target.RawPath = escaped
+	return nil
+}
+
+// unescapeDots unescapes the segments that are dot segments once unescaped,
+// like %2e%2e, which browsers and servers treat as "..".
+func unescapeDots(escaped string) string {
+	segments := strings.Split(escaped, "/")
+	for i, seg := range segments {
+		if !strings.Contains(seg, "%") {
+			continue
+		}
+		if u, err := url.PathUnescape(seg); err == nil && (u == "." || u == "..") {
+			segments[i] = u
+		}
+	}
+	return strings.Join(segments, "/")
+}
+
+// Target builds the final destination for a forwarding link.
+func Target(rawTarget, escapedSuffix string, query url.Values, policy string) (string, error) {
+	const op = "lib.forward.Target"
+	u, err := url.Parse(rawTarget)
+	if err != nil {
+		return "", fmt.Errorf("%s: %w", op, err)
+	}
+	if err = Path(u, escapedSuffix); err != nil {
+		return "", fmt.Errorf("%s: %w", op, err)
+	}
+	Query(u, query, policy)
+	return u.String(), nil
+}
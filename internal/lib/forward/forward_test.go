@@ -0,0 +1,95 @@
+package forward
+
+import (
+	"net/url"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+func TestTarget(t *testing.T) {
+	tests := []struct {
+		name   string
+		target string
+		suffix string
+		query  string
+		policy string
+		want   string
+	}{
+		{
+			name:   "nothing to forward",
+			target: "https://example.com/docs?a=1",
+			want:   "https://example.com/docs?a=1",
+		},
+		{
+			name:   "path is appended",
+			target: "https://example.com/docs/",
+			suffix: "guide/intro",
+			want:   "https://example.com/docs/guide/intro",
+		},
+		{
+			name:   "escaping is preserved",
+			target: "https://example.com/docs",
+			suffix: "a%20b/c%2Fd",
+			want:   "https://example.com/docs/a%20b/c%2Fd",
+		},
+		{
+			name:   "dot segments can't escape the target path",
+			target: "https://example.com/docs",
+			suffix: "../../admin",
+			want:   "https://example.com/docs/admin",
+		},
+		{
+			name:   "escaped dot segments can't escape the target path",
+			target: "https://example.com/docs",
+			suffix: "x/%2e%2e/%2E%2e/.%2E/%2e/admin",
+			want:   "https://example.com/docs/admin",
+		},
+		{
+			name:   "trailing slash is kept",
+			target: "https://example.com",
+			suffix: "blog/",
+			want:   "https://example.com/blog/",
+		},
+		{
+			name:   "target wins",
+			target: "https://example.com/?utm_source=mail&a=1",
+			query:  "utm_source=x&b=2",
+			policy: KeepTarget,
+			want:   "https://example.com/?a=1&b=2&utm_source=mail",
+		},
+		{
+			name:   "request wins",
+			target: "https://example.com/?utm_source=mail",
+			query:  "utm_source=x",
+			policy: KeepRequest,
+			want:   "https://example.com/?utm_source=x",
+		},
+		{
+			name:   "both are kept",
+			target: "https://example.com/?tag=a",
+			query:  "tag=b",
+			policy: KeepBoth,
+			want:   "https://example.com/?tag=a&tag=b",
+		},
+		{
+			name:   "fragment survives",
+			target: "https://example.com/page#top",
+			suffix: "sub",
+			query:  "x=1",
+			policy: KeepTarget,
+			want:   "https://example.com/page/sub?x=1#top",
+		},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			query, err := url.ParseQuery(tt.query)
+			require.NoError(t, err)
+
+			got, err := Target(tt.target, tt.suffix, query, tt.policy)
+			require.NoError(t, err)
+			assert.Equal(t, tt.want, got)
+		})
+	}
+}
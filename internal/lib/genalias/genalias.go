@@ -4,21 +4,23 @@ import (
 	"context"
 	"fmt"
 	"github.com/kxddry/url-shortener/internal/lib/random"
+	"github.com/kxddry/url-shortener/internal/storage"
 	"time"
 )
 
-type URLGetter interface {
-	GetURL(alias string) (string, error)
+type LinkGetter interface {
+	GetLink(ctx context.Context, domain, alias string) (storage.Link, error)
 }
 
-func GenerateAlias(length int, urlGetter URLGetter) (string, error) {
+// GenerateAlias returns a random alias that is not yet taken in domain.
+func GenerateAlias(ctx context.Context, length int, domain string, linkGetter LinkGetter) (string, error) {
 	const op = "lib.genalias.GenerateAlias"
 	alias := random.NewRandomString(length)
-	ctx, cancel := context.WithTimeout(context.Background(), 5*time.Second)
+	ctx, cancel := context.WithTimeout(ctx, 5*time.Second)
 	defer cancel()
-	for _, err := urlGetter.GetURL(alias); err == nil; _, err = urlGetter.GetURL(alias) {
-		if ctx.Err() != nil {
-			return "", fmt.Errorf("%s: %s", op, "couldn't generate alias: time ran out")
+	for _, err := linkGetter.GetLink(ctx, domain, alias); err == nil; _, err = linkGetter.GetLink(ctx, domain, alias) {
+		if err := ctx.Err(); err != nil {
+			return "", fmt.Errorf("%s: couldn't generate alias: %w", op, err)
 		}
 		alias = random.NewRandomString(length)
 	}
@@ -4,6 +4,7 @@ import (
 	"errors"
 	"fmt"
 	"github.com/golang-jwt/jwt/v5"
+	"github.com/kxddry/url-shortener/internal/lib/session"
 	"net/http"
 	"strings"
 )
@@ -73,3 +74,25 @@ func UIDfromHeader(r *http.Request, appSecret string) (int64, error) {
 
 	return id, nil
 }
+
+// UIDfromRequest authenticates r by its Authorization header or, if there is
+// none, by the session cookie set when logging in through the web UI.
+func UIDfromRequest(r *http.Request, appSecret string) (int64, error) {
+	const op = "lib.jwt.UIDfromRequest"
+
+	if r.Header.Get("Authorization") != "" {
+		return UIDfromHeader(r, appSecret)
+	}
+
+	token := session.Token(r)
+	if token == "" {
+		return 0, fmt.Errorf("%s: %w", op, ErrNoHeader)
+	}
+
+	id, err := UID(token, appSecret)
+	if err != nil {
+		return 0, fmt.Errorf("%s: %w", op, ErrInvalidToken)
+	}
+
+	return id, nil
+}
@@ -0,0 +1,250 @@
+package linkcheck
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"github.com/kxddry/url-shortener/internal/config"
+	"github.com/kxddry/url-shortener/internal/lib/logger/sl"
+	"github.com/kxddry/url-shortener/internal/lib/webhooks"
+	"github.com/kxddry/url-shortener/internal/storage"
+	"io"
+	"log/slog"
+	"net/http"
+	"net/url"
+	"strconv"
+	"sync"
+	"time"
+)
+
+// maxBody is how much of a GET response is read before hanging up.
+const maxBody = 4 << 10
+
+// maxRedirects is how many redirects a probe follows.
+const maxRedirects = 10
+
+// newClient returns the client destinations are probed with. Destinations are
+// whatever users save, so like webhooks it only connects to public addresses,
+// on every redirect as well, and doesn't use a proxy: otherwise saving a link
+// would tell what answers on internal networks.
+func newClient(timeout time.Duration) *http.Client {
+	return &http.Client{
+		Timeout: timeout,
+		Transport: &http.Transport{
+			DialContext:         webhooks.PublicDialer(timeout).DialContext,
+			ForceAttemptHTTP2:   true,
+			TLSHandshakeTimeout: timeout,
+			IdleConnTimeout:     90 * time.Second,
+		},
+		CheckRedirect: func(req *http.Request, via []*http.Request) error {
+			if len(via) >= maxRedirects {
+				return fmt.Errorf("stopped after %d redirects", maxRedirects)
+			}
+			if !webhooks.PublicHost(req.URL.Hostname()) {
+				return webhooks.ErrForbiddenAddress
+			}
+			return nil
+		},
+	}
+}
+
+type Store interface {
+	ClaimChecks(ctx context.Context, limit int, lease time.Duration) ([]storage.LinkHealth, error)
+	RecordCheck(ctx context.Context, h storage.LinkHealth) error
+}
+
+// Monitor periodically checks that the destinations of links still work.
+type Monitor struct {
+	log    *slog.Logger
+	store  Store
+	cfg    config.LinkCheck
+	client *http.Client
+	now    func() time.Time
+}
+
+func New(log *slog.Logger, store Store, cfg config.LinkCheck) *Monitor {
+	return &Monitor{
+		log:    log.With(slog.String("component", "linkcheck")),
+		store:  store,
+		cfg:    cfg,
+		client: newClient(cfg.Timeout),
+		now:    time.Now,
+	}
+}
+
+// Run checks the links that are due every poll interval until ctx is done.
+func (m *Monitor) Run(ctx context.Context) {
+	t := time.NewTicker(m.cfg.PollInterval)
+	defer t.Stop()
+	for {
+		select {
+		case <-ctx.Done():
+			return
+		case <-t.C:
+			m.checkDue(ctx)
+		}
+	}
+}
+
+func (m *Monitor) checkDue(ctx context.Context) {
+	// the lease outlives the batch even if every link waits for the same host
+	lease := (m.cfg.Timeout*2+m.cfg.HostDelay)*time.Duration(m.cfg.BatchSize/m.cfg.Workers+1) + time.Minute
+	due, err := m.store.ClaimChecks(ctx, m.cfg.BatchSize, lease)
+	if err != nil {
+		m.log.Error("failed to claim links to check", sl.Err(err))
+		return
+	}
+
+	hosts := newHosts(m.cfg.PerHost, m.cfg.HostDelay)
+	jobs := make(chan storage.LinkHealth)
+	var wg sync.WaitGroup
+	for range m.cfg.Workers {
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+			for h := range jobs {
+				release, err := hosts.acquire(ctx, host(h.URL))
+				if err != nil {
+					continue // shutting down, the lease runs out and another check picks the link up
+				}
+				h = m.check(ctx, h)
+				release()
+				if err := m.store.RecordCheck(context.WithoutCancel(ctx), h); err != nil {
+					m.log.Error("failed to record check", slog.Int64("link_id", h.LinkID), sl.Err(err))
+				}
+			}
+		}()
+	}
+	for _, h := range due {
+		jobs <- h
+	}
+	close(jobs)
+	wg.Wait()
+}
+
+// check probes the destination once and returns h updated with the outcome.
+// Destinations that aren't public are left unchecked.
+func (m *Monitor) check(ctx context.Context, h storage.LinkHealth) storage.LinkHealth {
+	status, latency, err := m.probe(ctx, http.MethodHead, h.URL)
+	// plenty of servers don't implement HEAD, or not like GET
+	if (err != nil || status >= 400) && !errors.Is(err, webhooks.ErrForbiddenAddress) {
+		status, latency, err = m.probe(ctx, http.MethodGet, h.URL)
+	}
+	now := m.now()
+
+	if errors.Is(err, webhooks.ErrForbiddenAddress) {
+		m.log.Info("destination is not public, not checking it", slog.Int64("link_id", h.LinkID), slog.String("url", h.URL))
+		return storage.LinkHealth{LinkID: h.LinkID, URL: h.URL, NextCheckAt: now.Add(m.cfg.Interval)}
+	}
+
+	h.StatusCode, h.Error = status, ""
+	h.LatencyMS = latency.Milliseconds()
+	h.CheckedAt = &now
+	h.NextCheckAt = now.Add(m.cfg.Interval)
+	switch {
+	case err != nil:
+		h.Error = err.Error()
+	case status >= 400:
+		h.Error = "unexpected status " + strconv.Itoa(status)
+	}
+
+	if h.Error == "" {
+		h.Failures, h.Broken = 0, false
+		return h
+	}
+	h.Failures++
+	if h.Failures >= m.cfg.FailureThreshold && !h.Broken {
+		h.Broken = true
+		m.log.Info("destination is broken", slog.Int64("link_id", h.LinkID), slog.String("url", h.URL),
+			slog.Int("failures", h.Failures), slog.String("error", h.Error))
+	}
+	return h
+}
+
+// probe returns the status of the destination, following public redirects,
+// and how long it took to get the response headers.
+func (m *Monitor) probe(ctx context.Context, method, target string) (int, time.Duration, error) {
+	req, err := http.NewRequestWithContext(ctx, method, target, nil)
+	if err != nil {
+		return 0, 0, err
+	}
+	req.Header.Set("User-Agent", m.cfg.UserAgent)
+
+	start := m.now()
+	resp, err := m.client.Do(req)
+	latency := m.now().Sub(start)
+	if err != nil {
+		var uerr *url.Error
+		if errors.As(err, &uerr) {
+			err = uerr.Err // the URL is known, don't repeat it
+		}
+		return 0, latency, err
+	}
+	defer resp.Body.Close()
+	_, _ = io.Copy(io.Discard, io.LimitReader(resp.Body, maxBody))
+	return resp.StatusCode, latency, nil
+}
+
+// host is what politeness limits apply to.
+func host(rawURL string) string {
+	u, err := url.Parse(rawURL)
+	if err != nil {
+		return rawURL
+	}
+	return u.Hostname()
+}
+
+// hosts limits the requests to every host to perHost at once, at least delay apart.
+type hosts struct {
+	mu      sync.Mutex
+	perHost int
+	delay   time.Duration
+	byName  map[string]*hostLimit
+}
+
+type hostLimit struct {
+	slots chan struct{}
+	next  time.Time
+}
+
+func newHosts(perHost int, delay time.Duration) *hosts {
+	return &hosts{perHost: perHost, delay: delay, byName: map[string]*hostLimit{}}
+}
+
+// acquire waits until a request to name may be made. The returned func must be
+// called once the request is done.
+func (h *hosts) acquire(ctx context.Context, name string) (func(), error) {
+	h.mu.Lock()
+	l, ok := h.byName[name]
+	if !ok {
+		l = &hostLimit{slots: make(chan struct{}, h.perHost)}
+		h.byName[name] = l
+	}
+	h.mu.Unlock()
+
+	select {
+	case l.slots <- struct{}{}:
+	case <-ctx.Done():
+		return nil, ctx.Err()
+	}
+	release := func() { <-l.slots }
+
+	h.mu.Lock()
+	now := time.Now()
+	at := l.next
+	if at.Before(now) {
+		at = now
+	}
+	l.next = at.Add(h.delay)
+	h.mu.Unlock()
+
+	t := time.NewTimer(at.Sub(now))
+	defer t.Stop()
+	select {
+	case <-t.C:
+		return release, nil
+	case <-ctx.Done():
+		release()
+		return nil, ctx.Err()
+	}
+}
@@ -0,0 +1,161 @@
+package linkcheck
+
+import (
+	"context"
+	"io"
+	"log/slog"
+	"net/http"
+	"net/http/httptest"
+	"sync"
+	"testing"
+	"time"
+
+	"github.com/kxddry/url-shortener/internal/config"
+	"github.com/kxddry/url-shortener/internal/lib/webhooks"
+	"github.com/kxddry/url-shortener/internal/storage"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+func newMonitor(store Store) *Monitor {
+	return New(slog.New(slog.NewTextHandler(io.Discard, nil)), store, config.LinkCheck{
+		Interval: time.Hour, Timeout: time.Second, BatchSize: 10, Workers: 4, PerHost: 1,
+		FailureThreshold: 2, UserAgent: "test",
+	})
+}
+
+func TestCheck(t *testing.T) {
+	srv := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		assert.Equal(t, "test", r.UserAgent())
+		switch r.URL.Path {
+		case "/ok":
+		case "/no-head":
+			if r.Method == http.MethodHead {
+				w.WriteHeader(http.StatusMethodNotAllowed)
+			}
+		case "/moved":
+			http.Redirect(w, r, "/ok", http.StatusMovedPermanently)
+		default:
+			w.WriteHeader(http.StatusNotFound)
+		}
+	}))
+	defer srv.Close()
+
+	now := time.Date(2025, 1, 1, 0, 0, 0, 0, time.UTC)
+	m := newMonitor(nil)
+	m.now = func() time.Time { return now }
+	// the test server is on loopback, which the monitor's own client refuses
+	m.client = srv.Client()
+
+	for _, path := range []string{"/ok", "/no-head", "/moved"} {
+		h := m.check(context.Background(), storage.LinkHealth{URL: srv.URL + path, Failures: 5, Broken: true})
+		assert.Equal(t, http.StatusOK, h.StatusCode, path)
+		assert.Empty(t, h.Error, path)
+		assert.Zero(t, h.Failures, path)
+		assert.False(t, h.Broken, path)
+		assert.Equal(t, now, *h.CheckedAt, path)
+		assert.Equal(t, now.Add(time.Hour), h.NextCheckAt, path)
+	}
+
+	h := m.check(context.Background(), storage.LinkHealth{URL: srv.URL + "/gone"})
+	assert.Equal(t, http.StatusNotFound, h.StatusCode)
+	assert.Equal(t, "unexpected status 404", h.Error)
+	assert.Equal(t, 1, h.Failures)
+	assert.False(t, h.Broken, "broken only after failure_threshold checks")
+
+	h = m.check(context.Background(), h)
+	assert.Equal(t, 2, h.Failures)
+	assert.True(t, h.Broken)
+
+	srv.Close()
+	h = m.check(context.Background(), storage.LinkHealth{URL: srv.URL + "/ok"})
+	assert.Zero(t, h.StatusCode)
+	assert.NotEmpty(t, h.Error)
+	assert.Equal(t, 1, h.Failures)
+}
+
+func TestCheckNotPublic(t *testing.T) {
+	hit := false
+	srv := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		hit = true
+	}))
+	defer srv.Close()
+
+	m := newMonitor(nil)
+	h := m.check(context.Background(), storage.LinkHealth{LinkID: 1, URL: srv.URL, Failures: 1, Error: "unexpected status 500"})
+	assert.False(t, hit, "nothing is sent to 127.0.0.1")
+	assert.Nil(t, h.CheckedAt, "left unchecked")
+	assert.Zero(t, h.StatusCode)
+	assert.Empty(t, h.Error)
+	assert.Zero(t, h.Failures)
+
+	req := httptest.NewRequest(http.MethodGet, "http://169.254.169.254/latest/meta-data/", nil)
+	assert.ErrorIs(t, m.client.CheckRedirect(req, []*http.Request{req}), webhooks.ErrForbiddenAddress)
+}
+
+type fakeStore struct {
+	mu       sync.Mutex
+	due      []storage.LinkHealth
+	recorded []storage.LinkHealth
+}
+
+func (s *fakeStore) ClaimChecks(_ context.Context, limit int, _ time.Duration) ([]storage.LinkHealth, error) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	due := s.due[:min(limit, len(s.due))]
+	s.due = s.due[len(due):]
+	return due, nil
+}
+
+func (s *fakeStore) RecordCheck(_ context.Context, h storage.LinkHealth) error {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	s.recorded = append(s.recorded, h)
+	return nil
+}
+
+func TestCheckDuePerHost(t *testing.T) {
+	var mu sync.Mutex
+	inFlight, most := 0, 0
+	srv := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		mu.Lock()
+		inFlight++
+		most = max(most, inFlight)
+		mu.Unlock()
+		time.Sleep(10 * time.Millisecond)
+		mu.Lock()
+		inFlight--
+		mu.Unlock()
+	}))
+	defer srv.Close()
+
+	store := &fakeStore{}
+	for i := range 6 {
+		store.due = append(store.due, storage.LinkHealth{LinkID: int64(i), URL: srv.URL})
+	}
+	m := newMonitor(store)
+	m.client = srv.Client()
+	m.checkDue(context.Background())
+
+	require.Len(t, store.recorded, 6)
+	for _, h := range store.recorded {
+		assert.Equal(t, http.StatusOK, h.StatusCode)
+	}
+	assert.Equal(t, 1, most, "per_host limits concurrent requests to a host")
+}
+
+func TestHostsDelay(t *testing.T) {
+	h := newHosts(2, 30*time.Millisecond)
+	start := time.Now()
+	for range 3 {
+		release, err := h.acquire(context.Background(), "example.com")
+		require.NoError(t, err)
+		release()
+	}
+	assert.GreaterOrEqual(t, time.Since(start), 60*time.Millisecond)
+
+	ctx, cancel := context.WithCancel(context.Background())
+	cancel()
+	_, err := h.acquire(ctx, "example.com")
+	assert.ErrorIs(t, err, context.Canceled)
+}
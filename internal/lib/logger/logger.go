@@ -2,6 +2,7 @@ package logger
 
 import (
 	"github.com/kxddry/url-shortener/internal/lib/logger/handlers/slogpretty"
+	"github.com/kxddry/url-shortener/internal/lib/logger/handlers/slogtrace"
 	"log/slog"
 	"os"
 )
@@ -12,31 +13,38 @@ const (
 	envProd  = "prod"
 )
 
-func SetupLogger(env string) *slog.Logger {
+// SetupLogger builds the logger for env. The returned level can be changed at runtime.
+func SetupLogger(env string) (*slog.Logger, *slog.LevelVar) {
 	var log *slog.Logger
+	level := new(slog.LevelVar)
+	level.Set(DefaultLevel(env))
 	switch env {
 	case envLocal:
-		log = setupPrettySlog()
-	case envDev:
-		log = slog.New(slog.NewJSONHandler(os.Stdout, &slog.HandlerOptions{
-			Level: slog.LevelDebug,
-		}))
-	case envProd:
-		log = slog.New(slog.NewJSONHandler(os.Stdout, &slog.HandlerOptions{
-			Level: slog.LevelInfo,
-		}))
+		log = setupPrettySlog(level)
+	case envDev, envProd:
+		log = slog.New(slogtrace.New(slog.NewJSONHandler(os.Stdout, &slog.HandlerOptions{
+			Level: level,
+		})))
 	}
-	return log
+	return log, level
 }
 
-func setupPrettySlog() *slog.Logger {
+// DefaultLevel is the level used for env when the config doesn't override it.
+func DefaultLevel(env string) slog.Level {
+	if env == envProd {
+		return slog.LevelInfo
+	}
+	return slog.LevelDebug
+}
+
+func setupPrettySlog(level slog.Leveler) *slog.Logger {
 	opts := slogpretty.PrettyHandlerOptions{
 		SlogOpts: &slog.HandlerOptions{
-			Level: slog.LevelDebug,
+			Level: level,
 		},
 	}
 
 	handler := opts.NewPrettyHandler(os.Stdout)
 
-	return slog.New(handler)
+	return slog.New(slogtrace.New(handler))
 }
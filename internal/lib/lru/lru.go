@@ -0,0 +1,110 @@
+package lru
+
+import (
+	"container/list"
+	"sync"
+	"time"
+)
+
+type entry[K comparable, V any] struct {
+	key     K
+	value   V
+	expires time.Time
+}
+
+// Cache keeps up to size values for ttl each, dropping the least recently
+// used one to make room. It is safe for concurrent use. A size of 0 keeps nothing.
+type Cache[K comparable, V any] struct {
+	mu        sync.Mutex
+	size      int
+	ttl       time.Duration
+	order     *list.List // front is the most recently used
+	items     map[K]*list.Element
+	evictions int64
+	now       func() time.Time
+}
+
+func New[K comparable, V any](size int, ttl time.Duration) *Cache[K, V] {
+	return &Cache[K, V]{
+		size:  size,
+		ttl:   ttl,
+		order: list.New(),
+		items: make(map[K]*list.Element),
+		now:   time.Now,
+	}
+}
+
+// Get returns the value of key unless it is missing or has expired.
+func (c *Cache[K, V]) Get(key K) (V, bool) {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	var zero V
+	el, ok := c.items[key]
+	if !ok {
+		return zero, false
+	}
+	e := el.Value.(*entry[K, V])
+	if !c.now().Before(e.expires) {
+		c.remove(el)
+		return zero, false
+	}
+	c.order.MoveToFront(el)
+	return e.value, true
+}
+
+// Add stores value under key for the ttl of the cache, or until expires if that is sooner.
+func (c *Cache[K, V]) Add(key K, value V, expires time.Time) {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	if c.size <= 0 {
+		return
+	}
+	if ttl := c.now().Add(c.ttl); expires.IsZero() || ttl.Before(expires) {
+		expires = ttl
+	}
+	if el, ok := c.items[key]; ok {
+		e := el.Value.(*entry[K, V])
+		e.value, e.expires = value, expires
+		c.order.MoveToFront(el)
+		return
+	}
+	for c.order.Len() >= c.size {
+		c.remove(c.order.Back())
+		c.evictions++
+	}
+	c.items[key] = c.order.PushFront(&entry[K, V]{key: key, value: value, expires: expires})
+}
+
+func (c *Cache[K, V]) Remove(key K) {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	if el, ok := c.items[key]; ok {
+		c.remove(el)
+	}
+}
+
+// Purge removes every value.
+func (c *Cache[K, V]) Purge() {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	c.order.Init()
+	clear(c.items)
+}
+
+func (c *Cache[K, V]) Len() int {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	return c.order.Len()
+}
+
+// Evictions counts the values dropped to make room for others.
+func (c *Cache[K, V]) Evictions() int64 {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	return c.evictions
+}
+
+func (c *Cache[K, V]) remove(el *list.Element) {
+	c.order.Remove(el)
+	delete(c.items, el.Value.(*entry[K, V]).key)
+}
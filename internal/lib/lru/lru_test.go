@@ -0,0 +1,44 @@
+package lru
+
+import (
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+)
+
+func TestCache(t *testing.T) {
+	now := time.Date(2025, 6, 1, 12, 0, 0, 0, time.UTC)
+	c := New[string, int](2, time.Minute)
+	c.now = func() time.Time { return now }
+
+	c.Add("a", 1, time.Time{})
+	c.Add("b", 2, time.Time{})
+	_, _ = c.Get("a")
+	c.Add("c", 3, time.Time{})
+	_, ok := c.Get("b")
+	assert.False(t, ok, "the least recently used value makes room")
+	v, ok := c.Get("a")
+	assert.True(t, ok)
+	assert.Equal(t, 1, v)
+	assert.Equal(t, int64(1), c.Evictions())
+
+	c.Add("d", 4, now.Add(time.Second))
+	now = now.Add(time.Second)
+	_, ok = c.Get("d")
+	assert.False(t, ok, "values expire at the earlier of expires and the ttl")
+	now = now.Add(time.Minute)
+	_, ok = c.Get("a")
+	assert.False(t, ok)
+	assert.Zero(t, c.Len())
+
+	c.Add("e", 5, time.Time{})
+	c.Remove("e")
+	_, ok = c.Get("e")
+	assert.False(t, ok)
+
+	off := New[string, int](0, time.Minute)
+	off.Add("a", 1, time.Time{})
+	_, ok = off.Get("a")
+	assert.False(t, ok, "a size of 0 keeps nothing")
+}
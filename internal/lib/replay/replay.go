@@ -0,0 +1,198 @@
+package replay
+
+import (
+	"context"
+	"errors"
+	"github.com/kxddry/url-shortener/internal/config"
+	"github.com/kxddry/url-shortener/internal/lib/breaker"
+	"github.com/kxddry/url-shortener/internal/lib/genalias"
+	"github.com/kxddry/url-shortener/internal/lib/logger/sl"
+	"github.com/kxddry/url-shortener/internal/storage"
+	"log/slog"
+	"os"
+	"strconv"
+	"time"
+)
+
+// reclaimAfter is how long a claimed link may go unacknowledged before
+// another instance creates it, such as when the one that claimed it died.
+const reclaimAfter = time.Minute
+
+// aliasAttempts is how many aliases a link whose generated alias turned out
+// to be taken tries before it is left for later.
+const aliasAttempts = 3
+
+type Queue interface {
+	ClaimCreations(ctx context.Context, consumer string, count int64, minIdle time.Duration) ([]storage.QueuedLink, error)
+	AckCreation(ctx context.Context, q storage.QueuedLink, status storage.QueuedStatus) error
+}
+
+type Store interface {
+	GetLink(ctx context.Context, domain, alias string) (storage.Link, error)
+	SaveLinkWithin(ctx context.Context, link storage.Link, defaults storage.Quota, customAlias bool) (int64, error)
+}
+
+type LinkSaver interface {
+	SaveLink(ctx context.Context, link storage.Link) (int64, error)
+}
+
+type Access interface {
+	Member(ctx context.Context, uid, workspaceID int64, min string) (bool, error)
+}
+
+// Primary tells whether the database is reachable.
+type Primary interface {
+	State() breaker.State
+}
+
+// Replayer creates the links queued while the database was unavailable.
+type Replayer struct {
+	log      *slog.Logger
+	queue    Queue
+	store    Store
+	cache    LinkSaver
+	access   Access
+	primary  Primary
+	quota    storage.Quota
+	cfg      config.Degraded
+	consumer string
+}
+
+func New(log *slog.Logger, queue Queue, store Store, cache LinkSaver, access Access, primary Primary, quota storage.Quota, cfg config.Degraded) *Replayer {
+	host, _ := os.Hostname()
+	return &Replayer{
+		log:      log.With(slog.String("component", "replay")),
+		queue:    queue,
+		store:    store,
+		cache:    cache,
+		access:   access,
+		primary:  primary,
+		quota:    quota,
+		cfg:      cfg,
+		consumer: host + "-" + strconv.Itoa(os.Getpid()),
+	}
+}
+
+// Run creates queued links every replay interval until ctx is done.
+func (p *Replayer) Run(ctx context.Context) {
+	t := time.NewTicker(p.cfg.ReplayInterval)
+	defer t.Stop()
+	for {
+		select {
+		case <-ctx.Done():
+			return
+		case <-t.C:
+			for p.replay(ctx) {
+			}
+		}
+	}
+}
+
+// replay creates a batch of queued links. It reports whether the whole
+// batch was handled and there may be more.
+func (p *Replayer) replay(ctx context.Context) bool {
+	// claimed links would wait for reclaimAfter if the database were still down
+	if p.primary.State() != breaker.Closed {
+		return false
+	}
+	queued, err := p.queue.ClaimCreations(ctx, p.consumer, p.cfg.ReplayBatch, reclaimAfter)
+	if err != nil {
+		p.log.Error("failed to claim queued links", sl.Err(err))
+		return false
+	}
+	for _, q := range queued {
+		if !p.create(ctx, q) {
+			return false
+		}
+	}
+	return int64(len(queued)) == p.cfg.ReplayBatch
+}
+
+// create creates q and removes it from the queue, or drops it if it can't
+// be created anymore. Either way its creator can look up what became of it.
+// It reports false if q is left to retry later.
+func (p *Replayer) create(ctx context.Context, q storage.QueuedLink) bool {
+	l := q.Link
+	log := p.log.With(slog.String("entry", q.ID), slog.String("domain", l.Domain), slog.String("alias", l.Alias),
+		slog.Int64("uid", l.CreatedBy), slog.Time("queued_at", q.QueuedAt))
+
+	if l.WorkspaceID != 0 {
+		ok, err := p.access.Member(ctx, l.CreatedBy, l.WorkspaceID, storage.RoleEditor)
+		if err != nil {
+			log.Error("failed to check membership", sl.Err(err))
+			return false
+		}
+		if !ok {
+			log.Warn("queued link dropped, its creator is no workspace editor", slog.Int64("workspace_id", l.WorkspaceID))
+			return p.ack(ctx, log, q, p.dropped(l, "you are no longer an editor of the workspace"))
+		}
+	}
+
+	id, err := p.store.SaveLinkWithin(ctx, l, p.quota, q.CustomAlias)
+	if errors.Is(err, storage.ErrAliasExists) {
+		// an earlier attempt may have created it and failed to acknowledge it
+		if existing, gErr := p.store.GetLink(ctx, l.Domain, l.Alias); gErr == nil && existing.CreatedBy == l.CreatedBy &&
+			existing.URL == l.URL && !existing.CreatedAt.Before(q.QueuedAt) {
+			id, err = existing.ID, nil
+		}
+	}
+	// generated aliases were picked without the database to check them against
+	for i := 0; i < aliasAttempts && !q.CustomAlias && errors.Is(err, storage.ErrAliasExists); i++ {
+		var alias string
+		if alias, err = genalias.GenerateAlias(ctx, len(q.Link.Alias), l.Domain, p.store); err != nil {
+			break
+		}
+		log.Info("generated alias is taken, trying another", slog.String("new_alias", alias))
+		l.Alias = alias
+		id, err = p.store.SaveLinkWithin(ctx, l, p.quota, false)
+	}
+	switch {
+	case errors.Is(err, storage.ErrAliasExists) && q.CustomAlias:
+		log.Warn("queued link dropped", sl.Err(err))
+		return p.ack(ctx, log, q, p.dropped(l, "the alias was taken in the meantime"))
+	case errors.Is(err, storage.ErrQuotaExceeded):
+		log.Warn("queued link dropped", sl.Err(err))
+		var qe *storage.QuotaError
+		reason := "the quota is used up"
+		if errors.As(err, &qe) {
+			reason = "the quota of " + qe.Limit + " is used up"
+		}
+		return p.ack(ctx, log, q, p.dropped(l, reason))
+	case err != nil:
+		log.Error("failed to create queued link", sl.Err(err))
+		return false
+	}
+	log.Info("queued link created", slog.Int64("id", id), slog.String("created_alias", l.Alias))
+
+	l.ID = id
+	if _, err = p.cache.SaveLink(ctx, l); err != nil {
+		log.Error("failed to save to redis", sl.Err(err))
+	}
+	return p.ack(ctx, log, q, storage.QueuedStatus{
+		Status:    storage.QueuedCreated,
+		Domain:    l.Domain,
+		Alias:     l.Alias,
+		CreatedBy: l.CreatedBy,
+		UpdatedAt: time.Now(),
+	})
+}
+
+func (p *Replayer) dropped(l storage.Link, reason string) storage.QueuedStatus {
+	return storage.QueuedStatus{
+		Status:    storage.QueuedDropped,
+		Domain:    l.Domain,
+		Alias:     l.Alias,
+		Reason:    reason,
+		CreatedBy: l.CreatedBy,
+		UpdatedAt: time.Now(),
+	}
+}
+
+func (p *Replayer) ack(ctx context.Context, log *slog.Logger, q storage.QueuedLink, status storage.QueuedStatus) bool {
+	if err := p.queue.AckCreation(ctx, q, status); err != nil {
+		// the link is tried again after reclaimAfter, and then found to exist
+		log.Error("failed to remove link from the queue", sl.Err(err))
+		return false
+	}
+	return true
+}
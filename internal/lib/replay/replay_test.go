@@ -0,0 +1,141 @@
+package replay
+
+import (
+	"context"
+	"io"
+	"log/slog"
+	"slices"
+	"testing"
+	"time"
+
+	"github.com/kxddry/url-shortener/internal/config"
+	"github.com/kxddry/url-shortener/internal/lib/breaker"
+	"github.com/kxddry/url-shortener/internal/storage"
+	"github.com/stretchr/testify/assert"
+)
+
+// fakeQueue hands out the links that weren't acknowledged yet.
+type fakeQueue struct {
+	queued   []storage.QueuedLink
+	acked    []string
+	statuses map[string]storage.QueuedStatus
+	claims   int
+}
+
+func (q *fakeQueue) ClaimCreations(_ context.Context, _ string, count int64, _ time.Duration) ([]storage.QueuedLink, error) {
+	q.claims++
+	var res []storage.QueuedLink
+	for _, l := range q.queued {
+		if int64(len(res)) < count && !slices.Contains(q.acked, l.ID) {
+			res = append(res, l)
+		}
+	}
+	return res, nil
+}
+
+func (q *fakeQueue) AckCreation(_ context.Context, l storage.QueuedLink, status storage.QueuedStatus) error {
+	q.acked = append(q.acked, l.ID)
+	if q.statuses == nil {
+		q.statuses = map[string]storage.QueuedStatus{}
+	}
+	q.statuses[l.ID] = status
+	return nil
+}
+
+type fakeStore struct {
+	taken   map[string]bool
+	down    bool
+	created []string
+}
+
+func (s *fakeStore) SaveLinkWithin(_ context.Context, l storage.Link, _ storage.Quota, _ bool) (int64, error) {
+	if s.down {
+		return 0, &storage.UnavailableError{RetryAfter: time.Second}
+	}
+	if s.taken[l.Alias] || slices.Contains(s.created, l.Alias) {
+		return 0, storage.ErrAliasExists
+	}
+	s.created = append(s.created, l.Alias)
+	return int64(len(s.created)), nil
+}
+
+// GetLink finds the created links, and those of the taken aliases, which belong to someone else.
+func (s *fakeStore) GetLink(_ context.Context, _, alias string) (storage.Link, error) {
+	if s.taken[alias] {
+		return storage.Link{Alias: alias, CreatedBy: 99}, nil
+	}
+	if i := slices.Index(s.created, alias); i >= 0 {
+		return storage.Link{ID: int64(i + 1), Alias: alias, CreatedBy: 1}, nil
+	}
+	return storage.Link{}, storage.ErrAliasNotFound
+}
+
+type fakeCache struct{ saved []storage.Link }
+
+func (c *fakeCache) SaveLink(_ context.Context, l storage.Link) (int64, error) {
+	c.saved = append(c.saved, l)
+	return l.ID, nil
+}
+
+type fakeAccess struct{}
+
+func (fakeAccess) Member(_ context.Context, uid, _ int64, _ string) (bool, error) {
+	return uid == 1, nil
+}
+
+type state breaker.State
+
+func (s *state) State() breaker.State { return breaker.State(*s) }
+
+func queued(id, alias string, uid, workspaceID int64) storage.QueuedLink {
+	return storage.QueuedLink{ID: id, Link: storage.Link{Alias: alias, CreatedBy: uid, WorkspaceID: workspaceID}, CustomAlias: true}
+}
+
+func TestReplay(t *testing.T) {
+	q := &fakeQueue{queued: []storage.QueuedLink{
+		queued("1-0", "a", 1, 0),
+		queued("2-0", "taken", 1, 0),
+		queued("3-0", "b", 2, 7),
+		queued("4-0", "c", 1, 7),
+		{ID: "5-0", Link: storage.Link{Alias: "taken", CreatedBy: 1}},
+	}}
+	store := &fakeStore{taken: map[string]bool{"taken": true}}
+	cache := &fakeCache{}
+	primary := state(breaker.Open)
+	p := New(slog.New(slog.NewTextHandler(io.Discard, nil)), q, store, cache, fakeAccess{}, &primary, storage.Quota{},
+		config.Degraded{ReplayBatch: 2})
+
+	assert.False(t, p.replay(context.Background()))
+	assert.Zero(t, q.claims, "nothing is claimed while the database is down")
+
+	primary = state(breaker.Closed)
+	store.down = true
+	assert.False(t, p.replay(context.Background()))
+	assert.Empty(t, q.acked, "links that couldn't be created stay queued")
+
+	store.down = false
+	for p.replay(context.Background()) {
+	}
+	if assert.Len(t, store.created, 3) {
+		assert.Equal(t, []string{"a", "c"}, store.created[:2])
+		assert.NotEqual(t, "taken", store.created[2], "taken generated aliases are generated again")
+	}
+	assert.Equal(t, []string{"1-0", "2-0", "3-0", "4-0", "5-0"}, q.acked, "taken custom aliases and links of non-editors are dropped")
+	if assert.Len(t, cache.saved, 3) {
+		assert.Equal(t, int64(2), cache.saved[1].ID)
+	}
+
+	assert.Equal(t, storage.QueuedCreated, q.statuses["1-0"].Status)
+	assert.Equal(t, storage.QueuedDropped, q.statuses["2-0"].Status)
+	assert.Equal(t, "the alias was taken in the meantime", q.statuses["2-0"].Reason)
+	assert.Equal(t, storage.QueuedDropped, q.statuses["3-0"].Status)
+	assert.Equal(t, storage.QueuedCreated, q.statuses["5-0"].Status)
+	assert.Equal(t, store.created[2], q.statuses["5-0"].Alias, "the status has the alias the link got")
+
+	// a link created by an attempt whose acknowledgement failed isn't created twice
+	q.queued = append(q.queued, queued("6-0", "a", 1, 0))
+	for p.replay(context.Background()) {
+	}
+	assert.Len(t, store.created, 3)
+	assert.Equal(t, storage.QueuedCreated, q.statuses["6-0"].Status)
+}
@@ -0,0 +1,233 @@
+package rules
+
+import (
+	"errors"
+	"fmt"
+	"net/http"
+	"net/url"
+	"sort"
+	"strconv"
+	"strings"
+	"time"
+)
+
+const (
+	maxRules   = 50
+	maxTargets = 10
+	// maxWeight keeps the sum of the weights of a rule far from overflowing.
+	maxWeight = 10000
+)
+
+// Devices a rule can match on, detected from the User-Agent.
+const (
+	DeviceIOS     = "ios"
+	DeviceAndroid = "android"
+	DeviceMobile  = "mobile" // any phone or tablet, including ios and android
+	DeviceDesktop = "desktop"
+)
+
+// Set is an ordered list of rules. The first matching rule decides the destination;
+// if none matches, the link's own URL is used.
+type Set []Rule
+
+// Rule matches when every condition that is set matches.
+type Rule struct {
+	Devices   []string `json:"devices,omitempty"`
+	Languages []string `json:"languages,omitempty"` // e.g. "en" matches en-US, "pt-BR" only matches pt-BR
+	// From is inclusive, Until is exclusive.
+	From  *time.Time `json:"from,omitempty"`
+	Until *time.Time `json:"until,omitempty"`
+	// Targets with more than one entry are an A/B split by Weight.
+	Targets []Target `json:"targets"`
+}
+
+type Target struct {
+	URL    string `json:"url"`
+	Weight int    `json:"weight,omitempty"` // 0 counts as 1, at most 10000
+}
+
+// Validate reports every problem with the rule set.
+func (s Set) Validate() error {
+	var errs []error
+	add := func(format string, args ...any) {
+		errs = append(errs, fmt.Errorf(format, args...))
+	}
+
+	if len(s) > maxRules {
+		add("rules: at most %d rules are allowed, got %d", maxRules, len(s))
+	}
+	for i, r := range s {
+		for _, d := range r.Devices {
+			switch d {
+			case DeviceIOS, DeviceAndroid, DeviceMobile, DeviceDesktop:
+			default:
+				add("rules[%d].devices: unknown device %q, want ios, android, mobile or desktop", i, d)
+			}
+		}
+		for _, l := range r.Languages {
+			if l == "" || strings.ContainsAny(l, " ,;") {
+				add("rules[%d].languages: %q is not a language tag", i, l)
+			}
+		}
+		if r.From != nil && r.Until != nil && !r.From.Before(*r.Until) {
+			add("rules[%d]: from must be before until", i)
+		}
+		if len(r.Targets) == 0 {
+			add("rules[%d].targets: at least one target is required", i)
+		}
+		if len(r.Targets) > maxTargets {
+			add("rules[%d].targets: at most %d targets are allowed, got %d", i, maxTargets, len(r.Targets))
+		}
+		for j, t := range r.Targets {
+			if u, err := url.ParseRequestURI(t.URL); err != nil || (u.Scheme != "http" && u.Scheme != "https") || u.Host == "" {
+				add("rules[%d].targets[%d].url: %q is not a valid http(s) URL", i, j, t.URL)
+			}
+			if t.Weight < 0 || t.Weight > maxWeight {
+				add("rules[%d].targets[%d].weight: must be between 0 and %d, got %d", i, j, maxWeight, t.Weight)
+			}
+		}
+	}
+	return errors.Join(errs...)
+}
+
+// URLs returns every target of the set.
+func (s Set) URLs() []string {
+	var res []string
+	for _, r := range s {
+		for _, t := range r.Targets {
+			res = append(res, t.URL)
+		}
+	}
+	return res
+}
+
+// Evaluate returns the target of the first rule matching r.
+// randN must return a number in [0, n) and is used for A/B splits.
+func (s Set) Evaluate(r *http.Request, now time.Time, randN func(n int) int) (string, bool) {
+	if len(s) == 0 {
+		return "", false
+	}
+	device := Device(r.UserAgent())
+	lang := PrimaryLanguage(r.Header.Get("Accept-Language"))
+	for _, rule := range s {
+		if rule.matches(device, lang, now) {
+			return rule.pick(randN), true
+		}
+	}
+	return "", false
+}
+
+func (r Rule) matches(device, lang string, now time.Time) bool {
+	if r.From != nil && now.Before(*r.From) {
+		return false
+	}
+	if r.Until != nil && !now.Before(*r.Until) {
+		return false
+	}
+	if len(r.Devices) > 0 && !matchesDevice(r.Devices, device) {
+		return false
+	}
+	if len(r.Languages) > 0 && !matchesLanguage(r.Languages, lang) {
+		return false
+	}
+	return true
+}
+
+func (r Rule) pick(randN func(n int) int) string {
+	if len(r.Targets) == 1 {
+		return r.Targets[0].URL
+	}
+	total := 0
+	for _, t := range r.Targets {
+		total += weight(t)
+	}
+	if total <= 0 {
+		// weights are validated to [0, maxWeight], so total is positive; randN would panic otherwise
+		return r.Targets[0].URL
+	}
+	n := randN(total)
+	for _, t := range r.Targets {
+		n -= weight(t)
+		if n < 0 {
+			return t.URL
+		}
+	}
+	return r.Targets[len(r.Targets)-1].URL
+}
+
+func weight(t Target) int {
+	if t.Weight == 0 {
+		return 1
+	}
+	return t.Weight
+}
+
+func matchesDevice(devices []string, device string) bool {
+	for _, d := range devices {
+		if d == device || (d == DeviceMobile && device != DeviceDesktop) {
+			return true
+		}
+	}
+	return false
+}
+
+func matchesLanguage(langs []string, lang string) bool {
+	if lang == "" {
+		return false
+	}
+	for _, l := range langs {
+		l = strings.ToLower(l)
+		if lang == l || strings.HasPrefix(lang, l+"-") {
+			return true
+		}
+	}
+	return false
+}
+
+// Device classifies a User-Agent as ios, android, mobile or desktop.
+func Device(userAgent string) string {
+	ua := strings.ToLower(userAgent)
+	switch {
+	case strings.Contains(ua, "iphone"), strings.Contains(ua, "ipad"), strings.Contains(ua, "ipod"):
+		return DeviceIOS
+	case strings.Contains(ua, "android"):
+		return DeviceAndroid
+	case strings.Contains(ua, "mobile"):
+		return DeviceMobile
+	default:
+		return DeviceDesktop
+	}
+}
+
+// PrimaryLanguage returns the lower-cased language the visitor prefers the most
+// according to an Accept-Language header, or "" if there is none.
+func PrimaryLanguage(header string) string {
+	type lang struct {
+		tag string
+		q   float64
+	}
+	var langs []lang
+	for _, part := range strings.Split(header, ",") {
+		tag, params, _ := strings.Cut(strings.TrimSpace(part), ";")
+		tag = strings.ToLower(strings.TrimSpace(tag))
+		if tag == "" || tag == "*" {
+			continue
+		}
+		q := 1.0
+		if v, ok := strings.CutPrefix(strings.TrimSpace(params), "q="); ok {
+			parsed, err := strconv.ParseFloat(v, 64)
+			if err != nil {
+				continue
+			}
+			q = parsed
+		}
+		if q > 0 {
+			langs = append(langs, lang{tag, q})
+		}
+	}
+	if len(langs) == 0 {
+		return ""
+	}
+	sort.SliceStable(langs, func(i, j int) bool { return langs[i].q > langs[j].q })
+	return langs[0].tag
+}
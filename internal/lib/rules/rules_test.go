@@ -0,0 +1,90 @@
+package rules
+
+import (
+	"math"
+	"net/http/httptest"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+)
+
+const (
+	iPhone  = "Mozilla/5.0 (iPhone; CPU iPhone OS 17_0 like Mac OS X) AppleWebKit/605.1.15 Mobile/15E148"
+	android = "Mozilla/5.0 (Linux; Android 14; Pixel 8) AppleWebKit/537.36 Chrome/120.0 Mobile Safari/537.36"
+	desktop = "Mozilla/5.0 (X11; Linux x86_64) AppleWebKit/537.36 Chrome/120.0 Safari/537.36"
+)
+
+func TestEvaluate(t *testing.T) {
+	launch := time.Date(2025, 6, 1, 0, 0, 0, 0, time.UTC)
+	set := Set{
+		{Devices: []string{DeviceIOS}, Targets: []Target{{URL: "https://apps.apple.com/app"}}},
+		{Devices: []string{DeviceAndroid}, Targets: []Target{{URL: "https://play.google.com/app"}}},
+		{Languages: []string{"de"}, Targets: []Target{{URL: "https://example.com/de"}}},
+		{From: &launch, Targets: []Target{{URL: "https://example.com/launch"}}},
+	}
+
+	tests := []struct {
+		name     string
+		ua       string
+		lang     string
+		now      time.Time
+		want     string
+		wantRule bool
+	}{
+		{name: "ios", ua: iPhone, want: "https://apps.apple.com/app", wantRule: true},
+		{name: "android", ua: android, want: "https://play.google.com/app", wantRule: true},
+		{name: "language prefix", ua: desktop, lang: "de-AT,en;q=0.8", want: "https://example.com/de", wantRule: true},
+		{name: "language by preference", ua: desktop, lang: "en;q=0.5,de;q=0.9", want: "https://example.com/de", wantRule: true},
+		{name: "time window", ua: desktop, lang: "en", now: launch, want: "https://example.com/launch", wantRule: true},
+		{name: "no match", ua: desktop, lang: "en", now: launch.Add(-time.Hour)},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			r := httptest.NewRequest("GET", "/alias", nil)
+			r.Header.Set("User-Agent", tt.ua)
+			r.Header.Set("Accept-Language", tt.lang)
+
+			got, ok := set.Evaluate(r, tt.now, func(int) int { return 0 })
+			assert.Equal(t, tt.wantRule, ok)
+			assert.Equal(t, tt.want, got)
+		})
+	}
+}
+
+func TestSplit(t *testing.T) {
+	rule := Rule{Targets: []Target{
+		{URL: "https://example.com/a", Weight: 3},
+		{URL: "https://example.com/b", Weight: 1},
+	}}
+
+	counts := map[string]int{}
+	for n := 0; n < 4; n++ {
+		counts[rule.pick(func(int) int { return n })]++
+	}
+	assert.Equal(t, map[string]int{"https://example.com/a": 3, "https://example.com/b": 1}, counts)
+}
+
+func TestValidate(t *testing.T) {
+	from := time.Date(2025, 6, 1, 0, 0, 0, 0, time.UTC)
+	until := from.Add(-time.Hour)
+
+	err := Set{
+		{Devices: []string{"tv"}, Targets: []Target{{URL: "ftp://example.com"}}},
+		{From: &from, Until: &until},
+		{Targets: []Target{{URL: "https://example.com/a", Weight: math.MaxInt}, {URL: "https://example.com/b", Weight: 1}}},
+		{Targets: []Target{{URL: "https://example.com/a", Weight: -1}}},
+	}.Validate()
+
+	if assert.Error(t, err) {
+		assert.Contains(t, err.Error(), `rules[0].devices: unknown device "tv"`)
+		assert.Contains(t, err.Error(), `rules[0].targets[0].url`)
+		assert.Contains(t, err.Error(), "rules[1]: from must be before until")
+		assert.Contains(t, err.Error(), "rules[1].targets: at least one target is required")
+		assert.Contains(t, err.Error(), "rules[2].targets[0].weight: must be between 0 and 10000")
+		assert.NotContains(t, err.Error(), "rules[2].targets[1]")
+		assert.Contains(t, err.Error(), "rules[3].targets[0].weight")
+	}
+
+	assert.NoError(t, Set{{Targets: []Target{{URL: "https://example.com"}}}}.Validate())
+}
@@ -0,0 +1,44 @@
+package session
+
+import (
+	"net/http"
+	"time"
+)
+
+// Cookie holds the access token of a browser session. Being HttpOnly, it
+// can't be read by scripts; SameSite keeps it off cross-site subrequests.
+const Cookie = "session"
+
+// Set starts a session that lasts as long as the token is valid.
+func Set(w http.ResponseWriter, token string, ttl time.Duration, secure bool) {
+	http.SetCookie(w, &http.Cookie{
+		Name:     Cookie,
+		Value:    token,
+		Path:     "/",
+		MaxAge:   int(ttl.Seconds()),
+		Secure:   secure,
+		HttpOnly: true,
+		SameSite: http.SameSiteLaxMode,
+	})
+}
+
+// Clear ends the session.
+func Clear(w http.ResponseWriter, secure bool) {
+	http.SetCookie(w, &http.Cookie{
+		Name:     Cookie,
+		Path:     "/",
+		MaxAge:   -1,
+		Secure:   secure,
+		HttpOnly: true,
+		SameSite: http.SameSiteLaxMode,
+	})
+}
+
+// Token returns the access token of the session r belongs to, if any.
+func Token(r *http.Request) string {
+	c, err := r.Cookie(Cookie)
+	if err != nil {
+		return ""
+	}
+	return c.Value
+}
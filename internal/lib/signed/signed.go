@@ -0,0 +1,156 @@
+package signed
+
+import (
+	"crypto/hmac"
+	"crypto/rand"
+	"crypto/sha256"
+	"encoding/base64"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"github.com/kxddry/url-shortener/internal/config"
+	"strings"
+	"sync"
+	"time"
+)
+
+var (
+	// ErrInvalidLink covers malformed and tampered tokens, and tokens signed
+	// with a key that has been removed.
+	ErrInvalidLink = errors.New("invalid signed link")
+	ErrExpired     = errors.New("signed link has expired")
+	ErrNoKeys      = errors.New("no signing keys")
+)
+
+var encoding = base64.RawURLEncoding
+
+// separator joins the payload and the signature. It is neither in the
+// base64url alphabet nor a '.', which routing takes for a format extension.
+const separator = "~"
+
+// Claims are what a token says.
+type Claims struct {
+	// ID tells tokens apart, even those of the same destination and expiry.
+	ID        string
+	URL       string
+	ExpiresAt time.Time
+	CreatedBy int64
+	// KeyID is the key the token was signed with.
+	KeyID string
+}
+
+// payload is the JSON form of Claims, kept short since it ends up in the URL.
+type payload struct {
+	ID        string `json:"i"`
+	KeyID     string `json:"k"`
+	URL       string `json:"u"`
+	ExpiresAt int64  `json:"e"`
+	CreatedBy int64  `json:"c,omitempty"`
+}
+
+// Signer mints and verifies signed links: tokens that carry their
+// destination and expiry, so that nothing has to be stored for them.
+// A token is its base64url payload and the base64url HMAC-SHA256 of that,
+// joined by a '~'.
+type Signer struct {
+	mu   sync.RWMutex
+	keys []config.SigningKey
+}
+
+func New(keys []config.SigningKey) *Signer {
+	s := &Signer{}
+	s.SetKeys(keys)
+	return s
+}
+
+// SetKeys replaces the keys. The first one signs new tokens, all of them
+// verify tokens, so keys are rotated by putting a new one first and removing
+// the old one once the tokens it signed have expired.
+func (s *Signer) SetKeys(keys []config.SigningKey) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	s.keys = keys
+}
+
+// Sign returns a token for c with c.ID and c.KeyID filled in.
+func (s *Signer) Sign(c Claims) (string, error) {
+	const op = "lib.signed.Sign"
+	s.mu.RLock()
+	defer s.mu.RUnlock()
+	if len(s.keys) == 0 {
+		return "", fmt.Errorf("%s: %w", op, ErrNoKeys)
+	}
+	key := s.keys[0]
+
+	id := make([]byte, 12)
+	if _, err := rand.Read(id); err != nil {
+		return "", fmt.Errorf("%s: %w", op, err)
+	}
+	b, err := json.Marshal(payload{
+		ID:        encoding.EncodeToString(id),
+		KeyID:     key.ID,
+		URL:       c.URL,
+		ExpiresAt: c.ExpiresAt.Unix(),
+		CreatedBy: c.CreatedBy,
+	})
+	if err != nil {
+		return "", fmt.Errorf("%s: %w", op, err)
+	}
+	p := encoding.EncodeToString(b)
+	return p + separator + encoding.EncodeToString(mac(key.Secret, p)), nil
+}
+
+// Verify returns the claims of token if one of the keys signed it. Tokens
+// past their expiry at now get ErrExpired along with their claims.
+func (s *Signer) Verify(token string, now time.Time) (Claims, error) {
+	const op = "lib.signed.Verify"
+	p, sig, ok := strings.Cut(token, separator)
+	if !ok {
+		return Claims{}, fmt.Errorf("%s: %w", op, ErrInvalidLink)
+	}
+	b, err := encoding.DecodeString(p)
+	if err != nil {
+		return Claims{}, fmt.Errorf("%s: %w", op, ErrInvalidLink)
+	}
+	want, err := encoding.DecodeString(sig)
+	if err != nil {
+		return Claims{}, fmt.Errorf("%s: %w", op, ErrInvalidLink)
+	}
+	var pl payload
+	if err = json.Unmarshal(b, &pl); err != nil {
+		return Claims{}, fmt.Errorf("%s: %w", op, ErrInvalidLink)
+	}
+
+	secret, ok := s.secret(pl.KeyID)
+	if !ok || !hmac.Equal(mac(secret, p), want) {
+		return Claims{}, fmt.Errorf("%s: %w", op, ErrInvalidLink)
+	}
+	c := Claims{
+		ID:        pl.ID,
+		URL:       pl.URL,
+		ExpiresAt: time.Unix(pl.ExpiresAt, 0),
+		CreatedBy: pl.CreatedBy,
+		KeyID:     pl.KeyID,
+	}
+	if !now.Before(c.ExpiresAt) {
+		return c, fmt.Errorf("%s: %w", op, ErrExpired)
+	}
+	return c, nil
+}
+
+func (s *Signer) secret(keyID string) (string, bool) {
+	s.mu.RLock()
+	defer s.mu.RUnlock()
+	for _, k := range s.keys {
+		if k.ID == keyID {
+			return k.Secret, true
+		}
+	}
+	return "", false
+}
+
+func mac(secret, payload string) []byte {
+	h := hmac.New(sha256.New, []byte(secret))
+	h.Write([]byte(payload))
+	return h.Sum(nil)
+}
@@ -0,0 +1,74 @@
+package signed
+
+import (
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/kxddry/url-shortener/internal/config"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+var (
+	oldKey = config.SigningKey{ID: "2025-05", Secret: "old-secret-old-secret-old-secret"}
+	newKey = config.SigningKey{ID: "2025-06", Secret: "new-secret-new-secret-new-secret"}
+)
+
+func TestSigner(t *testing.T) {
+	now := time.Date(2025, 6, 1, 12, 0, 0, 0, time.UTC)
+	s := New([]config.SigningKey{oldKey})
+	token, err := s.Sign(Claims{URL: "https://example.com/?a=1", ExpiresAt: now.Add(time.Hour), CreatedBy: 42})
+	require.NoError(t, err)
+
+	c, err := s.Verify(token, now)
+	require.NoError(t, err)
+	assert.Equal(t, "https://example.com/?a=1", c.URL)
+	assert.Equal(t, int64(42), c.CreatedBy)
+	assert.Equal(t, oldKey.ID, c.KeyID)
+	assert.True(t, c.ExpiresAt.Equal(now.Add(time.Hour)))
+
+	other, err := s.Sign(Claims{URL: "https://example.com/?a=1", ExpiresAt: now.Add(time.Hour), CreatedBy: 42})
+	require.NoError(t, err)
+	assert.NotEqual(t, token, other, "every token has its own id")
+
+	_, err = s.Verify(token, now.Add(time.Hour))
+	assert.ErrorIs(t, err, ErrExpired)
+
+	// rotation: new tokens are signed with the new key, old ones still verify
+	s.SetKeys([]config.SigningKey{newKey, oldKey})
+	rotated, err := s.Sign(Claims{URL: "https://example.com", ExpiresAt: now.Add(time.Hour)})
+	require.NoError(t, err)
+	c, err = s.Verify(rotated, now)
+	require.NoError(t, err)
+	assert.Equal(t, newKey.ID, c.KeyID)
+	_, err = s.Verify(token, now)
+	assert.NoError(t, err)
+
+	s.SetKeys([]config.SigningKey{newKey})
+	_, err = s.Verify(token, now)
+	assert.ErrorIs(t, err, ErrInvalidLink, "tokens of removed keys are rejected")
+}
+
+func TestVerifyTampered(t *testing.T) {
+	now := time.Date(2025, 6, 1, 12, 0, 0, 0, time.UTC)
+	s := New([]config.SigningKey{newKey})
+	token, err := s.Sign(Claims{URL: "https://example.com", ExpiresAt: now.Add(time.Hour)})
+	require.NoError(t, err)
+	p, sig, _ := strings.Cut(token, separator)
+
+	forged := encoding.EncodeToString([]byte(`{"i":"x","k":"2025-06","u":"https://evil.example","e":9999999999}`))
+	for name, tok := range map[string]string{
+		"payload":      forged + separator + sig,
+		"signature":    p + separator + encoding.EncodeToString([]byte("not the signature")),
+		"no separator": p + sig,
+		"garbage":      "%%%~%%%",
+		"empty":        "",
+	} {
+		_, err = s.Verify(tok, now)
+		assert.ErrorIs(t, err, ErrInvalidLink, name)
+	}
+
+	_, err = New(nil).Sign(Claims{URL: "https://example.com", ExpiresAt: now})
+	assert.ErrorIs(t, err, ErrNoKeys)
+}
@@ -0,0 +1,43 @@
+package tags
+
+import (
+	"fmt"
+	"sort"
+	"strings"
+	"unicode"
+)
+
+const (
+	MaxTags   = 20
+	MaxLength = 32
+)
+
+// Normalize lower-cases, trims and deduplicates tags, and checks that each one
+// is a single word of letters, digits, '-', '_' or '.'.
+func Normalize(tags []string) ([]string, error) {
+	seen := make(map[string]bool, len(tags))
+	res := make([]string, 0, len(tags))
+	for _, t := range tags {
+		t = strings.ToLower(strings.TrimSpace(t))
+		if t == "" {
+			return nil, fmt.Errorf("tags: empty tag")
+		}
+		if len(t) > MaxLength {
+			return nil, fmt.Errorf("tags: %q is longer than %d characters", t, MaxLength)
+		}
+		for _, r := range t {
+			if !unicode.IsLetter(r) && !unicode.IsDigit(r) && r != '-' && r != '_' && r != '.' {
+				return nil, fmt.Errorf("tags: %q may only contain letters, digits, '-', '_' and '.'", t)
+			}
+		}
+		if !seen[t] {
+			seen[t] = true
+			res = append(res, t)
+		}
+	}
+	if len(res) > MaxTags {
+		return nil, fmt.Errorf("tags: at most %d tags are allowed, got %d", MaxTags, len(res))
+	}
+	sort.Strings(res)
+	return res, nil
+}
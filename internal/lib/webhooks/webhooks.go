@@ -0,0 +1,298 @@
+package webhooks
+
+import (
+	"bytes"
+	"context"
+	"crypto/hmac"
+	"crypto/rand"
+	"crypto/sha256"
+	"encoding/hex"
+	"errors"
+	"fmt"
+	"github.com/kxddry/url-shortener/internal/config"
+	"github.com/kxddry/url-shortener/internal/lib/logger/sl"
+	"github.com/kxddry/url-shortener/internal/storage"
+	"io"
+	"log/slog"
+	"net"
+	"net/http"
+	"net/netip"
+	"strconv"
+	"strings"
+	"sync"
+	"syscall"
+	"time"
+)
+
+// Headers sent with every delivery.
+const (
+	HeaderEvent     = "X-Webhook-Event"
+	HeaderDelivery  = "X-Webhook-Delivery"
+	HeaderTimestamp = "X-Webhook-Timestamp"
+	// HeaderSignature is "sha256=" followed by the hex HMAC-SHA256 of
+	// "{timestamp}.{body}" keyed with the webhook's secret.
+	HeaderSignature = "X-Webhook-Signature"
+)
+
+// maxResponse is how much of a response body is kept in the delivery log.
+const maxResponse = 1024
+
+// ErrForbiddenAddress is returned for deliveries to an address that isn't
+// public, such as loopback, private networks or cloud metadata services.
+var ErrForbiddenAddress = errors.New("webhook address is not public")
+
+// nonPublic are the ranges netip doesn't classify but that aren't reachable
+// on the internet either, or lead back into it through a translator.
+var nonPublic = []netip.Prefix{
+	netip.MustParsePrefix("0.0.0.0/8"),
+	netip.MustParsePrefix("100.64.0.0/10"),
+	netip.MustParsePrefix("192.0.0.0/24"),
+	netip.MustParsePrefix("198.18.0.0/15"),
+	netip.MustParsePrefix("240.0.0.0/4"),
+	netip.MustParsePrefix("64:ff9b::/96"),
+	netip.MustParsePrefix("64:ff9b:1::/48"),
+	netip.MustParsePrefix("2002::/16"),
+}
+
+// Public reports whether deliveries may be sent to addr.
+func Public(addr netip.Addr) bool {
+	addr = addr.Unmap()
+	if !addr.IsValid() || addr.IsLoopback() || addr.IsPrivate() || addr.IsUnspecified() ||
+		addr.IsLinkLocalUnicast() || addr.IsLinkLocalMulticast() || addr.IsInterfaceLocalMulticast() || addr.IsMulticast() {
+		return false
+	}
+	for _, p := range nonPublic {
+		if p.Contains(addr) {
+			return false
+		}
+	}
+	return true
+}
+
+// PublicHost reports whether host, the host name of a URL, isn't obviously
+// non-public. Names that resolve to non-public addresses are only caught when
+// connecting, see PublicDialer.
+func PublicHost(host string) bool {
+	host = strings.TrimSuffix(strings.ToLower(host), ".")
+	if host == "localhost" || strings.HasSuffix(host, ".localhost") {
+		return false
+	}
+	if addr, err := netip.ParseAddr(host); err == nil {
+		return Public(addr)
+	}
+	return true
+}
+
+// PublicDialer returns a dialer that only connects to public addresses,
+// returning ErrForbiddenAddress for the others.
+func PublicDialer(timeout time.Duration) *net.Dialer {
+	return &net.Dialer{Timeout: timeout, Control: dialPublic}
+}
+
+// dialPublic refuses connections to addresses that aren't public. It runs
+// after the host was resolved, so DNS can't be used to get around it.
+func dialPublic(_, address string, _ syscall.RawConn) error {
+	host, _, err := net.SplitHostPort(address)
+	if err != nil {
+		return err
+	}
+	addr, err := netip.ParseAddr(host)
+	if err != nil || !Public(addr) {
+		return fmt.Errorf("%s: %w", address, ErrForbiddenAddress)
+	}
+	return nil
+}
+
+// newClient returns the client deliveries are sent with. It only connects to
+// public addresses, doesn't use a proxy, and doesn't follow redirects, which
+// would otherwise lead wherever the receiver wants.
+func newClient(timeout time.Duration) *http.Client {
+	return &http.Client{
+		Timeout: timeout,
+		Transport: &http.Transport{
+			DialContext:         PublicDialer(timeout).DialContext,
+			ForceAttemptHTTP2:   true,
+			TLSHandshakeTimeout: timeout,
+			MaxIdleConnsPerHost: 2,
+			IdleConnTimeout:     90 * time.Second,
+		},
+		CheckRedirect: func(*http.Request, []*http.Request) error {
+			return http.ErrUseLastResponse
+		},
+	}
+}
+
+type Store interface {
+	ExpireLinks(ctx context.Context, limit int) (int64, error)
+	FanOut(ctx context.Context, limit int) (int64, error)
+	ClaimDeliveries(ctx context.Context, limit int, lease time.Duration) ([]storage.Delivery, error)
+	RecordDelivery(ctx context.Context, d storage.Delivery) error
+}
+
+// Dispatcher drains the outbox into deliveries and sends them.
+type Dispatcher struct {
+	log    *slog.Logger
+	store  Store
+	cfg    config.Webhooks
+	client *http.Client
+	now    func() time.Time
+}
+
+func New(log *slog.Logger, store Store, cfg config.Webhooks) *Dispatcher {
+	return &Dispatcher{
+		log:    log.With(slog.String("component", "webhooks")),
+		store:  store,
+		cfg:    cfg,
+		client: newClient(cfg.Timeout),
+		now:    time.Now,
+	}
+}
+
+// Run dispatches every poll interval until ctx is done.
+func (d *Dispatcher) Run(ctx context.Context) {
+	t := time.NewTicker(d.cfg.PollInterval)
+	defer t.Stop()
+	for {
+		select {
+		case <-ctx.Done():
+			return
+		case <-t.C:
+			d.dispatch(ctx)
+		}
+	}
+}
+
+func (d *Dispatcher) dispatch(ctx context.Context) {
+	// nothing happens when a link's window closes, so its event is recorded here
+	if _, err := d.store.ExpireLinks(ctx, d.cfg.BatchSize); err != nil {
+		d.log.Error("failed to expire links", sl.Err(err))
+	}
+	if _, err := d.store.FanOut(ctx, d.cfg.BatchSize); err != nil {
+		d.log.Error("failed to fan out events", sl.Err(err))
+	}
+
+	// the lease outlives every attempt of the batch, even on a single worker
+	lease := d.cfg.Timeout*time.Duration(d.cfg.BatchSize/d.cfg.Workers+1) + time.Minute
+	due, err := d.store.ClaimDeliveries(ctx, d.cfg.BatchSize, lease)
+	if err != nil {
+		d.log.Error("failed to claim deliveries", sl.Err(err))
+		return
+	}
+
+	jobs := make(chan storage.Delivery)
+	var wg sync.WaitGroup
+	for range d.cfg.Workers {
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+			for del := range jobs {
+				del = d.attempt(ctx, del)
+				// an attempt that was made is recorded even while shutting down
+				if err := d.store.RecordDelivery(context.WithoutCancel(ctx), del); err != nil {
+					d.log.Error("failed to record delivery", slog.Int64("delivery_id", del.ID), sl.Err(err))
+				}
+			}
+		}()
+	}
+	for _, del := range due {
+		jobs <- del
+	}
+	close(jobs)
+	wg.Wait()
+}
+
+// attempt sends the delivery once and returns it updated with the outcome.
+func (d *Dispatcher) attempt(ctx context.Context, del storage.Delivery) storage.Delivery {
+	now := d.now()
+	del.Attempts++
+	del.LastStatusCode, del.LastResponse, del.LastError = 0, "", ""
+
+	status, body, err := d.send(ctx, del, now)
+	del.LastStatusCode, del.LastResponse = status, body
+	if err == nil && status >= 200 && status < 300 {
+		del.Status = storage.DeliveryDelivered
+		del.DeliveredAt = &now
+		return del
+	}
+	if err != nil {
+		del.LastError = err.Error()
+	} else {
+		del.LastError = "unexpected status " + strconv.Itoa(status)
+	}
+
+	if del.Attempts >= d.cfg.MaxAttempts {
+		del.Status = storage.DeliveryFailed
+		d.log.Warn("giving up on delivery", slog.Int64("delivery_id", del.ID), slog.Int64("webhook_id", del.WebhookID),
+			slog.String("error", del.LastError))
+		return del
+	}
+	del.Status = storage.DeliveryPending
+	del.NextAttemptAt = now.Add(Backoff(del.Attempts, d.cfg.MinBackoff, d.cfg.MaxBackoff))
+	return del
+}
+
+// send posts the delivery and returns the status code and the start of the
+// body of the response, at most maxResponse bytes of it.
+func (d *Dispatcher) send(ctx context.Context, del storage.Delivery, now time.Time) (int, string, error) {
+	req, err := http.NewRequestWithContext(ctx, http.MethodPost, del.URL, bytes.NewReader(del.Payload))
+	if err != nil {
+		return 0, "", err
+	}
+	ts := strconv.FormatInt(now.Unix(), 10)
+	req.Header.Set("Content-Type", "application/json")
+	req.Header.Set("User-Agent", "url-shortener-webhooks")
+	req.Header.Set(HeaderEvent, del.Event)
+	req.Header.Set(HeaderDelivery, strconv.FormatInt(del.ID, 10))
+	req.Header.Set(HeaderTimestamp, ts)
+	req.Header.Set(HeaderSignature, Sign(del.Secret, ts, del.Payload))
+
+	resp, err := d.client.Do(req)
+	if err != nil {
+		return 0, "", err
+	}
+	defer resp.Body.Close()
+	body, _ := io.ReadAll(io.LimitReader(resp.Body, maxResponse))
+	// drained a little so that the connection can be reused
+	_, _ = io.Copy(io.Discard, io.LimitReader(resp.Body, 4096))
+	// Postgres takes neither invalid UTF-8, such as a rune cut in half, nor NUL in text
+	return resp.StatusCode, strings.ReplaceAll(strings.ToValidUTF8(string(body), ""), "\x00", ""), nil
+}
+
+// Sign returns the value of HeaderSignature for a delivery.
+func Sign(secret, timestamp string, body []byte) string {
+	mac := hmac.New(sha256.New, []byte(secret))
+	mac.Write([]byte(timestamp))
+	mac.Write([]byte("."))
+	mac.Write(body)
+	return "sha256=" + hex.EncodeToString(mac.Sum(nil))
+}
+
+// Verify checks a signature made by Sign. Receivers written in Go can use it as is.
+func Verify(secret, timestamp string, body []byte, signature string) bool {
+	return hmac.Equal([]byte(Sign(secret, timestamp, body)), []byte(signature))
+}
+
+// Backoff is the wait after the given failed attempt: min, doubled for
+// every further attempt, capped at max.
+func Backoff(attempt int, min, max time.Duration) time.Duration {
+	b := min
+	for i := 1; i < attempt; i++ {
+		b *= 2
+		if b >= max || b <= 0 {
+			return max
+		}
+	}
+	if b > max {
+		return max
+	}
+	return b
+}
+
+// NewSecret generates a secret for a new webhook.
+func NewSecret() (string, error) {
+	b := make([]byte, 32)
+	if _, err := rand.Read(b); err != nil {
+		return "", fmt.Errorf("lib.webhooks.NewSecret: %w", err)
+	}
+	return "whsec_" + hex.EncodeToString(b), nil
+}
@@ -0,0 +1,112 @@
+package webhooks
+
+import (
+	"context"
+	"io"
+	"log/slog"
+	"net/http"
+	"net/http/httptest"
+	"net/netip"
+	"testing"
+	"time"
+
+	"github.com/kxddry/url-shortener/internal/config"
+	"github.com/kxddry/url-shortener/internal/storage"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+func TestSign(t *testing.T) {
+	// echo -n '1700000000.{"event":"link.created"}' | openssl dgst -sha256 -hmac secret
+	got := Sign("secret", "1700000000", []byte(`{"event":"link.created"}`))
+	assert.Equal(t, "sha256=4183334cf814c621c4bd89e061af921be573dd36def10573e13eca9fe9857c31", got)
+	assert.True(t, Verify("secret", "1700000000", []byte(`{"event":"link.created"}`), got))
+	assert.False(t, Verify("secret", "1700000001", []byte(`{"event":"link.created"}`), got))
+	assert.False(t, Verify("other", "1700000000", []byte(`{"event":"link.created"}`), got))
+}
+
+func TestBackoff(t *testing.T) {
+	min, max := 10*time.Second, time.Minute
+	assert.Equal(t, 10*time.Second, Backoff(1, min, max))
+	assert.Equal(t, 20*time.Second, Backoff(2, min, max))
+	assert.Equal(t, 40*time.Second, Backoff(3, min, max))
+	assert.Equal(t, time.Minute, Backoff(4, min, max))
+	assert.Equal(t, time.Minute, Backoff(100, min, max))
+}
+
+func TestAttempt(t *testing.T) {
+	status := http.StatusInternalServerError
+	srv := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		body, _ := io.ReadAll(r.Body)
+		assert.True(t, Verify("secret", r.Header.Get(HeaderTimestamp), body, r.Header.Get(HeaderSignature)))
+		assert.Equal(t, storage.EventLinkCreated, r.Header.Get(HeaderEvent))
+		w.WriteHeader(status)
+		_, _ = w.Write([]byte("nope"))
+	}))
+	defer srv.Close()
+
+	now := time.Date(2025, 1, 1, 0, 0, 0, 0, time.UTC)
+	d := New(slog.New(slog.NewTextHandler(io.Discard, nil)), nil, config.Webhooks{
+		Timeout: time.Second, MaxAttempts: 2, MinBackoff: time.Minute, MaxBackoff: time.Hour,
+	})
+	d.now = func() time.Time { return now }
+	// the test server is on loopback, which the dispatcher's own client refuses
+	d.client = srv.Client()
+
+	del := storage.Delivery{ID: 1, Event: storage.EventLinkCreated, Payload: []byte(`{}`), URL: srv.URL, Secret: "secret"}
+
+	del = d.attempt(context.Background(), del)
+	assert.Equal(t, storage.DeliveryPending, del.Status)
+	assert.Equal(t, 1, del.Attempts)
+	assert.Equal(t, http.StatusInternalServerError, del.LastStatusCode)
+	assert.Equal(t, "nope", del.LastResponse)
+	assert.Equal(t, now.Add(time.Minute), del.NextAttemptAt)
+
+	del = d.attempt(context.Background(), del)
+	assert.Equal(t, storage.DeliveryFailed, del.Status)
+	assert.Equal(t, 2, del.Attempts)
+
+	status = http.StatusNoContent
+	del = d.attempt(context.Background(), storage.Delivery{ID: 2, Event: storage.EventLinkCreated, Payload: []byte(`{}`), URL: srv.URL, Secret: "secret"})
+	assert.Equal(t, storage.DeliveryDelivered, del.Status)
+	require.NotNil(t, del.DeliveredAt)
+	assert.Empty(t, del.LastError)
+}
+
+func TestAttemptNotPublic(t *testing.T) {
+	hit := false
+	srv := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		hit = true
+	}))
+	defer srv.Close()
+
+	d := New(slog.New(slog.NewTextHandler(io.Discard, nil)), nil, config.Webhooks{
+		Timeout: time.Second, MaxAttempts: 1, MinBackoff: time.Minute, MaxBackoff: time.Hour,
+	})
+	del := d.attempt(context.Background(), storage.Delivery{ID: 1, Event: storage.EventLinkCreated, Payload: []byte(`{}`), URL: srv.URL, Secret: "secret"})
+	assert.False(t, hit, "nothing is sent to 127.0.0.1")
+	assert.Equal(t, storage.DeliveryFailed, del.Status)
+	assert.Zero(t, del.LastStatusCode)
+	assert.Contains(t, del.LastError, ErrForbiddenAddress.Error())
+}
+
+func TestPublic(t *testing.T) {
+	for addr, want := range map[string]bool{
+		"93.184.215.14":        true,
+		"2606:2800:21f:cb07::": true,
+		"127.0.0.1":            false,
+		"10.1.2.3":             false,
+		"172.16.0.1":           false,
+		"192.168.1.1":          false,
+		"169.254.169.254":      false,
+		"100.100.100.200":      false,
+		"0.0.0.0":              false,
+		"::1":                  false,
+		"::ffff:127.0.0.1":     false,
+		"fd00:ec2::254":        false,
+		"fe80::1":              false,
+		"64:ff9b::a9fe:a9fe":   false,
+	} {
+		assert.Equal(t, want, Public(netip.MustParseAddr(addr)), addr)
+	}
+}
@@ -0,0 +1,170 @@
+package cache
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"github.com/kxddry/url-shortener/internal/config"
+	"github.com/kxddry/url-shortener/internal/lib/logger/sl"
+	"github.com/kxddry/url-shortener/internal/lib/lru"
+	"github.com/kxddry/url-shortener/internal/storage"
+	"log/slog"
+	"sync/atomic"
+	"time"
+)
+
+// resubscribeDelay is how long Run waits before subscribing again after a failure.
+const resubscribeDelay = time.Second
+
+// Remote is the shared tier, Redis.
+type Remote interface {
+	GetLink(ctx context.Context, domain, alias string) (storage.Link, error)
+	SaveLink(ctx context.Context, link storage.Link) (int64, error)
+	DeleteURL(ctx context.Context, domain, alias string) error
+	PublishInvalidation(ctx context.Context, domain, alias string) error
+	SubscribeInvalidations(ctx context.Context, handle func(domain, alias string)) error
+}
+
+// Cache is the link cache: an in-process LRU in front of Redis. Links are
+// kept in memory for a short time only, and dropped by every instance as
+// soon as any of them deletes a link from the cache, see Run.
+type Cache struct {
+	local  *lru.Cache[string, storage.Link]
+	remote Remote
+
+	localHits, localMisses   atomic.Int64
+	remoteHits, remoteMisses atomic.Int64
+	sent, received           atomic.Int64
+}
+
+func New(remote Remote, cfg config.Cache) *Cache {
+	return &Cache{
+		local:  lru.New[string, storage.Link](cfg.Size, cfg.TTL),
+		remote: remote,
+	}
+}
+
+// key namespaces aliases by domain. Aliases can't contain '/', so keys never collide.
+func key(domain, alias string) string {
+	return domain + "/" + alias
+}
+
+// GetLink returns the link from memory, or else from Redis, keeping it in memory.
+func (c *Cache) GetLink(ctx context.Context, domain, alias string) (storage.Link, error) {
+	const op = "storage.cache.GetLink"
+	if l, ok := c.local.Get(key(domain, alias)); ok {
+		c.localHits.Add(1)
+		return l, nil
+	}
+	c.localMisses.Add(1)
+
+	l, err := c.remote.GetLink(ctx, domain, alias)
+	if err != nil {
+		c.remoteMisses.Add(1)
+		return storage.Link{}, fmt.Errorf("%s: %w", op, err)
+	}
+	c.remoteHits.Add(1)
+	c.keep(l)
+	return l, nil
+}
+
+// SaveLink caches link in Redis and in memory.
+func (c *Cache) SaveLink(ctx context.Context, link storage.Link) (int64, error) {
+	const op = "storage.cache.SaveLink"
+	id, err := c.remote.SaveLink(ctx, link)
+	if err != nil {
+		return 0, fmt.Errorf("%s: %w", op, err)
+	}
+	c.keep(link)
+	return id, nil
+}
+
+// DeleteURL drops the link from Redis and from the memory of every instance.
+func (c *Cache) DeleteURL(ctx context.Context, domain, alias string) error {
+	const op = "storage.cache.DeleteURL"
+	c.local.Remove(key(domain, alias))
+	err := c.remote.DeleteURL(ctx, domain, alias)
+	// the other instances drop it even if Redis kept it, the TTL bounds that
+	if pubErr := c.remote.PublishInvalidation(ctx, domain, alias); pubErr == nil {
+		c.sent.Add(1)
+	} else {
+		err = errors.Join(err, pubErr)
+	}
+	if err != nil {
+		return fmt.Errorf("%s: %w", op, err)
+	}
+	return nil
+}
+
+// keep stores l in memory, no longer than until its not_after.
+func (c *Cache) keep(l storage.Link) {
+	var expires time.Time
+	if l.NotAfter != nil {
+		if expires = *l.NotAfter; !time.Now().Before(expires) {
+			return
+		}
+	}
+	c.local.Add(key(l.Domain, l.Alias), l, expires)
+}
+
+// Run drops the links other instances invalidate from memory until ctx is
+// done. Whenever the subscription breaks, everything in memory is dropped,
+// since invalidations may have been missed.
+func (c *Cache) Run(ctx context.Context, log *slog.Logger) {
+	log = log.With(slog.String("component", "storage/cache"))
+	for {
+		err := c.remote.SubscribeInvalidations(ctx, func(domain, alias string) {
+			c.received.Add(1)
+			c.local.Remove(key(domain, alias))
+		})
+		if ctx.Err() != nil {
+			return
+		}
+		c.local.Purge()
+		log.Error("invalidation subscription failed, retrying", sl.Err(err))
+		select {
+		case <-ctx.Done():
+			return
+		case <-time.After(resubscribeDelay):
+		}
+	}
+}
+
+// TierStats are the counters of one tier. HitRate is 0 until the tier is used.
+type TierStats struct {
+	Hits    int64   `json:"hits"`
+	Misses  int64   `json:"misses"`
+	HitRate float64 `json:"hit_rate"`
+}
+
+func tierStats(hits, misses int64) TierStats {
+	s := TierStats{Hits: hits, Misses: misses}
+	if total := hits + misses; total > 0 {
+		s.HitRate = float64(hits) / float64(total)
+	}
+	return s
+}
+
+type Stats struct {
+	Local TierStats `json:"local"`
+	// Redis only counts the lookups the local tier missed.
+	Redis     TierStats `json:"redis"`
+	Size      int       `json:"size"`
+	Evictions int64     `json:"evictions"`
+	// InvalidationsSent and InvalidationsReceived count the invalidations
+	// published and those heard, this instance's own included.
+	InvalidationsSent     int64 `json:"invalidations_sent"`
+	InvalidationsReceived int64 `json:"invalidations_received"`
+}
+
+// Stats returns the counters since the start of the instance.
+func (c *Cache) Stats() Stats {
+	return Stats{
+		Local:                 tierStats(c.localHits.Load(), c.localMisses.Load()),
+		Redis:                 tierStats(c.remoteHits.Load(), c.remoteMisses.Load()),
+		Size:                  c.local.Len(),
+		Evictions:             c.local.Evictions(),
+		InvalidationsSent:     c.sent.Load(),
+		InvalidationsReceived: c.received.Load(),
+	}
+}
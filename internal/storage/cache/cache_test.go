@@ -0,0 +1,109 @@
+package cache
+
+import (
+	"context"
+	"errors"
+	"io"
+	"log/slog"
+	"sync"
+	"testing"
+	"time"
+
+	"github.com/kxddry/url-shortener/internal/config"
+	"github.com/kxddry/url-shortener/internal/storage"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+var errMiss = errors.New("miss")
+
+// bus is a Redis shared by instances, with a pub/sub channel.
+type bus struct {
+	mu       sync.Mutex
+	links    map[string]storage.Link
+	gets     int
+	handlers []func(domain, alias string)
+}
+
+func (b *bus) GetLink(_ context.Context, domain, alias string) (storage.Link, error) {
+	b.mu.Lock()
+	defer b.mu.Unlock()
+	b.gets++
+	l, ok := b.links[key(domain, alias)]
+	if !ok {
+		return storage.Link{}, errMiss
+	}
+	return l, nil
+}
+
+func (b *bus) SaveLink(_ context.Context, l storage.Link) (int64, error) {
+	b.mu.Lock()
+	defer b.mu.Unlock()
+	b.links[key(l.Domain, l.Alias)] = l
+	return l.ID, nil
+}
+
+func (b *bus) DeleteURL(_ context.Context, domain, alias string) error {
+	b.mu.Lock()
+	defer b.mu.Unlock()
+	delete(b.links, key(domain, alias))
+	return nil
+}
+
+func (b *bus) PublishInvalidation(_ context.Context, domain, alias string) error {
+	b.mu.Lock()
+	handlers := b.handlers
+	b.mu.Unlock()
+	for _, h := range handlers {
+		h(domain, alias)
+	}
+	return nil
+}
+
+func (b *bus) SubscribeInvalidations(ctx context.Context, handle func(domain, alias string)) error {
+	b.mu.Lock()
+	b.handlers = append(b.handlers, handle)
+	b.mu.Unlock()
+	<-ctx.Done()
+	return nil
+}
+
+func (b *bus) subscribers() int {
+	b.mu.Lock()
+	defer b.mu.Unlock()
+	return len(b.handlers)
+}
+
+func TestCache(t *testing.T) {
+	ctx, cancel := context.WithCancel(context.Background())
+	defer cancel()
+	log := slog.New(slog.NewTextHandler(io.Discard, nil))
+	remote := &bus{links: map[string]storage.Link{}}
+	cfg := config.Cache{Size: 10, TTL: time.Minute}
+	a, b := New(remote, cfg), New(remote, cfg)
+	go a.Run(ctx, log)
+	go b.Run(ctx, log)
+	require.Eventually(t, func() bool { return remote.subscribers() == 2 }, time.Second, time.Millisecond)
+
+	_, err := a.SaveLink(ctx, storage.Link{Alias: "hot", URL: "https://example.com"})
+	require.NoError(t, err)
+	for range 3 {
+		l, err := b.GetLink(ctx, "", "hot")
+		require.NoError(t, err)
+		assert.Equal(t, "https://example.com", l.URL)
+	}
+	assert.Equal(t, 1, remote.gets, "hot links are served from memory")
+	assert.Equal(t, TierStats{Hits: 2, Misses: 1, HitRate: 2.0 / 3}, b.Stats().Local)
+	assert.Equal(t, TierStats{Hits: 1, HitRate: 1}, b.Stats().Redis)
+
+	require.NoError(t, a.DeleteURL(ctx, "", "hot"))
+	_, err = b.GetLink(ctx, "", "hot")
+	assert.ErrorIs(t, err, errMiss, "every instance drops a deleted link")
+	assert.Equal(t, int64(1), a.Stats().InvalidationsSent)
+	assert.Equal(t, int64(1), b.Stats().InvalidationsReceived)
+
+	past := time.Now().Add(-time.Second)
+	_, err = a.SaveLink(ctx, storage.Link{Alias: "old", NotAfter: &past})
+	require.NoError(t, err)
+	assert.Zero(t, a.Stats().Size, "links past not_after aren't kept in memory")
+}
@@ -0,0 +1,292 @@
+package postgres
+
+import (
+	"context"
+	"database/sql"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"github.com/kxddry/url-shortener/internal/storage"
+	"strings"
+)
+
+// disabledNow is true for links that are disabled at the moment.
+const disabledNow = `(disabled_at IS NOT NULL AND (disabled_until IS NULL OR disabled_until > now()))`
+
+// likeEscaper makes a search query match literally inside a LIKE pattern.
+var likeEscaper = strings.NewReplacer(`\`, `\\`, `%`, `\%`, `_`, `\_`)
+
+// SearchLinks lists everyone's links matching f, newest first.
+func (s *Storage) SearchLinks(ctx context.Context, f storage.SearchFilter) ([]storage.Link, error) {
+	const op = "storage.postgres.SearchLinks"
+	ctx, cancel := s.withTimeout(ctx)
+	defer cancel()
+
+	pattern := "%" + likeEscaper.Replace(f.Query) + "%"
+	db, _ := s.reader()
+	rows, err := db.QueryContext(ctx, `SELECT `+linkColumns+`, `+healthColumn+` FROM url
+		WHERE ($1 = '%%' OR alias ILIKE $1 OR url ILIKE $1 OR title ILIKE $1)
+			AND ($2 = 0 OR createdBy = $2)
+			AND ($3 = '' OR domain = $3)
+			AND (NOT $4 OR `+disabledNow+`)
+		ORDER BY created_at DESC, id DESC
+		LIMIT $5 OFFSET $6;`, pattern, f.UserID, f.Domain, f.Disabled, f.Limit, f.Offset)
+	if err != nil {
+		return nil, fmt.Errorf("%s: %w", op, err)
+	}
+	defer rows.Close()
+
+	res := []storage.Link{}
+	for rows.Next() {
+		var health []byte
+		l, err := scanLink(rows, &health)
+		if err != nil {
+			return nil, fmt.Errorf("%s: %w", op, err)
+		}
+		if health != nil {
+			if err = json.Unmarshal(health, &l.Health); err != nil {
+				return nil, fmt.Errorf("%s: invalid health: %w", op, err)
+			}
+		}
+		res = append(res, l)
+	}
+	if err = rows.Err(); err != nil {
+		return nil, fmt.Errorf("%s: %w", op, err)
+	}
+	return res, nil
+}
+
+// DisableLink stops the link from redirecting until EnableLink, and returns it.
+// The pending reports of the link are resolved by the admin by.
+func (s *Storage) DisableLink(ctx context.Context, domain, alias, reason string, by int64) (storage.Link, error) {
+	const op = "storage.postgres.DisableLink"
+	resolve := func(ctx context.Context, tx *sql.Tx, l storage.Link) error {
+		return resolveReports(ctx, tx, l.ID, storage.ResolutionDisabled, by)
+	}
+	l, err := s.setDisabled(ctx, domain, alias, resolve, `disabled_at = CASE WHEN `+disabledNow+` THEN disabled_at ELSE now() END,
+		disabled_reason = $3, disabled_until = NULL`, reason)
+	if err != nil {
+		return storage.Link{}, fmt.Errorf("%s: %w", op, err)
+	}
+	return l, nil
+}
+
+// EnableLink lets a disabled link redirect again, and returns it.
+func (s *Storage) EnableLink(ctx context.Context, domain, alias string) (storage.Link, error) {
+	const op = "storage.postgres.EnableLink"
+	l, err := s.setDisabled(ctx, domain, alias, nil, `disabled_at = NULL, disabled_reason = '', disabled_until = NULL`)
+	if err != nil {
+		return storage.Link{}, fmt.Errorf("%s: %w", op, err)
+	}
+	return l, nil
+}
+
+// setDisabled updates the link with set, which may use args from $3 on, and
+// then calls after, if any, in the same transaction.
+func (s *Storage) setDisabled(ctx context.Context, domain, alias string, after func(ctx context.Context, tx *sql.Tx, l storage.Link) error,
+	set string, args ...any) (storage.Link, error) {
+	ctx, cancel := s.withTimeout(ctx)
+	defer cancel()
+
+	tx, err := s.db.BeginTx(ctx, nil)
+	if err != nil {
+		return storage.Link{}, err
+	}
+	defer tx.Rollback()
+
+	l, err := scanLink(tx.QueryRowContext(ctx, `UPDATE url SET `+set+` WHERE domain = $1 AND alias = $2 RETURNING `+linkColumns+`;`,
+		append([]any{domain, alias}, args...)...))
+	if errors.Is(err, sql.ErrNoRows) {
+		return storage.Link{}, storage.ErrAliasNotFound
+	}
+	if err != nil {
+		return storage.Link{}, err
+	}
+	if err = writeLinkEvent(ctx, tx, storage.EventLinkUpdated, l); err != nil {
+		return storage.Link{}, err
+	}
+	if after != nil {
+		if err = after(ctx, tx, l); err != nil {
+			return storage.Link{}, err
+		}
+	}
+	if err = tx.Commit(); err != nil {
+		return storage.Link{}, err
+	}
+	s.markWritten(linkKey(domain, alias), ownerKey(l.CreatedBy, l.WorkspaceID))
+	return l, nil
+}
+
+// DeleteUserLinks deletes every link created by uid, in workspaces as well,
+// and returns the deleted links.
+func (s *Storage) DeleteUserLinks(ctx context.Context, uid int64) ([]storage.Link, error) {
+	const op = "storage.postgres.DeleteUserLinks"
+	ctx, cancel := s.withTimeout(ctx)
+	defer cancel()
+
+	tx, err := s.db.BeginTx(ctx, nil)
+	if err != nil {
+		return nil, fmt.Errorf("%s: %w", op, err)
+	}
+	defer tx.Rollback()
+
+	rows, err := tx.QueryContext(ctx, `DELETE FROM url WHERE createdBy = $1 RETURNING `+linkColumns+`;`, uid)
+	if err != nil {
+		return nil, fmt.Errorf("%s: %w", op, err)
+	}
+	deleted := []storage.Link{}
+	for rows.Next() {
+		l, err := scanLink(rows)
+		if err != nil {
+			rows.Close()
+			return nil, fmt.Errorf("%s: %w", op, err)
+		}
+		deleted = append(deleted, l)
+	}
+	rows.Close()
+	if err = rows.Err(); err != nil {
+		return nil, fmt.Errorf("%s: %w", op, err)
+	}
+
+	keys := make([]string, 0, len(deleted)+1)
+	keys = append(keys, ownerKey(uid, 0))
+	for _, l := range deleted {
+		if err = writeLinkEvent(ctx, tx, storage.EventLinkDeleted, l); err != nil {
+			return nil, fmt.Errorf("%s: %w", op, err)
+		}
+		keys = append(keys, linkKey(l.Domain, l.Alias), ownerKey(l.CreatedBy, l.WorkspaceID))
+	}
+	if err = tx.Commit(); err != nil {
+		return nil, fmt.Errorf("%s: %w", op, err)
+	}
+	s.markWritten(keys...)
+	return deleted, nil
+}
+
+// BlockedDomains lists the destination domains blocked by admins.
+func (s *Storage) BlockedDomains(ctx context.Context) ([]storage.BlockedDomain, error) {
+	const op = "storage.postgres.BlockedDomains"
+	ctx, cancel := s.withTimeout(ctx)
+	defer cancel()
+
+	db, _ := s.reader()
+	rows, err := db.QueryContext(ctx, `SELECT domain, reason, blocked_by, created_at FROM blocked_domains ORDER BY domain;`)
+	if err != nil {
+		return nil, fmt.Errorf("%s: %w", op, err)
+	}
+	defer rows.Close()
+
+	res := []storage.BlockedDomain{}
+	for rows.Next() {
+		var d storage.BlockedDomain
+		if err = rows.Scan(&d.Domain, &d.Reason, &d.BlockedBy, &d.CreatedAt); err != nil {
+			return nil, fmt.Errorf("%s: %w", op, err)
+		}
+		res = append(res, d)
+	}
+	if err = rows.Err(); err != nil {
+		return nil, fmt.Errorf("%s: %w", op, err)
+	}
+	return res, nil
+}
+
+// BlockDomain blocks d.Domain, or updates the reason it is blocked for, and
+// returns it as stored.
+func (s *Storage) BlockDomain(ctx context.Context, d storage.BlockedDomain) (storage.BlockedDomain, error) {
+	const op = "storage.postgres.BlockDomain"
+	ctx, cancel := s.withTimeout(ctx)
+	defer cancel()
+
+	err := s.db.QueryRowContext(ctx, `INSERT INTO blocked_domains (domain, reason, blocked_by) VALUES ($1, $2, $3)
+		ON CONFLICT (domain) DO UPDATE SET reason = EXCLUDED.reason
+		RETURNING blocked_by, created_at;`, d.Domain, d.Reason, d.BlockedBy).Scan(&d.BlockedBy, &d.CreatedAt)
+	if err != nil {
+		return storage.BlockedDomain{}, fmt.Errorf("%s: %w", op, err)
+	}
+	return d, nil
+}
+
+func (s *Storage) UnblockDomain(ctx context.Context, domain string) error {
+	const op = "storage.postgres.UnblockDomain"
+	ctx, cancel := s.withTimeout(ctx)
+	defer cancel()
+
+	res, err := s.db.ExecContext(ctx, `DELETE FROM blocked_domains WHERE domain = $1;`, domain)
+	if err != nil {
+		return fmt.Errorf("%s: %w", op, err)
+	}
+	if n, err := res.RowsAffected(); err == nil && n == 0 {
+		return fmt.Errorf("%s: %w", op, storage.ErrNotBlocked)
+	}
+	return nil
+}
+
+// Stats counts what the service holds.
+func (s *Storage) Stats(ctx context.Context) (storage.Stats, error) {
+	const op = "storage.postgres.Stats"
+	ctx, cancel := s.withTimeout(ctx)
+	defer cancel()
+
+	var st storage.Stats
+	db, _ := s.reader()
+	err := db.QueryRowContext(ctx, `SELECT
+			(SELECT count(*) FROM url),
+			(SELECT count(*) FROM url WHERE created_at >= now() - interval '1 day'),
+			(SELECT count(*) FROM url WHERE `+disabledNow+`),
+			(SELECT count(DISTINCT url_id) FROM reports WHERE resolved_at IS NULL),
+			(SELECT count(*) FROM link_health WHERE broken),
+			(SELECT COALESCE(sum(clicks), 0) FROM url),
+			(SELECT count(DISTINCT createdBy) FROM url),
+			(SELECT count(*) FROM workspaces),
+			(SELECT count(*) FROM webhooks),
+			(SELECT count(*) FROM domains),
+			(SELECT count(*) FROM blocked_domains);`).Scan(
+		&st.Links, &st.LinksToday, &st.DisabledLinks, &st.ReportedLinks, &st.BrokenLinks, &st.Clicks,
+		&st.Users, &st.Workspaces, &st.Webhooks, &st.Domains, &st.BlockedDomains)
+	if err != nil {
+		return storage.Stats{}, fmt.Errorf("%s: %w", op, err)
+	}
+	return st, nil
+}
+
+func (s *Storage) RecordAudit(ctx context.Context, e storage.AuditEntry) error {
+	const op = "storage.postgres.RecordAudit"
+	ctx, cancel := s.withTimeout(ctx)
+	defer cancel()
+
+	_, err := s.db.ExecContext(ctx, `INSERT INTO admin_audit (actor, action, target, status, request_id) VALUES ($1, $2, $3, $4, $5);`,
+		e.Actor, e.Action, e.Target, e.Status, e.RequestID)
+	if err != nil {
+		return fmt.Errorf("%s: %w", op, err)
+	}
+	return nil
+}
+
+// AuditLog lists the audit entries of actor, or of every admin if actor is 0, newest first.
+func (s *Storage) AuditLog(ctx context.Context, actor int64, limit, offset int) ([]storage.AuditEntry, error) {
+	const op = "storage.postgres.AuditLog"
+	ctx, cancel := s.withTimeout(ctx)
+	defer cancel()
+
+	rows, err := s.db.QueryContext(ctx, `SELECT id, actor, action, target, status, request_id, created_at FROM admin_audit
+		WHERE $1 = 0 OR actor = $1
+		ORDER BY id DESC
+		LIMIT $2 OFFSET $3;`, actor, limit, offset)
+	if err != nil {
+		return nil, fmt.Errorf("%s: %w", op, err)
+	}
+	defer rows.Close()
+
+	res := []storage.AuditEntry{}
+	for rows.Next() {
+		var e storage.AuditEntry
+		if err = rows.Scan(&e.ID, &e.Actor, &e.Action, &e.Target, &e.Status, &e.RequestID, &e.CreatedAt); err != nil {
+			return nil, fmt.Errorf("%s: %w", op, err)
+		}
+		res = append(res, e)
+	}
+	if err = rows.Err(); err != nil {
+		return nil, fmt.Errorf("%s: %w", op, err)
+	}
+	return res, nil
+}
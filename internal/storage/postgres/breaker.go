@@ -0,0 +1,164 @@
+package postgres
+
+import (
+	"context"
+	"database/sql/driver"
+	"errors"
+	"github.com/kxddry/url-shortener/internal/lib/breaker"
+	"github.com/kxddry/url-shortener/internal/storage"
+	"github.com/lib/pq"
+	"io"
+	"net"
+	"syscall"
+)
+
+// breakerConnector connects to the primary through the breaker: while it is
+// open, connecting and every round trip on the pooled connections fail with
+// a *storage.UnavailableError right away, instead of each waiting for the
+// query timeout.
+type breakerConnector struct {
+	driver.Connector
+	b *breaker.Breaker
+}
+
+func (c breakerConnector) Connect(ctx context.Context) (driver.Conn, error) {
+	if err := allow(c.b); err != nil {
+		return nil, err
+	}
+	conn, err := c.Connector.Connect(ctx)
+	record(ctx, c.b, err)
+	if err != nil {
+		return nil, err
+	}
+	return &breakerConn{conn: conn, b: c.b}, nil
+}
+
+// pqConn is what lib/pq's connections implement.
+type pqConn interface {
+	driver.Conn
+	driver.ConnBeginTx
+	driver.ConnPrepareContext
+	driver.ExecerContext
+	driver.QueryerContext
+	driver.Pinger
+	driver.SessionResetter
+	driver.Validator
+}
+
+type breakerConn struct {
+	conn driver.Conn
+	b    *breaker.Breaker
+}
+
+var _ pqConn = (*breakerConn)(nil)
+
+func (c *breakerConn) Prepare(query string) (driver.Stmt, error) {
+	return c.PrepareContext(context.Background(), query)
+}
+
+func (c *breakerConn) PrepareContext(ctx context.Context, query string) (driver.Stmt, error) {
+	if err := allow(c.b); err != nil {
+		return nil, err
+	}
+	stmt, err := c.conn.(driver.ConnPrepareContext).PrepareContext(ctx, query)
+	record(ctx, c.b, err)
+	return stmt, err
+}
+
+func (c *breakerConn) Begin() (driver.Tx, error) {
+	return c.BeginTx(context.Background(), driver.TxOptions{})
+}
+
+func (c *breakerConn) BeginTx(ctx context.Context, opts driver.TxOptions) (driver.Tx, error) {
+	if err := allow(c.b); err != nil {
+		return nil, err
+	}
+	tx, err := c.conn.(driver.ConnBeginTx).BeginTx(ctx, opts)
+	record(ctx, c.b, err)
+	return tx, err
+}
+
+func (c *breakerConn) ExecContext(ctx context.Context, query string, args []driver.NamedValue) (driver.Result, error) {
+	if err := allow(c.b); err != nil {
+		return nil, err
+	}
+	res, err := c.conn.(driver.ExecerContext).ExecContext(ctx, query, args)
+	record(ctx, c.b, err)
+	return res, err
+}
+
+func (c *breakerConn) QueryContext(ctx context.Context, query string, args []driver.NamedValue) (driver.Rows, error) {
+	if err := allow(c.b); err != nil {
+		return nil, err
+	}
+	rows, err := c.conn.(driver.QueryerContext).QueryContext(ctx, query, args)
+	record(ctx, c.b, err)
+	return rows, err
+}
+
+func (c *breakerConn) Ping(ctx context.Context) error {
+	if err := allow(c.b); err != nil {
+		return err
+	}
+	err := c.conn.(driver.Pinger).Ping(ctx)
+	record(ctx, c.b, err)
+	return err
+}
+
+func (c *breakerConn) ResetSession(ctx context.Context) error {
+	return c.conn.(driver.SessionResetter).ResetSession(ctx)
+}
+
+func (c *breakerConn) IsValid() bool {
+	return c.conn.(driver.Validator).IsValid()
+}
+
+func (c *breakerConn) Close() error {
+	return c.conn.Close()
+}
+
+func allow(b *breaker.Breaker) error {
+	if b.Allow() {
+		return nil
+	}
+	return &storage.UnavailableError{RetryAfter: b.RetryAfter()}
+}
+
+// record tells the breaker whether a round trip reached the database.
+// Errors the database answered with, such as unique violations, count
+// as reaching it; callers hanging up count as neither.
+func record(ctx context.Context, b *breaker.Breaker, err error) {
+	switch {
+	case err == nil:
+		b.Success()
+	case errors.Is(ctx.Err(), context.Canceled):
+		b.Release()
+	case unreachable(ctx, err):
+		b.Failure()
+	default:
+		b.Success()
+	}
+}
+
+// unreachable reports whether err means that the database couldn't be
+// reached, or didn't answer in time.
+func unreachable(ctx context.Context, err error) bool {
+	if errors.Is(ctx.Err(), context.DeadlineExceeded) || errors.Is(err, context.DeadlineExceeded) ||
+		errors.Is(err, driver.ErrBadConn) || errors.Is(err, io.EOF) || errors.Is(err, io.ErrUnexpectedEOF) ||
+		errors.Is(err, syscall.ECONNREFUSED) || errors.Is(err, syscall.ECONNRESET) {
+		return true
+	}
+	var netErr net.Error
+	if errors.As(err, &netErr) {
+		return true
+	}
+	var pqErr *pq.Error
+	if errors.As(err, &pqErr) {
+		switch pqErr.Code {
+		case "57P01", "57P02", "57P03": // admin_shutdown, crash_shutdown, cannot_connect_now
+			return true
+		}
+		return pqErr.Code.Class() == "08" // connection exception
+	}
+	return false
+}
@@ -0,0 +1,66 @@
+package postgres
+
+import (
+	"context"
+	"database/sql/driver"
+	"errors"
+	"syscall"
+	"testing"
+	"time"
+
+	"github.com/kxddry/url-shortener/internal/lib/breaker"
+	"github.com/kxddry/url-shortener/internal/storage"
+	"github.com/lib/pq"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+type fakeConnector struct {
+	err   error
+	calls int
+}
+
+func (c *fakeConnector) Connect(context.Context) (driver.Conn, error) {
+	c.calls++
+	return nil, c.err
+}
+
+func (c *fakeConnector) Driver() driver.Driver { return nil }
+
+func TestBreakerConnector(t *testing.T) {
+	base := &fakeConnector{err: &pq.Error{Code: "23505"}}
+	b := breaker.New(2, time.Minute)
+	c := breakerConnector{Connector: base, b: b}
+
+	for range 3 {
+		_, err := c.Connect(context.Background())
+		require.Error(t, err)
+	}
+	assert.Equal(t, breaker.Closed, b.State(), "errors the database answered with don't count")
+
+	base.err = syscall.ECONNREFUSED
+	for range 2 {
+		_, _ = c.Connect(context.Background())
+	}
+	assert.Equal(t, breaker.Open, b.State())
+
+	_, err := c.Connect(context.Background())
+	var ue *storage.UnavailableError
+	require.ErrorAs(t, err, &ue)
+	assert.ErrorIs(t, err, storage.ErrUnavailable)
+	assert.Positive(t, ue.RetryAfter)
+	assert.Equal(t, 5, base.calls, "the primary is left alone while the breaker is open")
+}
+
+func TestUnreachable(t *testing.T) {
+	ctx := context.Background()
+	expired, cancel := context.WithDeadline(ctx, time.Now())
+	defer cancel()
+
+	assert.True(t, unreachable(ctx, driver.ErrBadConn))
+	assert.True(t, unreachable(ctx, &pq.Error{Code: "08006"}))
+	assert.True(t, unreachable(ctx, &pq.Error{Code: "57P01"}))
+	assert.True(t, unreachable(expired, errors.New("pq: canceling statement due to user request")))
+	assert.False(t, unreachable(ctx, &pq.Error{Code: "57014"}), "statements cancelled by us")
+	assert.False(t, unreachable(ctx, &pq.Error{Code: "23505"}))
+}
@@ -0,0 +1,92 @@
+package postgres
+
+import (
+	"context"
+	"database/sql"
+	"errors"
+	"fmt"
+	"github.com/kxddry/url-shortener/internal/storage"
+	"time"
+)
+
+// ClaimChecks returns up to limit links whose destination is due for a check,
+// links that were never checked first, and pushes their next check lease into
+// the future, so that other instances don't check them at the same time.
+// The returned health is that of the previous check.
+func (s *Storage) ClaimChecks(ctx context.Context, limit int, lease time.Duration) ([]storage.LinkHealth, error) {
+	const op = "storage.postgres.ClaimChecks"
+	ctx, cancel := s.withTimeout(ctx)
+	defer cancel()
+
+	rows, err := s.db.QueryContext(ctx, `WITH due AS (
+			SELECT url.id, url.url, h.status_code, h.latency_ms, h.error, h.failures, h.broken, h.checked_at, h.next_check_at
+			FROM url LEFT JOIN link_health h ON h.url_id = url.id
+			WHERE h.url_id IS NULL OR h.next_check_at <= now()
+			ORDER BY h.next_check_at NULLS FIRST
+			LIMIT $1
+			FOR UPDATE OF url SKIP LOCKED
+		), claimed AS (
+			INSERT INTO link_health (url_id, next_check_at)
+			SELECT id, now() + $2 * interval '1 millisecond' FROM due
+			ON CONFLICT (url_id) DO UPDATE SET next_check_at = EXCLUDED.next_check_at
+		)
+		SELECT id, url, COALESCE(status_code, 0), COALESCE(latency_ms, 0), COALESCE(error, ''),
+			COALESCE(failures, 0), COALESCE(broken, false), checked_at, COALESCE(next_check_at, now())
+		FROM due;`, limit, lease.Milliseconds())
+	if err != nil {
+		return nil, fmt.Errorf("%s: %w", op, err)
+	}
+	defer rows.Close()
+
+	var res []storage.LinkHealth
+	for rows.Next() {
+		var h storage.LinkHealth
+		err = rows.Scan(&h.LinkID, &h.URL, &h.StatusCode, &h.LatencyMS, &h.Error, &h.Failures, &h.Broken, &h.CheckedAt, &h.NextCheckAt)
+		if err != nil {
+			return nil, fmt.Errorf("%s: %w", op, err)
+		}
+		res = append(res, h)
+	}
+	if err = rows.Err(); err != nil {
+		return nil, fmt.Errorf("%s: %w", op, err)
+	}
+	return res, nil
+}
+
+// RecordCheck stores the outcome of a check.
+func (s *Storage) RecordCheck(ctx context.Context, h storage.LinkHealth) error {
+	const op = "storage.postgres.RecordCheck"
+	ctx, cancel := s.withTimeout(ctx)
+	defer cancel()
+
+	// the link may have been deleted or pointed elsewhere during the check
+	_, err := s.db.ExecContext(ctx, `UPDATE link_health SET status_code = $2, latency_ms = $3, error = $4,
+			failures = $5, broken = $6, checked_at = $7, next_check_at = $8
+		WHERE url_id = $1 AND EXISTS (SELECT 1 FROM url WHERE id = $1 AND url = $9);`,
+		h.LinkID, h.StatusCode, h.LatencyMS, h.Error, h.Failures, h.Broken, h.CheckedAt, h.NextCheckAt, h.URL)
+	if err != nil {
+		return fmt.Errorf("%s: %w", op, err)
+	}
+	return nil
+}
+
+// LinkHealth returns the outcome of the last check of the link, or
+// storage.ErrHealthNotChecked if its destination wasn't checked yet.
+func (s *Storage) LinkHealth(ctx context.Context, linkID int64) (storage.LinkHealth, error) {
+	const op = "storage.postgres.LinkHealth"
+	ctx, cancel := s.withTimeout(ctx)
+	defer cancel()
+
+	db, _ := s.reader()
+	h := storage.LinkHealth{LinkID: linkID}
+	err := db.QueryRowContext(ctx, `SELECT status_code, latency_ms, error, failures, broken, checked_at, next_check_at
+		FROM link_health WHERE url_id = $1 AND checked_at IS NOT NULL;`, linkID,
+	).Scan(&h.StatusCode, &h.LatencyMS, &h.Error, &h.Failures, &h.Broken, &h.CheckedAt, &h.NextCheckAt)
+	if err != nil {
+		if errors.Is(err, sql.ErrNoRows) {
+			return storage.LinkHealth{}, fmt.Errorf("%s: %w", op, storage.ErrHealthNotChecked)
+		}
+		return storage.LinkHealth{}, fmt.Errorf("%s: %w", op, err)
+	}
+	return h, nil
+}
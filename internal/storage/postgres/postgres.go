@@ -3,98 +3,591 @@ package postgres
 import (
 	"context"
 	"database/sql"
+	"encoding/json"
 	"errors"
 	"fmt"
 	"github.com/kxddry/url-shortener/internal/config"
-	"github.com/kxddry/url-shortener/internal/lib/pqlinks"
+	"github.com/kxddry/url-shortener/internal/lib/breaker"
+	"github.com/kxddry/url-shortener/internal/lib/rules"
 	"github.com/kxddry/url-shortener/internal/storage"
 	"github.com/lib/pq"
+	"slices"
+	"sync/atomic"
+	"time"
 )
 
+// Storage writes to the primary and reads from replicas where a slightly
+// stale answer is fine: redirect lookups, listings and domain refreshes.
 type Storage struct {
 	db *sql.DB
+	// breaker guards the primary, see breakerConnector.
+	breaker  *breaker.Breaker
+	replicas []*replica
+	next     atomic.Uint64
+	recent   recentWrites
+	// timeout bounds every query, on top of the caller's deadline.
+	timeout time.Duration
 }
 
 func New(cfg config.Storage) (*Storage, error) {
 	const op = "storage.postgres.New"
-	dsn := pqlinks.DataSourceName(cfg)
-	db, err := sql.Open("postgres", dsn)
+	b := breaker.New(cfg.Breaker.FailureThreshold, cfg.Breaker.OpenFor)
+	db, err := open(cfg, b)
 	if err != nil {
 		return nil, fmt.Errorf("%s: %w", op, err)
 	}
-	return &Storage{db: db}, db.Ping()
+	replicas, err := openReplicas(cfg)
+	if err != nil {
+		_ = db.Close()
+		return nil, fmt.Errorf("%s: %w", op, err)
+	}
+	s := &Storage{db: db, breaker: b, replicas: replicas, recent: recentWrites{window: cfg.ReadYourWrites}, timeout: cfg.QueryTimeout}
+	// replicas join the rotation once RunHealthChecks has seen them
+	return s, db.Ping()
+}
+
+// Breaker is the circuit breaker of the primary. While it is open, every
+// query to the primary fails with a *storage.UnavailableError.
+func (s *Storage) Breaker() *breaker.Breaker {
+	return s.breaker
+}
+
+// withTimeout bounds ctx by the configured query timeout.
+func (s *Storage) withTimeout(ctx context.Context) (context.Context, context.CancelFunc) {
+	if s.timeout <= 0 {
+		return context.WithCancel(ctx)
+	}
+	return context.WithTimeout(ctx, s.timeout)
+}
+
+// linkColumns are the columns scanned by scanLink, in order.
+const linkColumns = `id, domain, alias, url, createdBy, forward_query, forward_path, query_conflict, rules, redirect_code,
+	title, description, interstitial, created_at, clicks, COALESCE(max_clicks, 0), not_before, not_after, prelaunch_url,
+	disabled_at, disabled_reason, disabled_until, notes, COALESCE(workspace_id, 0),
+	COALESCE((SELECT array_agg(tag ORDER BY tag) FROM link_tags WHERE url_id = url.id), '{}')`
+
+// healthColumn is the link's last completed check as JSON, or NULL.
+const healthColumn = `(SELECT row_to_json(h) FROM link_health h WHERE h.url_id = url.id AND h.checked_at IS NOT NULL)`
+
+type scanner interface {
+	Scan(dest ...any) error
 }
 
-func (s *Storage) SaveURL(urlToSave, alias string, creator int64) (int64, error) {
-	const op = "storage.postgres.SaveURL"
-	tx, err := s.db.Begin()
+// scanLink scans linkColumns, followed by the columns scanned into extra.
+func scanLink(row scanner, extra ...any) (storage.Link, error) {
+	var l storage.Link
+	var rulesJSON []byte
+	err := row.Scan(append([]any{&l.ID, &l.Domain, &l.Alias, &l.URL, &l.CreatedBy, &l.ForwardQuery, &l.ForwardPath, &l.QueryConflict, &rulesJSON, &l.RedirectCode,
+		&l.Title, &l.Description, &l.Interstitial, &l.CreatedAt, &l.Clicks, &l.MaxClicks, &l.NotBefore, &l.NotAfter, &l.PrelaunchURL,
+		&l.DisabledAt, &l.DisabledReason, &l.DisabledUntil, &l.Notes, &l.WorkspaceID, (*pq.StringArray)(&l.Tags)}, extra...)...)
+	if err != nil {
+		return storage.Link{}, err
+	}
+	if err = json.Unmarshal(rulesJSON, &l.Rules); err != nil {
+		return storage.Link{}, fmt.Errorf("invalid rules: %w", err)
+	}
+	return l, nil
+}
+
+func (s *Storage) SaveLink(ctx context.Context, link storage.Link) (int64, error) {
+	return s.saveLink(ctx, link, nil)
+}
+
+// saveLink inserts the link once guard, if any, has returned nil in the same transaction.
+func (s *Storage) saveLink(ctx context.Context, link storage.Link, guard func(ctx context.Context, tx *sql.Tx) error) (int64, error) {
+	const op = "storage.postgres.SaveLink"
+	ctx, cancel := s.withTimeout(ctx)
+	defer cancel()
+	rulesJSON, err := marshalRules(link.Rules)
+	if err != nil {
+		return 0, fmt.Errorf("%s: %w", op, err)
+	}
+
+	tx, err := s.db.BeginTx(ctx, nil)
 	if err != nil {
 		return 0, fmt.Errorf("%s: %w", op, err)
 	}
 	defer tx.Rollback()
 
+	if guard != nil {
+		if err = guard(ctx, tx); err != nil {
+			return 0, fmt.Errorf("%s: %w", op, err)
+		}
+	}
+
 	var id int64
-	err = tx.QueryRow(`INSERT INTO url (alias, url, createdBy) VALUES ($1, $2, $3) RETURNING id;`, alias, urlToSave, creator).Scan(&id)
+	err = tx.QueryRowContext(ctx, `INSERT INTO url (domain, alias, url, createdBy, forward_query, forward_path, query_conflict, rules, redirect_code,
+			title, description, interstitial, notes, workspace_id, max_clicks, not_before, not_after, prelaunch_url)
+		VALUES ($1, $2, $3, $4, $5, $6, $7, $8, $9, $10, $11, $12, $13, NULLIF($14, 0), NULLIF($15, 0), $16, $17, $18) RETURNING id;`,
+		link.Domain, link.Alias, link.URL, link.CreatedBy, link.ForwardQuery, link.ForwardPath, link.QueryConflict, rulesJSON, link.RedirectCode,
+		link.Title, link.Description, link.Interstitial, link.Notes, link.WorkspaceID, link.MaxClicks, link.NotBefore, link.NotAfter, link.PrelaunchURL,
+	).Scan(&id)
 	if err != nil {
 		if pqErr, ok := err.(*pq.Error); ok && pqErr.Code.Name() == "unique_violation" {
 			return 0, fmt.Errorf("%s: %w", op, storage.ErrAliasExists)
 		}
 		return 0, fmt.Errorf("%s: %w", op, err)
 	}
+	if err = setTags(ctx, tx, id, link.Tags); err != nil {
+		return 0, fmt.Errorf("%s: %w", op, err)
+	}
+	if err = writeEvent(ctx, tx, storage.EventLinkCreated, id); err != nil {
+		return 0, fmt.Errorf("%s: %w", op, err)
+	}
+
+	if err = tx.Commit(); err != nil {
+		return 0, fmt.Errorf("%s: %w", op, err)
+	}
+	s.markWritten(linkKey(link.Domain, link.Alias), ownerKey(link.CreatedBy, link.WorkspaceID))
+	return id, nil
+}
+
+// UpdateLink locks the link on the primary, hands it to edit and stores the
+// mutable fields edit left it with, all in one transaction, so that the edit
+// neither works on a stale copy nor undoes a concurrent one. An error from
+// edit leaves the link as it was. The row stays locked while edit runs, so
+// edit doesn't query anything: callers authorize beforehand and only check
+// that the link didn't change owners since.
+func (s *Storage) UpdateLink(ctx context.Context, domain, alias string, edit func(link *storage.Link) error) (storage.Link, error) {
+	const op = "storage.postgres.UpdateLink"
+	ctx, cancel := s.withTimeout(ctx)
+	defer cancel()
+
+	tx, err := s.db.BeginTx(ctx, nil)
+	if err != nil {
+		return storage.Link{}, fmt.Errorf("%s: %w", op, err)
+	}
+	defer tx.Rollback()
+
+	old, err := lockLink(ctx, tx, domain, alias)
+	if err != nil {
+		return storage.Link{}, fmt.Errorf("%s: %w", op, err)
+	}
+	link := old
+	link.Tags = slices.Clone(old.Tags)
+	if err = edit(&link); err != nil {
+		return storage.Link{}, fmt.Errorf("%s: %w", op, err)
+	}
+	rulesJSON, err := marshalRules(link.Rules)
+	if err != nil {
+		return storage.Link{}, fmt.Errorf("%s: %w", op, err)
+	}
+
+	_, err = tx.ExecContext(ctx, `UPDATE url SET url = $2, forward_query = $3, forward_path = $4, query_conflict = $5, rules = $6, redirect_code = $7,
+			title = $8, description = $9, interstitial = $10, notes = $11, not_before = $12, not_after = $13, prelaunch_url = $14,
+			-- a link given a new end expires again
+			expired_at = CASE WHEN not_after IS NOT DISTINCT FROM $13 THEN expired_at END
+		WHERE id = $1;`,
+		old.ID, link.URL, link.ForwardQuery, link.ForwardPath, link.QueryConflict, rulesJSON, link.RedirectCode,
+		link.Title, link.Description, link.Interstitial, link.Notes, link.NotBefore, link.NotAfter, link.PrelaunchURL)
+	if err != nil {
+		return storage.Link{}, fmt.Errorf("%s: %w", op, err)
+	}
+	if link.URL != old.URL {
+		// the health of the old destination says nothing about the new one
+		if _, err = tx.ExecContext(ctx, `DELETE FROM link_health WHERE url_id = $1;`, old.ID); err != nil {
+			return storage.Link{}, fmt.Errorf("%s: %w", op, err)
+		}
+	}
+	if _, err = tx.ExecContext(ctx, `DELETE FROM link_tags WHERE url_id = $1;`, old.ID); err != nil {
+		return storage.Link{}, fmt.Errorf("%s: %w", op, err)
+	}
+	if err = setTags(ctx, tx, old.ID, link.Tags); err != nil {
+		return storage.Link{}, fmt.Errorf("%s: %w", op, err)
+	}
+	if err = writeEvent(ctx, tx, storage.EventLinkUpdated, old.ID); err != nil {
+		return storage.Link{}, fmt.Errorf("%s: %w", op, err)
+	}
+	if err = tx.Commit(); err != nil {
+		return storage.Link{}, fmt.Errorf("%s: %w", op, err)
+	}
+	s.markWritten(linkKey(domain, alias), ownerKey(old.CreatedBy, old.WorkspaceID))
+	return link, nil
+}
+
+// lockLink reads the link in tx, which is on the primary, and keeps others
+// from changing it until tx ends.
+func lockLink(ctx context.Context, tx *sql.Tx, domain, alias string) (storage.Link, error) {
+	l, err := scanLink(tx.QueryRowContext(ctx, `SELECT `+linkColumns+` FROM url WHERE domain = $1 AND alias = $2 FOR UPDATE OF url;`,
+		domain, alias))
+	if errors.Is(err, sql.ErrNoRows) {
+		return storage.Link{}, storage.ErrAliasNotFound
+	}
+	return l, err
+}
 
-	return id, tx.Commit()
+func setTags(ctx context.Context, tx *sql.Tx, urlID int64, tags []string) error {
+	if len(tags) == 0 {
+		return nil
+	}
+	_, err := tx.ExecContext(ctx, `INSERT INTO link_tags (url_id, tag) SELECT $1, unnest($2::text[]) ON CONFLICT DO NOTHING;`,
+		urlID, pq.Array(tags))
+	return err
 }
 
-func (s *Storage) GetURL(alias string) (string, error) {
-	const op = "storage.postgres.GetURL"
+// ownedBy matches the personal links of $1 when $2 is 0, and the links of workspace $2 otherwise.
+const ownedBy = `(($2 = 0 AND url.workspace_id IS NULL AND url.createdBy = $1) OR url.workspace_id = $2)`
 
-	row := s.db.QueryRow(`SELECT url FROM url WHERE alias = $1;`, alias)
+// Links lists the personal links of uid, or the links of f.WorkspaceID, newest first.
+func (s *Storage) Links(ctx context.Context, uid int64, f storage.LinkFilter) ([]storage.Link, error) {
+	const op = "storage.postgres.Links"
+	ctx, cancel := s.withTimeout(ctx)
+	defer cancel()
 
-	var url string
-	err := row.Scan(&url)
+	db, _ := s.reader(ownerKey(uid, f.WorkspaceID))
+	rows, err := db.QueryContext(ctx, `SELECT `+linkColumns+`, `+healthColumn+` FROM url
+		WHERE `+ownedBy+`
+			AND ($3 = '' OR EXISTS (SELECT 1 FROM link_tags t WHERE t.url_id = url.id AND t.tag = $3))
+			AND (NOT $6 OR EXISTS (SELECT 1 FROM link_health h WHERE h.url_id = url.id AND h.broken))
+		ORDER BY created_at DESC, id DESC
+		LIMIT $4 OFFSET $5;`, uid, f.WorkspaceID, f.Tag, f.Limit, f.Offset, f.Broken)
+	if err != nil {
+		return nil, fmt.Errorf("%s: %w", op, err)
+	}
+	defer rows.Close()
+
+	res := []storage.Link{}
+	for rows.Next() {
+		var health []byte
+		l, err := scanLink(rows, &health)
+		if err != nil {
+			return nil, fmt.Errorf("%s: %w", op, err)
+		}
+		if health != nil {
+			if err = json.Unmarshal(health, &l.Health); err != nil {
+				return nil, fmt.Errorf("%s: invalid health: %w", op, err)
+			}
+		}
+		res = append(res, l)
+	}
+	if err = rows.Err(); err != nil {
+		return nil, fmt.Errorf("%s: %w", op, err)
+	}
+	return res, nil
+}
+
+// TagCounts returns how many of the links listed by Links carry each tag, most used first.
+func (s *Storage) TagCounts(ctx context.Context, uid, workspaceID int64) ([]storage.TagCount, error) {
+	const op = "storage.postgres.TagCounts"
+	ctx, cancel := s.withTimeout(ctx)
+	defer cancel()
+
+	db, _ := s.reader(ownerKey(uid, workspaceID))
+	rows, err := db.QueryContext(ctx, `SELECT t.tag, count(*) FROM link_tags t JOIN url ON url.id = t.url_id
+		WHERE `+ownedBy+`
+		GROUP BY t.tag
+		ORDER BY count(*) DESC, t.tag;`, uid, workspaceID)
+	if err != nil {
+		return nil, fmt.Errorf("%s: %w", op, err)
+	}
+	defer rows.Close()
+
+	res := []storage.TagCount{}
+	for rows.Next() {
+		var tc storage.TagCount
+		if err = rows.Scan(&tc.Tag, &tc.Count); err != nil {
+			return nil, fmt.Errorf("%s: %w", op, err)
+		}
+		res = append(res, tc)
+	}
+	if err = rows.Err(); err != nil {
+		return nil, fmt.Errorf("%s: %w", op, err)
+	}
+	return res, nil
+}
+
+func (s *Storage) GetLink(ctx context.Context, domain, alias string) (storage.Link, error) {
+	const op = "storage.postgres.GetLink"
+	ctx, cancel := s.withTimeout(ctx)
+	defer cancel()
+
+	db, replica := s.reader(linkKey(domain, alias))
+	l, err := scanLink(db.QueryRowContext(ctx, `SELECT `+linkColumns+` FROM url WHERE domain = $1 AND alias = $2;`, domain, alias))
+	if replica && errors.Is(err, sql.ErrNoRows) {
+		// the link may have been created on another instance and not replicated yet
+		l, err = scanLink(s.db.QueryRowContext(ctx, `SELECT `+linkColumns+` FROM url WHERE domain = $1 AND alias = $2;`, domain, alias))
+	}
 	if err != nil {
 		if errors.Is(err, sql.ErrNoRows) {
-			return "", fmt.Errorf("%s: %w", op, storage.ErrAliasNotFound)
+			return storage.Link{}, fmt.Errorf("%s: %w", op, storage.ErrAliasNotFound)
 		}
 
-		return "", fmt.Errorf("%s: %w", op, err)
+		return storage.Link{}, fmt.Errorf("%s: %w", op, err)
 	}
 
-	return url, nil
+	return l, nil
 }
 
-func (s *Storage) DeleteURL(alias string) error {
-	const op = "storage.DeleteURL"
-	tx, err := s.db.Begin()
+// CountClick records that the link was followed, unless it has used up its
+// max clicks, in which case it returns storage.ErrLinkExhausted; so it does if
+// the link no longer exists. Concurrent clicks wait for each other on the row,
+// so a limited link is never followed more than max_clicks times.
+// Reaching a power of ten records a link.clicks event and using up the last
+// click a link.expired event in the same statement.
+func (s *Storage) CountClick(ctx context.Context, domain, alias string) error {
+	const op = "storage.postgres.CountClick"
+	ctx, cancel := s.withTimeout(ctx)
+	defer cancel()
+	var n int
+	err := s.db.QueryRowContext(ctx, `WITH c AS (
+			UPDATE url SET clicks = clicks + 1
+			WHERE domain = $1 AND alias = $2 AND (max_clicks IS NULL OR clicks < max_clicks)
+			RETURNING id, domain, alias, url, createdBy, workspace_id, clicks, max_clicks
+		), events AS (
+			INSERT INTO webhook_outbox (event, created_by, workspace_id, payload)
+			SELECT e.event, createdBy, workspace_id, jsonb_build_object(
+				'event', e.event,
+				'occurred_at', now(),
+				'link', jsonb_build_object('id', id, 'domain', domain, 'alias', alias, 'url', url,
+					'created_by', createdBy, 'workspace_id', COALESCE(workspace_id, 0), 'clicks', clicks,
+					'max_clicks', COALESCE(max_clicks, 0)))
+			FROM c, LATERAL (VALUES
+				($3::text, clicks >= 10 AND clicks::text ~ '^10+$'),
+				($4::text, clicks = max_clicks)
+			) AS e(event, due)
+			WHERE e.due
+		)
+		SELECT count(*) FROM c;`, domain, alias, storage.EventLinkClicks, storage.EventLinkExpired).Scan(&n)
+	if err != nil {
+		return fmt.Errorf("%s: %w", op, err)
+	}
+	if n == 0 {
+		return fmt.Errorf("%s: %w", op, storage.ErrLinkExhausted)
+	}
+	return nil
+}
+
+// ExpireLinks records a link.expired event for up to limit links whose
+// not_after has passed, once per link, and returns how many it expired.
+// Links that had already used up their max clicks got theirs then.
+func (s *Storage) ExpireLinks(ctx context.Context, limit int) (int64, error) {
+	const op = "storage.postgres.ExpireLinks"
+	ctx, cancel := s.withTimeout(ctx)
+	defer cancel()
+	var n int64
+	err := s.db.QueryRowContext(ctx, `WITH e AS (
+			UPDATE url SET expired_at = now()
+			WHERE id IN (
+				SELECT id FROM url WHERE not_after <= now() AND expired_at IS NULL
+				ORDER BY not_after LIMIT $1 FOR UPDATE SKIP LOCKED
+			)
+			RETURNING id, domain, alias, url, createdBy, workspace_id, clicks, max_clicks, not_before, not_after
+		), events AS (
+			INSERT INTO webhook_outbox (event, created_by, workspace_id, payload)
+			SELECT $2::text, createdBy, workspace_id, jsonb_build_object(
+				'event', $2::text,
+				'occurred_at', now(),
+				'link', jsonb_build_object('id', id, 'domain', domain, 'alias', alias, 'url', url,
+					'created_by', createdBy, 'workspace_id', COALESCE(workspace_id, 0), 'clicks', clicks,
+					'max_clicks', COALESCE(max_clicks, 0), 'not_before', not_before, 'not_after', not_after))
+			FROM e
+			WHERE max_clicks IS NULL OR clicks < max_clicks
+		)
+		SELECT count(*) FROM e;`, limit, storage.EventLinkExpired).Scan(&n)
+	if err != nil {
+		return 0, fmt.Errorf("%s: %w", op, err)
+	}
+	return n, nil
+}
+
+// writeEvent records an event about the link with the given id in the outbox,
+// as part of the transaction that changed it.
+func writeEvent(ctx context.Context, tx *sql.Tx, event string, id int64) error {
+	l, err := scanLink(tx.QueryRowContext(ctx, `SELECT `+linkColumns+` FROM url WHERE id = $1;`, id))
+	if err != nil {
+		return fmt.Errorf("failed to read link for %s: %w", event, err)
+	}
+	return writeLinkEvent(ctx, tx, event, l)
+}
+
+func writeLinkEvent(ctx context.Context, tx *sql.Tx, event string, l storage.Link) error {
+	payload, err := json.Marshal(storage.Event{Type: event, OccurredAt: time.Now().UTC(), Link: l})
+	if err != nil {
+		return err
+	}
+	_, err = tx.ExecContext(ctx, `INSERT INTO webhook_outbox (event, created_by, workspace_id, payload) VALUES ($1, $2, NULLIF($3, 0), $4);`,
+		event, l.CreatedBy, l.WorkspaceID, payload)
+	return err
+}
+
+func marshalRules(set rules.Set) ([]byte, error) {
+	if set == nil {
+		set = rules.Set{}
+	}
+	return json.Marshal(set)
+}
+
+// DeleteLink deletes the link once check has returned nil for it, locked on
+// the primary in the same transaction. Like the edit of UpdateLink, check
+// doesn't query anything.
+func (s *Storage) DeleteLink(ctx context.Context, domain, alias string, check func(link storage.Link) error) error {
+	const op = "storage.postgres.DeleteLink"
+	ctx, cancel := s.withTimeout(ctx)
+	defer cancel()
+	tx, err := s.db.BeginTx(ctx, nil)
+	if err != nil {
+		return fmt.Errorf("%s: %w", op, err)
+	}
 	defer tx.Rollback()
+
+	l, err := lockLink(ctx, tx, domain, alias)
 	if err != nil {
 		return fmt.Errorf("%s: %w", op, err)
 	}
-	_, err = tx.ExecContext(context.Background(), "DELETE FROM url WHERE alias = $1", alias)
+	if err = check(l); err != nil {
+		return fmt.Errorf("%s: %w", op, err)
+	}
+	if _, err = tx.ExecContext(ctx, `DELETE FROM url WHERE id = $1;`, l.ID); err != nil {
+		return fmt.Errorf("%s: %w", op, err)
+	}
+	if err = writeLinkEvent(ctx, tx, storage.EventLinkDeleted, l); err != nil {
+		return fmt.Errorf("%s: %w", op, err)
+	}
+	if err = tx.Commit(); err != nil {
+		return fmt.Errorf("%s: %w", op, err)
+	}
+	s.markWritten(linkKey(domain, alias), ownerKey(l.CreatedBy, l.WorkspaceID))
+	return nil
+}
+
+// TransferLink hands the link over to uid, or to workspaceID when it isn't 0,
+// once check has returned nil for it, locked on the primary in the same
+// transaction; like the edit of UpdateLink, check doesn't query anything.
+// Links moved into a workspace keep their creator. A new owner without room
+// for another active link in their quota, defaults overridden by their
+// QuotaOverride, gets a *storage.QuotaError.
+func (s *Storage) TransferLink(ctx context.Context, domain, alias string, uid, workspaceID int64, defaults storage.Quota,
+	check func(link storage.Link) error) error {
+	const op = "storage.postgres.TransferLink"
+	ctx, cancel := s.withTimeout(ctx)
+	defer cancel()
+
+	tx, err := s.db.BeginTx(ctx, nil)
+	if err != nil {
+		return fmt.Errorf("%s: %w", op, err)
+	}
+	defer tx.Rollback()
+
+	l, err := lockLink(ctx, tx, domain, alias)
 	if err != nil {
 		return fmt.Errorf("%s: %w", op, err)
 	}
-	return tx.Commit()
+	if err = check(l); err != nil {
+		return fmt.Errorf("%s: %w", op, err)
+	}
+	newUID := uid
+	if workspaceID != 0 {
+		newUID = l.CreatedBy
+	}
+	if ownerKey(newUID, workspaceID) != ownerKey(l.CreatedBy, l.WorkspaceID) {
+		if err = checkActiveLinks(ctx, tx, newUID, workspaceID, defaults); err != nil {
+			return fmt.Errorf("%s: %w", op, err)
+		}
+	}
+	_, err = tx.ExecContext(ctx, `UPDATE url SET
+			createdBy = CASE WHEN $3 = 0 THEN $2 ELSE createdBy END,
+			workspace_id = NULLIF($3, 0)
+		WHERE id = $1;`, l.ID, uid, workspaceID)
+	if err != nil {
+		return fmt.Errorf("%s: %w", op, err)
+	}
+	// delivered to the new owner's webhooks
+	if err = writeEvent(ctx, tx, storage.EventLinkUpdated, l.ID); err != nil {
+		return fmt.Errorf("%s: %w", op, err)
+	}
+	if err = tx.Commit(); err != nil {
+		return fmt.Errorf("%s: %w", op, err)
+	}
+	s.markWritten(linkKey(domain, alias), ownerKey(l.CreatedBy, l.WorkspaceID), ownerKey(newUID, workspaceID))
+	return nil
 }
 
-func (s *Storage) Creator(alias string) (int64, error) {
-	const op = "storage.Creator"
+func (s *Storage) SaveDomain(ctx context.Context, d storage.Domain) (int64, error) {
+	const op = "storage.postgres.SaveDomain"
+	ctx, cancel := s.withTimeout(ctx)
+	defer cancel()
+
+	var id int64
+	err := s.db.QueryRowContext(ctx, `INSERT INTO domains (host, fallback_url, interstitial) VALUES ($1, $2, $3) RETURNING id;`,
+		d.Host, d.FallbackURL, d.Interstitial).Scan(&id)
+	if err != nil {
+		if pqErr, ok := err.(*pq.Error); ok && pqErr.Code.Name() == "unique_violation" {
+			return 0, fmt.Errorf("%s: %w", op, storage.ErrDomainExists)
+		}
+		return 0, fmt.Errorf("%s: %w", op, err)
+	}
+	s.markWritten(domainsKey)
+	return id, nil
+}
+
+func (s *Storage) Domains(ctx context.Context) ([]storage.Domain, error) {
+	const op = "storage.postgres.Domains"
+	ctx, cancel := s.withTimeout(ctx)
+	defer cancel()
+
+	db, _ := s.reader(domainsKey)
+	rows, err := db.QueryContext(ctx, `SELECT id, host, fallback_url, interstitial FROM domains ORDER BY host;`)
+	if err != nil {
+		return nil, fmt.Errorf("%s: %w", op, err)
+	}
+	defer rows.Close()
+
+	var res []storage.Domain
+	for rows.Next() {
+		var d storage.Domain
+		if err = rows.Scan(&d.ID, &d.Host, &d.FallbackURL, &d.Interstitial); err != nil {
+			return nil, fmt.Errorf("%s: %w", op, err)
+		}
+		res = append(res, d)
+	}
+	if err = rows.Err(); err != nil {
+		return nil, fmt.Errorf("%s: %w", op, err)
+	}
+	return res, nil
+}
 
-	row := s.db.QueryRow(`SELECT createdBy FROM url WHERE alias = $1;`, alias)
+// DeleteDomain removes a domain that no longer has any links.
+func (s *Storage) DeleteDomain(ctx context.Context, id int64) error {
+	const op = "storage.postgres.DeleteDomain"
+	ctx, cancel := s.withTimeout(ctx)
+	defer cancel()
+	tx, err := s.db.BeginTx(ctx, nil)
+	if err != nil {
+		return fmt.Errorf("%s: %w", op, err)
+	}
+	defer tx.Rollback()
 
-	var uid int64
-	err := row.Scan(&uid)
+	var host string
+	err = tx.QueryRowContext(ctx, `SELECT host FROM domains WHERE id = $1 FOR UPDATE;`, id).Scan(&host)
 	if err != nil {
 		if errors.Is(err, sql.ErrNoRows) {
-			return 0, fmt.Errorf("%s: %w", op, storage.ErrAliasNotFound)
+			return fmt.Errorf("%s: %w", op, storage.ErrDomainNotFound)
 		}
+		return fmt.Errorf("%s: %w", op, err)
+	}
 
-		return 0, fmt.Errorf("%s: %w", op, err)
+	var inUse bool
+	err = tx.QueryRowContext(ctx, `SELECT EXISTS (SELECT 1 FROM url WHERE domain = $1);`, host).Scan(&inUse)
+	if err != nil {
+		return fmt.Errorf("%s: %w", op, err)
+	}
+	if inUse {
+		return fmt.Errorf("%s: %w", op, storage.ErrDomainInUse)
 	}
 
-	return uid, nil
+	if _, err = tx.ExecContext(ctx, `DELETE FROM domains WHERE id = $1;`, id); err != nil {
+		return fmt.Errorf("%s: %w", op, err)
+	}
+	if err = tx.Commit(); err != nil {
+		return fmt.Errorf("%s: %w", op, err)
+	}
+	s.markWritten(domainsKey)
+	return nil
 }
 
 func (s *Storage) Close() error {
+	for _, r := range s.replicas {
+		_ = r.db.Close()
+	}
 	return s.db.Close()
 }
@@ -0,0 +1,187 @@
+package postgres
+
+import (
+	"context"
+	"database/sql"
+	"errors"
+	"fmt"
+	"github.com/kxddry/url-shortener/internal/storage"
+	"time"
+)
+
+// rowQuerier is either the database or a transaction.
+type rowQuerier interface {
+	QueryRowContext(ctx context.Context, query string, args ...any) *sql.Row
+}
+
+// quotaKey is how the owner of links is keyed in quotas and link_usage:
+// users by (uid, 0) and workspaces by (0, workspaceID).
+func quotaKey(uid, workspaceID int64) (int64, int64) {
+	if workspaceID != 0 {
+		return 0, workspaceID
+	}
+	return uid, 0
+}
+
+// SaveLinkWithin saves the link like SaveLink, unless that would exceed the
+// quota of its owner, defaults overridden by the owner's QuotaOverride, in
+// which case it returns a *storage.QuotaError. customAlias counts the link
+// against the custom aliases of the month. Links of the same owner are
+// created one at a time, so concurrent requests can't exceed the quota.
+func (s *Storage) SaveLinkWithin(ctx context.Context, link storage.Link, defaults storage.Quota, customAlias bool) (int64, error) {
+	return s.saveLink(ctx, link, func(ctx context.Context, tx *sql.Tx) error {
+		user, workspace := quotaKey(link.CreatedBy, link.WorkspaceID)
+		if err := lockQuota(ctx, tx, link.CreatedBy, link.WorkspaceID); err != nil {
+			return err
+		}
+		o, err := quotaOverride(ctx, tx, link.CreatedBy, link.WorkspaceID)
+		if err != nil {
+			return err
+		}
+		q := o.Apply(defaults)
+		u, err := usage(ctx, tx, link.CreatedBy, link.WorkspaceID)
+		if err != nil {
+			return err
+		}
+		if err = q.Check(u, customAlias, time.Now()); err != nil {
+			return err
+		}
+
+		custom := 0
+		if customAlias {
+			custom = 1
+		}
+		_, err = tx.ExecContext(ctx, `INSERT INTO link_usage (user_id, workspace_id, day, links, custom_aliases)
+			VALUES ($1, $2, (now() AT TIME ZONE 'UTC')::date, 1, $3)
+			ON CONFLICT (user_id, workspace_id, day) DO UPDATE
+			SET links = link_usage.links + 1, custom_aliases = link_usage.custom_aliases + $3;`, user, workspace, custom)
+		return err
+	})
+}
+
+// lockQuota makes changes to the links of the owner wait for each other
+// until tx ends, so that concurrent ones can't exceed the quota.
+func lockQuota(ctx context.Context, tx *sql.Tx, uid, workspaceID int64) error {
+	user, workspace := quotaKey(uid, workspaceID)
+	_, err := tx.ExecContext(ctx, `SELECT pg_advisory_xact_lock(hashtext('quota'), hashtext($1::text || '/' || $2::text));`,
+		user, workspace)
+	return err
+}
+
+// checkActiveLinks returns a *storage.QuotaError if the owner has no room for
+// another active link. It takes the owner's quota lock for the rest of tx.
+func checkActiveLinks(ctx context.Context, tx *sql.Tx, uid, workspaceID int64, defaults storage.Quota) error {
+	if err := lockQuota(ctx, tx, uid, workspaceID); err != nil {
+		return err
+	}
+	o, err := quotaOverride(ctx, tx, uid, workspaceID)
+	if err != nil {
+		return err
+	}
+	u, err := usage(ctx, tx, uid, workspaceID)
+	if err != nil {
+		return err
+	}
+	// links that change hands aren't created, so only the active links count
+	return storage.Quota{Links: o.Apply(defaults).Links}.Check(u, false, time.Now())
+}
+
+// Usage returns the quota of the personal links of uid, or of the links of
+// workspaceID, and how much of it is used.
+func (s *Storage) Usage(ctx context.Context, uid, workspaceID int64, defaults storage.Quota) (storage.Quota, storage.Usage, error) {
+	const op = "storage.postgres.Usage"
+	ctx, cancel := s.withTimeout(ctx)
+	defer cancel()
+
+	o, err := quotaOverride(ctx, s.db, uid, workspaceID)
+	if err != nil {
+		return storage.Quota{}, storage.Usage{}, fmt.Errorf("%s: %w", op, err)
+	}
+	u, err := usage(ctx, s.db, uid, workspaceID)
+	if err != nil {
+		return storage.Quota{}, storage.Usage{}, fmt.Errorf("%s: %w", op, err)
+	}
+	return o.Apply(defaults), u, nil
+}
+
+func usage(ctx context.Context, db rowQuerier, uid, workspaceID int64) (storage.Usage, error) {
+	user, workspace := quotaKey(uid, workspaceID)
+	var u storage.Usage
+	err := db.QueryRowContext(ctx, `SELECT
+			(SELECT count(*) FROM url WHERE `+ownedBy+`
+				AND (not_after IS NULL OR not_after > now()) AND (max_clicks IS NULL OR clicks < max_clicks)),
+			COALESCE((SELECT links FROM link_usage
+				WHERE user_id = $3 AND workspace_id = $4 AND day = (now() AT TIME ZONE 'UTC')::date), 0),
+			COALESCE((SELECT sum(custom_aliases) FROM link_usage
+				WHERE user_id = $3 AND workspace_id = $4 AND day >= date_trunc('month', now() AT TIME ZONE 'UTC')::date), 0);`,
+		uid, workspaceID, user, workspace).Scan(&u.Links, &u.LinksToday, &u.CustomAliasesThisMonth)
+	return u, err
+}
+
+// QuotaOverride returns the override of the quota of the personal links of
+// uid, or of the links of workspaceID, with no limits if there is none.
+func (s *Storage) QuotaOverride(ctx context.Context, uid, workspaceID int64) (storage.QuotaOverride, error) {
+	const op = "storage.postgres.QuotaOverride"
+	ctx, cancel := s.withTimeout(ctx)
+	defer cancel()
+
+	o, err := quotaOverride(ctx, s.db, uid, workspaceID)
+	if err != nil {
+		return storage.QuotaOverride{}, fmt.Errorf("%s: %w", op, err)
+	}
+	return o, nil
+}
+
+func quotaOverride(ctx context.Context, db rowQuerier, uid, workspaceID int64) (storage.QuotaOverride, error) {
+	user, workspace := quotaKey(uid, workspaceID)
+	o := storage.QuotaOverride{UserID: user, WorkspaceID: workspace}
+	err := db.QueryRowContext(ctx, `SELECT links, links_per_day, custom_aliases_per_month FROM quotas
+		WHERE user_id = $1 AND workspace_id = $2;`, user, workspace).Scan(&o.Links, &o.LinksPerDay, &o.CustomAliasesPerMonth)
+	if errors.Is(err, sql.ErrNoRows) {
+		return o, nil
+	}
+	return o, err
+}
+
+// SetQuotaOverride replaces the override of o.UserID's personal links, or of
+// the links of o.WorkspaceID.
+func (s *Storage) SetQuotaOverride(ctx context.Context, o storage.QuotaOverride) error {
+	const op = "storage.postgres.SetQuotaOverride"
+	ctx, cancel := s.withTimeout(ctx)
+	defer cancel()
+
+	user, workspace := quotaKey(o.UserID, o.WorkspaceID)
+	if workspace != 0 {
+		var exists bool
+		err := s.db.QueryRowContext(ctx, `SELECT EXISTS (SELECT 1 FROM workspaces WHERE id = $1);`, workspace).Scan(&exists)
+		if err != nil {
+			return fmt.Errorf("%s: %w", op, err)
+		}
+		if !exists {
+			return fmt.Errorf("%s: %w", op, storage.ErrWorkspaceNotFound)
+		}
+	}
+	_, err := s.db.ExecContext(ctx, `INSERT INTO quotas (user_id, workspace_id, links, links_per_day, custom_aliases_per_month)
+		VALUES ($1, $2, $3, $4, $5)
+		ON CONFLICT (user_id, workspace_id) DO UPDATE
+		SET links = $3, links_per_day = $4, custom_aliases_per_month = $5;`,
+		user, workspace, o.Links, o.LinksPerDay, o.CustomAliasesPerMonth)
+	if err != nil {
+		return fmt.Errorf("%s: %w", op, err)
+	}
+	return nil
+}
+
+// DeleteQuotaOverride restores the configured quota of the personal links of
+// uid, or of the links of workspaceID.
+func (s *Storage) DeleteQuotaOverride(ctx context.Context, uid, workspaceID int64) error {
+	const op = "storage.postgres.DeleteQuotaOverride"
+	ctx, cancel := s.withTimeout(ctx)
+	defer cancel()
+
+	user, workspace := quotaKey(uid, workspaceID)
+	if _, err := s.db.ExecContext(ctx, `DELETE FROM quotas WHERE user_id = $1 AND workspace_id = $2;`, user, workspace); err != nil {
+		return fmt.Errorf("%s: %w", op, err)
+	}
+	return nil
+}
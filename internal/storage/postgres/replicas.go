@@ -0,0 +1,230 @@
+package postgres
+
+import (
+	"context"
+	"database/sql"
+	"database/sql/driver"
+	"fmt"
+	"github.com/XSAM/otelsql"
+	"github.com/kxddry/url-shortener/internal/config"
+	"github.com/kxddry/url-shortener/internal/lib/breaker"
+	"github.com/kxddry/url-shortener/internal/lib/logger/sl"
+	"github.com/kxddry/url-shortener/internal/lib/pqlinks"
+	"github.com/lib/pq"
+	semconv "go.opentelemetry.io/otel/semconv/v1.26.0"
+	"go.opentelemetry.io/otel/trace"
+	"log/slog"
+	"strconv"
+	"sync"
+	"sync/atomic"
+	"time"
+)
+
+type replica struct {
+	addr    string
+	db      *sql.DB
+	healthy atomic.Bool
+}
+
+// open connects to the database of cfg, through b unless it is nil.
+func open(cfg config.Storage, b *breaker.Breaker) (*sql.DB, error) {
+	var connector driver.Connector
+	connector, err := pq.NewConnector(pqlinks.DataSourceName(cfg))
+	if err != nil {
+		return nil, err
+	}
+	if b != nil {
+		connector = breakerConnector{Connector: connector, b: b}
+	}
+	db := otelsql.OpenDB(connector,
+		otelsql.WithAttributes(semconv.DBSystemPostgreSQL, semconv.ServerAddress(cfg.Host), semconv.ServerPort(cfg.Port)),
+		otelsql.WithSpanOptions(otelsql.SpanOptions{
+			OmitConnResetSession: true,
+			OmitRows:             true,
+			DisableErrSkip:       true,
+			// only queries made on behalf of a traced request or job, not health checks
+			SpanFilter: func(ctx context.Context, _ otelsql.Method, _ string, _ []driver.NamedValue) bool {
+				return trace.SpanContextFromContext(ctx).IsValid()
+			},
+		}))
+	db.SetMaxOpenConns(cfg.Pool.MaxOpenConns)
+	db.SetMaxIdleConns(cfg.Pool.MaxIdleConns)
+	db.SetConnMaxLifetime(cfg.Pool.ConnMaxLifetime)
+	db.SetConnMaxIdleTime(cfg.Pool.ConnMaxIdleTime)
+	return db, nil
+}
+
+func openReplicas(cfg config.Storage) ([]*replica, error) {
+	res := make([]*replica, 0, len(cfg.Replicas))
+	for _, rc := range cfg.Replicas {
+		c := cfg
+		c.Host, c.Port = rc.Host, rc.Port
+		db, err := open(c, nil)
+		if err != nil {
+			for _, r := range res {
+				_ = r.db.Close()
+			}
+			return nil, err
+		}
+		res = append(res, &replica{addr: rc.Host + ":" + strconv.Itoa(rc.Port), db: db})
+	}
+	return res, nil
+}
+
+// reader returns the connection for a read-only query. Queries about something
+// this instance has just written (see markWritten) go to the primary, the rest
+// go round-robin to the healthy replicas. Without healthy replicas everything
+// goes to the primary. While the primary is down, a stale answer beats none.
+func (s *Storage) reader(keys ...string) (db *sql.DB, replica bool) {
+	if len(s.replicas) == 0 || s.recent.has(keys...) && s.breaker.State() == breaker.Closed {
+		return s.db, false
+	}
+	n := uint64(len(s.replicas))
+	start := s.next.Add(1)
+	for i := range n {
+		if r := s.replicas[(start+i)%n]; r.healthy.Load() {
+			return r.db, true
+		}
+	}
+	return s.db, false
+}
+
+// markWritten sends reads about keys to the primary for a while,
+// so that replication lag doesn't hide what was just written.
+func (s *Storage) markWritten(keys ...string) {
+	if len(s.replicas) == 0 {
+		return
+	}
+	s.recent.mark(keys...)
+}
+
+func linkKey(domain, alias string) string {
+	return "link:" + domain + "/" + alias
+}
+
+// ownerKey identifies the links of a user, or of a workspace when workspaceID isn't 0.
+func ownerKey(uid, workspaceID int64) string {
+	if workspaceID != 0 {
+		return "workspace:" + strconv.FormatInt(workspaceID, 10)
+	}
+	return "user:" + strconv.FormatInt(uid, 10)
+}
+
+const domainsKey = "domains"
+
+// RunHealthChecks checks the primary and the replicas every interval until
+// ctx is done. Pinging the primary lets its breaker close again without
+// waiting for a request to try it. A replica is healthy if it answers and is
+// at most maxLag behind the primary.
+func (s *Storage) RunHealthChecks(ctx context.Context, log *slog.Logger, interval, maxLag time.Duration) {
+	log = log.With(slog.String("component", "storage/postgres"))
+	s.breaker.OnChange(func(from, to breaker.State) {
+		switch to {
+		case breaker.Open:
+			log.Error("primary is unreachable, serving read-only", slog.String("breaker", to.String()))
+		case breaker.Closed:
+			log.Info("primary is reachable again", slog.String("breaker", to.String()))
+		default:
+			log.Info("trying the primary again", slog.String("breaker", to.String()))
+		}
+	})
+	s.checkReplicas(ctx, log, maxLag)
+
+	t := time.NewTicker(interval)
+	defer t.Stop()
+	for {
+		select {
+		case <-ctx.Done():
+			return
+		case <-t.C:
+			s.checkPrimary(ctx)
+			s.checkReplicas(ctx, log, maxLag)
+		}
+	}
+}
+
+// checkPrimary pings the primary, which is recorded by the breaker.
+func (s *Storage) checkPrimary(ctx context.Context) {
+	ctx, cancel := context.WithTimeout(ctx, 2*time.Second)
+	defer cancel()
+	_ = s.db.PingContext(ctx)
+}
+
+func (s *Storage) checkReplicas(ctx context.Context, log *slog.Logger, maxLag time.Duration) {
+	for _, r := range s.replicas {
+		err := checkReplica(ctx, r.db, maxLag)
+		healthy := err == nil
+		if r.healthy.Swap(healthy) != healthy {
+			if healthy {
+				log.Info("replica is back in rotation", slog.String("replica", r.addr))
+			} else {
+				log.Warn("replica taken out of rotation", slog.String("replica", r.addr), sl.Err(err))
+			}
+		}
+	}
+}
+
+func checkReplica(ctx context.Context, db *sql.DB, maxLag time.Duration) error {
+	ctx, cancel := context.WithTimeout(ctx, 2*time.Second)
+	defer cancel()
+
+	// an idle primary doesn't advance the replay timestamp,
+	// so a replica that replayed everything it received isn't lagging
+	var lag float64
+	err := db.QueryRowContext(ctx, `SELECT CASE
+			WHEN NOT pg_is_in_recovery() OR pg_last_wal_receive_lsn() = pg_last_wal_replay_lsn() THEN 0
+			ELSE COALESCE(EXTRACT(EPOCH FROM now() - pg_last_xact_replay_timestamp()), 0)
+		END;`).Scan(&lag)
+	if err != nil {
+		return err
+	}
+	if d := time.Duration(lag * float64(time.Second)); d > maxLag {
+		return fmt.Errorf("replication lag %s exceeds %s", d.Round(time.Millisecond), maxLag)
+	}
+	return nil
+}
+
+// recentWrites remembers keys for a window after they were written.
+type recentWrites struct {
+	window time.Duration
+	mu     sync.Mutex
+	keys   map[string]time.Time
+	marks  int
+}
+
+func (w *recentWrites) mark(keys ...string) {
+	if w.window <= 0 {
+		return
+	}
+	now := time.Now()
+	w.mu.Lock()
+	defer w.mu.Unlock()
+	if w.keys == nil {
+		w.keys = make(map[string]time.Time)
+	}
+	for _, k := range keys {
+		w.keys[k] = now
+	}
+	// forget expired keys every now and then, so the map doesn't grow forever
+	if w.marks++; w.marks%1000 == 0 {
+		for k, t := range w.keys {
+			if now.Sub(t) > w.window {
+				delete(w.keys, k)
+			}
+		}
+	}
+}
+
+func (w *recentWrites) has(keys ...string) bool {
+	if w.window <= 0 {
+		return false
+	}
+	w.mu.Lock()
+	defer w.mu.Unlock()
+	for _, k := range keys {
+		if t, ok := w.keys[k]; ok && time.Since(t) <= w.window {
+			return true
+		}
+	}
+	return false
+}
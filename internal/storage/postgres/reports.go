@@ -0,0 +1,207 @@
+package postgres
+
+import (
+	"context"
+	"database/sql"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"github.com/kxddry/url-shortener/internal/storage"
+	"time"
+)
+
+// autoDisableReason is the disabled_reason of links disabled by AutoDisable.
+const autoDisableReason = "reported by visitors, pending review"
+
+// Report files r against the link, and disables the link for auto.For once
+// auto.After distinct reporters have pending reports against it. A reporter
+// counts once per link until the reports are resolved. It returns the link
+// and whether this report disabled it.
+func (s *Storage) Report(ctx context.Context, domain, alias string, r storage.Report, auto storage.AutoDisable) (storage.Link, bool, error) {
+	const op = "storage.postgres.Report"
+	ctx, cancel := s.withTimeout(ctx)
+	defer cancel()
+
+	tx, err := s.db.BeginTx(ctx, nil)
+	if err != nil {
+		return storage.Link{}, false, fmt.Errorf("%s: %w", op, err)
+	}
+	defer tx.Rollback()
+
+	// the row lock serializes the reports of the link, so that it is counted once
+	var disabled bool
+	err = tx.QueryRowContext(ctx, `SELECT id, `+disabledNow+` FROM url WHERE domain = $1 AND alias = $2 FOR UPDATE;`,
+		domain, alias).Scan(&r.LinkID, &disabled)
+	if errors.Is(err, sql.ErrNoRows) {
+		return storage.Link{}, false, fmt.Errorf("%s: %w", op, storage.ErrAliasNotFound)
+	}
+	if err != nil {
+		return storage.Link{}, false, fmt.Errorf("%s: %w", op, err)
+	}
+
+	_, err = tx.ExecContext(ctx, `INSERT INTO reports (url_id, reason, comment, reporter) VALUES ($1, $2, $3, $4)
+		ON CONFLICT (url_id, reporter) WHERE resolved_at IS NULL DO NOTHING;`, r.LinkID, r.Reason, r.Comment, r.Reporter)
+	if err != nil {
+		return storage.Link{}, false, fmt.Errorf("%s: %w", op, err)
+	}
+
+	var reporters int
+	if auto.After > 0 && !disabled {
+		err = tx.QueryRowContext(ctx, `SELECT count(*) FROM reports WHERE url_id = $1 AND resolved_at IS NULL;`, r.LinkID).Scan(&reporters)
+		if err != nil {
+			return storage.Link{}, false, fmt.Errorf("%s: %w", op, err)
+		}
+	}
+	if auto.After == 0 || disabled || reporters < auto.After {
+		l, err := scanLink(tx.QueryRowContext(ctx, `SELECT `+linkColumns+` FROM url WHERE id = $1;`, r.LinkID))
+		if err != nil {
+			return storage.Link{}, false, fmt.Errorf("%s: %w", op, err)
+		}
+		if err = tx.Commit(); err != nil {
+			return storage.Link{}, false, fmt.Errorf("%s: %w", op, err)
+		}
+		return l, false, nil
+	}
+
+	l, err := scanLink(tx.QueryRowContext(ctx, `UPDATE url SET disabled_at = now(), disabled_reason = $2, disabled_until = $3
+		WHERE id = $1 RETURNING `+linkColumns+`;`, r.LinkID, autoDisableReason, time.Now().Add(auto.For)))
+	if err != nil {
+		return storage.Link{}, false, fmt.Errorf("%s: %w", op, err)
+	}
+	if err = writeLinkEvent(ctx, tx, storage.EventLinkUpdated, l); err != nil {
+		return storage.Link{}, false, fmt.Errorf("%s: %w", op, err)
+	}
+	if err = tx.Commit(); err != nil {
+		return storage.Link{}, false, fmt.Errorf("%s: %w", op, err)
+	}
+	s.markWritten(linkKey(domain, alias), ownerKey(l.CreatedBy, l.WorkspaceID))
+	return l, true, nil
+}
+
+// ReportQueue lists the links with pending reports, most reported first.
+func (s *Storage) ReportQueue(ctx context.Context, limit, offset int) ([]storage.ReportedLink, error) {
+	const op = "storage.postgres.ReportQueue"
+	ctx, cancel := s.withTimeout(ctx)
+	defer cancel()
+
+	rows, err := s.db.QueryContext(ctx, `SELECT `+linkColumns+`, q.reports, q.reasons, q.last_reported_at FROM url
+		JOIN (SELECT url_id, sum(n)::bigint AS reports, jsonb_object_agg(reason, n) AS reasons, max(last) AS last_reported_at
+			FROM (SELECT url_id, reason, count(*) AS n, max(created_at) AS last FROM reports
+				WHERE resolved_at IS NULL GROUP BY url_id, reason) r
+			GROUP BY url_id) q ON q.url_id = url.id
+		ORDER BY q.reports DESC, q.last_reported_at DESC
+		LIMIT $1 OFFSET $2;`, limit, offset)
+	if err != nil {
+		return nil, fmt.Errorf("%s: %w", op, err)
+	}
+	defer rows.Close()
+
+	res := []storage.ReportedLink{}
+	for rows.Next() {
+		var rl storage.ReportedLink
+		var reasons []byte
+		rl.Link, err = scanLink(rows, &rl.Reports, &reasons, &rl.LastReportedAt)
+		if err != nil {
+			return nil, fmt.Errorf("%s: %w", op, err)
+		}
+		if err = json.Unmarshal(reasons, &rl.Reasons); err != nil {
+			return nil, fmt.Errorf("%s: invalid reasons: %w", op, err)
+		}
+		res = append(res, rl)
+	}
+	if err = rows.Err(); err != nil {
+		return nil, fmt.Errorf("%s: %w", op, err)
+	}
+	return res, nil
+}
+
+// LinkReports lists the reports against linkID, resolved ones included, newest first.
+func (s *Storage) LinkReports(ctx context.Context, linkID int64, limit, offset int) ([]storage.Report, error) {
+	const op = "storage.postgres.LinkReports"
+	ctx, cancel := s.withTimeout(ctx)
+	defer cancel()
+
+	rows, err := s.db.QueryContext(ctx, `SELECT id, url_id, reason, comment, created_at, resolution, COALESCE(resolved_by, 0), resolved_at
+		FROM reports WHERE url_id = $1
+		ORDER BY id DESC
+		LIMIT $2 OFFSET $3;`, linkID, limit, offset)
+	if err != nil {
+		return nil, fmt.Errorf("%s: %w", op, err)
+	}
+	defer rows.Close()
+
+	res := []storage.Report{}
+	for rows.Next() {
+		var r storage.Report
+		if err = rows.Scan(&r.ID, &r.LinkID, &r.Reason, &r.Comment, &r.CreatedAt, &r.Resolution, &r.ResolvedBy, &r.ResolvedAt); err != nil {
+			return nil, fmt.Errorf("%s: %w", op, err)
+		}
+		res = append(res, r)
+	}
+	if err = rows.Err(); err != nil {
+		return nil, fmt.Errorf("%s: %w", op, err)
+	}
+	return res, nil
+}
+
+// DismissReports resolves the pending reports of the link as unfounded, by
+// the admin by, and lifts a temporary disable they caused. It returns the link.
+func (s *Storage) DismissReports(ctx context.Context, domain, alias string, by int64) (storage.Link, error) {
+	const op = "storage.postgres.DismissReports"
+	ctx, cancel := s.withTimeout(ctx)
+	defer cancel()
+
+	tx, err := s.db.BeginTx(ctx, nil)
+	if err != nil {
+		return storage.Link{}, fmt.Errorf("%s: %w", op, err)
+	}
+	defer tx.Rollback()
+
+	var id int64
+	err = tx.QueryRowContext(ctx, `SELECT id FROM url WHERE domain = $1 AND alias = $2 FOR UPDATE;`, domain, alias).Scan(&id)
+	if errors.Is(err, sql.ErrNoRows) {
+		return storage.Link{}, fmt.Errorf("%s: %w", op, storage.ErrAliasNotFound)
+	}
+	if err != nil {
+		return storage.Link{}, fmt.Errorf("%s: %w", op, err)
+	}
+	if err = resolveReports(ctx, tx, id, storage.ResolutionDismissed, by); err != nil {
+		return storage.Link{}, fmt.Errorf("%s: %w", op, err)
+	}
+
+	// links disabled by an admin stay disabled
+	l, err := scanLink(tx.QueryRowContext(ctx, `UPDATE url SET disabled_at = NULL, disabled_reason = '', disabled_until = NULL
+		WHERE id = $1 AND disabled_until IS NOT NULL RETURNING `+linkColumns+`;`, id))
+	switch {
+	case errors.Is(err, sql.ErrNoRows):
+		l, err = scanLink(tx.QueryRowContext(ctx, `SELECT `+linkColumns+` FROM url WHERE id = $1;`, id))
+	case err == nil:
+		err = writeLinkEvent(ctx, tx, storage.EventLinkUpdated, l)
+	}
+	if err != nil {
+		return storage.Link{}, fmt.Errorf("%s: %w", op, err)
+	}
+	if err = tx.Commit(); err != nil {
+		return storage.Link{}, fmt.Errorf("%s: %w", op, err)
+	}
+	s.markWritten(linkKey(domain, alias), ownerKey(l.CreatedBy, l.WorkspaceID))
+	return l, nil
+}
+
+// resolveReports resolves the pending reports of linkID. It returns
+// storage.ErrNoReports if there are none, unless resolution is disabled:
+// links are disabled whether they were reported or not.
+func resolveReports(ctx context.Context, tx *sql.Tx, linkID int64, resolution string, by int64) error {
+	res, err := tx.ExecContext(ctx, `UPDATE reports SET resolution = $2, resolved_by = $3, resolved_at = now()
+		WHERE url_id = $1 AND resolved_at IS NULL;`, linkID, resolution, by)
+	if err != nil {
+		return err
+	}
+	if resolution == storage.ResolutionDisabled {
+		return nil
+	}
+	if n, err := res.RowsAffected(); err == nil && n == 0 {
+		return storage.ErrNoReports
+	}
+	return nil
+}
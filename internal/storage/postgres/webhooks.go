@@ -0,0 +1,230 @@
+package postgres
+
+import (
+	"context"
+	"database/sql"
+	"errors"
+	"fmt"
+	"github.com/kxddry/url-shortener/internal/storage"
+	"github.com/lib/pq"
+	"time"
+)
+
+func (s *Storage) SaveWebhook(ctx context.Context, wh storage.Webhook) (storage.Webhook, error) {
+	const op = "storage.postgres.SaveWebhook"
+	ctx, cancel := s.withTimeout(ctx)
+	defer cancel()
+
+	if wh.Events == nil {
+		wh.Events = []string{}
+	}
+	err := s.db.QueryRowContext(ctx, `INSERT INTO webhooks (url, secret, events, created_by, workspace_id)
+		VALUES ($1, $2, $3, $4, NULLIF($5, 0)) RETURNING id, created_at;`,
+		wh.URL, wh.Secret, pq.Array(wh.Events), wh.CreatedBy, wh.WorkspaceID).Scan(&wh.ID, &wh.CreatedAt)
+	if err != nil {
+		return storage.Webhook{}, fmt.Errorf("%s: %w", op, err)
+	}
+	return wh, nil
+}
+
+const webhookColumns = `id, url, events, created_by, COALESCE(workspace_id, 0), created_at`
+
+func scanWebhook(row scanner) (storage.Webhook, error) {
+	var wh storage.Webhook
+	err := row.Scan(&wh.ID, &wh.URL, (*pq.StringArray)(&wh.Events), &wh.CreatedBy, &wh.WorkspaceID, &wh.CreatedAt)
+	return wh, err
+}
+
+// Webhook returns the webhook without its secret.
+func (s *Storage) Webhook(ctx context.Context, id int64) (storage.Webhook, error) {
+	const op = "storage.postgres.Webhook"
+	ctx, cancel := s.withTimeout(ctx)
+	defer cancel()
+
+	wh, err := scanWebhook(s.db.QueryRowContext(ctx, `SELECT `+webhookColumns+` FROM webhooks WHERE id = $1;`, id))
+	if err != nil {
+		if errors.Is(err, sql.ErrNoRows) {
+			return storage.Webhook{}, fmt.Errorf("%s: %w", op, storage.ErrWebhookNotFound)
+		}
+		return storage.Webhook{}, fmt.Errorf("%s: %w", op, err)
+	}
+	return wh, nil
+}
+
+// Webhooks lists the personal webhooks of uid, or those of workspaceID when it isn't 0.
+func (s *Storage) Webhooks(ctx context.Context, uid, workspaceID int64) ([]storage.Webhook, error) {
+	const op = "storage.postgres.Webhooks"
+	ctx, cancel := s.withTimeout(ctx)
+	defer cancel()
+
+	rows, err := s.db.QueryContext(ctx, `SELECT `+webhookColumns+` FROM webhooks
+		WHERE ($2 = 0 AND workspace_id IS NULL AND created_by = $1) OR workspace_id = $2
+		ORDER BY id;`, uid, workspaceID)
+	if err != nil {
+		return nil, fmt.Errorf("%s: %w", op, err)
+	}
+	defer rows.Close()
+
+	res := []storage.Webhook{}
+	for rows.Next() {
+		wh, err := scanWebhook(rows)
+		if err != nil {
+			return nil, fmt.Errorf("%s: %w", op, err)
+		}
+		res = append(res, wh)
+	}
+	if err = rows.Err(); err != nil {
+		return nil, fmt.Errorf("%s: %w", op, err)
+	}
+	return res, nil
+}
+
+// DeleteWebhook removes the webhook together with its delivery log.
+func (s *Storage) DeleteWebhook(ctx context.Context, id int64) error {
+	const op = "storage.postgres.DeleteWebhook"
+	ctx, cancel := s.withTimeout(ctx)
+	defer cancel()
+
+	res, err := s.db.ExecContext(ctx, `DELETE FROM webhooks WHERE id = $1;`, id)
+	if err != nil {
+		return fmt.Errorf("%s: %w", op, err)
+	}
+	n, err := res.RowsAffected()
+	if err != nil {
+		return fmt.Errorf("%s: %w", op, err)
+	}
+	if n == 0 {
+		return fmt.Errorf("%s: %w", op, storage.ErrWebhookNotFound)
+	}
+	return nil
+}
+
+const deliveryColumns = `id, webhook_id, event, payload, status, attempts, next_attempt_at,
+	last_status_code, last_response, last_error, delivered_at, created_at`
+
+func scanDelivery(row scanner, extra ...any) (storage.Delivery, error) {
+	var d storage.Delivery
+	var payload []byte
+	dest := append([]any{&d.ID, &d.WebhookID, &d.Event, &payload, &d.Status, &d.Attempts, &d.NextAttemptAt,
+		&d.LastStatusCode, &d.LastResponse, &d.LastError, &d.DeliveredAt, &d.CreatedAt}, extra...)
+	if err := row.Scan(dest...); err != nil {
+		return storage.Delivery{}, err
+	}
+	d.Payload = payload
+	return d, nil
+}
+
+// Deliveries returns the latest deliveries of a webhook, newest first.
+func (s *Storage) Deliveries(ctx context.Context, webhookID int64, limit int) ([]storage.Delivery, error) {
+	const op = "storage.postgres.Deliveries"
+	ctx, cancel := s.withTimeout(ctx)
+	defer cancel()
+
+	rows, err := s.db.QueryContext(ctx, `SELECT `+deliveryColumns+` FROM webhook_deliveries
+		WHERE webhook_id = $1
+		ORDER BY id DESC
+		LIMIT $2;`, webhookID, limit)
+	if err != nil {
+		return nil, fmt.Errorf("%s: %w", op, err)
+	}
+	defer rows.Close()
+
+	res := []storage.Delivery{}
+	for rows.Next() {
+		d, err := scanDelivery(rows)
+		if err != nil {
+			return nil, fmt.Errorf("%s: %w", op, err)
+		}
+		res = append(res, d)
+	}
+	if err = rows.Err(); err != nil {
+		return nil, fmt.Errorf("%s: %w", op, err)
+	}
+	return res, nil
+}
+
+// FanOut moves up to limit events from the outbox into a delivery for every
+// webhook subscribed to them, and returns how many events it moved.
+// Events nobody is subscribed to are dropped.
+func (s *Storage) FanOut(ctx context.Context, limit int) (int64, error) {
+	const op = "storage.postgres.FanOut"
+	ctx, cancel := s.withTimeout(ctx)
+	defer cancel()
+
+	var n int64
+	err := s.db.QueryRowContext(ctx, `WITH batch AS (
+			DELETE FROM webhook_outbox WHERE id IN (
+				SELECT id FROM webhook_outbox ORDER BY id LIMIT $1 FOR UPDATE SKIP LOCKED
+			) RETURNING id, event, created_by, workspace_id, payload
+		), deliveries AS (
+			INSERT INTO webhook_deliveries (webhook_id, event, payload)
+			SELECT w.id, b.event, b.payload FROM batch b JOIN webhooks w
+				ON (cardinality(w.events) = 0 OR b.event = ANY(w.events))
+				AND ((w.workspace_id IS NULL AND b.workspace_id IS NULL AND w.created_by = b.created_by)
+					OR w.workspace_id = b.workspace_id)
+			ORDER BY b.id
+		)
+		SELECT count(*) FROM batch;`, limit).Scan(&n)
+	if err != nil {
+		return 0, fmt.Errorf("%s: %w", op, err)
+	}
+	return n, nil
+}
+
+// ClaimDeliveries returns up to limit pending deliveries that are due, and
+// pushes their next attempt lease into the future, so that other instances
+// don't pick them up while they are being delivered.
+func (s *Storage) ClaimDeliveries(ctx context.Context, limit int, lease time.Duration) ([]storage.Delivery, error) {
+	const op = "storage.postgres.ClaimDeliveries"
+	ctx, cancel := s.withTimeout(ctx)
+	defer cancel()
+
+	rows, err := s.db.QueryContext(ctx, `WITH due AS (
+			SELECT id FROM webhook_deliveries
+			WHERE status = 'pending' AND next_attempt_at <= now()
+			ORDER BY next_attempt_at
+			LIMIT $1
+			FOR UPDATE SKIP LOCKED
+		)
+		UPDATE webhook_deliveries d SET next_attempt_at = now() + $2 * interval '1 millisecond'
+		FROM due, webhooks w
+		WHERE d.id = due.id AND w.id = d.webhook_id
+		RETURNING d.id, d.webhook_id, d.event, d.payload, d.status, d.attempts, d.next_attempt_at,
+			d.last_status_code, d.last_response, d.last_error, d.delivered_at, d.created_at, w.url, w.secret;`,
+		limit, lease.Milliseconds())
+	if err != nil {
+		return nil, fmt.Errorf("%s: %w", op, err)
+	}
+	defer rows.Close()
+
+	var res []storage.Delivery
+	for rows.Next() {
+		var d storage.Delivery
+		var url, secret string
+		if d, err = scanDelivery(rows, &url, &secret); err != nil {
+			return nil, fmt.Errorf("%s: %w", op, err)
+		}
+		d.URL, d.Secret = url, secret
+		res = append(res, d)
+	}
+	if err = rows.Err(); err != nil {
+		return nil, fmt.Errorf("%s: %w", op, err)
+	}
+	return res, nil
+}
+
+// RecordDelivery stores the outcome of an attempt.
+func (s *Storage) RecordDelivery(ctx context.Context, d storage.Delivery) error {
+	const op = "storage.postgres.RecordDelivery"
+	ctx, cancel := s.withTimeout(ctx)
+	defer cancel()
+
+	_, err := s.db.ExecContext(ctx, `UPDATE webhook_deliveries SET status = $2, attempts = $3, next_attempt_at = $4,
+			last_status_code = $5, last_response = $6, last_error = $7, delivered_at = $8
+		WHERE id = $1;`,
+		d.ID, d.Status, d.Attempts, d.NextAttemptAt, d.LastStatusCode, d.LastResponse, d.LastError, d.DeliveredAt)
+	if err != nil {
+		return fmt.Errorf("%s: %w", op, err)
+	}
+	return nil
+}
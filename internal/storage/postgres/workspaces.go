@@ -0,0 +1,294 @@
+package postgres
+
+import (
+	"context"
+	"database/sql"
+	"errors"
+	"fmt"
+	"github.com/kxddry/url-shortener/internal/storage"
+	"github.com/lib/pq"
+)
+
+// CreateWorkspace creates a workspace with uid as its only owner.
+func (s *Storage) CreateWorkspace(ctx context.Context, name string, uid int64) (storage.Workspace, error) {
+	const op = "storage.postgres.CreateWorkspace"
+	ctx, cancel := s.withTimeout(ctx)
+	defer cancel()
+
+	tx, err := s.db.BeginTx(ctx, nil)
+	if err != nil {
+		return storage.Workspace{}, fmt.Errorf("%s: %w", op, err)
+	}
+	defer tx.Rollback()
+
+	ws := storage.Workspace{Name: name, CreatedBy: uid, Role: storage.RoleOwner}
+	err = tx.QueryRowContext(ctx, `INSERT INTO workspaces (name, created_by) VALUES ($1, $2) RETURNING id, created_at;`,
+		name, uid).Scan(&ws.ID, &ws.CreatedAt)
+	if err != nil {
+		return storage.Workspace{}, fmt.Errorf("%s: %w", op, err)
+	}
+	_, err = tx.ExecContext(ctx, `INSERT INTO workspace_members (workspace_id, user_id, role) VALUES ($1, $2, $3);`,
+		ws.ID, uid, storage.RoleOwner)
+	if err != nil {
+		return storage.Workspace{}, fmt.Errorf("%s: %w", op, err)
+	}
+	return ws, tx.Commit()
+}
+
+// Workspaces lists the workspaces uid is a member of, together with uid's role.
+func (s *Storage) Workspaces(ctx context.Context, uid int64) ([]storage.Workspace, error) {
+	const op = "storage.postgres.Workspaces"
+	ctx, cancel := s.withTimeout(ctx)
+	defer cancel()
+
+	rows, err := s.db.QueryContext(ctx, `SELECT w.id, w.name, w.created_by, w.created_at, m.role
+		FROM workspaces w JOIN workspace_members m ON m.workspace_id = w.id
+		WHERE m.user_id = $1
+		ORDER BY w.name, w.id;`, uid)
+	if err != nil {
+		return nil, fmt.Errorf("%s: %w", op, err)
+	}
+	defer rows.Close()
+
+	res := []storage.Workspace{}
+	for rows.Next() {
+		var ws storage.Workspace
+		if err = rows.Scan(&ws.ID, &ws.Name, &ws.CreatedBy, &ws.CreatedAt, &ws.Role); err != nil {
+			return nil, fmt.Errorf("%s: %w", op, err)
+		}
+		res = append(res, ws)
+	}
+	if err = rows.Err(); err != nil {
+		return nil, fmt.Errorf("%s: %w", op, err)
+	}
+	return res, nil
+}
+
+// Role returns the role of uid in the workspace, or ErrNotMember.
+func (s *Storage) Role(ctx context.Context, workspaceID, uid int64) (string, error) {
+	const op = "storage.postgres.Role"
+	ctx, cancel := s.withTimeout(ctx)
+	defer cancel()
+
+	var role string
+	err := s.db.QueryRowContext(ctx, `SELECT role FROM workspace_members WHERE workspace_id = $1 AND user_id = $2;`,
+		workspaceID, uid).Scan(&role)
+	if err != nil {
+		if errors.Is(err, sql.ErrNoRows) {
+			return "", fmt.Errorf("%s: %w", op, storage.ErrNotMember)
+		}
+		return "", fmt.Errorf("%s: %w", op, err)
+	}
+	return role, nil
+}
+
+func (s *Storage) Members(ctx context.Context, workspaceID int64) ([]storage.Member, error) {
+	const op = "storage.postgres.Members"
+	ctx, cancel := s.withTimeout(ctx)
+	defer cancel()
+
+	rows, err := s.db.QueryContext(ctx, `SELECT workspace_id, user_id, role FROM workspace_members
+		WHERE workspace_id = $1
+		ORDER BY user_id;`, workspaceID)
+	if err != nil {
+		return nil, fmt.Errorf("%s: %w", op, err)
+	}
+	defer rows.Close()
+
+	res := []storage.Member{}
+	for rows.Next() {
+		var m storage.Member
+		if err = rows.Scan(&m.WorkspaceID, &m.UserID, &m.Role); err != nil {
+			return nil, fmt.Errorf("%s: %w", op, err)
+		}
+		res = append(res, m)
+	}
+	if err = rows.Err(); err != nil {
+		return nil, fmt.Errorf("%s: %w", op, err)
+	}
+	return res, nil
+}
+
+// Invite records an invitation; inviting someone who is already a member is ErrAlreadyMember.
+func (s *Storage) Invite(ctx context.Context, inv storage.Invitation) (storage.Invitation, error) {
+	const op = "storage.postgres.Invite"
+	ctx, cancel := s.withTimeout(ctx)
+	defer cancel()
+
+	tx, err := s.db.BeginTx(ctx, nil)
+	if err != nil {
+		return storage.Invitation{}, fmt.Errorf("%s: %w", op, err)
+	}
+	defer tx.Rollback()
+
+	var member bool
+	err = tx.QueryRowContext(ctx, `SELECT EXISTS (SELECT 1 FROM workspace_members WHERE workspace_id = $1 AND user_id = $2);`,
+		inv.WorkspaceID, inv.UserID).Scan(&member)
+	if err != nil {
+		return storage.Invitation{}, fmt.Errorf("%s: %w", op, err)
+	}
+	if member {
+		return storage.Invitation{}, fmt.Errorf("%s: %w", op, storage.ErrAlreadyMember)
+	}
+
+	err = tx.QueryRowContext(ctx, `INSERT INTO workspace_invitations (workspace_id, user_id, role, invited_by)
+		VALUES ($1, $2, $3, $4) RETURNING id, created_at;`,
+		inv.WorkspaceID, inv.UserID, inv.Role, inv.InvitedBy).Scan(&inv.ID, &inv.CreatedAt)
+	if err != nil {
+		if pqErr, ok := err.(*pq.Error); ok && pqErr.Code.Name() == "unique_violation" {
+			return storage.Invitation{}, fmt.Errorf("%s: %w", op, storage.ErrInvitationExists)
+		}
+		if pqErr, ok := err.(*pq.Error); ok && pqErr.Code.Name() == "foreign_key_violation" {
+			return storage.Invitation{}, fmt.Errorf("%s: %w", op, storage.ErrWorkspaceNotFound)
+		}
+		return storage.Invitation{}, fmt.Errorf("%s: %w", op, err)
+	}
+	return inv, tx.Commit()
+}
+
+// Invitations lists the pending invitations of uid.
+func (s *Storage) Invitations(ctx context.Context, uid int64) ([]storage.Invitation, error) {
+	const op = "storage.postgres.Invitations"
+	ctx, cancel := s.withTimeout(ctx)
+	defer cancel()
+
+	rows, err := s.db.QueryContext(ctx, `SELECT id, workspace_id, user_id, role, invited_by, created_at FROM workspace_invitations
+		WHERE user_id = $1
+		ORDER BY created_at DESC;`, uid)
+	if err != nil {
+		return nil, fmt.Errorf("%s: %w", op, err)
+	}
+	defer rows.Close()
+
+	res := []storage.Invitation{}
+	for rows.Next() {
+		var inv storage.Invitation
+		if err = rows.Scan(&inv.ID, &inv.WorkspaceID, &inv.UserID, &inv.Role, &inv.InvitedBy, &inv.CreatedAt); err != nil {
+			return nil, fmt.Errorf("%s: %w", op, err)
+		}
+		res = append(res, inv)
+	}
+	if err = rows.Err(); err != nil {
+		return nil, fmt.Errorf("%s: %w", op, err)
+	}
+	return res, nil
+}
+
+// AcceptInvitation makes uid a member with the invited role and consumes the invitation.
+func (s *Storage) AcceptInvitation(ctx context.Context, id, uid int64) (storage.Member, error) {
+	const op = "storage.postgres.AcceptInvitation"
+	ctx, cancel := s.withTimeout(ctx)
+	defer cancel()
+
+	tx, err := s.db.BeginTx(ctx, nil)
+	if err != nil {
+		return storage.Member{}, fmt.Errorf("%s: %w", op, err)
+	}
+	defer tx.Rollback()
+
+	m := storage.Member{UserID: uid}
+	err = tx.QueryRowContext(ctx, `DELETE FROM workspace_invitations WHERE id = $1 AND user_id = $2 RETURNING workspace_id, role;`,
+		id, uid).Scan(&m.WorkspaceID, &m.Role)
+	if err != nil {
+		if errors.Is(err, sql.ErrNoRows) {
+			return storage.Member{}, fmt.Errorf("%s: %w", op, storage.ErrInvitationNotFound)
+		}
+		return storage.Member{}, fmt.Errorf("%s: %w", op, err)
+	}
+	_, err = tx.ExecContext(ctx, `INSERT INTO workspace_members (workspace_id, user_id, role) VALUES ($1, $2, $3)
+		ON CONFLICT (workspace_id, user_id) DO NOTHING;`, m.WorkspaceID, uid, m.Role)
+	if err != nil {
+		return storage.Member{}, fmt.Errorf("%s: %w", op, err)
+	}
+	return m, tx.Commit()
+}
+
+func (s *Storage) DeclineInvitation(ctx context.Context, id, uid int64) error {
+	const op = "storage.postgres.DeclineInvitation"
+	ctx, cancel := s.withTimeout(ctx)
+	defer cancel()
+
+	res, err := s.db.ExecContext(ctx, `DELETE FROM workspace_invitations WHERE id = $1 AND user_id = $2;`, id, uid)
+	if err != nil {
+		return fmt.Errorf("%s: %w", op, err)
+	}
+	n, err := res.RowsAffected()
+	if err != nil {
+		return fmt.Errorf("%s: %w", op, err)
+	}
+	if n == 0 {
+		return fmt.Errorf("%s: %w", op, storage.ErrInvitationNotFound)
+	}
+	return nil
+}
+
+// SetRole changes the role of a member. The last owner can't be demoted.
+func (s *Storage) SetRole(ctx context.Context, workspaceID, uid int64, role string) error {
+	const op = "storage.postgres.SetRole"
+	ctx, cancel := s.withTimeout(ctx)
+	defer cancel()
+	return s.changeMember(ctx, op, workspaceID, uid, func(tx *sql.Tx) error {
+		_, err := tx.ExecContext(ctx, `UPDATE workspace_members SET role = $3 WHERE workspace_id = $1 AND user_id = $2;`,
+			workspaceID, uid, role)
+		return err
+	}, role != storage.RoleOwner)
+}
+
+// RemoveMember removes uid from the workspace. The last owner can't be removed.
+func (s *Storage) RemoveMember(ctx context.Context, workspaceID, uid int64) error {
+	const op = "storage.postgres.RemoveMember"
+	ctx, cancel := s.withTimeout(ctx)
+	defer cancel()
+	return s.changeMember(ctx, op, workspaceID, uid, func(tx *sql.Tx) error {
+		_, err := tx.ExecContext(ctx, `DELETE FROM workspace_members WHERE workspace_id = $1 AND user_id = $2;`, workspaceID, uid)
+		return err
+	}, true)
+}
+
+// changeMember runs change on an existing member, with the workspace's members locked.
+// If losesOwner is set, change fails with ErrLastOwner when uid is the only owner.
+func (s *Storage) changeMember(ctx context.Context, op string, workspaceID, uid int64, change func(tx *sql.Tx) error, losesOwner bool) error {
+	tx, err := s.db.BeginTx(ctx, nil)
+	if err != nil {
+		return fmt.Errorf("%s: %w", op, err)
+	}
+	defer tx.Rollback()
+
+	rows, err := tx.QueryContext(ctx, `SELECT user_id, role FROM workspace_members WHERE workspace_id = $1 FOR UPDATE;`, workspaceID)
+	if err != nil {
+		return fmt.Errorf("%s: %w", op, err)
+	}
+	var role string
+	owners := 0
+	for rows.Next() {
+		var (
+			memberID   int64
+			memberRole string
+		)
+		if err = rows.Scan(&memberID, &memberRole); err != nil {
+			rows.Close()
+			return fmt.Errorf("%s: %w", op, err)
+		}
+		if memberID == uid {
+			role = memberRole
+		}
+		if memberRole == storage.RoleOwner {
+			owners++
+		}
+	}
+	rows.Close()
+	if err = rows.Err(); err != nil {
+		return fmt.Errorf("%s: %w", op, err)
+	}
+
+	if role == "" {
+		return fmt.Errorf("%s: %w", op, storage.ErrNotMember)
+	}
+	if losesOwner && role == storage.RoleOwner && owners == 1 {
+		return fmt.Errorf("%s: %w", op, storage.ErrLastOwner)
+	}
+	if err = change(tx); err != nil {
+		return fmt.Errorf("%s: %w", op, err)
+	}
+	return tx.Commit()
+}
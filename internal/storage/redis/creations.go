@@ -0,0 +1,214 @@
+package redis
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"github.com/kxddry/url-shortener/internal/storage"
+	"github.com/redis/go-redis/v9"
+	"strings"
+	"time"
+)
+
+const creationsGroup = "replay"
+
+// ErrQueueFull is returned by QueueCreation once the queue holds its maximum.
+var ErrQueueFull = errors.New("creation queue is full")
+
+// creationsStream holds the links created while Postgres was down, see QueueCreation.
+func (r *RedisClient) creationsStream() string {
+	return r.prefix + "creations"
+}
+
+// queuedRetention bounds how long an alias stays reserved for a queued link,
+// should its entry never be acknowledged, and how long its status is kept.
+const queuedRetention = 7 * 24 * time.Hour
+
+// queuedAliasKey reserves the alias of a queued link, so that no other link
+// is queued with it.
+func (r *RedisClient) queuedAliasKey(domain, alias string) string {
+	return r.prefix + "queued-alias:" + domain + "/" + alias
+}
+
+func (r *RedisClient) queuedStatusKey(id string) string {
+	return r.prefix + "queued:" + id
+}
+
+// QueueCreation appends q to the stream of links to create once the
+// database is back, unless it holds maxLen entries already, 0 meaning no limit.
+// It reserves the alias of q, returning storage.ErrAliasExists if another
+// queued link has it, and returns the ID of the entry, under which
+// QueuedStatus tells what became of it.
+// The stream is as durable as the server's persistence settings make it.
+func (r *RedisClient) QueueCreation(ctx context.Context, q storage.QueuedLink, maxLen int64) (string, error) {
+	const op = "storage.redis.QueueCreation"
+	ctx, cancel := r.withTimeout(ctx)
+	defer cancel()
+
+	if maxLen > 0 {
+		n, err := r.client.XLen(ctx, r.creationsStream()).Result()
+		if err != nil {
+			return "", fmt.Errorf("%s: %w", op, err)
+		}
+		if n >= maxLen {
+			return "", fmt.Errorf("%s: %w", op, ErrQueueFull)
+		}
+	}
+	b, err := json.Marshal(q)
+	if err != nil {
+		return "", fmt.Errorf("%s: %w", op, err)
+	}
+
+	aliasKey := r.queuedAliasKey(q.Link.Domain, q.Link.Alias)
+	ok, err := r.client.SetNX(ctx, aliasKey, q.Link.CreatedBy, queuedRetention).Result()
+	if err != nil {
+		return "", fmt.Errorf("%s: %w", op, err)
+	}
+	if !ok {
+		return "", fmt.Errorf("%s: %w", op, storage.ErrAliasExists)
+	}
+	id, err := r.client.XAdd(ctx, &redis.XAddArgs{Stream: r.creationsStream(), Values: []any{"link", b}}).Result()
+	if err != nil {
+		r.client.Del(ctx, aliasKey)
+		return "", fmt.Errorf("%s: %w", op, err)
+	}
+	err = r.setQueuedStatus(ctx, r.client, id, storage.QueuedStatus{
+		Status:    storage.QueuedPending,
+		Domain:    q.Link.Domain,
+		Alias:     q.Link.Alias,
+		CreatedBy: q.Link.CreatedBy,
+		UpdatedAt: q.QueuedAt,
+	})
+	if err != nil {
+		// a link its creator can't follow up on isn't queued
+		r.client.XDel(ctx, r.creationsStream(), id)
+		r.client.Del(ctx, aliasKey)
+		return "", fmt.Errorf("%s: %w", op, err)
+	}
+	return id, nil
+}
+
+// ClaimCreations returns up to count queued links for consumer to create:
+// first those another consumer claimed more than minIdle ago without
+// acknowledging them, then new ones. Claimed links stay queued until
+// AckCreation.
+func (r *RedisClient) ClaimCreations(ctx context.Context, consumer string, count int64, minIdle time.Duration) ([]storage.QueuedLink, error) {
+	const op = "storage.redis.ClaimCreations"
+	ctx, cancel := r.withTimeout(ctx)
+	defer cancel()
+
+	stream := r.creationsStream()
+	err := r.client.XGroupCreateMkStream(ctx, stream, creationsGroup, "0").Err()
+	if err != nil && !strings.HasPrefix(err.Error(), "BUSYGROUP") {
+		return nil, fmt.Errorf("%s: %w", op, err)
+	}
+
+	msgs, _, err := r.client.XAutoClaim(ctx, &redis.XAutoClaimArgs{
+		Stream:   stream,
+		Group:    creationsGroup,
+		Consumer: consumer,
+		MinIdle:  minIdle,
+		Start:    "0-0",
+		Count:    count,
+	}).Result()
+	if err != nil {
+		return nil, fmt.Errorf("%s: %w", op, err)
+	}
+	if len(msgs) == 0 {
+		streams, err := r.client.XReadGroup(ctx, &redis.XReadGroupArgs{
+			Group:    creationsGroup,
+			Consumer: consumer,
+			Streams:  []string{stream, ">"},
+			Count:    count,
+			Block:    -1,
+		}).Result()
+		if err != nil && !errors.Is(err, redis.Nil) {
+			return nil, fmt.Errorf("%s: %w", op, err)
+		}
+		for _, s := range streams {
+			msgs = append(msgs, s.Messages...)
+		}
+	}
+
+	res := make([]storage.QueuedLink, 0, len(msgs))
+	for _, m := range msgs {
+		var q storage.QueuedLink
+		v, _ := m.Values["link"].(string)
+		if err = json.Unmarshal([]byte(v), &q); err != nil {
+			return nil, fmt.Errorf("%s: invalid entry %s: %w", op, m.ID, err)
+		}
+		q.ID = m.ID
+		res = append(res, q)
+	}
+	return res, nil
+}
+
+// AckCreation removes the queued link q, once it is created or given up on,
+// releases its alias and records status for its creator.
+func (r *RedisClient) AckCreation(ctx context.Context, q storage.QueuedLink, status storage.QueuedStatus) error {
+	const op = "storage.redis.AckCreation"
+	ctx, cancel := r.withTimeout(ctx)
+	defer cancel()
+
+	stream := r.creationsStream()
+	_, err := r.client.TxPipelined(ctx, func(p redis.Pipeliner) error {
+		p.XAck(ctx, stream, creationsGroup, q.ID)
+		p.XDel(ctx, stream, q.ID)
+		p.Del(ctx, r.queuedAliasKey(q.Link.Domain, q.Link.Alias))
+		return r.setQueuedStatus(ctx, p, q.ID, status)
+	})
+	if err != nil {
+		return fmt.Errorf("%s: %w", op, err)
+	}
+	return nil
+}
+
+// QueuedStatus tells what became of the queued link id.
+func (r *RedisClient) QueuedStatus(ctx context.Context, id string) (storage.QueuedStatus, error) {
+	const op = "storage.redis.QueuedStatus"
+	ctx, cancel := r.withTimeout(ctx)
+	defer cancel()
+
+	b, err := r.client.Get(ctx, r.queuedStatusKey(id)).Bytes()
+	if errors.Is(err, redis.Nil) {
+		return storage.QueuedStatus{}, fmt.Errorf("%s: %w", op, storage.ErrQueuedNotFound)
+	}
+	if err != nil {
+		return storage.QueuedStatus{}, fmt.Errorf("%s: %w", op, err)
+	}
+	var st queuedStatus
+	if err = json.Unmarshal(b, &st); err != nil {
+		return storage.QueuedStatus{}, fmt.Errorf("%s: %w", op, err)
+	}
+	st.QueuedStatus.CreatedBy = st.CreatedBy
+	return st.QueuedStatus, nil
+}
+
+// queuedStatus is how a status is stored, with the creator that the JSON
+// of storage.QueuedStatus leaves out.
+type queuedStatus struct {
+	storage.QueuedStatus
+	CreatedBy int64 `json:"created_by"`
+}
+
+func (r *RedisClient) setQueuedStatus(ctx context.Context, c redis.Cmdable, id string, status storage.QueuedStatus) error {
+	b, err := json.Marshal(queuedStatus{QueuedStatus: status, CreatedBy: status.CreatedBy})
+	if err != nil {
+		return err
+	}
+	return c.Set(ctx, r.queuedStatusKey(id), b, queuedRetention).Err()
+}
+
+// QueuedCreations is how many links are waiting to be created.
+func (r *RedisClient) QueuedCreations(ctx context.Context) (int64, error) {
+	const op = "storage.redis.QueuedCreations"
+	ctx, cancel := r.withTimeout(ctx)
+	defer cancel()
+
+	n, err := r.client.XLen(ctx, r.creationsStream()).Result()
+	if err != nil {
+		return 0, fmt.Errorf("%s: %w", op, err)
+	}
+	return n, nil
+}
@@ -0,0 +1,82 @@
+package redis
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"github.com/kxddry/url-shortener/internal/storage"
+	"github.com/redis/go-redis/v9"
+	"time"
+)
+
+// idempotencyKey has no '/', so it never collides with the key of a link.
+func (r *RedisClient) idempotencyKey(key string) string {
+	return r.prefix + "idempotency:" + key
+}
+
+// ReserveIdempotencyKey stores res under key for ttl unless something is
+// stored there already. It reports whether res was stored.
+func (r *RedisClient) ReserveIdempotencyKey(ctx context.Context, key string, res storage.IdempotentResponse, ttl time.Duration) (bool, error) {
+	const op = "storage.redis.ReserveIdempotencyKey"
+	ctx, cancel := r.withTimeout(ctx)
+	defer cancel()
+
+	b, err := json.Marshal(res)
+	if err != nil {
+		return false, fmt.Errorf("%s: %w", op, err)
+	}
+	ok, err := r.client.SetNX(ctx, r.idempotencyKey(key), b, ttl).Result()
+	if err != nil {
+		return false, fmt.Errorf("%s: %w", op, err)
+	}
+	return ok, nil
+}
+
+// IdempotentResponse returns what is stored under key, and whether anything is.
+func (r *RedisClient) IdempotentResponse(ctx context.Context, key string) (storage.IdempotentResponse, bool, error) {
+	const op = "storage.redis.IdempotentResponse"
+	ctx, cancel := r.withTimeout(ctx)
+	defer cancel()
+
+	b, err := r.client.Get(ctx, r.idempotencyKey(key)).Bytes()
+	if errors.Is(err, redis.Nil) {
+		return storage.IdempotentResponse{}, false, nil
+	}
+	if err != nil {
+		return storage.IdempotentResponse{}, false, fmt.Errorf("%s: %w", op, err)
+	}
+	var res storage.IdempotentResponse
+	if err = json.Unmarshal(b, &res); err != nil {
+		return storage.IdempotentResponse{}, false, fmt.Errorf("%s: %w", op, err)
+	}
+	return res, true, nil
+}
+
+// SaveIdempotentResponse stores res under key for ttl, replacing the reservation.
+func (r *RedisClient) SaveIdempotentResponse(ctx context.Context, key string, res storage.IdempotentResponse, ttl time.Duration) error {
+	const op = "storage.redis.SaveIdempotentResponse"
+	ctx, cancel := r.withTimeout(ctx)
+	defer cancel()
+
+	b, err := json.Marshal(res)
+	if err != nil {
+		return fmt.Errorf("%s: %w", op, err)
+	}
+	if err = r.client.Set(ctx, r.idempotencyKey(key), b, ttl).Err(); err != nil {
+		return fmt.Errorf("%s: %w", op, err)
+	}
+	return nil
+}
+
+// ReleaseIdempotencyKey forgets key, so that the request can be made again.
+func (r *RedisClient) ReleaseIdempotencyKey(ctx context.Context, key string) error {
+	const op = "storage.redis.ReleaseIdempotencyKey"
+	ctx, cancel := r.withTimeout(ctx)
+	defer cancel()
+
+	if err := r.client.Del(ctx, r.idempotencyKey(key)).Err(); err != nil {
+		return fmt.Errorf("%s: %w", op, err)
+	}
+	return nil
+}
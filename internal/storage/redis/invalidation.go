@@ -0,0 +1,54 @@
+package redis
+
+import (
+	"context"
+	"fmt"
+	"strings"
+)
+
+// invalidationChannel carries the links changed by any instance, as domain/alias.
+func (r *RedisClient) invalidationChannel() string {
+	return r.prefix + "invalidate"
+}
+
+// PublishInvalidation tells every instance that the link has changed.
+func (r *RedisClient) PublishInvalidation(ctx context.Context, domain, alias string) error {
+	const op = "storage.redis.PublishInvalidation"
+	ctx, cancel := r.withTimeout(ctx)
+	defer cancel()
+
+	if err := r.client.Publish(ctx, r.invalidationChannel(), domain+"/"+alias).Err(); err != nil {
+		return fmt.Errorf("%s: %w", op, err)
+	}
+	return nil
+}
+
+// SubscribeInvalidations calls handle for every link any instance publishes
+// an invalidation of, until ctx is done. It returns once subscribing fails or
+// the subscription is closed; invalidations published meanwhile are lost.
+func (r *RedisClient) SubscribeInvalidations(ctx context.Context, handle func(domain, alias string)) error {
+	const op = "storage.redis.SubscribeInvalidations"
+	sub := r.client.Subscribe(ctx, r.invalidationChannel())
+	defer sub.Close()
+	if _, err := sub.Receive(ctx); err != nil {
+		if ctx.Err() != nil {
+			return nil
+		}
+		return fmt.Errorf("%s: %w", op, err)
+	}
+
+	ch := sub.Channel()
+	for {
+		select {
+		case <-ctx.Done():
+			return nil
+		case m, ok := <-ch:
+			if !ok {
+				return fmt.Errorf("%s: subscription closed", op)
+			}
+			// neither domains nor aliases contain '/'
+			domain, alias, _ := strings.Cut(m.Payload, "/")
+			handle(domain, alias)
+		}
+	}
+}
@@ -2,75 +2,180 @@ package redis
 
 import (
 	"context"
+	"crypto/tls"
+	"crypto/x509"
+	"encoding/json"
+	"errors"
 	"fmt"
 	"github.com/kxddry/url-shortener/internal/config"
+	"github.com/kxddry/url-shortener/internal/storage"
+	"github.com/redis/go-redis/extra/redisotel/v9"
 	"github.com/redis/go-redis/v9"
+	"os"
+	"time"
 )
 
-type RedisOptions struct {
-	redis.Options
-}
-
+// RedisClient is the link cache. It works the same whether the client
+// behind it talks to a single server, a Sentinel-managed master or a cluster.
 type RedisClient struct {
-	redis.Options
-	client *redis.Client
+	client  redis.UniversalClient
+	prefix  string
+	timeout time.Duration
+	linkTTL time.Duration
 }
 
 func New(cfg config.RedisStorage) (*RedisClient, error) {
-	r := RedisClient{
-		Options: redis.Options{
-			Addr:     cfg.Host + ":" + cfg.Port,
-			Password: cfg.Password,
-			Username: cfg.User,
-			DB:       cfg.DB,
-			PoolSize: cfg.PoolSize,
-			Network:  cfg.Protocol,
-		},
-		client: nil,
-	}
-	return &r, r.connect()
+	const op = "storage.redis.New"
+
+	tlsConfig, err := newTLSConfig(cfg.TLS)
+	if err != nil {
+		return nil, fmt.Errorf("%s: %w", op, err)
+	}
+	opts := &redis.UniversalOptions{
+		Addrs:            cfg.Addrs,
+		MasterName:       cfg.MasterName,
+		SentinelPassword: cfg.SentinelPassword,
+		Username:         cfg.User,
+		Password:         cfg.Password,
+		DB:               cfg.DB,
+		PoolSize:         cfg.PoolSize,
+		DialTimeout:      cfg.DialTimeout,
+		ReadTimeout:      cfg.ReadTimeout,
+		WriteTimeout:     cfg.WriteTimeout,
+		TLSConfig:        tlsConfig,
+		// let deadlines of the callers' contexts interrupt blocked commands
+		ContextTimeoutEnabled: true,
+	}
+
+	r := RedisClient{prefix: cfg.KeyPrefix, timeout: cfg.CommandTimeout, linkTTL: cfg.LinkTTL}
+	switch cfg.Mode {
+	case "sentinel":
+		r.client = redis.NewFailoverClient(opts.Failover())
+	case "cluster":
+		r.client = redis.NewClusterClient(opts.Cluster())
+	default:
+		opts.Addrs = []string{cfg.Host + ":" + cfg.Port}
+		simple := opts.Simple()
+		simple.Network = cfg.Protocol
+		r.client = redis.NewClient(simple)
+	}
+
+	ctx, cancel := r.withTimeout(context.Background())
+	defer cancel()
+	if err = r.client.Ping(ctx).Err(); err != nil {
+		return &r, fmt.Errorf("%s: %w", op, err)
+	}
+	// commands become spans of the traces they are sent from
+	if err = redisotel.InstrumentTracing(r.client); err != nil {
+		return &r, fmt.Errorf("%s: %w", op, err)
+	}
+	return &r, nil
 }
 
-func (r *RedisClient) connect() error {
-	if r.client != nil {
-		return nil
+func newTLSConfig(cfg config.TLS) (*tls.Config, error) {
+	if !cfg.Enabled {
+		return nil, nil
+	}
+	res := &tls.Config{
+		MinVersion:         tls.VersionTLS12,
+		ServerName:         cfg.ServerName,
+		InsecureSkipVerify: cfg.InsecureSkipVerify,
 	}
-	r.client = redis.NewClient(&r.Options)
-	return r.client.Ping(context.Background()).Err()
+	if cfg.CAFile != "" {
+		pem, err := os.ReadFile(cfg.CAFile)
+		if err != nil {
+			return nil, fmt.Errorf("tls.ca_file: %w", err)
+		}
+		res.RootCAs = x509.NewCertPool()
+		if !res.RootCAs.AppendCertsFromPEM(pem) {
+			return nil, errors.New("tls.ca_file: no certificates found")
+		}
+	}
+	if cfg.CertFile != "" {
+		cert, err := tls.LoadX509KeyPair(cfg.CertFile, cfg.KeyFile)
+		if err != nil {
+			return nil, fmt.Errorf("tls.cert_file: %w", err)
+		}
+		res.Certificates = []tls.Certificate{cert}
+	}
+	return res, nil
 }
 
-func (r *RedisClient) SaveURL(urlToSave, alias string, creator int64) (int64, error) {
-	_ = creator // don't store the creator in redis
-	const op = "storage.redis.SaveURL"
-	multi := r.client.TxPipeline()
+// withTimeout bounds ctx by the configured command timeout.
+func (r *RedisClient) withTimeout(ctx context.Context) (context.Context, context.CancelFunc) {
+	if r.timeout <= 0 {
+		return context.WithCancel(ctx)
+	}
+	return context.WithTimeout(ctx, r.timeout)
+}
+
+// key namespaces aliases by domain. Aliases can't contain '/', so keys never collide.
+func (r *RedisClient) key(domain, alias string) string {
+	return r.prefix + domain + "/" + alias
+}
 
-	err := multi.SetNX(context.Background(), alias, urlToSave, 0).Err()
+// SaveLink caches link for the configured link TTL, overwriting whatever was
+// cached for its alias. Links with a not_after are only cached until then,
+// and not at all once it has passed.
+func (r *RedisClient) SaveLink(ctx context.Context, link storage.Link) (int64, error) {
+	const op = "storage.redis.SaveLink"
+	ttl := r.linkTTL
+	if link.NotAfter != nil {
+		if ttl = min(ttl, time.Until(*link.NotAfter)); ttl <= 0 {
+			return link.ID, nil
+		}
+	}
+	ctx, cancel := r.withTimeout(ctx)
+	defer cancel()
+
+	b, err := json.Marshal(link)
+	if err != nil {
+		return 0, fmt.Errorf("%s: %w", op, err)
+	}
+	err = r.client.Set(ctx, r.key(link.Domain, link.Alias), b, ttl).Err()
 	if err != nil {
-		multi.Discard()
 		return 0, fmt.Errorf("%s: %w", op, err)
 	}
-	multi.Save(context.Background())
-	return 0, nil
+	return link.ID, nil
 }
 
-func (r *RedisClient) GetURL(alias string) (string, error) {
-	const op = "storage.redis.GetURL"
-	url, err := r.client.Get(context.Background(), alias).Result()
+func (r *RedisClient) GetLink(ctx context.Context, domain, alias string) (storage.Link, error) {
+	const op = "storage.redis.GetLink"
+	ctx, cancel := r.withTimeout(ctx)
+	defer cancel()
+	b, err := r.client.Get(ctx, r.key(domain, alias)).Bytes()
 	if err != nil {
-		return "", fmt.Errorf("%s: %w", op, err)
+		return storage.Link{}, fmt.Errorf("%s: %w", op, err)
+	}
+	var link storage.Link
+	if err = json.Unmarshal(b, &link); err != nil {
+		return storage.Link{}, fmt.Errorf("%s: %w", op, err)
 	}
-	return url, nil
+	return link, nil
 }
 
-func (r *RedisClient) DeleteURL(alias string) error {
+func (r *RedisClient) DeleteURL(ctx context.Context, domain, alias string) error {
 	const op = "storage.redis.DeleteURL"
-	err := r.client.Del(context.Background(), alias).Err()
+	ctx, cancel := r.withTimeout(ctx)
+	defer cancel()
+	err := r.client.Del(ctx, r.key(domain, alias)).Err()
 	if err != nil {
 		return fmt.Errorf("%s: %w", op, err)
 	}
 	return nil
 }
 
+// Ping checks that the server answers.
+func (r *RedisClient) Ping(ctx context.Context) error {
+	const op = "storage.redis.Ping"
+	ctx, cancel := r.withTimeout(ctx)
+	defer cancel()
+	if err := r.client.Ping(ctx).Err(); err != nil {
+		return fmt.Errorf("%s: %w", op, err)
+	}
+	return nil
+}
+
 func (r *RedisClient) Close() error {
 	return r.client.Close()
 }
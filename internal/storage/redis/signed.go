@@ -0,0 +1,48 @@
+package redis
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"github.com/redis/go-redis/v9"
+	"time"
+)
+
+// signedClicksKey has no '/', so it never collides with the key of a link.
+func (r *RedisClient) signedClicksKey(id string) string {
+	return r.prefix + "signed-clicks:" + id
+}
+
+// CountSignedClick counts a visit of the signed link id, which is kept until expires.
+func (r *RedisClient) CountSignedClick(ctx context.Context, id string, expires time.Time) error {
+	const op = "storage.redis.CountSignedClick"
+	ctx, cancel := r.withTimeout(ctx)
+	defer cancel()
+
+	key := r.signedClicksKey(id)
+	_, err := r.client.TxPipelined(ctx, func(p redis.Pipeliner) error {
+		p.Incr(ctx, key)
+		p.ExpireAt(ctx, key, expires)
+		return nil
+	})
+	if err != nil {
+		return fmt.Errorf("%s: %w", op, err)
+	}
+	return nil
+}
+
+// SignedClicks returns the visits of the signed link id counted so far.
+func (r *RedisClient) SignedClicks(ctx context.Context, id string) (int64, error) {
+	const op = "storage.redis.SignedClicks"
+	ctx, cancel := r.withTimeout(ctx)
+	defer cancel()
+
+	n, err := r.client.Get(ctx, r.signedClicksKey(id)).Int64()
+	if errors.Is(err, redis.Nil) {
+		return 0, nil
+	}
+	if err != nil {
+		return 0, fmt.Errorf("%s: %w", op, err)
+	}
+	return n, nil
+}
@@ -1,17 +1,487 @@
 package storage
 
 import (
+	"context"
+	"encoding/json"
 	"errors"
+	"fmt"
+	"github.com/kxddry/url-shortener/internal/lib/rules"
+	"time"
 )
 
 type Storage interface {
-	SaveURL(urlToSave, alias string) (int64, error)
-	GetURL(alias string) (string, error)
-	DeleteURL(alias string) error
-	GenerateAlias(int) (string, error)
+	SaveLink(ctx context.Context, link Link) (int64, error)
+	GetLink(ctx context.Context, domain, alias string) (Link, error)
+	DeleteURL(ctx context.Context, domain, alias string) error
+}
+
+// Link is a short link together with its per-link redirect options.
+// It is what gets cached, so everything needed to redirect belongs here.
+type Link struct {
+	ID        int64  `json:"id"`
+	Domain    string `json:"domain,omitempty"`
+	Alias     string `json:"alias"`
+	URL       string `json:"url"`
+	CreatedBy int64  `json:"created_by"`
+	// WorkspaceID owns the link when set; otherwise the link belongs to CreatedBy.
+	WorkspaceID int64 `json:"workspace_id,omitempty"`
+
+	// ForwardQuery merges the visitor's query string into URL.
+	ForwardQuery bool `json:"forward_query,omitempty"`
+	// ForwardPath appends anything after /{alias}/ to the path of URL.
+	ForwardPath bool `json:"forward_path,omitempty"`
+	// QueryConflict is one of the forward policies, empty means the configured default.
+	QueryConflict string `json:"query_conflict,omitempty"`
+	// Rules pick a destination other than URL depending on the visitor.
+	Rules rules.Set `json:"rules,omitempty"`
+	// RedirectCode is one of 301, 302, 307 and 308.
+	RedirectCode int `json:"redirect_code,omitempty"`
+
+	Title       string `json:"title,omitempty"`
+	Description string `json:"description,omitempty"`
+	// Interstitial shows a warning page before leaving for an external destination.
+	Interstitial bool      `json:"interstitial,omitempty"`
+	CreatedAt    time.Time `json:"created_at"`
+	// Clicks is only up to date when read from the database, never from the cache.
+	Clicks int64 `json:"clicks"`
+	// MaxClicks makes the link stop working after that many clicks, 0 means unlimited.
+	MaxClicks int64 `json:"max_clicks,omitempty"`
+	// NotBefore and NotAfter bound when the link redirects, nil leaves that end open.
+	NotBefore *time.Time `json:"not_before,omitempty"`
+	NotAfter  *time.Time `json:"not_after,omitempty"`
+	// PrelaunchURL is where visitors go before NotBefore, instead of getting an error.
+	PrelaunchURL string `json:"prelaunch_url,omitempty"`
+	// DisabledAt is set while an admin has disabled the link, which then doesn't redirect.
+	DisabledAt     *time.Time `json:"disabled_at,omitempty"`
+	DisabledReason string     `json:"disabled_reason,omitempty"`
+	// DisabledUntil ends a temporary disable, such as the one of a link reported
+	// by too many visitors. Nil keeps the link disabled until it is enabled.
+	DisabledUntil *time.Time `json:"disabled_until,omitempty"`
+
+	// Notes are private to whoever manages the link.
+	Notes string   `json:"notes,omitempty"`
+	Tags  []string `json:"tags,omitempty"`
+
+	// Health is only filled in by listings, once the destination has been checked.
+	Health *LinkHealth `json:"health,omitempty"`
+}
+
+// CheckWindow reports whether the link redirects at t: it returns
+// ErrLinkNotActive before NotBefore and ErrLinkExpired from NotAfter on.
+func (l Link) CheckWindow(t time.Time) error {
+	switch {
+	case l.NotBefore != nil && t.Before(*l.NotBefore):
+		return ErrLinkNotActive
+	case l.NotAfter != nil && !t.Before(*l.NotAfter):
+		return ErrLinkExpired
+	}
+	return nil
+}
+
+// SameOwner reports whether l and o belong to the same user or workspace, such
+// as a link authorized before it was locked and the locked link.
+func (l Link) SameOwner(o Link) bool {
+	return l.CreatedBy == o.CreatedBy && l.WorkspaceID == o.WorkspaceID
+}
+
+// Disabled reports whether the link is disabled at t.
+func (l Link) Disabled(t time.Time) bool {
+	return l.DisabledAt != nil && (l.DisabledUntil == nil || t.Before(*l.DisabledUntil))
+}
+
+// Report categories.
+const (
+	ReportPhishing = "phishing"
+	ReportMalware  = "malware"
+	ReportSpam     = "spam"
+	ReportIllegal  = "illegal"
+	ReportOther    = "other"
+)
+
+// Report is a visitor's complaint about a link.
+type Report struct {
+	ID     int64  `json:"id"`
+	LinkID int64  `json:"link_id"`
+	Reason string `json:"reason"`
+	// Comment is free text from the reporter.
+	Comment string `json:"comment,omitempty"`
+	// Reporter is a keyed hash of the reporter's IP address: it tells reporters
+	// apart without revealing them.
+	Reporter  string    `json:"-"`
+	CreatedAt time.Time `json:"created_at"`
+	// Resolution is empty while the report is pending, then dismissed or disabled.
+	Resolution string     `json:"resolution,omitempty"`
+	ResolvedBy int64      `json:"resolved_by,omitempty"`
+	ResolvedAt *time.Time `json:"resolved_at,omitempty"`
+}
+
+// Report resolutions.
+const (
+	ResolutionDismissed = "dismissed"
+	ResolutionDisabled  = "disabled"
+)
+
+// ReportedLink is an entry of the moderation queue: a link with pending reports.
+type ReportedLink struct {
+	Link Link `json:"link"`
+	// Reports counts the pending reports, Reasons them by category.
+	Reports        int64            `json:"reports"`
+	Reasons        map[string]int64 `json:"reasons"`
+	LastReportedAt time.Time        `json:"last_reported_at"`
+}
+
+// AutoDisable disables a link for For once After distinct reporters have
+// reported it. After 0 never disables links.
+type AutoDisable struct {
+	After int
+	For   time.Duration
+}
+
+// IdempotentResponse is what a request with an Idempotency-Key got, kept to
+// answer its retries with.
+type IdempotentResponse struct {
+	// Fingerprint identifies the request, so that the key can't be reused for another one.
+	Fingerprint string `json:"fingerprint"`
+	// Done is false while the first request is in flight.
+	Done   bool                `json:"done"`
+	Status int                 `json:"status,omitempty"`
+	Header map[string][]string `json:"header,omitempty"`
+	Body   []byte              `json:"body,omitempty"`
+}
+
+// QueuedLink is a link created while the database was unavailable,
+// waiting to be saved once it is back.
+type QueuedLink struct {
+	// ID is the ID of the entry in the queue.
+	ID   string `json:"-"`
+	Link Link   `json:"link"`
+	// CustomAlias is whether the creator picked the alias, which counts towards their quota.
+	CustomAlias bool      `json:"custom_alias,omitempty"`
+	QueuedAt    time.Time `json:"queued_at"`
+}
+
+// Outcomes of a queued link.
+const (
+	QueuedPending = "queued"
+	QueuedCreated = "created"
+	QueuedDropped = "dropped"
+)
+
+// QueuedStatus is what became of a queued link, for its creator to look up.
+type QueuedStatus struct {
+	Status string `json:"status"`
+	Domain string `json:"domain,omitempty"`
+	// Alias is the one the link got, which differs from the one it was queued
+	// with when that was generated and turned out to be taken.
+	Alias string `json:"alias"`
+	// Reason says why the link was dropped.
+	Reason    string    `json:"reason,omitempty"`
+	CreatedBy int64     `json:"-"`
+	UpdatedAt time.Time `json:"updated_at"`
+}
+
+// SearchFilter narrows down a search of everyone's links.
+type SearchFilter struct {
+	// Query matches part of the alias, destination or title, ignoring case.
+	Query string
+	// UserID lists only the links created by that user.
+	UserID int64
+	// Domain lists only the links of that domain, all domains if empty.
+	Domain   string
+	Disabled bool
+	Limit    int
+	Offset   int
+}
+
+// BlockedDomain is a destination domain an admin blocked for every link.
+type BlockedDomain struct {
+	Domain    string    `json:"domain"`
+	Reason    string    `json:"reason,omitempty"`
+	BlockedBy int64     `json:"blocked_by"`
+	CreatedAt time.Time `json:"created_at"`
+}
+
+// Stats are system-wide counts for admins.
+type Stats struct {
+	Links         int64 `json:"links"`
+	LinksToday    int64 `json:"links_today"`
+	DisabledLinks int64 `json:"disabled_links"`
+	// ReportedLinks counts the links waiting in the moderation queue.
+	ReportedLinks int64 `json:"reported_links"`
+	BrokenLinks   int64 `json:"broken_links"`
+	Clicks        int64 `json:"clicks"`
+	// Users counts the users that created at least one link.
+	Users          int64 `json:"users"`
+	Workspaces     int64 `json:"workspaces"`
+	Webhooks       int64 `json:"webhooks"`
+	Domains        int64 `json:"domains"`
+	BlockedDomains int64 `json:"blocked_domains"`
+}
+
+// AuditEntry records a change made through the admin API.
+type AuditEntry struct {
+	ID    int64 `json:"id"`
+	Actor int64 `json:"actor"`
+	// Action is the method and route, Target the request URI.
+	Action    string    `json:"action"`
+	Target    string    `json:"target"`
+	Status    int       `json:"status"`
+	RequestID string    `json:"request_id,omitempty"`
+	CreatedAt time.Time `json:"created_at"`
+}
+
+// LinkFilter narrows down a listing of links.
+type LinkFilter struct {
+	// WorkspaceID lists the workspace's links instead of the user's personal ones.
+	WorkspaceID int64
+	Tag         string
+	Limit       int
+	Offset      int
+	// Broken lists only the links the dead-link monitor flagged.
+	Broken bool
+}
+
+type TagCount struct {
+	Tag   string `json:"tag"`
+	Count int64  `json:"count"`
+}
+
+// Domain is a branded short domain with its own alias namespace.
+// Links created without a domain live in the default namespace "".
+type Domain struct {
+	ID          int64  `json:"id"`
+	Host        string `json:"host"`
+	FallbackURL string `json:"fallback_url,omitempty"`
+	// Interstitial is Link.Interstitial for every link of the domain.
+	Interstitial bool `json:"interstitial,omitempty"`
+}
+
+// Roles of workspace members, from most to least privileged.
+const (
+	RoleOwner  = "owner"
+	RoleEditor = "editor"
+	RoleViewer = "viewer"
+)
+
+// Workspace shares ownership of links between its members.
+type Workspace struct {
+	ID        int64     `json:"id"`
+	Name      string    `json:"name"`
+	CreatedBy int64     `json:"created_by"`
+	CreatedAt time.Time `json:"created_at"`
+	// Role is the role of the user the workspace was listed for.
+	Role string `json:"role,omitempty"`
+}
+
+type Member struct {
+	WorkspaceID int64  `json:"workspace_id"`
+	UserID      int64  `json:"user_id"`
+	Role        string `json:"role"`
+}
+
+// Invitation lets UserID join WorkspaceID with Role once they accept it.
+type Invitation struct {
+	ID          int64     `json:"id"`
+	WorkspaceID int64     `json:"workspace_id"`
+	UserID      int64     `json:"user_id"`
+	Role        string    `json:"role"`
+	InvitedBy   int64     `json:"invited_by"`
+	CreatedAt   time.Time `json:"created_at"`
+}
+
+// Link lifecycle events delivered to webhooks.
+const (
+	EventLinkCreated = "link.created"
+	EventLinkUpdated = "link.updated"
+	EventLinkDeleted = "link.deleted"
+	// EventLinkExpired fires when a link stops working because it used up its
+	// max clicks, or shortly after its not_after has passed.
+	EventLinkExpired = "link.expired"
+	// EventLinkClicks fires whenever a link's click count reaches a power of ten, from 10 on.
+	EventLinkClicks = "link.clicks"
+)
+
+// Event is the body of a webhook delivery.
+type Event struct {
+	Type       string    `json:"event"`
+	OccurredAt time.Time `json:"occurred_at"`
+	Link       Link      `json:"link"`
+}
+
+// Webhook receives the events of the personal links of CreatedBy, or of the
+// links of WorkspaceID when it is set.
+type Webhook struct {
+	ID  int64  `json:"id"`
+	URL string `json:"url"`
+	// Secret signs deliveries. It is only shown when the webhook is created.
+	Secret string `json:"secret,omitempty"`
+	// Events to deliver, all of them if empty.
+	Events      []string  `json:"events"`
+	CreatedBy   int64     `json:"created_by"`
+	WorkspaceID int64     `json:"workspace_id,omitempty"`
+	CreatedAt   time.Time `json:"created_at"`
+}
+
+// Delivery statuses.
+const (
+	DeliveryPending   = "pending"
+	DeliveryDelivered = "delivered"
+	DeliveryFailed    = "failed"
+)
+
+// Delivery is one event on its way to one webhook, with the outcome of the last attempt.
+type Delivery struct {
+	ID             int64           `json:"id"`
+	WebhookID      int64           `json:"webhook_id"`
+	Event          string          `json:"event"`
+	Payload        json.RawMessage `json:"payload"`
+	Status         string          `json:"status"`
+	Attempts       int             `json:"attempts"`
+	NextAttemptAt  time.Time       `json:"next_attempt_at"`
+	LastStatusCode int             `json:"last_status_code,omitempty"`
+	LastResponse   string          `json:"last_response,omitempty"`
+	LastError      string          `json:"last_error,omitempty"`
+	DeliveredAt    *time.Time      `json:"delivered_at,omitempty"`
+	CreatedAt      time.Time       `json:"created_at"`
+
+	// URL and Secret of the webhook, filled in for the dispatcher.
+	URL    string `json:"-"`
+	Secret string `json:"-"`
+}
+
+// LinkHealth is the outcome of the last check of a link's destination.
+type LinkHealth struct {
+	LinkID int64 `json:"-"`
+	// URL is the destination, filled in for the monitor.
+	URL string `json:"-"`
+	// StatusCode is 0 if no response came back, see Error.
+	StatusCode int    `json:"status_code"`
+	LatencyMS  int64  `json:"latency_ms"`
+	Error      string `json:"error,omitempty"`
+	// Failures counts the consecutive failed checks; Broken is set once
+	// they reach the configured threshold.
+	Failures    int        `json:"failures"`
+	Broken      bool       `json:"broken"`
+	CheckedAt   *time.Time `json:"checked_at,omitempty"`
+	NextCheckAt time.Time  `json:"next_check_at"`
+}
+
+// Quota limits the links an owner, a user or a workspace, may create. 0 means unlimited.
+type Quota struct {
+	Links                 int `json:"links"`
+	LinksPerDay           int `json:"links_per_day"`
+	CustomAliasesPerMonth int `json:"custom_aliases_per_month"`
+}
+
+// Check returns a *QuotaError if one more link, with a custom alias or not,
+// doesn't fit into q after u.
+func (q Quota) Check(u Usage, customAlias bool, now time.Time) error {
+	now = now.UTC()
+	switch {
+	case q.Links > 0 && u.Links >= q.Links:
+		return &QuotaError{Limit: "links", Max: q.Links}
+	case q.LinksPerDay > 0 && u.LinksToday >= q.LinksPerDay:
+		tomorrow := time.Date(now.Year(), now.Month(), now.Day()+1, 0, 0, 0, 0, time.UTC)
+		return &QuotaError{Limit: "links_per_day", Max: q.LinksPerDay, Reset: tomorrow}
+	case customAlias && q.CustomAliasesPerMonth > 0 && u.CustomAliasesThisMonth >= q.CustomAliasesPerMonth:
+		nextMonth := time.Date(now.Year(), now.Month()+1, 1, 0, 0, 0, 0, time.UTC)
+		return &QuotaError{Limit: "custom_aliases_per_month", Max: q.CustomAliasesPerMonth, Reset: nextMonth}
+	}
+	return nil
+}
+
+// QuotaOverride replaces limits of the configured Quota for the personal links
+// of UserID, or for the links of WorkspaceID. Nil limits keep the default.
+type QuotaOverride struct {
+	UserID                int64 `json:"user_id,omitempty"`
+	WorkspaceID           int64 `json:"workspace_id,omitempty"`
+	Links                 *int  `json:"links"`
+	LinksPerDay           *int  `json:"links_per_day"`
+	CustomAliasesPerMonth *int  `json:"custom_aliases_per_month"`
+}
+
+// Apply returns q with the limits o overrides replaced.
+func (o QuotaOverride) Apply(q Quota) Quota {
+	if o.Links != nil {
+		q.Links = *o.Links
+	}
+	if o.LinksPerDay != nil {
+		q.LinksPerDay = *o.LinksPerDay
+	}
+	if o.CustomAliasesPerMonth != nil {
+		q.CustomAliasesPerMonth = *o.CustomAliasesPerMonth
+	}
+	return q
+}
+
+// Usage is what counts against a Quota. Days and months are UTC; deleting
+// links frees up Links, but not what was created today or this month.
+type Usage struct {
+	Links                  int `json:"links"`
+	LinksToday             int `json:"links_today"`
+	CustomAliasesThisMonth int `json:"custom_aliases_this_month"`
+}
+
+// QuotaError is returned when creating a link would exceed a limit of the owner's Quota.
+// It matches ErrQuotaExceeded.
+type QuotaError struct {
+	// Limit is the JSON name of the Quota field.
+	Limit string
+	Max   int
+	// Reset is when the limit frees up again, zero for Links.
+	Reset time.Time
+}
+
+func (e *QuotaError) Error() string {
+	return fmt.Sprintf("%s: %s is %d", ErrQuotaExceeded, e.Limit, e.Max)
+}
+
+func (e *QuotaError) Is(target error) bool {
+	return target == ErrQuotaExceeded
 }
 
 var (
-	ErrAliasExists   = errors.New("alias exists")
-	ErrAliasNotFound = errors.New("alias not found")
+	ErrAliasExists    = errors.New("alias exists")
+	ErrAliasNotFound  = errors.New("alias not found")
+	ErrLinkExhausted  = errors.New("link has no clicks left")
+	ErrLinkNotActive  = errors.New("link is not active yet")
+	ErrLinkExpired    = errors.New("link has expired")
+	ErrQuotaExceeded  = errors.New("quota exceeded")
+	ErrLinkDisabled   = errors.New("link is disabled")
+	ErrNotBlocked     = errors.New("domain is not blocked")
+	ErrNoReports      = errors.New("link has no pending reports")
+	ErrDomainExists   = errors.New("domain exists")
+	ErrDomainNotFound = errors.New("domain not found")
+	ErrDomainInUse    = errors.New("domain has links")
+	ErrOwnerChanged   = errors.New("link changed owners")
+
+	ErrWorkspaceNotFound  = errors.New("workspace not found")
+	ErrNotMember          = errors.New("not a member of the workspace")
+	ErrAlreadyMember      = errors.New("already a member of the workspace")
+	ErrInvitationExists   = errors.New("invitation exists")
+	ErrInvitationNotFound = errors.New("invitation not found")
+	ErrLastOwner          = errors.New("workspace must keep an owner")
+
+	ErrWebhookNotFound = errors.New("webhook not found")
+
+	ErrHealthNotChecked = errors.New("destination not checked yet")
+
+	ErrUnavailable = errors.New("database unavailable")
+
+	ErrQueuedNotFound = errors.New("queued link not found")
 )
+
+// UnavailableError is returned instead of querying a database that is known
+// to be down. It matches ErrUnavailable.
+type UnavailableError struct {
+	// RetryAfter is when the database is tried again.
+	RetryAfter time.Duration
+}
+
+func (e *UnavailableError) Error() string {
+	return fmt.Sprintf("%s, retrying in %s", ErrUnavailable, e.RetryAfter.Round(time.Second))
+}
+
+func (e *UnavailableError) Is(target error) bool {
+	return target == ErrUnavailable
+}
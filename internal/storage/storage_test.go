@@ -0,0 +1,51 @@
+package storage
+
+import (
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+func TestCheckWindow(t *testing.T) {
+	now := time.Date(2025, 6, 1, 12, 0, 0, 0, time.UTC)
+	before, after := now.Add(-time.Minute), now.Add(time.Minute)
+
+	assert.NoError(t, Link{}.CheckWindow(now))
+	assert.NoError(t, Link{NotBefore: &before, NotAfter: &after}.CheckWindow(now))
+	assert.NoError(t, Link{NotBefore: &now}.CheckWindow(now), "the window opens at not_before")
+	assert.ErrorIs(t, Link{NotBefore: &after}.CheckWindow(now), ErrLinkNotActive)
+	assert.ErrorIs(t, Link{NotAfter: &now}.CheckWindow(now), ErrLinkExpired, "and closes at not_after")
+}
+
+func TestDisabled(t *testing.T) {
+	now := time.Date(2025, 6, 1, 12, 0, 0, 0, time.UTC)
+	before, after := now.Add(-time.Minute), now.Add(time.Minute)
+
+	assert.False(t, Link{}.Disabled(now))
+	assert.True(t, Link{DisabledAt: &before}.Disabled(now))
+	assert.True(t, Link{DisabledAt: &before, DisabledUntil: &after}.Disabled(now))
+	assert.False(t, Link{DisabledAt: &before, DisabledUntil: &now}.Disabled(now), "a temporary disable ends at disabled_until")
+}
+
+func TestQuotaCheck(t *testing.T) {
+	now := time.Date(2025, 12, 31, 18, 0, 0, 0, time.UTC)
+	q := Quota{Links: 10, LinksPerDay: 5, CustomAliasesPerMonth: 2}
+
+	assert.NoError(t, q.Check(Usage{Links: 9, LinksToday: 4, CustomAliasesThisMonth: 1}, true, now))
+	assert.NoError(t, q.Check(Usage{CustomAliasesThisMonth: 2}, false, now), "generated aliases don't count")
+	assert.NoError(t, Quota{}.Check(Usage{Links: 1e6, LinksToday: 1e6}, true, now), "0 is unlimited")
+
+	var qe *QuotaError
+	err := q.Check(Usage{Links: 10}, false, now)
+	require.ErrorAs(t, err, &qe)
+	assert.ErrorIs(t, err, ErrQuotaExceeded)
+	assert.Equal(t, QuotaError{Limit: "links", Max: 10}, *qe)
+
+	require.ErrorAs(t, q.Check(Usage{LinksToday: 5}, false, now), &qe)
+	assert.Equal(t, QuotaError{Limit: "links_per_day", Max: 5, Reset: time.Date(2026, 1, 1, 0, 0, 0, 0, time.UTC)}, *qe)
+
+	require.ErrorAs(t, q.Check(Usage{CustomAliasesThisMonth: 2}, true, now), &qe)
+	assert.Equal(t, QuotaError{Limit: "custom_aliases_per_month", Max: 2, Reset: time.Date(2026, 1, 1, 0, 0, 0, 0, time.UTC)}, *qe)
+}
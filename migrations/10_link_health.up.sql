@@ -0,0 +1,14 @@
+-- the last check of a link's destination by the dead-link monitor
+CREATE TABLE IF NOT EXISTS link_health(
+    url_id INTEGER PRIMARY KEY REFERENCES url(id) ON DELETE CASCADE,
+    status_code INTEGER NOT NULL DEFAULT 0, -- 0 when no response came back
+    latency_ms INTEGER NOT NULL DEFAULT 0,
+    error TEXT NOT NULL DEFAULT '',
+    failures INTEGER NOT NULL DEFAULT 0, -- consecutive failed checks
+    broken BOOLEAN NOT NULL DEFAULT false,
+    checked_at TIMESTAMPTZ, -- NULL until the first check has completed
+    next_check_at TIMESTAMPTZ NOT NULL DEFAULT now()
+);
+
+CREATE INDEX IF NOT EXISTS idx_link_health_next_check_at ON link_health(next_check_at);
+CREATE INDEX IF NOT EXISTS idx_link_health_broken ON link_health(url_id) WHERE broken;
@@ -0,0 +1 @@
+ALTER TABLE url DROP COLUMN IF EXISTS max_clicks;
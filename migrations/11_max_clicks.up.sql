@@ -0,0 +1,2 @@
+-- NULL means unlimited; once clicks reaches max_clicks the link answers 410
+ALTER TABLE url ADD COLUMN IF NOT EXISTS max_clicks INTEGER CHECK (max_clicks > 0);
@@ -0,0 +1,5 @@
+DROP INDEX IF EXISTS idx_url_not_after;
+ALTER TABLE url DROP COLUMN IF EXISTS expired_at;
+ALTER TABLE url DROP COLUMN IF EXISTS prelaunch_url;
+ALTER TABLE url DROP COLUMN IF EXISTS not_after;
+ALTER TABLE url DROP COLUMN IF EXISTS not_before;
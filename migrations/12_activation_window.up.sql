@@ -0,0 +1,11 @@
+-- a link only redirects from not_before until not_after, either end may be open
+ALTER TABLE url ADD COLUMN IF NOT EXISTS not_before TIMESTAMPTZ;
+ALTER TABLE url ADD COLUMN IF NOT EXISTS not_after TIMESTAMPTZ;
+ALTER TABLE url DROP CONSTRAINT IF EXISTS url_window_check;
+ALTER TABLE url ADD CONSTRAINT url_window_check CHECK (not_after > not_before);
+-- where visitors go before not_before, instead of getting an error
+ALTER TABLE url ADD COLUMN IF NOT EXISTS prelaunch_url TEXT NOT NULL DEFAULT '';
+-- set once the link.expired event for not_after has been recorded
+ALTER TABLE url ADD COLUMN IF NOT EXISTS expired_at TIMESTAMPTZ;
+
+CREATE INDEX IF NOT EXISTS idx_url_not_after ON url(not_after) WHERE not_after IS NOT NULL AND expired_at IS NULL;
@@ -0,0 +1,2 @@
+DROP TABLE IF EXISTS link_usage;
+DROP TABLE IF EXISTS quotas;
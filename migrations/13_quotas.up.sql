@@ -0,0 +1,22 @@
+-- admins' overrides of the configured quota, for the personal links of a user
+-- (workspace_id = 0) or for the links of a workspace (user_id = 0);
+-- NULL keeps the configured limit, 0 means unlimited
+CREATE TABLE IF NOT EXISTS quotas(
+    user_id INTEGER NOT NULL DEFAULT 0,
+    workspace_id INTEGER NOT NULL DEFAULT 0,
+    links INTEGER CHECK (links >= 0),
+    links_per_day INTEGER CHECK (links_per_day >= 0),
+    custom_aliases_per_month INTEGER CHECK (custom_aliases_per_month >= 0),
+    PRIMARY KEY (user_id, workspace_id),
+    CHECK ((user_id = 0) <> (workspace_id = 0))
+);
+
+-- links created per owner and UTC day, kept when links are deleted
+CREATE TABLE IF NOT EXISTS link_usage(
+    user_id INTEGER NOT NULL DEFAULT 0,
+    workspace_id INTEGER NOT NULL DEFAULT 0,
+    day DATE NOT NULL,
+    links INTEGER NOT NULL DEFAULT 0,
+    custom_aliases INTEGER NOT NULL DEFAULT 0,
+    PRIMARY KEY (user_id, workspace_id, day)
+);
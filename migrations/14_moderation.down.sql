@@ -0,0 +1,4 @@
+DROP TABLE IF EXISTS admin_audit;
+DROP TABLE IF EXISTS blocked_domains;
+ALTER TABLE url DROP COLUMN IF EXISTS disabled_reason;
+ALTER TABLE url DROP COLUMN IF EXISTS disabled_at;
@@ -0,0 +1,24 @@
+-- disabled by an admin: the link stays, but no longer redirects
+ALTER TABLE url ADD COLUMN IF NOT EXISTS disabled_at TIMESTAMPTZ;
+ALTER TABLE url ADD COLUMN IF NOT EXISTS disabled_reason TEXT NOT NULL DEFAULT '';
+
+-- destinations blocked by admins, on top of blocklist.domains from the config
+CREATE TABLE IF NOT EXISTS blocked_domains(
+    domain TEXT PRIMARY KEY,
+    reason TEXT NOT NULL DEFAULT '',
+    blocked_by INTEGER NOT NULL,
+    created_at TIMESTAMPTZ NOT NULL DEFAULT now()
+);
+
+-- every change made through the admin API
+CREATE TABLE IF NOT EXISTS admin_audit(
+    id BIGSERIAL PRIMARY KEY,
+    actor INTEGER NOT NULL,
+    action TEXT NOT NULL, -- method and route, e.g. POST /admin/links/{alias}/disable
+    target TEXT NOT NULL, -- the request URI
+    status INTEGER NOT NULL,
+    request_id TEXT NOT NULL DEFAULT '',
+    created_at TIMESTAMPTZ NOT NULL DEFAULT now()
+);
+
+CREATE INDEX IF NOT EXISTS idx_admin_audit_actor ON admin_audit(actor, id);
@@ -0,0 +1,2 @@
+DROP TABLE IF EXISTS reports;
+ALTER TABLE url DROP COLUMN IF EXISTS disabled_until;
@@ -0,0 +1,18 @@
+-- a temporary disable, e.g. after too many reports, ends by itself
+ALTER TABLE url ADD COLUMN IF NOT EXISTS disabled_until TIMESTAMPTZ;
+
+-- abuse reports of visitors, pending until an admin resolves them
+CREATE TABLE IF NOT EXISTS reports(
+    id BIGSERIAL PRIMARY KEY,
+    url_id INTEGER NOT NULL REFERENCES url(id) ON DELETE CASCADE,
+    reason TEXT NOT NULL,
+    comment TEXT NOT NULL DEFAULT '',
+    reporter TEXT NOT NULL, -- keyed hash of the reporter's IP address
+    created_at TIMESTAMPTZ NOT NULL DEFAULT now(),
+    resolution TEXT NOT NULL DEFAULT '', -- dismissed or disabled
+    resolved_by INTEGER,
+    resolved_at TIMESTAMPTZ
+);
+
+-- a visitor counts once per link until the reports are resolved
+CREATE UNIQUE INDEX IF NOT EXISTS idx_reports_pending ON reports(url_id, reporter) WHERE resolved_at IS NULL;
@@ -0,0 +1,8 @@
+ALTER TABLE url DROP CONSTRAINT IF EXISTS url_domain_alias_key;
+DELETE FROM url WHERE domain <> '';
+ALTER TABLE url DROP COLUMN IF EXISTS domain;
+ALTER TABLE url DROP CONSTRAINT IF EXISTS url_alias_key;
+ALTER TABLE url ADD CONSTRAINT url_alias_key UNIQUE (alias);
+CREATE INDEX IF NOT EXISTS idx_alias ON url(alias);
+
+DROP TABLE IF EXISTS domains;
\ No newline at end of file
@@ -0,0 +1,12 @@
+CREATE TABLE IF NOT EXISTS domains(
+    id SERIAL PRIMARY KEY,
+    host TEXT NOT NULL UNIQUE,
+    fallback_url TEXT NOT NULL DEFAULT ''
+);
+
+-- '' is the default namespace, used for every host that isn't in domains
+ALTER TABLE url ADD COLUMN IF NOT EXISTS domain TEXT NOT NULL DEFAULT '';
+ALTER TABLE url DROP CONSTRAINT IF EXISTS url_alias_key;
+ALTER TABLE url DROP CONSTRAINT IF EXISTS url_domain_alias_key;
+ALTER TABLE url ADD CONSTRAINT url_domain_alias_key UNIQUE (domain, alias);
+DROP INDEX IF EXISTS idx_alias;
@@ -0,0 +1,3 @@
+ALTER TABLE url DROP COLUMN IF EXISTS query_conflict;
+ALTER TABLE url DROP COLUMN IF EXISTS forward_path;
+ALTER TABLE url DROP COLUMN IF EXISTS forward_query;
@@ -0,0 +1,4 @@
+ALTER TABLE url ADD COLUMN IF NOT EXISTS forward_query BOOLEAN NOT NULL DEFAULT FALSE;
+ALTER TABLE url ADD COLUMN IF NOT EXISTS forward_path BOOLEAN NOT NULL DEFAULT FALSE;
+-- '' falls back to redirect.query_conflict from the config
+ALTER TABLE url ADD COLUMN IF NOT EXISTS query_conflict TEXT NOT NULL DEFAULT '';
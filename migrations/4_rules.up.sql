@@ -0,0 +1,2 @@
+-- ordered list of conditional redirect rules, see lib/rules
+ALTER TABLE url ADD COLUMN IF NOT EXISTS rules JSONB NOT NULL DEFAULT '[]';
@@ -0,0 +1,7 @@
+ALTER TABLE domains DROP COLUMN IF EXISTS interstitial;
+
+ALTER TABLE url DROP COLUMN IF EXISTS created_at;
+ALTER TABLE url DROP COLUMN IF EXISTS clicks;
+ALTER TABLE url DROP COLUMN IF EXISTS interstitial;
+ALTER TABLE url DROP COLUMN IF EXISTS description;
+ALTER TABLE url DROP COLUMN IF EXISTS title;
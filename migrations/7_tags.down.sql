@@ -0,0 +1,3 @@
+DROP INDEX IF EXISTS idx_url_createdBy;
+DROP TABLE IF EXISTS link_tags;
+ALTER TABLE url DROP COLUMN IF EXISTS notes;
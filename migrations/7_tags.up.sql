@@ -0,0 +1,11 @@
+-- private to the owner, unlike title and description
+ALTER TABLE url ADD COLUMN IF NOT EXISTS notes TEXT NOT NULL DEFAULT '';
+
+CREATE TABLE IF NOT EXISTS link_tags(
+    url_id INTEGER NOT NULL REFERENCES url(id) ON DELETE CASCADE,
+    tag TEXT NOT NULL,
+    PRIMARY KEY (url_id, tag)
+);
+
+CREATE INDEX IF NOT EXISTS idx_link_tags_tag ON link_tags(tag);
+CREATE INDEX IF NOT EXISTS idx_url_createdBy ON url(createdBy);
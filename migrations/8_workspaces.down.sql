@@ -0,0 +1,5 @@
+DROP INDEX IF EXISTS idx_url_workspace_id;
+ALTER TABLE url DROP COLUMN IF EXISTS workspace_id;
+DROP TABLE IF EXISTS workspace_invitations;
+DROP TABLE IF EXISTS workspace_members;
+DROP TABLE IF EXISTS workspaces;